@@ -0,0 +1,194 @@
+// Package addressbook keeps the known destinations of a project, and turns what a user types as a destination
+// (a label, an ENS name or an address) into a validated address.
+package addressbook
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// A known destination
+type Entry struct {
+	Label   string
+	Address string
+	// The ENS name the address was resolved from, if any
+	ENS   string `json:",omitempty"`
+	Notes string `json:",omitempty"`
+	// Whether spending policies may allow sending to this address
+	AllowedForPolicy bool
+	AddedAt          time.Time
+	// Transactions sent to the address, entries without a label are added by sending
+	Sends    int       `json:",omitempty"`
+	LastSent time.Time `json:",omitempty"`
+}
+
+// The entries of the address book, at most one per address and per label
+type Book []Entry
+
+// Where a destination typed by a user resolved to
+type Destination struct {
+	// What the user typed
+	Input string
+	// The checksummed address
+	Address common.Address
+	// The address book label of the address, if any
+	Label string
+	// The ENS name the address was resolved from, if any
+	ENS string
+	// Whether we never sent to nor labeled this address before
+	FirstTime bool
+}
+
+func (d Destination) String() string {
+	switch {
+	case d.Label != "" && d.ENS != "":
+		return fmt.Sprintf("%s (%s, %s)", d.Label, d.ENS, d.Address.Hex())
+	case d.Label != "":
+		return fmt.Sprintf("%s (%s)", d.Label, d.Address.Hex())
+	case d.ENS != "":
+		return fmt.Sprintf("%s (%s)", d.ENS, d.Address.Hex())
+	default:
+		return d.Address.Hex()
+	}
+}
+
+// Resolves ENS names to addresses
+type Resolver func(name string) (common.Address, error)
+
+var ensNamePattern = regexp.MustCompile(`^([a-z0-9-]+\.)+eth$`)
+
+// Whether s looks like an ENS name, e.g. vitalik.eth
+func IsENSName(s string) bool {
+	return ensNamePattern.MatchString(strings.ToLower(s))
+}
+
+// Parse a hex address, rejecting it if it is mixed case and not a valid EIP-55 checksum. All lower or upper
+// case addresses carry no checksum and are accepted.
+func ParseAddress(s string) (common.Address, error) {
+	if !common.IsHexAddress(s) {
+		return common.Address{}, fmt.Errorf("invalid address %q, expected 0x and 40 hex digits", s)
+	}
+
+	addr := common.HexToAddress(s)
+	digits := strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+	if digits != strings.ToLower(digits) && digits != strings.ToUpper(digits) && addr.Hex() != "0x"+digits {
+		return common.Address{}, fmt.Errorf("invalid EIP-55 checksum of address %s, did you mean %s?", s, addr.Hex())
+	}
+	return addr, nil
+}
+
+// Resolve a label of the book, an ENS name or an address. The resolver is only used for ENS names and may be nil.
+func (b Book) Resolve(input string, resolve Resolver) (Destination, error) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return Destination{}, errors.New("no destination given")
+	}
+	d := Destination{Input: input}
+
+	if e := b.FindLabel(input); e != nil {
+		d.Address = common.HexToAddress(e.Address)
+		d.ENS = e.ENS
+	} else if IsENSName(input) {
+		if resolve == nil {
+			return Destination{}, fmt.Errorf("can not resolve ENS name %s without a connection", input)
+		}
+		addr, err := resolve(strings.ToLower(input))
+		if err != nil {
+			return Destination{}, fmt.Errorf("error resolving ENS name %s: %w", input, err)
+		}
+		if addr == (common.Address{}) {
+			return Destination{}, fmt.Errorf("ENS name %s does not resolve to an address", input)
+		}
+		d.Address = addr
+		d.ENS = strings.ToLower(input)
+	} else {
+		addr, err := ParseAddress(input)
+		if err != nil {
+			return Destination{}, err
+		}
+		d.Address = addr
+	}
+
+	e := b.FindAddress(d.Address)
+	if e != nil {
+		d.Label = e.Label
+	}
+	d.FirstTime = e == nil
+	return d, nil
+}
+
+// The entry with the label, case insensitive
+func (b Book) FindLabel(label string) *Entry {
+	for i := range b {
+		if b[i].Label != "" && strings.EqualFold(b[i].Label, label) {
+			return &b[i]
+		}
+	}
+	return nil
+}
+
+func (b Book) FindAddress(addr common.Address) *Entry {
+	for i := range b {
+		if common.HexToAddress(b[i].Address) == addr {
+			return &b[i]
+		}
+	}
+	return nil
+}
+
+// Add an entry, or update the one with the same address. The label must not be used by another address.
+func (b *Book) Add(e Entry) error {
+	addr, err := ParseAddress(e.Address)
+	if err != nil {
+		return err
+	}
+	e.Address = addr.Hex()
+	if e.Label != "" {
+		if other := b.FindLabel(e.Label); other != nil && other.Address != e.Address {
+			return fmt.Errorf("label %s is already used for %s", e.Label, other.Address)
+		}
+		if IsENSName(e.Label) || common.IsHexAddress(e.Label) {
+			return fmt.Errorf("label %s can not look like an ENS name or address", e.Label)
+		}
+	}
+
+	if existing := b.FindAddress(addr); existing != nil {
+		existing.Label = e.Label
+		existing.ENS = e.ENS
+		existing.Notes = e.Notes
+		existing.AllowedForPolicy = e.AllowedForPolicy
+		return nil
+	}
+	if e.AddedAt.IsZero() {
+		e.AddedAt = time.Now().UTC()
+	}
+	*b = append(*b, e)
+	return nil
+}
+
+// Remove the entry with the label or address
+func (b *Book) Remove(labelOrAddress string) error {
+	for i, e := range *b {
+		if strings.EqualFold(e.Label, labelOrAddress) || strings.EqualFold(e.Address, labelOrAddress) {
+			*b = append((*b)[:i], (*b)[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not in the address book", labelOrAddress)
+}
+
+// Count a transaction sent to the address, adding an unlabeled entry for it if it is new
+func (b *Book) RecordSend(addr common.Address) {
+	e := b.FindAddress(addr)
+	if e == nil {
+		*b = append(*b, Entry{Address: addr.Hex(), AddedAt: time.Now().UTC()})
+		e = &(*b)[len(*b)-1]
+	}
+	e.Sends++
+	e.LastSent = time.Now().UTC()
+}
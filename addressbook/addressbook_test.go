@@ -0,0 +1,136 @@
+package addressbook
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const checksummed = "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"
+
+func TestParseAddress(t *testing.T) {
+	for _, s := range []string{checksummed, strings.ToLower(checksummed), "0x" + strings.ToUpper(checksummed[2:])} {
+		addr, err := ParseAddress(s)
+		if err != nil {
+			t.Errorf("%s: %v", s, err)
+		} else if addr.Hex() != checksummed {
+			t.Errorf("%s parsed as %s", s, addr.Hex())
+		}
+	}
+
+	// one letter in the wrong case
+	typo := "0x5aaeb6053F3E94C9b9A09f33669435E7Ef1BeAed"
+	if _, err := ParseAddress(typo); err == nil || !strings.Contains(err.Error(), "EIP-55 checksum") || !strings.Contains(err.Error(), checksummed) {
+		t.Errorf("address with a wrong checksum: %v", err)
+	}
+	for _, s := range []string{"", "0x1234", "5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAedXX", "vitalik.eth"} {
+		if _, err := ParseAddress(s); err == nil {
+			t.Errorf("parsed %q", s)
+		}
+	}
+}
+
+func TestBook(t *testing.T) {
+	b := Book{}
+	if err := b.Add(Entry{Label: "Treasury", Address: strings.ToLower(checksummed)}); err != nil {
+		t.Fatal(err)
+	}
+	other := "0x000000000000000000000000000000000000dEaD"
+	if err := b.Add(Entry{Label: "treasury", Address: other}); err == nil {
+		t.Error("label added twice")
+	}
+	for _, label := range []string{"burn.eth", other} {
+		if err := b.Add(Entry{Label: label, Address: other}); err == nil {
+			t.Errorf("added label %s", label)
+		}
+	}
+
+	e := b.FindLabel("TREASURY")
+	if e == nil || e.Address != checksummed {
+		t.Fatalf("found %v by label", e)
+	}
+	if e := b.FindAddress(common.HexToAddress(checksummed)); e == nil || e.Label != "Treasury" {
+		t.Errorf("found %v by address", e)
+	}
+
+	// adding the address again updates its entry
+	if err := b.Add(Entry{Label: "Vault", Address: checksummed, AllowedForPolicy: true}); err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 1 || b[0].Label != "Vault" || !b[0].AllowedForPolicy {
+		t.Errorf("book after updating the entry: %+v", b)
+	}
+
+	b.RecordSend(common.HexToAddress(other))
+	b.RecordSend(common.HexToAddress(other))
+	if e := b.FindAddress(common.HexToAddress(other)); e == nil || e.Sends != 2 || e.Label != "" {
+		t.Errorf("entry of sends %+v", e)
+	}
+
+	if err := b.Remove("vault"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Remove(strings.ToLower(other)); err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 0 {
+		t.Errorf("%d entries left", len(b))
+	}
+	if err := b.Remove("vault"); err == nil {
+		t.Error("removed an entry twice")
+	}
+}
+
+func TestResolve(t *testing.T) {
+	b := Book{}
+	if err := b.Add(Entry{Label: "treasury", Address: checksummed}); err != nil {
+		t.Fatal(err)
+	}
+	resolved := common.HexToAddress("0xd8dA6BF26964aF9D7eEd9e03E53415D37aA96045")
+	resolve := func(name string) (common.Address, error) {
+		switch name {
+		case "vitalik.eth":
+			return resolved, nil
+		case "treasury.eth":
+			return common.HexToAddress(checksummed), nil
+		case "broken.eth":
+			return common.Address{}, errors.New("connection refused")
+		}
+		return common.Address{}, nil
+	}
+
+	cases := []struct {
+		input     string
+		address   string
+		label     string
+		ens       string
+		firstTime bool
+	}{
+		{"Treasury", checksummed, "treasury", "", false},
+		{strings.ToLower(checksummed), checksummed, "treasury", "", false},
+		{"Vitalik.eth", resolved.Hex(), "", "vitalik.eth", true},
+		{"treasury.eth", checksummed, "treasury", "treasury.eth", false},
+		{" 0x000000000000000000000000000000000000dead ", "0x000000000000000000000000000000000000dEaD", "", "", true},
+	}
+	for _, c := range cases {
+		d, err := b.Resolve(c.input, resolve)
+		if err != nil {
+			t.Errorf("%s: %v", c.input, err)
+			continue
+		}
+		if d.Address.Hex() != c.address || d.Label != c.label || d.ENS != c.ens || d.FirstTime != c.firstTime {
+			t.Errorf("%s resolved to %+v", c.input, d)
+		}
+	}
+
+	for _, input := range []string{"", "unknown.eth", "broken.eth", "0x5aaeb6053F3E94C9b9A09f33669435E7Ef1BeAed", "nobody"} {
+		if d, err := b.Resolve(input, resolve); err == nil {
+			t.Errorf("%q resolved to %s", input, d)
+		}
+	}
+	if _, err := b.Resolve("vitalik.eth", nil); err == nil {
+		t.Error("ENS name resolved without a resolver")
+	}
+}
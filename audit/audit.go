@@ -0,0 +1,338 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	libp2pcrypto "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/shykerbogdan/mpc-wallet/user"
+)
+
+type EventType string
+
+const (
+	EventChat      EventType = "chat"
+	EventProposal  EventType = "proposal"
+	EventApproval  EventType = "approval"
+	EventRejection EventType = "rejection"
+	EventKeygen    EventType = "keygen"
+	EventReshare   EventType = "reshare"
+	EventSignature EventType = "signature"
+	EventBroadcast EventType = "broadcast"
+	EventError     EventType = "error"
+	// An identity key replaced by a new one, ours or a signer's
+	EventIdentity EventType = "identity"
+	// A signer admitted to a project with an invitation
+	EventMember EventType = "member"
+	// Going ahead although a check failed, e.g. approving a transaction that reverts in simulation
+	EventOverride EventType = "override"
+)
+
+// An Entry is one line of the audit log. Every entry includes the hash of the entry before it
+// and is signed with the local identity key, so entries can not be changed, removed or
+// reordered without Verify noticing.
+type Entry struct {
+	Seq     int
+	Time    time.Time
+	Type    EventType
+	Actor   string
+	Wallet  string            `json:",omitempty"`
+	Message string            `json:",omitempty"`
+	Data    map[string]string `json:",omitempty"`
+
+	// Hash of the previous entry, empty for the first one
+	Prev string
+	Hash string
+	Sig  []byte
+}
+
+// The hash of the first entry's previous entry
+const genesisHash = ""
+
+// Log is an append-only audit log file. Each line is an entry encrypted with a key derived from
+// the local identity key, so the log reveals nothing to someone who only gets hold of the file.
+type Log struct {
+	filename string
+	me       user.Me
+	aead     cipher.AEAD
+
+	seq  int
+	head string
+
+	mutex sync.Mutex
+}
+
+// Open the audit log in filename, creating it if it does not exist yet
+func Open(filename string, me user.Me) (*Log, error) {
+	aead, err := logCipher(me.IdentPrivKey)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Log{filename: filename, me: me, aead: aead, head: genesisHash}
+
+	entries, err := l.read()
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) > 0 {
+		last := entries[len(entries)-1]
+		l.seq = last.Seq
+		l.head = last.Hash
+	}
+
+	return l, nil
+}
+
+// Read and decrypt all entries of the audit log in filename
+func Read(filename string, me user.Me) ([]Entry, error) {
+	aead, err := logCipher(me.IdentPrivKey)
+	if err != nil {
+		return nil, err
+	}
+	l := &Log{filename: filename, me: me, aead: aead}
+	return l.read()
+}
+
+// Add an entry to the log. Safe to call on a nil Log, which records nothing.
+func (l *Log) Append(t EventType, actor string, wallet string, message string, data map[string]string) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	e := Entry{
+		Seq:     l.seq + 1,
+		Time:    time.Now().UTC(),
+		Type:    t,
+		Actor:   actor,
+		Wallet:  wallet,
+		Message: message,
+		Data:    data,
+		Prev:    l.head,
+	}
+
+	hash, err := e.digest()
+	if err != nil {
+		return err
+	}
+	e.Hash = hex.EncodeToString(hash)
+	e.Sig, err = l.me.IdentPrivKey.Sign(hash)
+	if err != nil {
+		return err
+	}
+
+	line, err := l.seal(e)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(l.filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	l.seq = e.Seq
+	l.head = e.Hash
+	return nil
+}
+
+// Check that entries form an unbroken hash chain signed by pubkey.
+// The returned error says which entry is the first one that does not check out.
+func Verify(entries []Entry, pubkey libp2pcrypto.PubKey) error {
+	prev := genesisHash
+	for i, e := range entries {
+		if e.Seq != i+1 {
+			return fmt.Errorf("entry %d: expected sequence number %d, entries are missing or reordered", e.Seq, i+1)
+		}
+		if e.Prev != prev {
+			return fmt.Errorf("entry %d: does not follow the previous entry, the chain is broken", e.Seq)
+		}
+
+		hash, err := e.digest()
+		if err != nil {
+			return fmt.Errorf("entry %d: %w", e.Seq, err)
+		}
+		if hex.EncodeToString(hash) != e.Hash {
+			return fmt.Errorf("entry %d: hash mismatch, the entry has been modified", e.Seq)
+		}
+		ok, err := pubkey.Verify(hash, e.Sig)
+		if err != nil || !ok {
+			return fmt.Errorf("entry %d: invalid signature", e.Seq)
+		}
+
+		prev = e.Hash
+	}
+	return nil
+}
+
+// Move the audit log in filename from the identity key of previous to the one of me, which replaces it.
+// The entries are verified against the previous key first, then encrypted and signed with the new one;
+// their hashes and so the chain stay the same. The file is replaced as a whole, an error leaves it as it was.
+func Rekey(filename string, previous user.Me, me user.Me) error {
+	entries, err := Read(filename, previous)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	if err := Verify(entries, previous.IdentPubKey); err != nil {
+		return fmt.Errorf("audit log %s failed verification: %w", filename, err)
+	}
+
+	aead, err := logCipher(me.IdentPrivKey)
+	if err != nil {
+		return err
+	}
+	l := &Log{filename: filename, me: me, aead: aead}
+
+	var buf bytes.Buffer
+	for _, e := range entries {
+		hash, err := hex.DecodeString(e.Hash)
+		if err != nil {
+			return err
+		}
+		e.Sig, err = me.IdentPrivKey.Sign(hash)
+		if err != nil {
+			return err
+		}
+		line, err := l.seal(e)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	tmpname := filename + ".rekey"
+	if err := ioutil.WriteFile(tmpname, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpname, filename); err != nil {
+		os.Remove(tmpname)
+		return err
+	}
+	return nil
+}
+
+// The hash an entry is chained and signed by, over everything but the hash and signature themselves
+func (e Entry) digest() ([]byte, error) {
+	e.Hash = ""
+	e.Sig = nil
+	b, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.Sum256(b)
+	return h[:], nil
+}
+
+func (l *Log) read() ([]Entry, error) {
+	entries := []Entry{}
+
+	f, err := os.Open(l.filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		e, err := l.open(line)
+		if err != nil {
+			return nil, fmt.Errorf("audit log %s line %d: %w", l.filename, n, err)
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, scanner.Err()
+}
+
+// Lines are base64(nonce | AES-256-GCM(json entry))
+func (l *Log) seal(e Entry) ([]byte, error) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, l.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	sealed := l.aead.Seal(nonce, nonce, b, nil)
+
+	out := make([]byte, base64.StdEncoding.EncodedLen(len(sealed)))
+	base64.StdEncoding.Encode(out, sealed)
+	return out, nil
+}
+
+func (l *Log) open(line []byte) (Entry, error) {
+	e := Entry{}
+
+	sealed := make([]byte, base64.StdEncoding.DecodedLen(len(line)))
+	n, err := base64.StdEncoding.Decode(sealed, line)
+	if err != nil {
+		return e, err
+	}
+	sealed = sealed[:n]
+	if len(sealed) < l.aead.NonceSize() {
+		return e, errors.New("entry is too short")
+	}
+
+	b, err := l.aead.Open(nil, sealed[:l.aead.NonceSize()], sealed[l.aead.NonceSize():], nil)
+	if err != nil {
+		return e, errors.New("entry can not be decrypted, it has been modified or belongs to another identity")
+	}
+
+	err = json.Unmarshal(b, &e)
+	return e, err
+}
+
+func logCipher(privkey libp2pcrypto.PrivKey) (cipher.AEAD, error) {
+	if privkey == nil {
+		return nil, errors.New("an identity key is required for the audit log")
+	}
+	raw, err := libp2pcrypto.MarshalPrivateKey(privkey)
+	if err != nil {
+		return nil, err
+	}
+	key := sha256.Sum256(append([]byte("thresher-audit-log-key:"), raw...))
+
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,184 @@
+package audit
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/shykerbogdan/mpc-wallet/user"
+)
+
+func newTestMe(t *testing.T) user.Me {
+	me, err := user.NewMe("alice", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return me
+}
+
+// Write a log of n entries, returning its file name and entries
+func writeLog(t *testing.T, me user.Me, n int) (string, []Entry) {
+	filename := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(filename, me)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if err := l.Append(EventChat, "alice", "w", "message", map[string]string{"n": string(rune('a' + i))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := Read(filename, me)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != n {
+		t.Fatalf("read %d entries, want %d", len(entries), n)
+	}
+	return filename, entries
+}
+
+func TestVerify(t *testing.T) {
+	me := newTestMe(t)
+	_, entries := writeLog(t, me, 4)
+	if err := Verify(entries, me.IdentPubKey); err != nil {
+		t.Fatal(err)
+	}
+
+	// each case changes a copy of the entries
+	cases := []struct {
+		name   string
+		change func([]Entry) []Entry
+		want   string
+	}{
+		{"modified", func(es []Entry) []Entry {
+			es[1].Message = "changed"
+			return es
+		}, "entry 2: hash mismatch"},
+		{"reordered", func(es []Entry) []Entry {
+			es[1], es[2] = es[2], es[1]
+			return es
+		}, "expected sequence number 2"},
+		{"removed", func(es []Entry) []Entry {
+			return append(es[:1], es[2:]...)
+		}, "expected sequence number 2"},
+		{"removed first", func(es []Entry) []Entry {
+			return es[1:]
+		}, "expected sequence number 1"},
+		{"renumbered", func(es []Entry) []Entry {
+			// an entry removed and the ones after renumbered still break the chain
+			es = append(es[:1], es[2:]...)
+			for i := range es {
+				es[i].Seq = i + 1
+			}
+			return es
+		}, "entry 2: does not follow the previous entry"},
+		{"bad signature", func(es []Entry) []Entry {
+			es[2].Sig = append([]byte{}, es[2].Sig...)
+			es[2].Sig[0] ^= 1
+			return es
+		}, "entry 3: invalid signature"},
+	}
+	for _, c := range cases {
+		changed := c.change(append([]Entry{}, entries...))
+		err := Verify(changed, me.IdentPubKey)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: got %v, want %q", c.name, err, c.want)
+		}
+	}
+
+	// signed by another identity
+	if err := Verify(entries, newTestMe(t).IdentPubKey); err == nil || !strings.Contains(err.Error(), "entry 1: invalid signature") {
+		t.Errorf("entries verified with another key: %v", err)
+	}
+}
+
+func TestTruncatedFile(t *testing.T) {
+	me := newTestMe(t)
+	filename, _ := writeLog(t, me, 3)
+
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// cut the last line short, as a crash while writing it would
+	if err := ioutil.WriteFile(filename, b[:len(b)-10], 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Read(filename, me); err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("truncated log read: %v", err)
+	}
+}
+
+func TestReopen(t *testing.T) {
+	me := newTestMe(t)
+	filename, _ := writeLog(t, me, 2)
+
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b, []byte("message")) {
+		t.Error("log is not encrypted")
+	}
+	if _, err := Read(filename, newTestMe(t)); err == nil {
+		t.Error("log read with another identity")
+	}
+
+	// the reopened log continues the chain
+	l, err := Open(filename, me)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Append(EventKeygen, "alice", "w", "", nil); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := Read(filename, me)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[2].Type != EventKeygen {
+		t.Fatalf("reopened log has %d entries", len(entries))
+	}
+	if err := Verify(entries, me.IdentPubKey); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRekey(t *testing.T) {
+	previous := newTestMe(t)
+	filename, before := writeLog(t, previous, 3)
+
+	me := previous
+	if err := me.RotateIdentity(); err != nil {
+		t.Fatal(err)
+	}
+	if err := Rekey(filename, previous, me); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Read(filename, previous); err == nil {
+		t.Error("rekeyed log read with the previous identity")
+	}
+	entries, err := Read(filename, me)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(entries, me.IdentPubKey); err != nil {
+		t.Fatal(err)
+	}
+	for i := range entries {
+		if entries[i].Hash != before[i].Hash {
+			t.Errorf("entry %d changed its hash", i+1)
+		}
+	}
+
+	// a log that does not verify is left as it was
+	if err := Rekey(filename, previous, me); err == nil {
+		t.Error("log rekeyed from an identity it is not encrypted to")
+	}
+	if _, err := Read(filename, me); err != nil {
+		t.Errorf("failed rekey changed the log: %v", err)
+	}
+}
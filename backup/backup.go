@@ -0,0 +1,111 @@
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/shykerbogdan/mpc-wallet/config"
+	"github.com/shykerbogdan/mpc-wallet/user"
+	"github.com/shykerbogdan/mpc-wallet/utils"
+	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet"
+)
+
+const (
+	// Backup files are named <wallet>.thbackup
+	FileExt = ".thbackup"
+
+	backupVersion = 1
+)
+
+// A Backup holds everything needed to restore one wallet share on a new machine: the share itself
+// with the signer roster and chain config (both part of the wallet), and the identity it belongs to,
+// since the other signers know us by the party id derived from our identity key.
+type Backup struct {
+	Version    int
+	Created    time.Time
+	Blockchain string
+	Network    string
+	Project    string
+	Me         user.Me
+	Wallet     *ethwallet.Wallet
+}
+
+// Create a backup of the wallet name from the config
+func New(cfg *config.AppConfig, name string) (*Backup, error) {
+	w := cfg.FindWallet(name)
+	if w == nil {
+		return nil, fmt.Errorf("wallet %s not found", name)
+	}
+	if err := w.VerifyKeyShare(); err != nil {
+		return nil, fmt.Errorf("refusing to back up wallet %s: %w", name, err)
+	}
+
+	return &Backup{
+		Version:    backupVersion,
+		Created:    time.Now().UTC(),
+		Blockchain: cfg.Blockchain,
+		Network:    cfg.Network,
+		Project:    cfg.WalletProject(w),
+		Me:         cfg.Me,
+		Wallet:     w,
+	}, nil
+}
+
+// Encode, compress and encrypt the backup with passphrase
+func (b *Backup) Seal(passphrase []byte) ([]byte, error) {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return utils.SealWithPassphrase(buf.Bytes(), passphrase)
+}
+
+// Decrypt a sealed backup and verify the key share it contains
+func Open(sealed []byte, passphrase []byte) (*Backup, error) {
+	compressed, err := utils.OpenWithPassphrase(sealed, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Backup{}
+	if err := json.Unmarshal(data, b); err != nil {
+		return nil, err
+	}
+	if b.Version != backupVersion {
+		return nil, fmt.Errorf("unsupported backup version %d", b.Version)
+	}
+	if b.Wallet == nil {
+		return nil, fmt.Errorf("backup contains no wallet")
+	}
+	if b.Wallet.Me.PartyID() != b.Me.PartyID() {
+		return nil, fmt.Errorf("backup wallet share does not belong to the backup identity")
+	}
+	if err := b.Wallet.VerifyKeyShare(); err != nil {
+		return nil, fmt.Errorf("backup failed verification: %w", err)
+	}
+
+	return b, nil
+}
@@ -0,0 +1,67 @@
+package backup
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/shykerbogdan/mpc-wallet/config"
+)
+
+// A backup of the wallet in testdata/config.json, which holds a real key share
+func newTestBackup(t *testing.T) *Backup {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(t.TempDir(), "P-alice.json")
+	if err := ioutil.WriteFile(filename, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cfg.Close() })
+
+	b, err := New(cfg, "w1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestSealOpen(t *testing.T) {
+	b := newTestBackup(t)
+	sealed, err := b.Seal([]byte("correct horse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opened, err := Open(sealed, []byte("correct horse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened.Wallet.Address != b.Wallet.Address || opened.Me.PartyID() != b.Me.PartyID() || opened.Project != b.Project {
+		t.Errorf("opened backup of %s for %s, want %s for %s", opened.Wallet.Address, opened.Me.PartyID(), b.Wallet.Address, b.Me.PartyID())
+	}
+
+	if _, err := Open(sealed, []byte("wrong horse")); err == nil {
+		t.Error("backup opened with a wrong passphrase")
+	}
+}
+
+func TestMismatchedShare(t *testing.T) {
+	b := newTestBackup(t)
+	// the share is of a wallet with one more signer
+	b.Wallet.Others = b.Wallet.Others[1:]
+
+	sealed, err := b.Seal([]byte("correct horse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(sealed, []byte("correct horse")); err == nil || !strings.Contains(err.Error(), "failed verification") {
+		t.Errorf("backup of a share for other signers opened: %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package backup
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strconv"
+	"strings"
+
+	"github.com/shykerbogdan/mpc-wallet/utils"
+)
+
+// The paper form writes each byte of a sealed backup as a word, in numbered lines of paperLineWords
+// words followed by a check word, so a typo when typing it back in is pinned to a single line.
+const (
+	paperHeader    = "THRESHER PAPER BACKUP v1"
+	paperLineWords = 8
+)
+
+var wordIndex = func() map[string]byte {
+	m := make(map[string]byte, len(utils.Words))
+	for i, w := range utils.Words {
+		m[w[:4]] = byte(i)
+	}
+	return m
+}()
+
+// Encode a sealed backup in the paper form, title is printed in the header for reference
+func PaperForm(sealed []byte, title string) string {
+	var sb strings.Builder
+
+	lines := (len(sealed) + paperLineWords - 1) / paperLineWords
+	fmt.Fprintf(&sb, "%s %s\n", paperHeader, title)
+	fmt.Fprintf(&sb, "This backup is %d bytes in %d lines. The last word of each line is a check word.\n", len(sealed), lines)
+	fmt.Fprintf(&sb, "Only the first four letters of each word are needed to restore it.\n\n")
+
+	for i := 0; i < lines; i++ {
+		end := (i + 1) * paperLineWords
+		if end > len(sealed) {
+			end = len(sealed)
+		}
+		chunk := sealed[i*paperLineWords : end]
+
+		fmt.Fprintf(&sb, "%04d", i+1)
+		for _, b := range chunk {
+			fmt.Fprintf(&sb, " %-8s", utils.Words[b])
+		}
+		fmt.Fprintf(&sb, " %s\n", utils.Words[lineCheck(i+1, chunk)])
+	}
+
+	return sb.String()
+}
+
+// Decode the paper form back into a sealed backup. Lines that do not start with a line number are ignored.
+func ParsePaperForm(text string) ([]byte, error) {
+	sealed := []byte{}
+	expected := 1
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		n, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		if n != expected {
+			return nil, fmt.Errorf("expected line %d but found line %d", expected, n)
+		}
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("line %d is too short", n)
+		}
+
+		chunk := []byte{}
+		for _, w := range fields[1:] {
+			b, ok := lookupWord(w)
+			if !ok {
+				return nil, fmt.Errorf("line %d: unknown word %q", n, w)
+			}
+			chunk = append(chunk, b)
+		}
+		check := chunk[len(chunk)-1]
+		chunk = chunk[:len(chunk)-1]
+		if lineCheck(n, chunk) != check {
+			return nil, fmt.Errorf("line %d: check word does not match, there is a typo in this line", n)
+		}
+
+		sealed = append(sealed, chunk...)
+		expected++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(sealed) == 0 {
+		return nil, errors.New("no backup lines found")
+	}
+	return sealed, nil
+}
+
+func lookupWord(w string) (byte, bool) {
+	w = strings.ToLower(w)
+	if len(w) < 4 {
+		return 0, false
+	}
+	b, ok := wordIndex[w[:4]]
+	return b, ok
+}
+
+// The line number is part of the check so swapped or repeated lines are caught too
+func lineCheck(n int, chunk []byte) byte {
+	return byte(crc32.ChecksumIEEE(append([]byte(strconv.Itoa(n)+":"), chunk...)))
+}
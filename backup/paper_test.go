@@ -0,0 +1,72 @@
+package backup
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/shykerbogdan/mpc-wallet/utils"
+)
+
+// Change the words of the first line of a paper form with f
+func editFirstLine(form string, f func(words []string)) string {
+	lines := strings.Split(form, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "0001 ") {
+			words := strings.Fields(line)
+			f(words[1:])
+			lines[i] = strings.Join(words, " ")
+			break
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestPaperForm(t *testing.T) {
+	sealed := []byte{}
+	for i := 0; i < 100; i++ {
+		sealed = append(sealed, byte(i*37))
+	}
+	form := PaperForm(sealed, "w1")
+
+	parsed, err := ParsePaperForm(form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(parsed, sealed) {
+		t.Fatal("paper form does not round trip")
+	}
+
+	// only the first four letters count
+	short := editFirstLine(form, func(words []string) {
+		for i, w := range words {
+			words[i] = strings.ToUpper(w[:4])
+		}
+	})
+	if parsed, err := ParsePaperForm(short); err != nil || !bytes.Equal(parsed, sealed) {
+		t.Errorf("abbreviated paper form: %v", err)
+	}
+
+	typo := editFirstLine(form, func(words []string) {
+		words[2] = editWord(t, words[2])
+	})
+	if _, err := ParsePaperForm(typo); err == nil || !strings.Contains(err.Error(), "line 1: check word does not match") {
+		t.Errorf("typo not caught: %v", err)
+	}
+
+	swap := editFirstLine(form, func(words []string) {
+		words[0], words[1] = words[1], words[0]
+	})
+	if _, err := ParsePaperForm(swap); err == nil || !strings.Contains(err.Error(), "line 1: check word does not match") {
+		t.Errorf("swapped words not caught: %v", err)
+	}
+}
+
+// Another valid word, as if the wrong one was typed
+func editWord(t *testing.T, w string) string {
+	b, ok := lookupWord(w)
+	if !ok {
+		t.Fatalf("unknown word %q", w)
+	}
+	return utils.Words[b+1]
+}
@@ -0,0 +1,139 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/shykerbogdan/mpc-wallet/addressbook"
+	"github.com/spf13/cobra"
+)
+
+func addressCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "address",
+		Short: "Manage the address book of known destinations",
+		Long: `
+The address book keeps labeled destinations, so transactions can be sent to a label instead of a
+pasted address. Sending to an address that is not in the address book shows a first-time destination
+warning to the proposer and to every co-signer before they approve.
+		`,
+	}
+
+	cmd.AddCommand(addressAddCommand())
+	cmd.AddCommand(addressListCommand())
+	cmd.AddCommand(addressRemoveCommand())
+
+	return cmd
+}
+
+func addressAddCommand() *cobra.Command {
+	var notes string
+	var allowPolicy bool
+	var walletname string
+
+	cmd := &cobra.Command{
+		Use:          "add <label> <address|ens-name>",
+		Short:        "Add a labeled address, or update the label of a known one",
+		Args:         cobra.ExactArgs(2),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			appConfig.MustExist()
+			label, target := args[0], args[1]
+
+			e := addressbook.Entry{Label: label, Address: target, Notes: notes, AllowedForPolicy: allowPolicy}
+			if addressbook.IsENSName(target) {
+				// ENS names are resolved through the blockchain connection of a wallet
+				w := appConfig.FindWallet(walletname)
+				if walletname == "" {
+					for _, other := range appConfig.Wallets {
+						w = other
+						break
+					}
+				}
+				if w == nil {
+					return exitErrorf(exitUsage, "no wallet to resolve ENS name %s with", target)
+				}
+				dest, err := addressbook.Book{}.Resolve(target, w.ResolveName)
+				if err != nil {
+					return withExitCode(exitFailure, err)
+				}
+				e.Address = dest.Address.Hex()
+				e.ENS = dest.ENS
+			}
+
+			if err := appConfig.AddAddress(e); err != nil {
+				return withExitCode(exitUsage, err)
+			}
+			fmt.Printf("Added %s %s\n", label, appConfig.AddressBook.FindLabel(label).Address)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&notes, "notes", "", "notes about the address")
+	cmd.Flags().BoolVar(&allowPolicy, "allow-policy", false, "allow spending policies to send to this address")
+	cmd.Flags().StringVar(&walletname, "wallet", "", "wallet whose blockchain connection resolves ENS names (default is any wallet)")
+
+	return cmd
+}
+
+func addressListCommand() *cobra.Command {
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:          "list",
+		Annotations:  readOnlyConfig,
+		Short:        "List the address book",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			appConfig.MustExist()
+
+			entries := appConfig.AddressBook
+			if entries == nil {
+				entries = addressbook.Book{}
+			}
+			var sb strings.Builder
+			for _, e := range entries {
+				label := e.Label
+				if label == "" {
+					label = "-"
+				}
+				fmt.Fprintf(&sb, "%-20s %s", label, e.Address)
+				if e.ENS != "" {
+					fmt.Fprintf(&sb, " %s", e.ENS)
+				}
+				if e.AllowedForPolicy {
+					sb.WriteString(" [policy]")
+				}
+				if e.Sends > 0 {
+					fmt.Fprintf(&sb, " sent %d times, last %s", e.Sends, e.LastSent.Format("2006-01-02 15:04"))
+				}
+				if e.Notes != "" {
+					fmt.Fprintf(&sb, " - %s", e.Notes)
+				}
+				sb.WriteString("\n")
+			}
+			return printResult(asJSON, entries, strings.TrimSuffix(sb.String(), "\n"))
+		},
+	}
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print the address book as JSON")
+
+	return cmd
+}
+
+func addressRemoveCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:          "remove <label|address>",
+		Short:        "Remove an address from the address book",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			appConfig.MustExist()
+
+			if err := appConfig.RemoveAddress(args[0]); err != nil {
+				return withExitCode(exitUsage, err)
+			}
+			fmt.Printf("Removed %s\n", args[0])
+			return nil
+		},
+	}
+}
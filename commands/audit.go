@@ -0,0 +1,120 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/shykerbogdan/mpc-wallet/audit"
+	"github.com/spf13/cobra"
+)
+
+func auditCommand() *cobra.Command {
+	var filename string
+	var project string
+
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Inspect the audit log of proposals, approvals, chat and protocol results",
+		Long: `
+Every wallet session records chat messages, proposals, approvals and rejections, generated wallets,
+signatures and broadcast transaction ids in an append-only audit log next to the config file.
+Each entry is chained to the one before it by hash and signed with your identity key, and the
+log is encrypted, so only the config it belongs to can read it.
+		`,
+	}
+
+	cmd.PersistentFlags().StringVar(&filename, "file", "", "audit log file (default is the config file name with an .audit extension)")
+	cmd.PersistentFlags().StringVar(&project, "project", "", "show the audit log of the room of another project")
+
+	auditFile := func() string {
+		if filename != "" {
+			return filename
+		}
+		if project != "" {
+			return appConfig.ProjectAuditFile(project)
+		}
+		return appConfig.AuditFile()
+	}
+
+	var asJSON bool
+	showCmd := &cobra.Command{
+		Use:         "show",
+		Annotations: readOnlyConfig,
+		Short:       "Print the audit log",
+		Args:        cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			appConfig.MustExist()
+
+			entries, err := audit.Read(auditFile(), appConfig.Me)
+			if err != nil {
+				return err
+			}
+
+			if asJSON {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(entries)
+			}
+
+			for _, e := range entries {
+				fmt.Println(formatAuditEntry(e))
+			}
+			return nil
+		},
+	}
+	showCmd.Flags().BoolVar(&asJSON, "json", false, "print the entries as JSON")
+	cmd.AddCommand(showCmd)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:         "verify",
+		Annotations: readOnlyConfig,
+		Short:       "Check the audit log has not been tampered with",
+		Args:        cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			appConfig.MustExist()
+
+			entries, err := audit.Read(auditFile(), appConfig.Me)
+			if err != nil {
+				return err
+			}
+			if err := audit.Verify(entries, appConfig.Me.IdentPubKey); err != nil {
+				return fmt.Errorf("audit log %s failed verification: %w", auditFile(), err)
+			}
+
+			fmt.Printf("Audit log %s is intact, %d entries\n", auditFile(), len(entries))
+			if len(entries) > 0 {
+				// Entries removed from the end can only be noticed by comparing against an earlier head
+				last := entries[len(entries)-1]
+				fmt.Printf("Head: entry %d %s\n", last.Seq, last.Hash)
+			}
+			return nil
+		},
+	})
+
+	return cmd
+}
+
+func formatAuditEntry(e audit.Entry) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%5d %s %-9s <%s>", e.Seq, e.Time.Format("2006-01-02 15:04:05"), e.Type, e.Actor)
+	if e.Wallet != "" {
+		fmt.Fprintf(&sb, " [%s]", e.Wallet)
+	}
+	if e.Message != "" {
+		fmt.Fprintf(&sb, " %s", e.Message)
+	}
+
+	keys := []string{}
+	for k := range e.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&sb, " %s=%s", k, e.Data[k])
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,160 @@
+package commands
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/shykerbogdan/mpc-wallet/backup"
+	"github.com/shykerbogdan/mpc-wallet/config"
+	"github.com/spf13/cobra"
+)
+
+func walletExportCommand() *cobra.Command {
+	var out string
+	var paper bool
+
+	cmd := &cobra.Command{
+		Use:         "export [wallet name]",
+		Annotations: readOnlyConfig,
+		Short:       "Write an encrypted backup of a wallet share",
+		Long: `
+Writes an encrypted backup of your share of a wallet, together with the signer roster, chain config
+and your identity, which is everything needed to restore it on a new machine with 'thresher wallet import'.
+
+With --paper the backup is written as numbered lines of words instead, which can be printed or written
+down and typed back in later. Keep the passphrase separate from the backup.
+		`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			appConfig.MustExist()
+			name := args[0]
+
+			b, err := backup.New(appConfig, name)
+			if err != nil {
+				return err
+			}
+
+			passphrase, err := getPassphrase("Backup passphrase: ", true)
+			if err != nil {
+				return err
+			}
+			sealed, err := b.Seal(passphrase)
+			if err != nil {
+				return err
+			}
+
+			data := sealed
+			if out == "" {
+				out = name + backup.FileExt
+			}
+			if paper {
+				if !c.Flags().Changed("out") {
+					out = name + "-paper.txt"
+				}
+				title := fmt.Sprintf("wallet %s %s (%s) signer %s", name, b.Wallet.Address, appConfig.Project, appConfig.Me.Nick)
+				data = []byte(backup.PaperForm(sealed, title))
+			}
+
+			if config.FileExists(out) {
+				return fmt.Errorf("%s already exists", out)
+			}
+			if err := ioutil.WriteFile(out, data, 0600); err != nil {
+				return err
+			}
+
+			fmt.Printf("Backup of wallet %s (%s) written to %s\n", name, b.Wallet.Address, out)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&out, "out", "o", "", "backup file (default is [wallet name].thbackup)")
+	cmd.Flags().BoolVar(&paper, "paper", false, "write the backup in the paper form")
+
+	return cmd
+}
+
+func walletImportCommand() *cobra.Command {
+	var paper bool
+	var name string
+
+	cmd := &cobra.Command{
+		Use:   "import [backup file]",
+		Short: "Restore a wallet share from a backup",
+		Long: `
+Restores a wallet share from a backup written by 'thresher wallet export'. The share is checked against
+the wallet address and the public shares of the other signers before it is added.
+
+If the config file does not exist yet, a new one is created for the identity in the backup, which is how
+a lost machine is replaced. Otherwise the backup must belong to the same identity as the config.
+		`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			data, err := ioutil.ReadFile(args[0])
+			if err != nil {
+				return err
+			}
+
+			sealed := data
+			if paper {
+				sealed, err = backup.ParsePaperForm(string(data))
+				if err != nil {
+					return err
+				}
+			}
+
+			passphrase, err := getPassphrase("Backup passphrase: ", false)
+			if err != nil {
+				return err
+			}
+			b, err := backup.Open(sealed, passphrase)
+			if err != nil {
+				return err
+			}
+			if name != "" {
+				b.Wallet.SetName(name)
+			}
+
+			filename, _ := c.Flags().GetString("config")
+			return restoreWallet(filename, b)
+		},
+	}
+
+	cmd.Flags().BoolVar(&paper, "paper", false, "the backup file is in the paper form")
+	cmd.Flags().StringVar(&name, "name", "", "restore the wallet under a different name")
+
+	return cmd
+}
+
+func restoreWallet(filename string, b *backup.Backup) error {
+	cfg := appConfig
+	if !cfg.IsLoaded() {
+		if filename == "" {
+			filename = fmt.Sprintf("%s-%s.json", b.Project, b.Me.Nick)
+		}
+		cfg = config.NewWithIdentity(b.Blockchain, b.Network, b.Project, b.Me)
+		if err := cfg.Save(filename); err != nil {
+			return err
+		}
+		fmt.Printf("Created config file %s for %s\n", cfg.CfgFile(), b.Me.Nick)
+	} else if cfg.Me.PartyID() != b.Me.PartyID() {
+		return fmt.Errorf("backup belongs to identity %s (%s), not to the identity of config %s", b.Me.Nick, b.Me.PeerID(), cfg.CfgFile())
+	}
+
+	if cfg.FindWallet(b.Wallet.Name) != nil {
+		return fmt.Errorf("wallet %s already exists, use --name to restore it under a different name", b.Wallet.Name)
+	}
+	// The wallet goes back to the room of the project it was backed up from
+	b.Wallet.Project = ""
+	if b.Project != cfg.Project {
+		if err := cfg.AddProject(b.Project); err != nil {
+			return err
+		}
+		b.Wallet.Project = b.Project
+	}
+	if err := cfg.AddWallet(b.Wallet); err != nil {
+		return err
+	}
+
+	fmt.Printf("Wallet %s (%s) restored, signers %v\n", b.Wallet.Name, b.Wallet.Address, b.Wallet.AllPartyNicks())
+	return nil
+}
@@ -0,0 +1,677 @@
+package commands
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/shykerbogdan/mpc-wallet/network/chat"
+	"github.com/shykerbogdan/mpc-wallet/protocols"
+	"github.com/shykerbogdan/mpc-wallet/user"
+	"github.com/shykerbogdan/mpc-wallet/utils"
+	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet"
+	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet/fees"
+	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet/units"
+	"github.com/spf13/cobra"
+)
+
+// The non-interactive commands join the room like a wallet session would, propose a single
+// operation to the other signers (who approve it in their wallet UI), wait for the result and exit.
+// The proposal expires after --wait if not enough signers approved it by then.
+type sessionOptions struct {
+	p2popts         chat.P2POptions
+	waitTimeout     time.Duration
+	protocolTimeout time.Duration
+}
+
+func (o *sessionOptions) addFlags(cmd *cobra.Command) {
+	addP2PFlags(cmd, &o.p2popts)
+	cmd.Flags().DurationVar(&o.waitTimeout, "wait", time.Minute*2, "how long to wait for the other signers to come online and approve")
+	cmd.Flags().DurationVar(&o.protocolTimeout, "protocol-timeout", time.Minute*10, "how long the other signers have to approve and complete the protocol")
+}
+
+// Join the room of a project and wait for the signers with the given nicks, returning all signers including
+// ourselves
+func (o *sessionOptions) start(c *cobra.Command, project string, nicks []string) (*chat.ChatRoom, []user.User, error) {
+	logFileName, _ := c.Flags().GetString("log")
+	setLogOutput(logFileName)
+
+	others := []string{}
+	for _, nick := range nicks {
+		if nick != appConfig.Me.Nick {
+			others = append(others, nick)
+		}
+	}
+
+	room := joinChatRoom(appConfig, project, o.p2popts)
+	room.DrainEvents()
+
+	users, err := room.WaitForParticipants(others, o.waitTimeout)
+	if err != nil {
+		room.Exit()
+		return nil, nil, withExitCode(exitSignersUnavailable, err)
+	}
+
+	return room, append([]user.User{appConfig.Me.User}, users...), nil
+}
+
+// Run a proposal, giving up after the protocol timeout
+func (o *sessionOptions) run(fn func() error) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- proposalExitCode(fn())
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-time.After(o.protocolTimeout):
+		return exitErrorf(exitProtocolFailed, "protocol did not complete within %s", o.protocolTimeout)
+	}
+}
+
+// Resolve the nicks of signers of the wallet, which need not be online yet, all signers if nicks is empty
+func walletSigners(w *ethwallet.Wallet, nicks []string) ([]user.User, error) {
+	all := append([]user.User{w.Me}, w.Others...)
+	if len(nicks) == 0 {
+		return all, nil
+	}
+
+	signers := []user.User{w.Me}
+	for _, nick := range nicks {
+		if nick == w.Me.Nick {
+			continue
+		}
+		found := false
+		for _, u := range all {
+			if u.Nick == nick {
+				signers = append(signers, u)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%s is not a signer of wallet %s", nick, w.Name)
+		}
+	}
+	return signers, nil
+}
+
+// Print v as JSON if asJSON is set, otherwise print the text
+func printResult(asJSON bool, v interface{}, text string) error {
+	if !asJSON {
+		fmt.Println(text)
+		return nil
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+type walletInfo struct {
+	Name        string    `json:"name"`
+	Address     string    `json:"address"`
+	Threshold   int       `json:"threshold"`
+	Required    int       `json:"required"`
+	Signers     []string  `json:"signers"`
+	Network     string    `json:"network"`
+	Project     string    `json:"project"`
+	CreatedAt   time.Time `json:"created"`
+	Fingerprint string    `json:"fingerprint"`
+	Unconfirmed bool      `json:"unconfirmed,omitempty"`
+	Balance     string    `json:"balance,omitempty"`
+}
+
+func newWalletInfo(w *ethwallet.Wallet) walletInfo {
+	return walletInfo{
+		Name:      w.Name,
+		Address:   w.Address,
+		Threshold: w.Threshold,
+		Required:  w.Threshold + 1,
+		Signers:   w.AllPartyNicks(),
+		Network:   w.Config.NetworkName,
+		Project:   appConfig.WalletProject(w),
+		CreatedAt: w.CreatedAt,
+		// For the signers to compare out-of-band
+		Fingerprint: protocols.Fingerprint(w),
+		Unconfirmed: w.Unconfirmed,
+	}
+}
+
+func (wi walletInfo) String() string {
+	s := fmt.Sprintf("%s %s %d-of-%d signers %v", wi.Name, wi.Address, wi.Required, len(wi.Signers), wi.Signers)
+	if wi.Project != appConfig.Project {
+		s += fmt.Sprintf(" project %s", wi.Project)
+	}
+	if wi.Unconfirmed {
+		s += " unconfirmed"
+	}
+	if wi.Balance != "" {
+		s += fmt.Sprintf(" balance %s wei", wi.Balance)
+	}
+	return s
+}
+
+func findWallet(name string) (*ethwallet.Wallet, error) {
+	w := appConfig.FindWallet(name)
+	if w == nil {
+		return nil, fmt.Errorf("wallet %s not found", name)
+	}
+	return w, nil
+}
+
+func walletListCommand() *cobra.Command {
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:          "list",
+		Annotations:  readOnlyConfig,
+		Short:        "List the wallets in the config",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			appConfig.MustExist()
+
+			infos := []walletInfo{}
+			text := ""
+			for _, name := range appConfig.SortedWalletNames() {
+				wi := newWalletInfo(appConfig.FindWallet(name))
+				infos = append(infos, wi)
+				text += wi.String() + "\n"
+			}
+			if len(infos) == 0 {
+				text = "No wallets yet\n"
+			}
+
+			return printResult(asJSON, infos, text[:len(text)-1])
+		},
+	}
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print JSON")
+
+	return cmd
+}
+
+func walletShowCommand() *cobra.Command {
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:          "show [wallet name]",
+		Annotations:  readOnlyConfig,
+		Short:        "Show the details of a wallet",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			appConfig.MustExist()
+
+			w, err := findWallet(args[0])
+			if err != nil {
+				return err
+			}
+			wi := newWalletInfo(w)
+
+			text := fmt.Sprintf("Name: %s\nAddress: %s\nSigners: %v (%d of %d)\nNetwork: %s\nProject: %s\nCreated: %s",
+				wi.Name, wi.Address, wi.Signers, wi.Required, len(wi.Signers), wi.Network, wi.Project, wi.CreatedAt.Format(time.RFC3339))
+			return printResult(asJSON, wi, text)
+		},
+	}
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print JSON")
+
+	return cmd
+}
+
+func walletBalanceCommand() *cobra.Command {
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:          "balance [wallet name]",
+		Annotations:  readOnlyConfig,
+		Short:        "Fetch the balance of a wallet, or of all wallets",
+		Args:         cobra.MaximumNArgs(1),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			appConfig.MustExist()
+
+			names := appConfig.SortedWalletNames()
+			if len(args) == 1 {
+				names = args
+			}
+
+			infos := []walletInfo{}
+			text := ""
+			for _, name := range names {
+				w, err := findWallet(name)
+				if err != nil {
+					return err
+				}
+				if err := w.FetchBalance(); err != nil {
+					return fmt.Errorf("error fetching balance of wallet %s: %w", name, err)
+				}
+				wi := newWalletInfo(w)
+				wi.Balance = w.Balance().String()
+				infos = append(infos, wi)
+				text += fmt.Sprintf("%s %s %s\n", wi.Name, wi.Address, units.FormatEther(w.Balance()))
+			}
+			if len(infos) == 0 {
+				text = "No wallets yet\n"
+			}
+
+			return printResult(asJSON, infos, text[:len(text)-1])
+		},
+	}
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print JSON")
+
+	return cmd
+}
+
+func walletFeesCommand() *cobra.Command {
+	var fee string
+	var maxGasPrice string
+	var gasMultiplier float64
+	var maxFee string
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:          "fees [wallet name]",
+		Short:        "Show or set the fee strategy and maximum fee of a wallet",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			appConfig.MustExist()
+
+			w, err := findWallet(args[0])
+			if err != nil {
+				return withExitCode(exitUsage, err)
+			}
+
+			strategy, err := w.Fees.Override(fee)
+			if err != nil {
+				return withExitCode(exitUsage, err)
+			}
+			if c.Flags().Changed("max-gas-price") {
+				strategy.MaxGasPrice = nil
+				if maxGasPrice != "none" {
+					if strategy.MaxGasPrice, err = units.Parse(maxGasPrice, units.Gwei); err != nil {
+						return exitErrorf(exitUsage, "invalid maximum gas price %s: %v", maxGasPrice, err)
+					}
+				}
+			}
+			if c.Flags().Changed("gas-multiplier") {
+				strategy.GasLimitMultiplier = gasMultiplier
+			}
+			max := w.MaxFee
+			if c.Flags().Changed("max-fee") {
+				max = nil
+				if maxFee != "none" {
+					if max, err = units.Parse(maxFee, units.Wei); err != nil {
+						return exitErrorf(exitUsage, "invalid maximum fee %s: %v", maxFee, err)
+					}
+				}
+			}
+			set := false
+			for _, name := range []string{"fee", "max-gas-price", "gas-multiplier", "max-fee"} {
+				set = set || c.Flags().Changed(name)
+			}
+			if set {
+				if err := appConfig.SetWalletFees(w.Name, strategy, max); err != nil {
+					return withExitCode(exitUsage, err)
+				}
+			}
+
+			result := map[string]interface{}{"wallet": w.Name, "strategy": strategy}
+			text := fmt.Sprintf("Fees: %s\nMaximum fee: ", strategy)
+			if max != nil {
+				result["maxFee"] = max.String()
+				text += units.FormatEther(max)
+			} else {
+				text += "none"
+			}
+			return printResult(asJSON, result, text)
+		},
+	}
+	cmd.Flags().StringVar(&fee, "fee", "", "slow, normal or fast, or a fixed gas price like 30gwei")
+	cmd.Flags().StringVar(&maxGasPrice, "max-gas-price", "", "cap the gas price, e.g. 100gwei, plain numbers are gwei, none removes the cap")
+	cmd.Flags().Float64Var(&gasMultiplier, "gas-multiplier", fees.DefaultGasLimitMultiplier, "multiplier of gas limit estimates")
+	cmd.Flags().StringVar(&maxFee, "max-fee", "", "most a transaction may pay in fees, e.g. 0.01eth, plain numbers are wei, none removes the maximum")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print JSON")
+
+	return cmd
+}
+
+func keygenCommand() *cobra.Command {
+	var opts sessionOptions
+	var name string
+	var project string
+	var threshold int
+	var signerNicks []string
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "keygen",
+		Short: "Generate a new mpc wallet with other signers without the wallet UI",
+		Long: `
+Joins the project room, waits for the signers to come online, proposes the new wallet and runs keygen.
+The other signers approve the proposal in their wallet session.
+		`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			appConfig.MustExist()
+
+			if name == "" {
+				return withExitCode(exitUsage, errors.New("--name is required"))
+			}
+			if appConfig.FindWallet(name) != nil {
+				return fmt.Errorf("wallet %s already exists", name)
+			}
+			if project == "" {
+				project = appConfig.Project
+			}
+			if !appConfig.HasProject(project) {
+				return exitErrorf(exitUsage, "not taking part in project %s, add it with 'thresher project add %s'", project, project)
+			}
+
+			room, signers, err := opts.start(c, project, signerNicks)
+			if err != nil {
+				return err
+			}
+			defer room.Exit()
+
+			if (threshold <= 0) || (threshold > len(signers)-1) {
+				return withExitCode(exitUsage, errors.New("threshold must be less than total signers"))
+			}
+
+			var w *ethwallet.Wallet
+			err = opts.run(func() error {
+				w, err = room.ProposeKeygen(name, threshold, signers, opts.waitTimeout)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+
+			wi := newWalletInfo(w)
+			return printResult(asJSON, wi, fmt.Sprintf("Wallet '%s' has been generated with address %s and fingerprint %s", name, wi.Address, wi.Fingerprint))
+		},
+	}
+
+	cmd.Flags().StringVar(&name, "name", "", "name of the new wallet")
+	cmd.Flags().StringVar(&project, "project", "", "project room the wallet belongs to (default is the project of the config)")
+	cmd.Flags().IntVar(&threshold, "threshold", 1, "maximum amount of parties corrupted, the wallet needs threshold+1 signers")
+	cmd.Flags().StringSliceVar(&signerNicks, "signers", []string{}, "nicks of the other signers")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print JSON")
+	opts.addFlags(cmd)
+
+	return cmd
+}
+
+func sendCommand() *cobra.Command {
+	var opts sessionOptions
+	var walletname string
+	var to string
+	var amount string
+	var memo string
+	var datahex string
+	var force bool
+	var fee string
+	var maxGasPrice string
+	var gasMultiplier float64
+	var signerNicks []string
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:          "send",
+		Short:        "Sign and broadcast a transaction with other signers without the wallet UI",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			appConfig.MustExist()
+
+			w, err := findWallet(walletname)
+			if err != nil {
+				return withExitCode(exitUsage, err)
+			}
+			dest, err := appConfig.AddressBook.Resolve(to, w.ResolveName)
+			if err != nil {
+				return withExitCode(exitUsage, err)
+			}
+			if dest.FirstTime {
+				fmt.Fprintf(os.Stderr, "Warning: %s is a first-time destination, it is not in the address book\n", dest.Address.Hex())
+			}
+			wei, err := units.ParseWithUnit(amount)
+			if err != nil {
+				return withExitCode(exitUsage, err)
+			}
+			data := []byte{}
+			if datahex != "" {
+				if data, err = hexutil.Decode(datahex); err != nil {
+					return exitErrorf(exitUsage, "invalid data %s: %v", datahex, err)
+				}
+			}
+
+			// The flags override the fee strategy of the wallet
+			strategy, err := w.Fees.Override(fee)
+			if err != nil {
+				return withExitCode(exitUsage, err)
+			}
+			if maxGasPrice != "" {
+				if strategy.MaxGasPrice, err = units.Parse(maxGasPrice, units.Gwei); err != nil {
+					return exitErrorf(exitUsage, "invalid maximum gas price %s: %v", maxGasPrice, err)
+				}
+			}
+			if c.Flags().Changed("gas-multiplier") {
+				strategy.GasLimitMultiplier = gasMultiplier
+			}
+			if err := strategy.Validate(); err != nil {
+				return withExitCode(exitUsage, err)
+			}
+
+			// Simulate before bothering the other signers, the proposal simulates again when it is made
+			tx, preview, err := w.CreateSimulatedTransaction(&dest.Address, wei, data, strategy)
+			if err != nil {
+				return withExitCode(exitFailure, err)
+			}
+			fmt.Fprintln(os.Stderr, preview)
+			if preview.Reverted && !force {
+				return withExitCode(exitSimulationReverted, fmt.Errorf("%w: %s, use --force to send it anyway", chat.ErrSimulationReverted, preview.RevertReason))
+			}
+			if err := w.CheckMaxFee(tx); err != nil {
+				return withExitCode(exitUsage, err)
+			}
+
+			signers, err := walletSigners(w, signerNicks)
+			if err != nil {
+				return withExitCode(exitUsage, err)
+			}
+			if len(signers) <= w.Threshold {
+				return exitErrorf(exitUsage, "wallet threshold requires at least %v signers", w.Threshold+1)
+			}
+
+			room, _, err := opts.start(c, appConfig.WalletProject(w), nil)
+			if err != nil {
+				return err
+			}
+			defer room.Exit()
+
+			var txid string
+			err = opts.run(func() error {
+				req := chat.SendTxRequest{Wallet: walletname, Dest: dest, Amount: wei, Data: data, Memo: memo, Fees: &strategy, Force: force}
+				txid, err = room.ProposeSendTx(req, signers, opts.waitTimeout)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+
+			result := map[string]interface{}{"wallet": walletname, "txid": txid, "to": dest.Address.Hex(), "amount": wei.String()}
+			if dest.Label != "" {
+				result["label"] = dest.Label
+			}
+			if dest.ENS != "" {
+				result["ens"] = dest.ENS
+			}
+			return printResult(asJSON, result, fmt.Sprintf("Transaction %s sent to %s", txid, dest))
+		},
+	}
+
+	cmd.Flags().StringVar(&walletname, "wallet", "", "name of the wallet to send from")
+	cmd.Flags().StringVar(&to, "to", "", "destination address, ENS name or address book label")
+	cmd.Flags().StringVar(&amount, "amount", "", "amount with a unit, e.g. 1.5eth, 20gwei or 1000wei")
+	cmd.Flags().StringVar(&memo, "memo", "", "memo shown to the other signers")
+	cmd.Flags().StringVar(&datahex, "data", "", "hex calldata of a contract call")
+	cmd.Flags().BoolVar(&force, "force", false, "propose the transaction even if it reverts in simulation")
+	cmd.Flags().StringVar(&fee, "fee", "", "slow, normal or fast, or a fixed gas price like 30gwei (default: the fee strategy of the wallet)")
+	cmd.Flags().StringVar(&maxGasPrice, "max-gas-price", "", "cap the gas price, e.g. 100gwei, plain numbers are gwei")
+	cmd.Flags().Float64Var(&gasMultiplier, "gas-multiplier", fees.DefaultGasLimitMultiplier, "multiplier of the gas limit estimate")
+	cmd.Flags().StringSliceVar(&signerNicks, "signers", []string{}, "nicks of the other signers that may approve (default: all signers of the wallet)")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print JSON")
+	opts.addFlags(cmd)
+
+	return cmd
+}
+
+func signMessageCommand() *cobra.Command {
+	var opts sessionOptions
+	var walletname string
+	var signerNicks []string
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:          "sign-message [message]",
+		Short:        "Sign a text message with other signers without the wallet UI",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			appConfig.MustExist()
+			message := args[0]
+
+			w, err := findWallet(walletname)
+			if err != nil {
+				return withExitCode(exitUsage, err)
+			}
+
+			signers, err := walletSigners(w, signerNicks)
+			if err != nil {
+				return withExitCode(exitUsage, err)
+			}
+			if len(signers) <= w.Threshold {
+				return exitErrorf(exitUsage, "wallet threshold requires at least %v signers", w.Threshold+1)
+			}
+
+			room, _, err := opts.start(c, appConfig.WalletProject(w), nil)
+			if err != nil {
+				return err
+			}
+			defer room.Exit()
+
+			var ethsig []byte
+			err = opts.run(func() error {
+				ethsig, err = room.ProposeSign(walletname, message, signers, opts.waitTimeout)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+
+			result := map[string]string{
+				"wallet":    walletname,
+				"address":   w.Address,
+				"message":   message,
+				"hash":      "0x" + hex.EncodeToString(utils.DigestAvaMsg(message)),
+				"signature": "0x" + hex.EncodeToString(ethsig),
+			}
+			return printResult(asJSON, result, fmt.Sprintf("Signature: %s", result["signature"]))
+		},
+	}
+
+	cmd.Flags().StringVar(&walletname, "wallet", "", "name of the wallet to sign with")
+	cmd.Flags().StringSliceVar(&signerNicks, "signers", []string{}, "nicks of the other signers that may approve (default: all signers of the wallet)")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print JSON")
+	opts.addFlags(cmd)
+
+	return cmd
+}
+
+func reshareCommand() *cobra.Command {
+	var opts sessionOptions
+	var walletname string
+	var threshold int
+	var signerNicks []string
+	var dealerNicks []string
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "reshare",
+		Short: "Move a wallet to a new set of signers and threshold, keeping its address",
+		Long: `
+Moves the key of a wallet to a new set of signers and/or a new threshold without changing its address,
+to add, remove or replace a signer. --signers is the complete new set of signers, which may include new
+parties that do not hold the wallet yet. At least threshold+1 of the current signers deal their shares,
+by default the current signers that stay on. Everyone else approves the proposal in their wallet session.
+
+Current signers that are not in the new set have their share removed if they take part. The old shares
+of signers that are offline still work together with each other, so only rely on the new signer set once
+every removed signer has either taken part or lost their share.
+		`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			appConfig.MustExist()
+
+			w, err := findWallet(walletname)
+			if err != nil {
+				return withExitCode(exitUsage, err)
+			}
+			if (threshold <= 0) || (threshold > len(signerNicks)-1) {
+				return withExitCode(exitUsage, errors.New("threshold must be less than total signers"))
+			}
+
+			room, users, err := opts.start(c, appConfig.WalletProject(w), append(append([]string{}, signerNicks...), dealerNicks...))
+			if err != nil {
+				return err
+			}
+			defer room.Exit()
+
+			byNick := make(map[string]user.User)
+			for _, u := range users {
+				byNick[u.Nick] = u
+			}
+			signers := []user.User{}
+			for _, nick := range signerNicks {
+				signers = append(signers, byNick[nick])
+			}
+			var dealers []user.User
+			for _, nick := range dealerNicks {
+				dealers = append(dealers, byNick[nick])
+			}
+
+			var next *ethwallet.Wallet
+			err = opts.run(func() error {
+				next, err = room.ProposeReshare(walletname, threshold, signers, dealers, opts.waitTimeout)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+
+			wi := newWalletInfo(w)
+			text := fmt.Sprintf("Wallet '%s' has been reshared and your share removed", walletname)
+			if next != nil {
+				wi = newWalletInfo(next)
+				text = fmt.Sprintf("Wallet '%s' has been reshared, %d of %v signers %v", walletname, wi.Required, len(wi.Signers), wi.Signers)
+			}
+			return printResult(asJSON, wi, text)
+		},
+	}
+
+	cmd.Flags().StringVar(&walletname, "wallet", "", "name of the wallet to reshare")
+	cmd.Flags().IntVar(&threshold, "threshold", 1, "new maximum amount of parties corrupted, the wallet will need threshold+1 signers")
+	cmd.Flags().StringSliceVar(&signerNicks, "signers", []string{}, "nicks of all the new signers, including yourself if you stay on")
+	cmd.Flags().StringSliceVar(&dealerNicks, "dealers", []string{}, "nicks of the current signers dealing their shares (default: yourself and the current signers that stay on)")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print JSON")
+	opts.addFlags(cmd)
+
+	return cmd
+}
@@ -0,0 +1,68 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/shykerbogdan/mpc-wallet/network/chat"
+	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet/fees"
+)
+
+// Exit codes of thresher, so scripts can tell why a command failed
+const (
+	exitOK                 = 0
+	exitFailure            = 1
+	exitUsage              = 2
+	exitSignersUnavailable = 3
+	exitProtocolFailed     = 4
+	exitBroadcastFailed    = 5
+	exitRejected           = 6
+	exitSimulationReverted = 7
+)
+
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+// Make the command exit with code when it returns err
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitError{code: code, err: err}
+}
+
+func exitErrorf(code int, format string, a ...interface{}) error {
+	return withExitCode(code, fmt.Errorf(format, a...))
+}
+
+// The exit code for the error of running a proposal
+func proposalExitCode(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, chat.ErrProposalRejected):
+		return withExitCode(exitRejected, err)
+	case errors.Is(err, chat.ErrProposalExpired):
+		return withExitCode(exitSignersUnavailable, err)
+	case errors.Is(err, chat.ErrBroadcastFailed):
+		return withExitCode(exitBroadcastFailed, err)
+	case errors.Is(err, chat.ErrSimulationReverted):
+		return withExitCode(exitSimulationReverted, err)
+	case errors.Is(err, fees.ErrFeeTooHigh):
+		return withExitCode(exitUsage, err)
+	case errors.Is(err, chat.ErrInsufficientFunds):
+		return withExitCode(exitFailure, err)
+	default:
+		return withExitCode(exitProtocolFailed, err)
+	}
+}
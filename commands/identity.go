@@ -0,0 +1,129 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	crypto_pb "github.com/libp2p/go-libp2p-core/crypto/pb"
+	"github.com/shykerbogdan/mpc-wallet/audit"
+	"github.com/shykerbogdan/mpc-wallet/user"
+
+	"github.com/spf13/cobra"
+)
+
+func identityCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "identity",
+		Short: "Show or upgrade your identity key",
+		Long: `
+Your identity key identifies you to the other signers and signs your approvals and audit log. New
+identities are Ed25519 keys, identities created by earlier versions are 2048-bit RSA keys, which
+'identity upgrade' replaces with an Ed25519 key.
+
+Your party id, which the wallets know you by, stays the same. The previous key signs the new one,
+and the other signers take the new key when they next see you in the room. Give signers who only
+take part offline a new identity file, see 'offline identity'.
+		`,
+	}
+
+	cmd.AddCommand(identityShowCommand())
+	cmd.AddCommand(identityUpgradeCommand())
+
+	return cmd
+}
+
+func identityShowCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:          "show",
+		Annotations:  readOnlyConfig,
+		Short:        "Show your identity",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			appConfig.MustExist()
+
+			me := appConfig.Me
+			fmt.Printf("Nick: %s\n", me.Nick)
+			fmt.Printf("PeerID: %s\n", me.PeerID())
+			fmt.Printf("Key type: %s\n", me.IdentPubKey.Type())
+			fmt.Printf("PartyID: %s\n", me.PartyID())
+			if me.PreviousIdentPubKey != nil {
+				fmt.Printf("Replaces key of type %s\n", me.PreviousIdentPubKey.Type())
+			}
+			return nil
+		},
+	}
+}
+
+func identityUpgradeCommand() *cobra.Command {
+	var force bool
+
+	cmd := &cobra.Command{
+		Use:          "upgrade",
+		Short:        "Replace your identity key with a new Ed25519 key, keeping your party id",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			appConfig.MustExist()
+
+			previous := appConfig.Me
+			if !isRSAIdentity(previous.User) && !force {
+				return fmt.Errorf("identity key already is %s, use --force to replace it anyway", previous.IdentPubKey.Type())
+			}
+
+			me := previous
+			if err := me.RotateIdentity(); err != nil {
+				return err
+			}
+
+			// The audit logs are encrypted and signed with the identity key, move them to the new one first
+			rekeyed := []string{}
+			undo := func() {
+				for _, f := range rekeyed {
+					if err := audit.Rekey(f, me, previous); err != nil {
+						fmt.Fprintf(os.Stderr, "Error moving audit log %s back to the previous identity key: %v\n", f, err)
+					}
+				}
+			}
+			for _, project := range appConfig.ProjectNames() {
+				f := appConfig.ProjectAuditFile(project)
+				if err := audit.Rekey(f, previous, me); err != nil {
+					undo()
+					return fmt.Errorf("error moving audit log %s to the new identity key: %w", f, err)
+				}
+				rekeyed = append(rekeyed, f)
+			}
+
+			if err := appConfig.SetIdentity(me); err != nil {
+				undo()
+				return err
+			}
+
+			for _, f := range rekeyed {
+				l, err := audit.Open(f, me)
+				if err == nil {
+					err = l.Append(audit.EventIdentity, me.Nick, "", "replaced identity key", map[string]string{
+						"peerid":   me.PeerID().Pretty(),
+						"previous": previous.PeerID().Pretty(),
+					})
+				}
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error writing to audit log %s: %v\n", f, err)
+				}
+			}
+
+			fmt.Printf("Identity key replaced, the new key is %s\n", me.IdentPubKey.Type())
+			fmt.Printf("PeerID: %s (was %s)\n", me.PeerID(), previous.PeerID())
+			fmt.Printf("PartyID: %s\n", me.PartyID())
+			fmt.Println("The other signers take the new key when they next see you in the room.")
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&force, "force", false, "replace an identity key which is not RSA")
+
+	return cmd
+}
+
+func isRSAIdentity(u user.User) bool {
+	return u.IdentPubKey.Type() == crypto_pb.KeyType_RSA
+}
@@ -9,6 +9,8 @@ import (
 )
 
 func initCommand() *cobra.Command {
+	var relayURL string
+
 	cmd := &cobra.Command{
 		Use:   "init [blockchain network project nick address]",
 		Short: "Initialize a new project config (default filename is ./[project]-[nick].json)",
@@ -19,6 +21,9 @@ network:    'mainnet' or 'fuji'
 project:    The name of your project, e.g. 'DAOTreasury'
 nick:       Your nickname in the chat, e.g. 'PrezCamacho'
 address:    Your goerli X chain address, e.g. X-fuji1xv3653....
+
+Use --relay to talk to the other signers through a thresher relay server instead of libp2p,
+e.g. when libp2p can not get through a corporate firewall (see 'thresher help relay').
 `,
 		Args: cobra.ExactArgs(5),
 		RunE: func(c *cobra.Command, args []string) error {
@@ -26,19 +31,26 @@ address:    Your goerli X chain address, e.g. X-fuji1xv3653....
 			if filename == "" {
 				filename = fmt.Sprintf("%s-%s.json", args[2], args[3])
 			}
-			return initProjectConfig(filename, args[0], args[1], args[2], args[3], args[4])
+			return initProjectConfig(filename, args[0], args[1], args[2], args[3], args[4], relayURL)
 		},
 	}
 
+	cmd.Flags().StringVar(&relayURL, "relay", "", "relay server url, e.g. wss://relay.example.com/ws")
+
 	return cmd
 }
 
-func initProjectConfig(filename string, blockchain string, network string, project string, nick string, address string) error {
+func initProjectConfig(filename string, blockchain string, network string, project string, nick string, address string, relayURL string) error {
 	cfg, err := config.New(blockchain, network, project, nick, address)
 	if err != nil {
 		return err
 	}
 
+	if relayURL != "" {
+		cfg.P2PNetwork = config.P2PNetworkRelay
+		cfg.RelayURL = relayURL
+	}
+
 	err = cfg.Save(filename)
 	if err != nil {
 		return err
@@ -47,18 +59,3 @@ func initProjectConfig(filename string, blockchain string, network string, proje
 	fmt.Printf("New project created with config file: %s \n", cfg.CfgFile())
 	return nil
 }
-
-// func getPassPhrase(prompt string, confirmation bool) string {
-// 	fmt.Print(prompt)
-// 	var password string
-// 	fmt.Scanln(&password)
-// 	if confirmation {
-// 		fmt.Print("Repeat password: ")
-// 		var confirmationPassword string
-// 		fmt.Scanln(&confirmationPassword)
-// 		if password != confirmationPassword {
-// 			utils.Fatalf("Password should match")
-// 		}
-// 	}
-// 	return password
-// }
@@ -0,0 +1,207 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shykerbogdan/mpc-wallet/config"
+	"github.com/shykerbogdan/mpc-wallet/invite"
+	"github.com/shykerbogdan/mpc-wallet/network/chat"
+	"github.com/shykerbogdan/mpc-wallet/user"
+
+	"github.com/spf13/cobra"
+)
+
+func inviteCommand() *cobra.Command {
+	var project string
+	var bootstrapaddrs []string
+	var ttl time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "invite [nick]",
+		Short: "Invite a new signer to the room of a project",
+		Long: `
+Print an invitation token for a new signer, who joins with 'thresher join <token>'. The token is
+signed with your identity key and holds everything needed to find the room: the project, the
+network, the bootstrap addrs and the room key. Hand it over privately, whoever has it can read
+the room until it expires.
+
+Once the new signer is in the room you are asked to confirm them, which registers them as a member
+with everyone in the room. Each invitation can be used once, by the given nick if there is one.
+
+The first invitation to a project creates its room key, and the room moves to a name only those
+with the key can find. Invite the existing members of the project as well, so they follow.
+		`,
+		Args:         cobra.MaximumNArgs(1),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			appConfig.MustExist()
+
+			if project == "" {
+				project = appConfig.Project
+			}
+			if !appConfig.HasProject(project) {
+				return withExitCode(exitUsage, fmt.Errorf("not taking part in project %s", project))
+			}
+			nick := ""
+			if len(args) > 0 {
+				nick = args[0]
+			}
+			if len(bootstrapaddrs) == 0 {
+				bootstrapaddrs = appConfig.BootstrapAddrs
+			}
+
+			roomKey := appConfig.RoomKey(project)
+			if roomKey == nil {
+				key, err := invite.NewRoomKey()
+				if err != nil {
+					return err
+				}
+				if err := appConfig.SetRoomKey(project, key); err != nil {
+					return err
+				}
+				roomKey = key
+				fmt.Printf("Created a room key for project %s, invite its existing members as well.\n", project)
+			}
+
+			inv, err := invite.New(appConfig.Me, project, appConfig.Blockchain, appConfig.Network, appConfig.P2PNetwork, appConfig.RelayURL, bootstrapaddrs, roomKey, nick, ttl)
+			if err != nil {
+				return err
+			}
+			token, err := inv.Encode()
+			if err != nil {
+				return err
+			}
+			if err := appConfig.AddInvitation(inv.Issued()); err != nil {
+				return err
+			}
+
+			fmt.Printf("Invitation %s to project %s, expires %s:\n\n%s\n\n", inv.ID, project, inv.Expires.Local().Format(time.RFC1123), token)
+			fmt.Println("Hand it over privately. The new signer joins with: thresher join <token> <nick> <address>")
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&project, "project", "", "project to invite to (default is the project of the config)")
+	cmd.Flags().StringSliceVar(&bootstrapaddrs, "bootstrap", []string{}, "bootstrap addrs for the new signer (default is those of the config)")
+	cmd.Flags().DurationVar(&ttl, "ttl", invite.DefaultTTL, "how long the invitation can be used")
+
+	return cmd
+}
+
+func joinCommand() *cobra.Command {
+	var p2popts chat.P2POptions
+	var noConnect bool
+
+	cmd := &cobra.Command{
+		Use:   "join <token> <nick> <address>",
+		Short: "Join the room of a project with an invitation",
+		Long: `
+Join a project with an invitation token from one of its members, see 'thresher help invite'.
+
+Without a config file this creates one for the project (default filename is ./[project]-[nick].json).
+With one, the project is added to it, which must be on the same blockchain, network and P2P network.
+
+Then the wallet session starts and asks the inviter to admit you, which they confirm in theirs.
+		`,
+		Args:         cobra.ExactArgs(3),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			token, nick, address := args[0], args[1], args[2]
+
+			inv, err := invite.Decode(token)
+			if err != nil {
+				return withExitCode(exitUsage, err)
+			}
+			if err := inv.Verify(time.Now()); err != nil {
+				return withExitCode(exitUsage, err)
+			}
+			if !inv.Admits(nick) {
+				return withExitCode(exitUsage, fmt.Errorf("invitation is for %s, not %s", inv.Nick, nick))
+			}
+
+			cfg := appConfig
+			if !cfg.IsLoaded() {
+				filename, _ := c.Flags().GetString("config")
+				if filename == "" {
+					filename = fmt.Sprintf("%s-%s.json", inv.Project, nick)
+				}
+				if config.FileExists(filename) {
+					return fmt.Errorf("config file %s already exists, join with -c %s to add the project to it", filename, filename)
+				}
+				cfg, err = newJoinConfig(filename, inv, nick, address)
+				if err != nil {
+					return err
+				}
+			} else if err := checkJoinConfig(cfg, inv, nick); err != nil {
+				return err
+			}
+
+			if err := joinProject(cfg, inv, token); err != nil {
+				return err
+			}
+			fmt.Printf("Joined project %s with config file %s, waiting for %s to admit you\n", inv.Project, cfg.CfgFile(), inv.Inviter.Nick)
+
+			if noConnect {
+				return nil
+			}
+			logFileName, _ := c.Flags().GetString("log")
+			setLogOutput(logFileName)
+			runChatCmd(cfg, p2popts)
+			return nil
+		},
+	}
+
+	addP2PFlags(cmd, &p2popts)
+	cmd.Flags().BoolVar(&noConnect, "no-connect", false, "only set up the config, ask to be admitted in the next wallet session")
+
+	return cmd
+}
+
+// Create the config of a new signer from an invitation
+func newJoinConfig(filename string, inv *invite.Invitation, nick string, address string) (*config.AppConfig, error) {
+	cfg, err := config.New(inv.Blockchain, inv.Network, inv.Project, nick, address)
+	if err != nil {
+		return nil, err
+	}
+	cfg.P2PNetwork = inv.P2PNetwork
+	cfg.RelayURL = inv.RelayURL
+	cfg.BootstrapAddrs = inv.BootstrapAddrs
+
+	if err := cfg.Save(filename); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// An existing config can only join projects it can talk to
+func checkJoinConfig(cfg *config.AppConfig, inv *invite.Invitation, nick string) error {
+	if cfg.Me.Nick != nick {
+		return fmt.Errorf("config has nick %s, not %s", cfg.Me.Nick, nick)
+	}
+	if cfg.Blockchain != inv.Blockchain || cfg.Network != inv.Network {
+		return fmt.Errorf("project %s is on %s %s, the config on %s %s", inv.Project, inv.Blockchain, inv.Network, cfg.Blockchain, cfg.Network)
+	}
+	if cfg.P2PNetwork != inv.P2PNetwork || cfg.RelayURL != inv.RelayURL {
+		return fmt.Errorf("project %s uses P2P network %s %s, the config %s %s", inv.Project, inv.P2PNetwork, inv.RelayURL, cfg.P2PNetwork, cfg.RelayURL)
+	}
+	if inv.Inviter.IdentPubKey.Equals(cfg.Me.IdentPubKey) {
+		return errors.New("the invitation is your own")
+	}
+	return nil
+}
+
+// Take the room key and the inviter of an invitation into the config, and ask to be admitted
+func joinProject(cfg *config.AppConfig, inv *invite.Invitation, token string) error {
+	if err := cfg.AddProject(inv.Project); err != nil {
+		return err
+	}
+	if err := cfg.SetRoomKey(inv.Project, inv.RoomKey); err != nil {
+		return err
+	}
+	if err := cfg.AddMembers(inv.Project, []user.User{inv.Inviter}); err != nil {
+		return err
+	}
+	return cfg.SetPendingJoin(inv.Project, token)
+}
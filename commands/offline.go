@@ -0,0 +1,406 @@
+package commands
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shykerbogdan/mpc-wallet/network/filenet"
+	"github.com/shykerbogdan/mpc-wallet/protocols"
+	"github.com/shykerbogdan/mpc-wallet/user"
+	"github.com/shykerbogdan/mpc-wallet/utils"
+	"github.com/spf13/cobra"
+)
+
+func offlineCommand() *cobra.Command {
+	var dir string
+	var showQR bool
+
+	cmd := &cobra.Command{
+		Use:   "offline",
+		Short: "Take part in keygen or signing without a network connection",
+		Long: `
+Offline mode lets a signer that is never online, such as a cold signer on an air-gapped laptop,
+take part in keygen and signing. Instead of talking to the other parties over libp2p, each round's
+outgoing messages are written to an encrypted bundle file in the bundle directory, and the protocol
+advances as bundles from the other parties are copied into the same directory (USB stick, QR codes...).
+
+All parties must use the same passphrase, which is used to encrypt the bundles.
+		`,
+	}
+
+	cmd.PersistentFlags().StringVar(&dir, "dir", "bundles", "directory outgoing bundles are written to and incoming bundles are read from")
+	cmd.PersistentFlags().BoolVar(&showQR, "qr", false, "also show each outgoing bundle as an animated QR code")
+
+	cmd.AddCommand(&cobra.Command{
+		Use:         "identity [file]",
+		Annotations: readOnlyConfig,
+		Short:       "Write your public identity to a file, to hand to the other parties for an offline keygen",
+		Args:        cobra.ExactArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			appConfig.MustExist()
+			b, err := json.MarshalIndent(appConfig.Me.User, "", "  ")
+			if err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(args[0], b, 0644); err != nil {
+				return err
+			}
+			fmt.Printf("Identity of %s written to %s\n", appConfig.Me.Nick, args[0])
+			return nil
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "keygen [wallet name] [threshold] [identity files...]",
+		Short: "Generate a new mpc wallet with the parties whose identity files are given",
+		Args:  cobra.MinimumNArgs(3),
+		RunE: func(c *cobra.Command, args []string) error {
+			appConfig.MustExist()
+			setOfflineLogOutput(c)
+
+			var threshold int
+			if _, err := fmt.Sscan(args[1], &threshold); err != nil {
+				return fmt.Errorf("invalid threshold %s", args[1])
+			}
+			return runOfflineKeygen(args[0], threshold, args[2:], dir, showQR)
+		},
+	})
+
+	var hashHex string
+	var signerNicks []string
+	signCmd := &cobra.Command{
+		Use:   "sign [wallet name] [message]",
+		Short: "Sign a text message, or a raw hash with --hash, with an mpc wallet",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE: func(c *cobra.Command, args []string) error {
+			appConfig.MustExist()
+			setOfflineLogOutput(c)
+
+			var msghash []byte
+			switch {
+			case hashHex != "":
+				h, err := hex.DecodeString(strings.TrimPrefix(hashHex, "0x"))
+				if err != nil || len(h) != 32 {
+					return errors.New("--hash must be 32 hex encoded bytes")
+				}
+				msghash = h
+			case len(args) == 2:
+				msghash = utils.DigestAvaMsg(args[1])
+			default:
+				return errors.New("either a message or --hash is required")
+			}
+			return runOfflineSign(args[0], msghash, signerNicks, dir, showQR)
+		},
+	}
+	signCmd.Flags().StringVar(&hashHex, "hash", "", "hex encoded 32 byte hash to sign, e.g. a transaction sign hash")
+	signCmd.Flags().StringSliceVar(&signerNicks, "signers", []string{}, "nicks of the signers taking part, including yourself")
+	cmd.AddCommand(signCmd)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "confirm [wallet name]",
+		Short: "Confirm the keygen of a wallet again with all its signers, once it was not confirmed by all of them",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			appConfig.MustExist()
+			setOfflineLogOutput(c)
+			return runOfflineConfirm(args[0], dir, showQR)
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "import-qr [frames file]",
+		Short: "Reassemble a bundle from scanned QR frames (one per line) into the bundle directory",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			return importQRFrames(args[0], dir)
+		},
+	})
+
+	return cmd
+}
+
+func setOfflineLogOutput(c *cobra.Command) {
+	logFileName, _ := c.Flags().GetString("log")
+	setLogOutput(logFileName)
+}
+
+// The other parties confirm keygen with one more round of bundles, which can take a while to be carried over
+const offlineConfirmTimeout = time.Hour * 24
+
+func runOfflineKeygen(name string, threshold int, identityFiles []string, dir string, showQR bool) error {
+	if appConfig.FindWallet(name) != nil {
+		return fmt.Errorf("wallet %s already exists", name)
+	}
+
+	signers := []user.User{appConfig.Me.User}
+	for _, f := range identityFiles {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		u := user.User{}
+		if err := json.Unmarshal(b, &u); err != nil {
+			return fmt.Errorf("invalid identity file %s: %w", f, err)
+		}
+		if u.IdentPubKey == nil {
+			return fmt.Errorf("identity file %s has no public key", f)
+		}
+		if u.PartyID() == appConfig.Me.PartyID() {
+			continue
+		}
+		signers = append(signers, u)
+	}
+
+	if (threshold <= 0) || (threshold > len(signers)-1) {
+		return errors.New("threshold must be less than total signers")
+	}
+	if err := user.CheckPartyIDs(signers); err != nil {
+		return err
+	}
+
+	ids := []string{fmt.Sprint(threshold)}
+	for _, u := range signers {
+		ids = append(ids, string(u.PartyID()))
+	}
+	sort.Strings(ids[1:])
+	session := filenet.Session(append([]string{"keygen", name}, ids...)...)
+
+	passphrase, err := getPassphrase("Bundle passphrase: ", false)
+	if err != nil {
+		return err
+	}
+
+	net, err := newOfflineNetwork(session, dir, passphrase, showQR)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Offline keygen session %s for %v-of-%v wallet %s\n", session, threshold+1, len(signers), name)
+	fmt.Printf("Copy bundles from %s to the other parties, and theirs into it, until keygen completes.\n", dir)
+
+	wallet := appConfig.NewEmptyWallet(appConfig.Project, name, threshold, signers)
+	err = protocols.RunKeygen(appConfig.Me, wallet, net, offlineConfirmTimeout)
+	net.Close()
+	if err != nil && wallet.Unconfirmed {
+		// Our share can not be made again, keep it until the signers confirm it
+		if err := appConfig.AddWallet(wallet); err != nil {
+			return err
+		}
+		return fmt.Errorf("keygen was not confirmed, wallet %s is kept but can not sign until 'thresher offline confirm %s' succeeds: %w", name, name, err)
+	}
+	if err != nil {
+		return fmt.Errorf("keygen failed: %w", err)
+	}
+
+	if err := appConfig.AddWallet(wallet); err != nil {
+		return err
+	}
+
+	fmt.Printf("\nWallet '%s' has been generated with address %s\n", name, wallet.Address)
+	fmt.Printf("Compare its fingerprint with the other parties: %s\n", protocols.Fingerprint(wallet))
+	fmt.Printf("Make sure the last bundle in %s reaches the other parties, so they can complete too.\n", dir)
+	return nil
+}
+
+// Every signer of the wallet runs the confirmation, the signers that already confirmed it too
+func runOfflineConfirm(name string, dir string, showQR bool) error {
+	w := appConfig.FindWallet(name)
+	if w == nil {
+		return fmt.Errorf("wallet %s not found", name)
+	}
+	session := filenet.Session("confirm", name, w.Address)
+
+	passphrase, err := getPassphrase("Bundle passphrase: ", false)
+	if err != nil {
+		return err
+	}
+
+	net, err := newOfflineNetwork(session, dir, passphrase, showQR)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Offline confirmation session %s of wallet %s\n", session, name)
+	fmt.Printf("Copy bundles from %s to the other signers, and theirs into it, until all of them confirmed.\n", dir)
+
+	err = protocols.RunConfirm(appConfig.Me, w, net, offlineConfirmTimeout)
+	net.Close()
+	if err != nil {
+		return fmt.Errorf("confirmation failed: %w", err)
+	}
+	if err := appConfig.AddWallet(w); err != nil {
+		return err
+	}
+
+	fmt.Printf("\nWallet '%s' has been confirmed by all signers, its fingerprint is %s\n", name, protocols.Fingerprint(w))
+	return nil
+}
+
+func runOfflineSign(name string, msghash []byte, signerNicks []string, dir string, showQR bool) error {
+	w := appConfig.FindWallet(name)
+	if w == nil {
+		return fmt.Errorf("wallet %s not found", name)
+	}
+
+	roster := map[string]user.User{w.Me.Nick: w.Me}
+	for _, u := range w.Others {
+		roster[u.Nick] = u
+	}
+
+	signers := []user.User{}
+	includesMe := false
+	for _, nick := range signerNicks {
+		u, ok := roster[nick]
+		if !ok {
+			return fmt.Errorf("%s is not a signer of wallet %s", nick, name)
+		}
+		includesMe = includesMe || nick == w.Me.Nick
+		signers = append(signers, u)
+	}
+	if !includesMe {
+		return errors.New("--signers must include yourself")
+	}
+	if len(signers) <= w.Threshold {
+		return fmt.Errorf("wallet threshold requires at least %v signers", w.Threshold+1)
+	}
+
+	nicks := append([]string{}, signerNicks...)
+	sort.Strings(nicks)
+	session := filenet.Session(append([]string{"sign", name, hex.EncodeToString(msghash)}, nicks...)...)
+
+	passphrase, err := getPassphrase("Bundle passphrase: ", false)
+	if err != nil {
+		return err
+	}
+
+	net, err := newOfflineNetwork(session, dir, passphrase, showQR)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Offline signing session %s for hash %x with signers %s\n", session, msghash, strings.Join(nicks, ","))
+	fmt.Printf("Copy bundles from %s to the other signers, and theirs into it, until signing completes.\n", dir)
+
+	sig, err := protocols.RunSign(w, msghash, signers, net)
+	net.Close()
+	if err != nil {
+		return fmt.Errorf("signing failed: %w", err)
+	}
+
+	ethsig, err := w.MpcSigToEthSig(msghash, sig)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\nSignature: 0x%x\n", ethsig)
+	return nil
+}
+
+func importQRFrames(framesFile string, dir string) error {
+	f, err := os.Open(framesFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	lines := []string{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	sealed, err := filenet.AssembleQRFrames(lines)
+	if err != nil {
+		return err
+	}
+
+	passphrase, err := getPassphrase("Bundle passphrase: ", false)
+	if err != nil {
+		return err
+	}
+
+	b, err := filenet.ReadBundle(sealed, passphrase)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, b.FileName())
+	if err := ioutil.WriteFile(path, sealed, 0600); err != nil {
+		return err
+	}
+
+	fmt.Printf("Bundle %d from %s imported to %s\n", b.Seq, b.From, path)
+	return nil
+}
+
+func newOfflineNetwork(session string, dir string, passphrase []byte, showQR bool) (*filenet.Network, error) {
+	net, err := filenet.NewNetwork(appConfig.Me.PartyID(), session, dir, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	display := &qrDisplay{}
+	net.OnBundle = func(path string, sealed []byte) {
+		fmt.Printf("Wrote bundle %s\n", path)
+		if showQR {
+			display.show(filenet.QRFrames(sealed))
+		}
+	}
+
+	return net, nil
+}
+
+// Cycles through the QR frames of the most recent bundle until a newer one replaces it
+type qrDisplay struct {
+	frames []string
+	mutex  sync.Mutex
+}
+
+const qrFrameInterval = time.Millisecond * 500
+
+func (d *qrDisplay) show(frames []string) {
+	d.mutex.Lock()
+	running := d.frames != nil
+	d.frames = frames
+	d.mutex.Unlock()
+
+	if !running {
+		go d.loop()
+	}
+}
+
+func (d *qrDisplay) loop() {
+	for i := 0; ; i++ {
+		d.mutex.Lock()
+		frames := d.frames
+		d.mutex.Unlock()
+
+		frame := frames[i%len(frames)]
+		qr, err := filenet.RenderQR(frame)
+		if err != nil {
+			fmt.Printf("Error rendering QR frame: %v\n", err)
+			return
+		}
+
+		// Clear the screen and draw the frame in the top left
+		fmt.Printf("\033[H\033[2J%s\nFrame %d of %d\n", qr, i%len(frames)+1, len(frames))
+		time.Sleep(qrFrameInterval)
+	}
+}
@@ -0,0 +1,48 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/term"
+)
+
+// Environment variable which can hold a passphrase, for running non-interactively
+const passphraseEnv = "THRESHER_PASSPHRASE"
+
+// Read a passphrase from the environment or prompt for it on the terminal
+func getPassphrase(prompt string, confirmation bool) ([]byte, error) {
+	if p := os.Getenv(passphraseEnv); p != "" {
+		return []byte(p), nil
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, fmt.Errorf("no terminal to prompt for a passphrase, set %s instead", passphraseEnv)
+	}
+
+	fmt.Print(prompt)
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Println()
+	if err != nil {
+		return nil, err
+	}
+	if len(passphrase) == 0 {
+		return nil, errors.New("passphrase must not be empty")
+	}
+
+	if confirmation {
+		fmt.Print("Repeat passphrase: ")
+		confirm, err := term.ReadPassword(fd)
+		fmt.Println()
+		if err != nil {
+			return nil, err
+		}
+		if string(confirm) != string(passphrase) {
+			return nil, errors.New("passphrases do not match")
+		}
+	}
+
+	return passphrase, nil
+}
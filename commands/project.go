@@ -0,0 +1,93 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func projectCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "project",
+		Short: "Manage the projects whose rooms the wallet session joins",
+		Long: `
+One identity can take part in several projects, e.g. the treasuries of several DAOs. The wallet session
+joins the room of every project and shows one at a time, F5 or /room <project> switches between them.
+Each wallet belongs to the room of one project, proposals and protocols for it only run in that room.
+		`,
+	}
+
+	cmd.AddCommand(projectAddCommand())
+	cmd.AddCommand(projectListCommand())
+	cmd.AddCommand(projectRemoveCommand())
+
+	return cmd
+}
+
+func projectAddCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:          "add <project>",
+		Short:        "Join the room of another project in wallet sessions",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			appConfig.MustExist()
+
+			if err := appConfig.AddProject(args[0]); err != nil {
+				return withExitCode(exitUsage, err)
+			}
+			fmt.Printf("Added project %s\n", args[0])
+			return nil
+		},
+	}
+}
+
+func projectListCommand() *cobra.Command {
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:          "list",
+		Annotations:  readOnlyConfig,
+		Short:        "List the projects and their wallets",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			appConfig.MustExist()
+
+			type projectInfo struct {
+				Name    string   `json:"name"`
+				Wallets []string `json:"wallets"`
+			}
+			infos := []projectInfo{}
+			var sb strings.Builder
+			for _, project := range appConfig.ProjectNames() {
+				wallets := appConfig.ProjectWalletNames(project)
+				infos = append(infos, projectInfo{Name: project, Wallets: wallets})
+				fmt.Fprintf(&sb, "%-20s %s\n", project, strings.Join(wallets, ", "))
+			}
+			return printResult(asJSON, infos, strings.TrimSuffix(sb.String(), "\n"))
+		},
+	}
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print JSON")
+
+	return cmd
+}
+
+func projectRemoveCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:          "remove <project>",
+		Short:        "Stop joining the room of a project that has no wallets left",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			appConfig.MustExist()
+
+			if err := appConfig.RemoveProject(args[0]); err != nil {
+				return withExitCode(exitUsage, err)
+			}
+			fmt.Printf("Removed project %s\n", args[0])
+			return nil
+		},
+	}
+}
@@ -0,0 +1,87 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/shykerbogdan/mpc-wallet/network/relay"
+	"github.com/spf13/cobra"
+)
+
+func relayCommand() *cobra.Command {
+	var listenaddr string
+	var certfile string
+	var keyfile string
+
+	cmd := &cobra.Command{
+		Use:   "relay",
+		Short: "Start a relay server for signers that can not use libp2p",
+		Long: `
+Signers behind strict NATs or corporate firewalls often can not reach each other over libp2p.
+A relay server is a small server that all of them connect to over WebSocket (HTTPS), which simply
+forwards messages between the members of a project.
+
+Messages are end-to-end encrypted between the signers with keys signed by their libp2p identity keys,
+so the relay can neither read nor forge them. It only learns a hash of the project name and the
+public identity keys of the signers connected to it. A signer talks to everyone in the room of a
+project until it has members or wallets, and from then on only to its members and co-signers.
+
+Run it on a machine all signers can reach, ideally with a TLS certificate, and point the signers at it with
+  thresher init ... --relay wss://relay.example.com/ws
+		`,
+		RunE: func(c *cobra.Command, args []string) error {
+			if (certfile == "") != (keyfile == "") {
+				return errors.New("--tls-cert and --tls-key must be given together")
+			}
+			return startRelayServer(listenaddr, certfile, keyfile)
+		},
+	}
+
+	cmd.Flags().StringVar(&listenaddr, "listen", ":8080", "address the server should listen on")
+	cmd.Flags().StringVar(&certfile, "tls-cert", "", "TLS certificate file, serves wss:// instead of ws://")
+	cmd.Flags().StringVar(&keyfile, "tls-key", "", "TLS private key file")
+
+	return cmd
+}
+
+func startRelayServer(listenaddr string, certfile string, keyfile string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/ws", relay.NewServer())
+
+	server := &http.Server{Addr: listenaddr, Handler: mux}
+
+	errs := make(chan error, 1)
+	go func() {
+		if certfile != "" {
+			errs <- server.ListenAndServeTLS(certfile, keyfile)
+		} else {
+			errs <- server.ListenAndServe()
+		}
+	}()
+
+	scheme := "ws"
+	if certfile != "" {
+		scheme = "wss"
+	}
+	fmt.Printf("\n[*] Thresher Relay Server Is Listening On: %s (%s://<host>%s/ws)\n\n", listenaddr, scheme, listenaddr)
+
+	// wait for a SIGINT or SIGTERM signal
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-errs:
+		return err
+	case <-ch:
+	}
+	fmt.Println("\n\nReceived signal, shutting down...")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	return server.Shutdown(ctx)
+}
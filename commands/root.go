@@ -1,10 +1,13 @@
 package commands
 
 import (
+	"errors"
 	"fmt"
+	"os"
 
 	"github.com/shykerbogdan/mpc-wallet/config"
 	"github.com/shykerbogdan/mpc-wallet/configdir"
+	"github.com/shykerbogdan/mpc-wallet/logging"
 
 	"github.com/moby/term"
 	"github.com/spf13/cobra"
@@ -15,6 +18,15 @@ const rootCmdName = "thresher"
 var appDirs = configdir.New(rootCmdName)
 var appConfig *config.AppConfig = &config.AppConfig{}
 
+// Commands which only read the config are annotated with readOnlyConfig, so they load it without taking its
+// lock and can run next to a session
+const (
+	configAnnotation = "config"
+	configReadOnly   = "read-only"
+)
+
+var readOnlyConfig = map[string]string{configAnnotation: configReadOnly}
+
 const asciiArt = `
 
   _______________________________  __      __        .__  .__          __   
@@ -31,24 +43,46 @@ func NewRootCommand() *cobra.Command {
 		Use:   rootCmdName,
 		Short: "short desc",
 		Long:  `long desc`,
-		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		// Errors are printed by Execute, which also picks the exit code
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
 			// We run this method for its side-effects. On windows, this will enable the windows terminal
 			// to understand ANSI escape codes.
 			// TODO do we need this? brings in a lot of dependencies
 			_, _, _ = term.StdStreams()
 
+			if err := setupLogging(cmd); err != nil {
+				return withExitCode(exitUsage, err)
+			}
+
 			filename, _ := cmd.Flags().GetString("config")
 			if config.FileExists(filename) {
-				appConfig = config.Load(filename)
+				load := config.Load
+				if cmd.Annotations[configAnnotation] == configReadOnly {
+					load = config.LoadReadOnly
+				}
+				cfg, err := load(filename)
+				if err != nil {
+					return err
+				}
+				appConfig = cfg
 			}
 
-			fmt.Print(asciiArt)
+			// Keep stdout clean for the commands that print JSON
+			if asJSON, _ := cmd.Flags().GetBool("json"); !asJSON {
+				fmt.Print(asciiArt)
+			}
+			return nil
 		},
 	}
+	cmd.SetFlagErrorFunc(func(c *cobra.Command, err error) error {
+		return withExitCode(exitUsage, err)
+	})
 
 	cmd.PersistentFlags().StringP("config", "c", "", "config file which **contains secrets**")
 	cmd.PersistentFlags().StringP("log", "l", "thresher.log", "logfile")
-	// cmd.PersistentFlags().BoolP("verbose", "v", false, "verbose logging")
+	cmd.PersistentFlags().String("log-level", "info", "log level: debug, info, warn or error")
+	cmd.PersistentFlags().String("log-format", logging.FormatText, "log format: text or json")
 
 	cmd.AddCommand(initCommand())
 	cmd.AddCommand(versionCommand())
@@ -56,10 +90,50 @@ func NewRootCommand() *cobra.Command {
 	cmd.AddCommand(testUICommand())
 	//cmd.AddCommand(debugCommand())
 	cmd.AddCommand(bootstrapCommand())
+	cmd.AddCommand(simulateCommand())
+	cmd.AddCommand(offlineCommand())
+	cmd.AddCommand(relayCommand())
+	cmd.AddCommand(auditCommand())
+	cmd.AddCommand(keygenCommand())
+	cmd.AddCommand(sendCommand())
+	cmd.AddCommand(signMessageCommand())
+	cmd.AddCommand(reshareCommand())
+	cmd.AddCommand(addressCommand())
+	cmd.AddCommand(projectCommand())
+	cmd.AddCommand(secretsCommand())
+	cmd.AddCommand(identityCommand())
+	cmd.AddCommand(inviteCommand())
+	cmd.AddCommand(joinCommand())
 
 	return cmd
 }
 
+// Set the log level and format of the flags. Logs go to stderr until a session sets the log file.
+func setupLogging(cmd *cobra.Command) error {
+	levelname, _ := cmd.Flags().GetString("log-level")
+	level, err := logging.ParseLevel(levelname)
+	if err != nil {
+		return err
+	}
+	format, _ := cmd.Flags().GetString("log-format")
+	if err := logging.SetFormat(format); err != nil {
+		return err
+	}
+	logging.SetLevel(level)
+	logging.SetOutput(os.Stderr)
+	return nil
+}
+
 func Execute() {
-	cobra.CheckErr(NewRootCommand().Execute())
+	err := NewRootCommand().Execute()
+	if err == nil {
+		os.Exit(exitOK)
+	}
+
+	fmt.Fprintln(os.Stderr, "Error:", err)
+	var exitErr *exitError
+	if errors.As(err, &exitErr) {
+		os.Exit(exitErr.code)
+	}
+	os.Exit(exitFailure)
 }
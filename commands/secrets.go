@@ -0,0 +1,111 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/shykerbogdan/mpc-wallet/config"
+	"github.com/shykerbogdan/mpc-wallet/secretstore"
+
+	"github.com/spf13/cobra"
+)
+
+func secretsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "secrets",
+		Short: "Manage where the identity key and the key shares are kept",
+		Long: `
+By default the libp2p identity key and the mpc key shares are kept in the config file, protected by its
+file permissions only. Enrolling another secret store moves the identity key into it, and keeps the key
+shares in the config file encrypted with a key of the store:
+
+  keyring  the keyring of the OS: the Secret Service on linux, the Keychain on macOS, the Credential Manager on windows
+  pkcs11   a PKCS#11 token, e.g. an HSM, a smartcard or SoftHSM. The share-encryption key is generated on the
+           token and never leaves it, so the key shares can only be decrypted on the enrolled device.
+           The user PIN of the token is read from ` + secretstore.PINEnv + `.
+  file     the config file itself
+
+Enrolling another store from the file backend removes the backups of the config file, as they hold the
+secrets in plaintext, unless --keep-plaintext-backups is given.
+		`,
+	}
+
+	cmd.AddCommand(secretsShowCommand())
+	cmd.AddCommand(secretsEnrollCommand())
+
+	return cmd
+}
+
+func secretsShowCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:          "show",
+		Annotations:  readOnlyConfig,
+		Short:        "Show the secret store of the config",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			appConfig.MustExist()
+
+			fmt.Println(appConfig.SecretStoreConfig())
+			return nil
+		},
+	}
+}
+
+func secretsEnrollCommand() *cobra.Command {
+	var store secretstore.Config
+	var keepBackups bool
+
+	cmd := &cobra.Command{
+		Use:          "enroll <file|keyring|pkcs11>",
+		Short:        "Move the identity key and the key shares to another secret store",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			appConfig.MustExist()
+
+			store.Backend = args[0]
+			fromFile := appConfig.SecretStoreConfig().IsFile()
+			if err := appConfig.SetSecretStore(store); err != nil {
+				return err
+			}
+
+			// Only trust the enrollment once the config file can be read back with the new store
+			check, err := config.LoadReadOnly(appConfig.CfgFile())
+			if err != nil {
+				return fmt.Errorf("config file can not be read back after enrolling: %w", err)
+			}
+			defer check.Close()
+			for _, name := range check.SortedWalletNames() {
+				if err := check.FindWallet(name).VerifyKeyShare(); err != nil {
+					return fmt.Errorf("key share of wallet %s can not be read back after enrolling: %w", name, err)
+				}
+			}
+			fmt.Printf("Secrets are now kept in %s\n", check.SecretStoreConfig())
+
+			// Backups made with the file backend hold the secrets in plaintext, which would defeat the store
+			if fromFile && !check.SecretStoreConfig().IsFile() && !keepBackups {
+				removed, err := appConfig.RemoveBackups()
+				if len(removed) > 0 {
+					fmt.Printf("Removed the backups of the config file holding the secrets in plaintext:\n  %s\n", strings.Join(removed, "\n  "))
+				}
+				if err != nil {
+					return fmt.Errorf("error removing the backups of the config file: %w", err)
+				}
+				return nil
+			}
+
+			backups, err := appConfig.BackupFiles()
+			if err == nil && len(backups) > 0 {
+				fmt.Printf("Backups of the config file made before still hold the secrets as they were kept then:\n  %s\n", strings.Join(backups, "\n  "))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&keepBackups, "keep-plaintext-backups", false, "keep the backups of the config file, which hold the secrets in plaintext when enrolling from the file backend")
+	cmd.Flags().StringVar(&store.Namespace, "namespace", "", "prefix of the names of the secrets in the store (default <project>-<nick>)")
+	cmd.Flags().StringVar(&store.Module, "module", "", "path of the PKCS#11 module, e.g. /usr/lib/softhsm/libsofthsm2.so")
+	cmd.Flags().StringVar(&store.TokenLabel, "token", "", "label of the PKCS#11 token")
+
+	return cmd
+}
@@ -14,7 +14,7 @@ func testUICommand() *cobra.Command {
 		Short: "All software has versions.",
 		Run: func(c *cobra.Command, args []string) {
 			appConfig.MustExist()
-			ui := chat.NewTerminalApp("blockchain", "room", "nick", cmdchan, msgchan)
+			ui := chat.NewTerminalApp("blockchain", []string{"room"}, "nick", cmdchan, msgchan)
 			_ = ui.TerminalApp.Run()
 		},
 	}
@@ -1,19 +1,27 @@
 package commands
 
 import (
+	"errors"
 	"fmt"
-	"log"
 	"os"
+	"strings"
 	"time"
 
+	libp2pcrypto "github.com/libp2p/go-libp2p-core/crypto"
 	"github.com/shykerbogdan/mpc-wallet/config"
+	"github.com/shykerbogdan/mpc-wallet/logging"
+	"github.com/shykerbogdan/mpc-wallet/metrics"
 	"github.com/shykerbogdan/mpc-wallet/network/chat"
+	"github.com/shykerbogdan/mpc-wallet/network/relay"
+	"github.com/shykerbogdan/mpc-wallet/user"
 	"github.com/spf13/cobra"
 )
 
+var sessionlog = logging.New("session")
+
 func walletCommand() *cobra.Command {
-	var bootstrapaddrs []string
-	var listenaddrs []string
+	var p2popts chat.P2POptions
+	var metricsaddr string
 
 	cmd := &cobra.Command{
 		Use:   "wallet",
@@ -24,50 +32,175 @@ func walletCommand() *cobra.Command {
 
 			logFileName, _ := c.Flags().GetString("log")
 			setLogOutput(logFileName)
-			log.Println(appConfig.String())
+			sessionlog.Info("Starting the wallet session", "config", appConfig.CfgFile(), "nick", appConfig.Me.Nick, "peerid", appConfig.Me.PeerID().Pretty(),
+				"projects", strings.Join(appConfig.ProjectNames(), ","), "p2pnetwork", appConfig.P2PNetwork)
 
 			fmt.Println(appConfig.String())
 			fmt.Printf("STT wallet chat session started, logging to %s \n", logFileName)
-			fmt.Println("(This could take a while to connect to libp2p network)")
 
-			runChatCmd(appConfig, bootstrapaddrs, listenaddrs)
+			if len(appConfig.Projects) > 0 {
+				fmt.Printf("Joining the rooms of projects %s\n", strings.Join(appConfig.ProjectNames(), ", "))
+			}
+			if appConfig.P2PNetwork == config.P2PNetworkRelay {
+				fmt.Printf("(Connecting to relay %s)\n", appConfig.RelayURL)
+			} else {
+				fmt.Println("(This could take a while to connect to libp2p network)")
+			}
+
+			if metricsaddr != "" {
+				if _, err := metrics.Serve(metricsaddr); err != nil {
+					sessionlog.Fatal("Error serving the metrics", "addr", metricsaddr, "err", err)
+				}
+				fmt.Printf("Serving metrics on http://%s/metrics and health on http://%s/healthz\n", metricsaddr, metricsaddr)
+			}
+
+			runChatCmd(appConfig, p2popts)
 		},
 	}
 
-	cmd.Flags().StringSliceVar(&bootstrapaddrs, "bootstrap", []string{}, "bootstrap addrs")
-	cmd.Flags().StringSliceVar(&listenaddrs, "listen", []string{}, "listen addrs")
+	addP2PFlags(cmd, &p2popts)
+	cmd.Flags().StringVar(&metricsaddr, "metrics-addr", "", "serve Prometheus metrics and /healthz on this addr, e.g. 127.0.0.1:9090")
 	// TODO Where should we put the config and log files?
 	cmd.Flags().StringP("config", "c", "thresher.json", "config file which **contains secrets**")
 	cmd.Flags().StringP("log", "l", "thresher.log", "logfile")
 
+	cmd.AddCommand(walletListCommand())
+	cmd.AddCommand(walletShowCommand())
+	cmd.AddCommand(walletBalanceCommand())
+	cmd.AddCommand(walletFeesCommand())
+	cmd.AddCommand(walletExportCommand())
+	cmd.AddCommand(walletImportCommand())
+
 	return cmd
 }
 
-func runChatCmd(cfg *config.AppConfig, bootstrapaddrs []string, listenaddrs []string) {
+// The flags of how a session finds the other signers over libp2p
+func addP2PFlags(cmd *cobra.Command, opts *chat.P2POptions) {
+	cmd.Flags().StringSliceVar(&opts.BootstrapAddrs, "bootstrap", []string{}, "bootstrap addrs")
+	cmd.Flags().StringSliceVar(&opts.ListenAddrs, "listen", []string{}, "listen addrs")
+	cmd.Flags().StringSliceVar(&opts.Peers, "peer", []string{}, "multiaddrs of peers to connect to directly, e.g. /ip4/192.168.1.20/tcp/4001/p2p/<peer id>")
+	cmd.Flags().BoolVar(&opts.MDNS, "mdns", false, "find the other signers on the local network with mDNS")
+	cmd.Flags().BoolVar(&opts.NoDHT, "no-dht", false, "do not use the DHT and bootstrap peers, only --peer and --mdns, e.g. offline on a LAN")
+}
+
+func runChatCmd(cfg *config.AppConfig, p2popts chat.P2POptions) {
+	rooms := joinChatRooms(cfg, cfg.ProjectNames(), p2popts)
+
+	ui := chat.NewUI(rooms)
+	if err := ui.Run(); err != nil {
+		sessionlog.Fatal("Error starting the UI", "err", err)
+	}
+}
+
+// Join the chatroom of a project over the P2PNetwork of the config
+func joinChatRoom(cfg *config.AppConfig, project string, p2popts chat.P2POptions) *chat.ChatRoom {
+	return joinChatRooms(cfg, []string{project}, p2popts)[0]
+}
+
+// Join the chatrooms of projects over the P2PNetwork of the config, in the order of the projects. The rooms
+// share one libp2p host, protocol messages are only routed within their own room.
+func joinChatRooms(cfg *config.AppConfig, projects []string, p2popts chat.P2POptions) []*chat.ChatRoom {
+	if cfg.P2PNetwork == config.P2PNetworkRelay {
+		return joinRelayChatRooms(cfg, projects)
+	}
+
 	nick := cfg.Me.Nick
+	if len(p2popts.BootstrapAddrs) == 0 {
+		p2popts.BootstrapAddrs = cfg.BootstrapAddrs
+	}
+
+	roomnames := []string{}
+	for _, project := range projects {
+		roomnames = append(roomnames, cfg.RoomName(project))
+	}
 
-	p2phost := chat.NewP2P(cfg.Me, cfg.Project, bootstrapaddrs, listenaddrs)
-	log.Printf("Connecting to libp2p network with peerID %s listening on %v", p2phost.Host.ID().Pretty(), p2phost.Host.Addrs())
+	p2phost := chat.NewP2P(cfg.Me, roomnames, p2popts)
+	metrics.AddHealthCheck("p2p", func() error {
+		if len(p2phost.Host.Network().Peers()) == 0 {
+			return errors.New("not connected to any libp2p peers")
+		}
+		return nil
+	})
+	addRPCHealthCheck(cfg)
 
 	p2phost.AnnounceConnect()
 	// p2phost.AdvertiseConnect()
 
-	chatapp, err := chat.JoinChatRoom(p2phost, cfg)
-	if err != nil {
-		log.Fatalf("Error joining chatroom %v", err)
+	rooms := []*chat.ChatRoom{}
+	for _, project := range projects {
+		chatapp, err := chat.JoinChatRoom(p2phost, cfg, project)
+		if err != nil {
+			sessionlog.Fatal("Error joining the room", "project", project, "err", err)
+		}
+		rooms = append(rooms, chatapp)
 	}
 
-	net := chat.NewNetwork(chatapp)
-	log.Printf("Joined chatroom with nick %s, waiting for network to start...", nick)
+	sessionlog.Info("Joined the rooms, waiting for the network to start", "projects", strings.Join(projects, ","), "nick", nick)
 	// Wait for network setup to complete
 	time.Sleep(time.Second * 1)
 
-	ui := chat.NewUI(chatapp, net)
-	if err := ui.Run(); err != nil {
-		log.Fatalf("Error starting ui %v", err)
+	return rooms
+}
+
+// Same as joinChatRooms, but all messages go through a relay server instead of libp2p, one connection per room
+func joinRelayChatRooms(cfg *config.AppConfig, projects []string) []*chat.ChatRoom {
+	if cfg.RelayURL == "" {
+		sessionlog.Fatal("The config has no RelayURL", "p2pnetwork", cfg.P2PNetwork)
+	}
+
+	rooms := []*chat.ChatRoom{}
+	clients := []*relay.Client{}
+	for _, project := range projects {
+		project := project
+		trusted := func(k libp2pcrypto.PubKey) bool {
+			return cfg.IsTrustedSigner(project, user.User{IdentPubKey: k})
+		}
+		client, err := relay.Dial(cfg.RelayURL, relay.RoomID(cfg.RoomName(project)), cfg.Me, trusted)
+		if err != nil {
+			sessionlog.Fatal("Error joining the relay", "relay", cfg.RelayURL, "project", project, "err", err)
+		}
+		sessionlog.Info("Connected to the relay", "relay", cfg.RelayURL, "project", project, "peerid", client.ID().Pretty())
+
+		room, err := chat.NewChatRoom(client, cfg, project)
+		if err != nil {
+			sessionlog.Fatal("Error joining the room", "project", project, "err", err)
+		}
+		rooms = append(rooms, room)
+		clients = append(clients, client)
 	}
+	metrics.AddHealthCheck("p2p", func() error {
+		for _, client := range clients {
+			if err := client.Err(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	addRPCHealthCheck(cfg)
+	sessionlog.Info("Joined the relay rooms", "projects", strings.Join(projects, ","), "nick", cfg.Me.Nick)
+
+	return rooms
+}
+
+// Check the blockchain backends of the wallets in /healthz, each backend once
+func addRPCHealthCheck(cfg *config.AppConfig) {
+	metrics.AddHealthCheck("rpc", func() error {
+		checked := map[string]bool{}
+		for _, name := range cfg.SortedWalletNames() {
+			w := cfg.FindWallet(name)
+			if w == nil || checked[w.Config.RPCHostURL] {
+				continue
+			}
+			checked[w.Config.RPCHostURL] = true
+			if err := w.CheckBackend(); err != nil {
+				return fmt.Errorf("backend of wallet %s: %w", name, err)
+			}
+		}
+		return nil
+	})
 }
 
+// Write the logs to filename, with the level and format of --log-level and --log-format
 func setLogOutput(filename string) {
 	// Just use default stderr if no name specified
 	if filename == "" {
@@ -78,5 +211,5 @@ func setLogOutput(filename string) {
 	if err != nil {
 		panic(err)
 	}
-	log.SetOutput(file)
+	logging.SetOutput(file)
 }
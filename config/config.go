@@ -5,16 +5,27 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math/big"
 	"os"
+	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/shykerbogdan/mpc-wallet/addressbook"
+	"github.com/shykerbogdan/mpc-wallet/invite"
+	"github.com/shykerbogdan/mpc-wallet/secretstore"
 	"github.com/shykerbogdan/mpc-wallet/user"
 	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet"
+	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet/fees"
 )
 
 type AppConfig struct {
+	// Format of the file, see CurrentVersion. Files without it are upgraded by Load.
+	Version int
+
 	// Goerli
 	Blockchain string
 
@@ -24,20 +35,57 @@ type AppConfig struct {
 	// Name of the project, e.g. DAO-SuperSwap
 	Project string
 
-	// "chatnet" uses libp2p. Could also implement Keybase chat? Others?
+	// Other projects we take part in with the same identity, each one has a room and wallets of its own
+	Projects []string `json:",omitempty"`
+
+	// "chatnet" uses libp2p, "relay" uses a thresher relay server at RelayURL. Could also implement Keybase chat? Others?
 	P2PNetwork string
 
+	// e.g. wss://relay.example.com/ws, only used by the "relay" P2PNetwork
+	RelayURL string `json:",omitempty"`
+
+	// libp2p peers to bootstrap from when none are given, the public libp2p bootstrap peers if empty
+	BootstrapAddrs []string `json:",omitempty"`
+
 	Me user.Me
 
 	Wallets map[string]*ethwallet.Wallet
 
+	// Known destinations, shared by all wallets of the project
+	AddressBook addressbook.Book `json:",omitempty"`
+
+	// Where the identity key and the key shares are kept, in the file itself if nil
+	SecretStore *secretstore.Config `json:",omitempty"`
+
+	// The pre-shared keys of the project rooms, see RoomName. Rooms without one are found by project name.
+	RoomKeys map[string][]byte `json:",omitempty"`
+	// The other signers registered as members of each project, through invitations
+	Members map[string][]user.User `json:",omitempty"`
+	// The invitations we issued that have not been used yet
+	Invitations []invite.Issued `json:",omitempty"`
+	// The invitation tokens of the projects we joined and have not been admitted to yet
+	PendingJoins map[string]string `json:",omitempty"`
+
 	UpdatedAt time.Time
 
 	filename string
 	isLoaded bool
-	mutex    sync.Mutex
+	readOnly bool
+	// Held while the config may be written, see acquireLock
+	lock *os.File
+	// The open SecretStore, nil for the file backend
+	secrets     secretstore.Store
+	sealed      map[string]sealedShare
+	identStored bool
+	mutex       sync.Mutex
 }
 
+// The supported P2PNetwork values
+const (
+	P2PNetworkChat  = "chatnet"
+	P2PNetworkRelay = "relay"
+)
+
 var errUnsupportedBlockchain = errors.New("Blockchain/Network is unsupported")
 
 // Create a new AppConfig
@@ -51,43 +99,108 @@ func New(blockchain string, network string, project string, nick string, address
 		return nil, err
 	}
 
-	cfg := &AppConfig{
+	return NewWithIdentity(blockchain, network, project, me), nil
+}
+
+// Create a new AppConfig for an existing identity, e.g. when restoring a wallet backup on a new machine
+func NewWithIdentity(blockchain string, network string, project string, me user.Me) *AppConfig {
+	return &AppConfig{
+		Version:    CurrentVersion,
 		Blockchain: blockchain,
 		Network:    network,
 		Me:         me,
 		Project:    project,
-		P2PNetwork: "chatnet",
+		P2PNetwork: P2PNetworkChat,
 		Wallets:    make(map[string]*ethwallet.Wallet),
 		isLoaded:   false,
 	}
+}
 
-	return cfg, nil
+// Initialize the AppConfig from a marshalled file on disk. The config file stays locked until Close or
+// the end of the process, so no other thresher process can write it meanwhile.
+func Load(filename string) (*AppConfig, error) {
+	return load(filename, false)
 }
 
-// Initialize the AppConfig from a marshalled file on disk
-func Load(filename string) *AppConfig {
+// Initialize the AppConfig from a file on disk without locking it, for commands which only read the config
+// and so may run next to a session. It can not be persisted, an old format is only upgraded in memory.
+func LoadReadOnly(filename string) (*AppConfig, error) {
+	return load(filename, true)
+}
+
+func load(filename string, readOnly bool) (*AppConfig, error) {
 	if !FileExists(filename) {
-		fmt.Fprintf(os.Stderr, "Config file not found: %s \nRun 'thresher help init' for more info.", filename)
-		os.Exit(1)
+		return nil, fmt.Errorf("config file not found: %s \nRun 'thresher help init' for more info", filename)
+	}
+
+	var lock *os.File
+	if !readOnly {
+		var err error
+		lock, err = acquireLock(filename)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ac, err := read(filename, readOnly)
+	if err != nil {
+		if lock != nil {
+			lock.Close()
+		}
+		return nil, err
 	}
+	ac.lock = lock
+	return ac, nil
+}
 
+func read(filename string, readOnly bool) (*AppConfig, error) {
 	jsonb, err := ioutil.ReadFile(filename)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Fatal error reading config file %s: %v \n", filename, err)
-		os.Exit(1)
+		return nil, fmt.Errorf("error reading config file %s: %w", filename, err)
+	}
+
+	upgraded, version, err := Migrate(jsonb)
+	if err != nil {
+		return nil, fmt.Errorf("error loading config file %s: %w", filename, err)
+	}
+
+	unsealed, secrets, sealed, err := openSecrets(upgraded)
+	if err != nil {
+		return nil, fmt.Errorf("error loading config file %s: %w", filename, err)
 	}
 
 	ac := &AppConfig{}
-	err = json.Unmarshal(jsonb, ac)
+	err = json.Unmarshal(unsealed, ac)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Fatal error loading config file %s: %v \n", filename, err)
-		os.Exit(1)
+		if secrets != nil {
+			secrets.Close()
+		}
+		return nil, fmt.Errorf("error loading config file %s: %w", filename, err)
 	}
 
+	ac.secrets = secrets
+	ac.sealed = sealed
+	ac.identStored = secrets != nil
 	ac.filename = filename
 	ac.isLoaded = true
+	ac.readOnly = readOnly
+
+	if version != CurrentVersion && !readOnly {
+		// The file holds our key shares, keep the original around in case the upgrade lost anything
+		backupname := migrationBackupName(filename, version, time.Now().UTC())
+		err = write(backupname, jsonb)
+		if err != nil {
+			return nil, fmt.Errorf("error saving backup config file %s: %w", backupname, err)
+		}
+
+		if err := ac.Persist(); err != nil {
+			ac.Close()
+			return nil, err
+		}
+		fmt.Fprintf(os.Stderr, "Upgraded config file %s from version %d to %d, the original is kept in %s\n", filename, version, CurrentVersion, backupname)
+	}
 
-	return ac
+	return ac, nil
 }
 
 // Name of the config file on disk
@@ -95,6 +208,35 @@ func (ac *AppConfig) CfgFile() string {
 	return ac.filename
 }
 
+// Name of the audit log file kept next to the config file, e.g. DAOTreasury-alice.audit
+func (ac *AppConfig) AuditFile() string {
+	return ac.ProjectAuditFile(ac.Project)
+}
+
+// Name of the file with the proposals we are an approver of, kept next to the config file
+func (ac *AppConfig) ProposalsFile() string {
+	return ac.ProjectProposalsFile(ac.Project)
+}
+
+// Name of the audit log of a project room, e.g. DAOTreasury-alice.OtherDAO.audit. The project of the config
+// has the audit log of AuditFile.
+func (ac *AppConfig) ProjectAuditFile(project string) string {
+	return ac.projectFile(project, ".audit")
+}
+
+// Name of the proposals file of a project room, like ProjectAuditFile
+func (ac *AppConfig) ProjectProposalsFile(project string) string {
+	return ac.projectFile(project, ".proposals")
+}
+
+func (ac *AppConfig) projectFile(project string, ext string) string {
+	base := strings.TrimSuffix(ac.filename, filepath.Ext(ac.filename))
+	if project != ac.Project {
+		base += "." + project
+	}
+	return base + ext
+}
+
 // Has the file been loaded from disk
 func (ac *AppConfig) IsLoaded() bool {
 	return ac.isLoaded
@@ -105,47 +247,56 @@ func (ac *AppConfig) Save(filename string) error {
 	if FileExists(filename) {
 		return fmt.Errorf("config file %s already exists", filename)
 	}
+
+	lock, err := acquireLock(filename)
+	if err != nil {
+		return err
+	}
 	ac.filename = filename
-	ac.Persist()
-	return nil
+	ac.lock = lock
+	return ac.Persist()
 }
 
-// Write the current config back to disk
-func (ac *AppConfig) Persist() {
+// Write the current config back to disk. The previous file is kept as a backup, and a crash while writing
+// leaves either the previous or the new file, never a partly written one.
+func (ac *AppConfig) Persist() error {
 	ac.mutex.Lock()
 	defer ac.mutex.Unlock()
 
-	now := time.Now().UTC()
+	if ac.readOnly {
+		return ErrConfigReadOnly
+	}
+	if ac.filename == "" {
+		return errors.New("config has no file to be saved to")
+	}
 
-	// TODO should probably do something like this and keep some backups?
-	// if ac.Exists() {
-	// 	backupname := ac.cfgFile + now.Format(".2006-01-02T15-04-05.0") + ".bak"
+	now := time.Now().UTC()
 
-	// 	err := os.Rename(ac.cfgFile, backupname)
-	// 	if err != nil {
-	// 		fmt.Fprintf(os.Stderr, "Fatal error saving backup config file %s: %v \n", backupname, err)
-	// 		os.Exit(1)
-	// 	}
-	// }
+	if err := rotateBackups(ac.filename, now); err != nil {
+		return fmt.Errorf("error saving backup of config file %s: %w", ac.filename, err)
+	}
 
 	ac.UpdatedAt = now
 
 	jsonb, err := json.MarshalIndent(ac, "", "  ")
+	if err == nil && ac.secrets != nil {
+		jsonb, err = ac.sealSecrets(jsonb)
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Fatal error preparing to save config file %s: %v \n", ac.filename, err)
-		os.Exit(1)
+		return fmt.Errorf("error preparing to save config file %s: %w", ac.filename, err)
 	}
 
 	err = write(ac.filename, jsonb)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Fatal error saving config file %s: %v \n", ac.filename, err)
-		os.Exit(1)
+		return fmt.Errorf("error saving config file %s: %w", ac.filename, err)
 	}
 	ac.isLoaded = true
+	return nil
 }
 
-// Create a new empty wallet which will hold a mpc share after the multi-party keygen protocol has been completed
-func (ac *AppConfig) NewEmptyWallet(name string, threshold int, signers []user.User) *ethwallet.Wallet {
+// Create a new empty wallet of a project which will hold a mpc share after the multi-party keygen protocol
+// has been completed
+func (ac *AppConfig) NewEmptyWallet(project string, name string, threshold int, signers []user.User) *ethwallet.Wallet {
 	others := []user.User{}
 	for _, u := range signers {
 		if u.Address != ac.Me.Address {
@@ -154,9 +305,101 @@ func (ac *AppConfig) NewEmptyWallet(name string, threshold int, signers []user.U
 	}
 
 	w := ethwallet.NewEmptyWallet(ac.Network, name, threshold, ac.Me.User, others)
+	if project != ac.Project {
+		w.Project = project
+	}
 	return w
 }
 
+var errInvalidProjectName = errors.New("project names may only contain letters, digits, '-', '_' and '.'")
+
+// The projects we take part in, the project of the config first
+func (ac *AppConfig) ProjectNames() []string {
+	return append([]string{ac.Project}, ac.Projects...)
+}
+
+func (ac *AppConfig) HasProject(project string) bool {
+	for _, p := range ac.ProjectNames() {
+		if p == project {
+			return true
+		}
+	}
+	return false
+}
+
+// Take part in another project, whose room is joined next to the one of the config project. Adding a
+// project we already take part in does nothing.
+func (ac *AppConfig) AddProject(project string) error {
+	if project == "" || strings.Trim(project, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_.") != "" {
+		return errInvalidProjectName
+	}
+	if ac.HasProject(project) {
+		return nil
+	}
+
+	ac.mutex.Lock()
+	ac.Projects = append(ac.Projects, project)
+	ac.mutex.Unlock()
+
+	return ac.Persist()
+}
+
+// Stop taking part in a project, which must not have wallets anymore
+func (ac *AppConfig) RemoveProject(project string) error {
+	if project == ac.Project {
+		return fmt.Errorf("project %s is the project of the config and can not be removed", project)
+	}
+	if !ac.HasProject(project) {
+		return fmt.Errorf("not taking part in project %s", project)
+	}
+	if names := ac.ProjectWalletNames(project); len(names) > 0 {
+		return fmt.Errorf("project %s still has wallets %s", project, strings.Join(names, ", "))
+	}
+
+	ac.mutex.Lock()
+	projects := []string{}
+	for _, p := range ac.Projects {
+		if p != project {
+			projects = append(projects, p)
+		}
+	}
+	ac.Projects = projects
+	delete(ac.RoomKeys, project)
+	delete(ac.Members, project)
+	delete(ac.PendingJoins, project)
+	ac.mutex.Unlock()
+
+	return ac.Persist()
+}
+
+// The project room a wallet belongs to
+func (ac *AppConfig) WalletProject(w *ethwallet.Wallet) string {
+	if w.Project == "" {
+		return ac.Project
+	}
+	return w.Project
+}
+
+// The wallet with the name if it belongs to the project, nil otherwise
+func (ac *AppConfig) FindProjectWallet(project string, name string) *ethwallet.Wallet {
+	w := ac.FindWallet(name)
+	if w == nil || ac.WalletProject(w) != project {
+		return nil
+	}
+	return w
+}
+
+// The sorted names of the wallets of a project
+func (ac *AppConfig) ProjectWalletNames(project string) []string {
+	names := []string{}
+	for _, name := range ac.SortedWalletNames() {
+		if ac.WalletProject(ac.Wallets[name]) == project {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
 func (ac *AppConfig) FindWallet(name string) *ethwallet.Wallet {
 	return ac.Wallets[name]
 }
@@ -166,8 +409,93 @@ func (ac *AppConfig) AddWallet(w *ethwallet.Wallet) error {
 	ac.Wallets[w.GetName()] = w
 	ac.mutex.Unlock()
 
-	ac.Persist()
-	return nil
+	return ac.Persist()
+}
+
+func (ac *AppConfig) RemoveWallet(name string) error {
+	ac.mutex.Lock()
+	delete(ac.Wallets, name)
+	ac.mutex.Unlock()
+
+	return ac.Persist()
+}
+
+// Remember the signers of a wallet we chose to sign with, so they are selected first next time
+func (ac *AppConfig) PreferSigners(name string, nicks []string) error {
+	ac.mutex.Lock()
+	w, ok := ac.Wallets[name]
+	if !ok {
+		ac.mutex.Unlock()
+		return nil
+	}
+
+	preferred := []string{}
+	chosen := make(map[string]bool)
+	for _, nick := range nicks {
+		if nick != ac.Me.Nick && !chosen[nick] {
+			preferred = append(preferred, nick)
+			chosen[nick] = true
+		}
+	}
+	for _, nick := range w.PreferredSigners {
+		if !chosen[nick] {
+			preferred = append(preferred, nick)
+		}
+	}
+	w.PreferredSigners = preferred
+	ac.mutex.Unlock()
+
+	return ac.Persist()
+}
+
+// Set the fee strategy of a wallet and the most its transactions may pay in fees, nil for no maximum
+func (ac *AppConfig) SetWalletFees(name string, strategy fees.Strategy, maxFee *big.Int) error {
+	if err := strategy.Validate(); err != nil {
+		return err
+	}
+	ac.mutex.Lock()
+	w, ok := ac.Wallets[name]
+	if !ok {
+		ac.mutex.Unlock()
+		return fmt.Errorf("wallet %s not found", name)
+	}
+	w.Fees = strategy
+	w.MaxFee = maxFee
+	ac.mutex.Unlock()
+
+	return ac.Persist()
+}
+
+// Add or update an address book entry
+func (ac *AppConfig) AddAddress(e addressbook.Entry) error {
+	ac.mutex.Lock()
+	err := ac.AddressBook.Add(e)
+	ac.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return ac.Persist()
+}
+
+func (ac *AppConfig) RemoveAddress(labelOrAddress string) error {
+	ac.mutex.Lock()
+	err := ac.AddressBook.Remove(labelOrAddress)
+	ac.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return ac.Persist()
+}
+
+// Remember that we sent to addr, so it is no longer a first-time destination
+func (ac *AppConfig) RecordSend(addr common.Address) error {
+	ac.mutex.Lock()
+	ac.AddressBook.RecordSend(addr)
+	ac.mutex.Unlock()
+
+	return ac.Persist()
 }
 
 func (ac *AppConfig) RenameWallet(oldName string, newName string) error {
@@ -175,6 +503,7 @@ func (ac *AppConfig) RenameWallet(oldName string, newName string) error {
 
 	_, found := ac.Wallets[newName]
 	if found {
+		ac.mutex.Unlock()
 		return errors.New("Cannot rename wallet, new name already exists")
 	}
 
@@ -186,9 +515,7 @@ func (ac *AppConfig) RenameWallet(oldName string, newName string) error {
 
 	ac.mutex.Unlock()
 
-	ac.Persist()
-
-	return nil
+	return ac.Persist()
 }
 
 func (ac *AppConfig) SortedWalletNames() []string {
@@ -223,11 +550,13 @@ func (ac *AppConfig) String() string {
   Config File: %s			
   Blockchain: %s
   Network: %s
-  Project: %s
+  Project: %s %s
   Nick: %s
   PeerID: %s
   Address: %s
-			`, ac.CfgFile(), ac.Blockchain, ac.Network, ac.Project, ac.Me.Nick, ac.Me.PeerID(), ac.Me.Address)
+  P2PNetwork: %s %s
+  SecretStore: %s
+			`, ac.CfgFile(), ac.Blockchain, ac.Network, ac.Project, strings.Join(ac.Projects, " "), ac.Me.Nick, ac.Me.PeerID(), ac.Me.Address, ac.P2PNetwork, ac.RelayURL, ac.SecretStoreConfig())
 	return msg
 }
 
@@ -249,21 +578,3 @@ func FileExists(filename string) bool {
 	}
 	return !info.IsDir()
 }
-
-// Create and write a new file
-func write(name string, data []byte) error {
-	f, err := os.Create(name)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	if err := f.Chmod(0600); err != nil {
-		return err
-	}
-	if _, err := f.Write(data); err != nil {
-		return err
-	}
-
-	return f.Sync()
-}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"sort"
+
+	"github.com/shykerbogdan/mpc-wallet/user"
+)
+
+// Replace our identity, e.g. after user.Me.RotateIdentity, in the config and in all wallets, and persist
+// it. The config is left as it was when persisting fails.
+func (ac *AppConfig) SetIdentity(me user.Me) error {
+	ac.mutex.Lock()
+	previous := ac.Me
+	ac.Me = me
+	for _, w := range ac.Wallets {
+		w.Me = me.User
+	}
+	ac.identStored = false
+	ac.mutex.Unlock()
+
+	if err := ac.Persist(); err != nil {
+		ac.mutex.Lock()
+		ac.Me = previous
+		for _, w := range ac.Wallets {
+			w.Me = previous.User
+		}
+		ac.mutex.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Take the new identity key of another signer, who replaced the previous one with user.Me.RotateIdentity.
+// Only signers and members u replaces, see user.User.Replaces, are updated. Returns the names of the
+// wallets updated.
+func (ac *AppConfig) ReplaceSigner(u user.User) ([]string, error) {
+	ac.mutex.Lock()
+	updated := []string{}
+	for name, w := range ac.Wallets {
+		replaced := false
+		for i, o := range w.Others {
+			if u.Replaces(o) {
+				w.Others[i] = u
+				replaced = true
+			}
+		}
+		if replaced {
+			updated = append(updated, name)
+		}
+	}
+	members := false
+	for _, users := range ac.Members {
+		for i, m := range users {
+			if u.Replaces(m) {
+				users[i] = u
+				members = true
+			}
+		}
+	}
+	ac.mutex.Unlock()
+	sort.Strings(updated)
+
+	if len(updated) == 0 && !members {
+		return updated, nil
+	}
+	return updated, ac.Persist()
+}
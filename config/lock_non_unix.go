@@ -0,0 +1,16 @@
+//go:build !darwin && !linux && !netbsd && !openbsd
+
+package config
+
+import (
+	"errors"
+	"os"
+)
+
+var errWouldBlock = errors.New("lock is held by another process")
+
+// lockFile is a no-op on non-unix systems, running two thresher processes on the same config is up to the user
+func lockFile(f *os.File) error { return nil }
+
+// syncDir is a no-op on non-unix systems, where directories can not be opened for syncing
+func syncDir(dir string) error { return nil }
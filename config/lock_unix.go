@@ -0,0 +1,30 @@
+//go:build darwin || linux || netbsd || openbsd
+
+package config
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+var errWouldBlock = errors.New("lock is held by another process")
+
+// Take an exclusive lock of f without waiting for it
+func lockFile(f *os.File) error {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return errWouldBlock
+	}
+	return err
+}
+
+// Flush the entries of a directory to disk, e.g. after renaming a file in it
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,32 @@
+//go:build darwin || linux || netbsd || openbsd
+
+package config
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLoadLocksConfig(t *testing.T) {
+	filename := tempConfig(t)
+	ac, err := Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(filename); !errors.Is(err, ErrConfigLocked) {
+		t.Fatalf("second Load: got %v, want ErrConfigLocked", err)
+	}
+	if _, err := LoadReadOnly(filename); err != nil {
+		t.Fatalf("LoadReadOnly of a locked config: %v", err)
+	}
+
+	if err := ac.Close(); err != nil {
+		t.Fatal(err)
+	}
+	again, err := Load(filename)
+	if err != nil {
+		t.Fatalf("Load after Close: %v", err)
+	}
+	again.Close()
+}
@@ -0,0 +1,136 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// The version of the config file format written by this build. Any change to AppConfig, or to the types
+// it contains, that a previous build could not read needs a bump and a migration appended to migrations.
+const CurrentVersion = 4
+
+// A migration upgrades the JSON of a config file from one version to the next. It works on the raw
+// objects rather than on AppConfig so it keeps working however the Go types change later on.
+type migration struct {
+	description string
+	apply       func(cfg map[string]json.RawMessage) error
+}
+
+// migrations[v] upgrades a config file of version v to version v+1
+var migrations = []migration{
+	{"version the config file, default the P2P network and the wallets", migrateV0},
+	{"keep the secrets in a secret store", migrateV1},
+	{"Ed25519 identities and pinned party ids", migrateV2},
+	{"room keys, members and invitations", migrateV3},
+}
+
+var ErrConfigTooNew = errors.New("config file was written by a newer version of thresher")
+
+// Upgrade the JSON of a config file to CurrentVersion. Returns the upgraded JSON and the version the file
+// had, the JSON is returned unchanged when it already is the current version.
+func Migrate(data []byte) ([]byte, int, error) {
+	var cfg map[string]json.RawMessage
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, 0, err
+	}
+	if cfg == nil {
+		return nil, 0, errors.New("config file is not a JSON object")
+	}
+
+	version, err := configVersion(cfg)
+	if err != nil {
+		return nil, 0, err
+	}
+	if version > CurrentVersion {
+		return nil, version, fmt.Errorf("%w: it has version %d, this build reads up to version %d", ErrConfigTooNew, version, CurrentVersion)
+	}
+	if version == CurrentVersion {
+		return data, version, nil
+	}
+
+	for v := version; v < CurrentVersion; v++ {
+		if err := migrations[v].apply(cfg); err != nil {
+			return nil, version, fmt.Errorf("error upgrading config file from version %d (%s): %w", v, migrations[v].description, err)
+		}
+		cfg["Version"] = json.RawMessage(strconv.Itoa(v + 1))
+	}
+
+	upgraded, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return nil, version, err
+	}
+	return upgraded, version, nil
+}
+
+// Config files from before versioning have no Version and are version 0
+func configVersion(cfg map[string]json.RawMessage) (int, error) {
+	raw, ok := cfg["Version"]
+	if !ok {
+		return 0, nil
+	}
+
+	var version int
+	if err := json.Unmarshal(raw, &version); err != nil {
+		return 0, fmt.Errorf("config file has an invalid Version %s: %w", raw, err)
+	}
+	if version < 0 {
+		return 0, fmt.Errorf("config file has an invalid Version %d", version)
+	}
+	return version, nil
+}
+
+// Name of the copy of a config file kept before upgrading it, e.g. DAOTreasury-alice.json.v0.2021-11-02T10-04-05.0.bak
+func migrationBackupName(filename string, version int, now time.Time) string {
+	return fmt.Sprintf("%s.v%d%s.bak", filename, version, now.Format(".2006-01-02T15-04-05.0"))
+}
+
+// Version 0 is the unversioned format. Fields were only ever added to it, but files written before a field
+// existed lack it, and hand edited files may have a null Wallets which AddWallet can not add to.
+func migrateV0(cfg map[string]json.RawMessage) error {
+	if isMissing(cfg, "Me") {
+		return errors.New("config file has no identity")
+	}
+
+	if isMissing(cfg, "Wallets") {
+		cfg["Wallets"] = json.RawMessage("{}")
+	}
+
+	var network string
+	if !isMissing(cfg, "P2PNetwork") {
+		if err := json.Unmarshal(cfg["P2PNetwork"], &network); err != nil {
+			return fmt.Errorf("invalid P2PNetwork: %w", err)
+		}
+	}
+	if network == "" {
+		cfg["P2PNetwork"] = json.RawMessage(strconv.Quote(P2PNetworkChat))
+	}
+
+	return nil
+}
+
+func isMissing(cfg map[string]json.RawMessage, name string) bool {
+	raw, ok := cfg[name]
+	return !ok || string(raw) == "null"
+}
+
+// Version 2 may keep the identity key and the key shares in a SecretStore, which previous builds can not
+// read. Version 1 files keep them in the file itself, which is the file backend of version 2.
+func migrateV1(cfg map[string]json.RawMessage) error {
+	return nil
+}
+
+// Version 3 users may have an Ed25519 identity key, and a PartyID pinned when their identity key was
+// replaced, which previous builds would ignore and derive another party id instead. Version 2 identities
+// are RSA keys whose party id is derived as before.
+func migrateV2(cfg map[string]json.RawMessage) error {
+	return nil
+}
+
+// Version 4 may have room keys, members and invitations, which previous builds would drop when saving the
+// file. Version 3 files have none of them, their rooms are found by project name as before.
+func migrateV3(cfg map[string]json.RawMessage) error {
+	return nil
+}
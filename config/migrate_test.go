@@ -0,0 +1,178 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// Regenerate the golden files with: go test ./config -update
+var update = flag.Bool("update", false, "update the golden files of config migrations")
+
+// Each config format ever written has a sample in testdata/config-v<version>.json, and each format older
+// than CurrentVersion the upgraded JSON in testdata/config-v<version>.golden.json
+func sampleFile(version int) string {
+	return filepath.Join("testdata", fmt.Sprintf("config-v%d.json", version))
+}
+
+func goldenFile(version int) string {
+	return filepath.Join("testdata", fmt.Sprintf("config-v%d.golden.json", version))
+}
+
+func TestMigrateGolden(t *testing.T) {
+	for version := 0; version <= CurrentVersion; version++ {
+		data, err := ioutil.ReadFile(sampleFile(version))
+		if err != nil {
+			t.Fatalf("no sample of config version %d: %v", version, err)
+		}
+
+		upgraded, from, err := Migrate(data)
+		if err != nil {
+			t.Fatalf("Migrate(%s): %v", sampleFile(version), err)
+		}
+		if from != version {
+			t.Errorf("Migrate(%s) found version %d, want %d", sampleFile(version), from, version)
+		}
+
+		if version == CurrentVersion {
+			if !bytes.Equal(upgraded, data) {
+				t.Errorf("Migrate(%s) changed a config of the current version", sampleFile(version))
+			}
+			continue
+		}
+
+		if *update {
+			if err := ioutil.WriteFile(goldenFile(version), upgraded, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		golden, err := ioutil.ReadFile(goldenFile(version))
+		if err != nil {
+			t.Fatalf("no golden file of config version %d: %v", version, err)
+		}
+		if !bytes.Equal(upgraded, golden) {
+			t.Errorf("Migrate(%s) does not match %s:\n%s", sampleFile(version), goldenFile(version), upgraded)
+		}
+
+		// Upgrading must be idempotent
+		again, from, err := Migrate(upgraded)
+		if err != nil || from != CurrentVersion || !bytes.Equal(again, upgraded) {
+			t.Errorf("Migrate of the upgraded %s is not a no-op: version %d, %v", sampleFile(version), from, err)
+		}
+	}
+}
+
+func TestLoadHistoricalFormats(t *testing.T) {
+	for version := 0; version <= CurrentVersion; version++ {
+		data, err := ioutil.ReadFile(sampleFile(version))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dir := t.TempDir()
+		filename := filepath.Join(dir, "P-alice.json")
+		if err := ioutil.WriteFile(filename, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		ac, err := Load(filename)
+		if err != nil {
+			t.Fatalf("version %d: %v", version, err)
+		}
+		defer ac.Close()
+		if ac.Version != CurrentVersion {
+			t.Errorf("version %d: loaded config has version %d", version, ac.Version)
+		}
+		if ac.Me.Nick != "alice" || ac.Me.IdentPrivKey == nil {
+			t.Errorf("version %d: identity was not loaded: %q", version, ac.Me.Nick)
+		}
+		if ac.P2PNetwork != P2PNetworkChat {
+			t.Errorf("version %d: P2PNetwork is %q", version, ac.P2PNetwork)
+		}
+		w := ac.FindWallet("w1")
+		if w == nil {
+			t.Fatalf("version %d: wallet w1 was not loaded", version)
+		}
+		if got := w.GetFormattedAddress(); got != "0x03bE082ff187B083A540d0bA048F9B7B6516919B" {
+			t.Errorf("version %d: wallet w1 has address %s", version, got)
+		}
+		if err := w.VerifyKeyShare(); err != nil {
+			t.Errorf("version %d: key share of w1: %v", version, err)
+		}
+
+		backups, err := filepath.Glob(filename + ".v*.bak")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version == CurrentVersion {
+			if len(backups) != 0 {
+				t.Errorf("loading the current version made backups %v", backups)
+			}
+			continue
+		}
+		if len(backups) != 1 {
+			t.Fatalf("version %d: want one backup, got %v", version, backups)
+		}
+		backup, err := ioutil.ReadFile(backups[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(backup, data) {
+			t.Errorf("version %d: backup %s differs from the original file", version, backups[0])
+		}
+
+		// The upgraded file must be saved, so the next load neither migrates nor backs up again
+		persisted, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, from, err := Migrate(persisted); err != nil || from != CurrentVersion {
+			t.Errorf("version %d: persisted file has version %d, %v", version, from, err)
+		}
+	}
+}
+
+func TestMigrateRefusesNewerVersion(t *testing.T) {
+	data := []byte(fmt.Sprintf(`{"Version": %d, "Me": {}}`, CurrentVersion+1))
+	_, _, err := Migrate(data)
+	if !errors.Is(err, ErrConfigTooNew) {
+		t.Errorf("Migrate of a newer version: got %v, want ErrConfigTooNew", err)
+	}
+}
+
+func TestMigrateInvalid(t *testing.T) {
+	for _, data := range []string{
+		`[]`,
+		`null`,
+		`{"Version": "1"}`,
+		`{"Version": -1}`,
+		`{"Wallets": {}}`,
+		`{"Me": {}, "P2PNetwork": 5}`,
+	} {
+		if _, _, err := Migrate([]byte(data)); err == nil {
+			t.Errorf("Migrate(%s) succeeded", data)
+		}
+	}
+}
+
+func TestMigrateV0Defaults(t *testing.T) {
+	upgraded, _, err := Migrate([]byte(`{"Me": {"Nick": "alice"}, "Wallets": null}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{
+  "Me": {
+    "Nick": "alice"
+  },
+  "P2PNetwork": "chatnet",
+  "Version": 4,
+  "Wallets": {}
+}`
+	if string(upgraded) != want {
+		t.Errorf("Migrate of a bare version 0 config:\n%s\nwant:\n%s", upgraded, want)
+	}
+}
@@ -0,0 +1,222 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How many backups of the config file Persist keeps, older ones are removed. The backups made when upgrading
+// the format are counted apart, so a burst of saves does not remove the copy from before an upgrade.
+const backupsKept = 5
+
+var (
+	ErrConfigLocked   = errors.New("config file is in use by another thresher process")
+	ErrConfigReadOnly = errors.New("config file was loaded read-only")
+)
+
+// Name of the lock file of a config file. The config file itself can not be locked, as it is replaced
+// rather than written to.
+func lockFileName(filename string) string {
+	return filename + ".lock"
+}
+
+// Take the lock of a config file for as long as this process runs or until it is released, so no other
+// thresher process writes the file in the meantime
+func acquireLock(filename string) (*os.File, error) {
+	f, err := os.OpenFile(lockFileName(filename), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening lock of config file %s: %w", filename, err)
+	}
+
+	err = lockFile(f)
+	if errors.Is(err, errWouldBlock) {
+		pid, _ := ioutil.ReadAll(f)
+		f.Close()
+		if len(pid) > 0 {
+			return nil, fmt.Errorf("%w: %s is locked by process %s", ErrConfigLocked, filename, strings.TrimSpace(string(pid)))
+		}
+		return nil, fmt.Errorf("%w: %s is locked", ErrConfigLocked, filename)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("error locking config file %s: %w", filename, err)
+	}
+
+	// Tell whoever finds the file locked who holds it
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return f, nil
+}
+
+// Release the lock of the config file, if we hold it, and close its secret store. Exiting the process
+// releases the lock as well.
+func (ac *AppConfig) Close() error {
+	ac.mutex.Lock()
+	defer ac.mutex.Unlock()
+
+	var err error
+	if ac.secrets != nil {
+		err = ac.secrets.Close()
+		ac.secrets = nil
+	}
+	if ac.lock != nil {
+		if lockErr := ac.lock.Close(); err == nil {
+			err = lockErr
+		}
+		ac.lock = nil
+	}
+	return err
+}
+
+// Name of a rotating backup of a config file, e.g. DAOTreasury-alice.json.2021-11-02T10-04-05.123.bak
+func backupName(filename string, now time.Time) string {
+	return filename + now.Format(".2006-01-02T15-04-05.000") + ".bak"
+}
+
+// The rotating backups of a config file, oldest first
+func backupNames(filename string) ([]string, error) {
+	names, err := filepath.Glob(filename + ".[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T*.bak")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// The backups of a config file made when upgrading its format, oldest first. They are ordered by the time
+// in their name, as the version before it does not sort as text once it has two digits.
+func migrationBackupNames(filename string) ([]string, error) {
+	names, err := filepath.Glob(filename + ".v[0-9]*.bak")
+	if err != nil {
+		return nil, err
+	}
+	made := func(name string) string {
+		return strings.TrimLeft(strings.TrimPrefix(name, filename+".v"), "0123456789")
+	}
+	sort.Slice(names, func(i, j int) bool { return made(names[i]) < made(names[j]) })
+	return names, nil
+}
+
+// The backups of the config file: the rotating ones, oldest first, and those made when upgrading its format
+func (ac *AppConfig) BackupFiles() ([]string, error) {
+	names, err := backupNames(ac.filename)
+	if err != nil {
+		return nil, err
+	}
+	upgrades, err := migrationBackupNames(ac.filename)
+	if err != nil {
+		return nil, err
+	}
+	return append(names, upgrades...), nil
+}
+
+// Remove all backups of the config file, e.g. once the secrets they hold in plaintext have been moved to a
+// secret store, returning the removed files. Each is overwritten first, so the secrets do not linger on disk
+// on filesystems that write in place.
+func (ac *AppConfig) RemoveBackups() ([]string, error) {
+	names, err := ac.BackupFiles()
+	if err != nil {
+		return nil, err
+	}
+	removed := []string{}
+	for _, name := range names {
+		if err := shred(name); err != nil {
+			return removed, err
+		}
+		removed = append(removed, name)
+	}
+	return removed, nil
+}
+
+// Overwrite a file with zeros and remove it
+func shred(name string) error {
+	f, err := os.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	err = func() error {
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(make([]byte, info.Size())); err != nil {
+			return err
+		}
+		return f.Sync()
+	}()
+	if err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
+
+// Copy the config file as it is on disk to a new backup, and remove the oldest backups beyond backupsKept,
+// of the rotating ones and of those made when upgrading the format each
+func rotateBackups(filename string, now time.Time) error {
+	current, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := write(backupName(filename, now), current); err != nil {
+		return err
+	}
+
+	for _, list := range []func(string) ([]string, error){backupNames, migrationBackupNames} {
+		names, err := list(filename)
+		if err != nil {
+			return err
+		}
+		for len(names) > backupsKept {
+			if err := os.Remove(names[0]); err != nil {
+				return err
+			}
+			names = names[1:]
+		}
+	}
+	return nil
+}
+
+// Replace a file with data without ever leaving a partly written file behind: write a temporary file next
+// to it, flush it to disk, and rename it over the file
+func write(name string, data []byte) error {
+	dir := filepath.Dir(name)
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(name)+".tmp-")
+	if err != nil {
+		return err
+	}
+	tmpname := f.Name()
+
+	err = func() error {
+		defer f.Close()
+		if err := f.Chmod(0600); err != nil {
+			return err
+		}
+		if _, err := f.Write(data); err != nil {
+			return err
+		}
+		return f.Sync()
+	}()
+	if err == nil {
+		err = os.Rename(tmpname, name)
+	}
+	if err != nil {
+		os.Remove(tmpname)
+		return err
+	}
+
+	// Make the rename itself durable
+	return syncDir(dir)
+}
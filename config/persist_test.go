@@ -0,0 +1,151 @@
+package config
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Copy the sample of the current config format to a temporary directory
+func tempConfig(t *testing.T) string {
+	data, err := ioutil.ReadFile(sampleFile(CurrentVersion))
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(t.TempDir(), "P-alice.json")
+	if err := ioutil.WriteFile(filename, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestPersistRotatesBackups(t *testing.T) {
+	filename := tempConfig(t)
+	ac, err := Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ac.Close()
+
+	for i := 0; i < backupsKept+3; i++ {
+		if err := ac.AddProject("Q" + string(rune('a'+i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	names, err := backupNames(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != backupsKept {
+		t.Fatalf("got %d backups, want %d: %v", len(names), backupsKept, names)
+	}
+
+	// The newest backup is the file as it was before the last Persist
+	newest, err := LoadReadOnly(names[len(names)-1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(newest.Projects), len(ac.Projects)-1; got != want {
+		t.Errorf("newest backup has %d projects, want %d", got, want)
+	}
+
+	temps, _ := filepath.Glob(filepath.Join(filepath.Dir(filename), ".*.tmp-*"))
+	if len(temps) != 0 {
+		t.Errorf("temporary files were left behind: %v", temps)
+	}
+}
+
+func TestPersistRotatesMigrationBackups(t *testing.T) {
+	filename := tempConfig(t)
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Versions 9 and 10 show the oldest is found by time, not by name
+	made := time.Date(2021, 11, 2, 10, 4, 5, 0, time.UTC)
+	var oldest []string
+	for i := 0; i < backupsKept+2; i++ {
+		name := migrationBackupName(filename, 9+i%2, made.Add(time.Duration(i)*time.Hour))
+		if err := ioutil.WriteFile(name, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if i < 2 {
+			oldest = append(oldest, name)
+		}
+	}
+
+	ac, err := Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ac.Close()
+	if err := ac.Persist(); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := migrationBackupNames(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != backupsKept {
+		t.Fatalf("got %d migration backups, want %d: %v", len(names), backupsKept, names)
+	}
+	for _, name := range names {
+		if name == oldest[0] || name == oldest[1] {
+			t.Errorf("oldest migration backup %s was kept", name)
+		}
+	}
+	all, err := ac.BackupFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != backupsKept+1 {
+		t.Errorf("got %d backup files, want %d: %v", len(all), backupsKept+1, all)
+	}
+}
+
+func TestReadOnlyConfigIsNotPersisted(t *testing.T) {
+	ac, err := LoadReadOnly(tempConfig(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ac.AddProject("R"); !errors.Is(err, ErrConfigReadOnly) {
+		t.Errorf("AddProject on a read-only config: got %v, want ErrConfigReadOnly", err)
+	}
+}
+
+func TestRemoveBackups(t *testing.T) {
+	filename := tempConfig(t)
+	ac, err := Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ac.Close()
+	if err := ac.Persist(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(migrationBackupName(filename, 3, time.Now()), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := ac.RemoveBackups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 2 {
+		t.Errorf("removed %v", removed)
+	}
+	if left, _ := ac.BackupFiles(); len(left) != 0 {
+		t.Errorf("backups left: %v", left)
+	}
+	if _, err := LoadReadOnly(filename); err != nil {
+		t.Errorf("config file not readable after removing its backups: %v", err)
+	}
+}
@@ -0,0 +1,191 @@
+package config
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/shykerbogdan/mpc-wallet/invite"
+	"github.com/shykerbogdan/mpc-wallet/user"
+)
+
+// The pre-shared key of the room of a project, nil if it has none
+func (ac *AppConfig) RoomKey(project string) []byte {
+	ac.mutex.Lock()
+	defer ac.mutex.Unlock()
+	return ac.RoomKeys[project]
+}
+
+// The name the room of a project is found by, the pubsub topic or relay room. Rooms with a key are named
+// after a hash of the key and the project, so only those who have the key find them.
+func (ac *AppConfig) RoomName(project string) string {
+	key := ac.RoomKey(project)
+	if key == nil {
+		return project
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("thresher-room:" + project))
+	return "thresher-room-" + hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// Set the room key of a project and persist it
+func (ac *AppConfig) SetRoomKey(project string, key []byte) error {
+	if len(key) != invite.RoomKeySize {
+		return fmt.Errorf("room key must be %d bytes", invite.RoomKeySize)
+	}
+
+	ac.mutex.Lock()
+	if ac.RoomKeys == nil {
+		ac.RoomKeys = make(map[string][]byte)
+	}
+	ac.RoomKeys[project] = key
+	ac.mutex.Unlock()
+
+	return ac.Persist()
+}
+
+// The other signers registered as members of a project
+func (ac *AppConfig) ProjectMembers(project string) []user.User {
+	ac.mutex.Lock()
+	defer ac.mutex.Unlock()
+	return append([]user.User{}, ac.Members[project]...)
+}
+
+// Whether u is a registered member of a project, by identity key
+func (ac *AppConfig) IsMember(project string, u user.User) bool {
+	for _, m := range ac.ProjectMembers(project) {
+		if m.IdentPubKey.Equals(u.IdentPubKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether u is a registered member of a project, or a co-signer of one of its wallets, by identity key
+func (ac *AppConfig) IsKnownSigner(project string, u user.User) bool {
+	if u.IdentPubKey == nil {
+		return false
+	}
+	if ac.IsMember(project, u) {
+		return true
+	}
+	for _, name := range ac.ProjectWalletNames(project) {
+		for _, o := range ac.FindProjectWallet(project, name).Others {
+			if o.IdentPubKey != nil && o.IdentPubKey.Equals(u.IdentPubKey) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Whether to talk to u in the room of a project. A project without members or wallets has no signers to
+// check against yet, as after 'thresher init', and trusts everyone in its room like the libp2p rooms do.
+func (ac *AppConfig) IsTrustedSigner(project string, u user.User) bool {
+	if len(ac.ProjectMembers(project)) == 0 && len(ac.ProjectWalletNames(project)) == 0 {
+		return u.IdentPubKey != nil
+	}
+	return ac.IsKnownSigner(project, u)
+}
+
+// Register other signers as members of a project and persist them. Members already registered are left
+// as they are, and a nick can not be registered again with another identity key.
+func (ac *AppConfig) AddMembers(project string, users []user.User) error {
+	ac.mutex.Lock()
+	members := ac.Members[project]
+	added := false
+	for _, u := range users {
+		if u.Nick == ac.Me.Nick {
+			continue
+		}
+		known := false
+		for _, m := range members {
+			if m.Nick == u.Nick {
+				if !m.IdentPubKey.Equals(u.IdentPubKey) {
+					ac.mutex.Unlock()
+					return fmt.Errorf("%s is already a member of project %s with another identity key", u.Nick, project)
+				}
+				known = true
+			}
+		}
+		if !known {
+			members = append(members, u)
+			added = true
+		}
+	}
+	if !added {
+		ac.mutex.Unlock()
+		return nil
+	}
+	if ac.Members == nil {
+		ac.Members = make(map[string][]user.User)
+	}
+	ac.Members[project] = members
+	ac.mutex.Unlock()
+
+	return ac.Persist()
+}
+
+// Remember an invitation we issued and persist it
+func (ac *AppConfig) AddInvitation(inv invite.Issued) error {
+	ac.mutex.Lock()
+	ac.Invitations = append(ac.Invitations, inv)
+	ac.mutex.Unlock()
+
+	return ac.Persist()
+}
+
+// Find an invitation we issued that has not expired or been used yet
+func (ac *AppConfig) FindInvitation(id string) (invite.Issued, bool) {
+	ac.mutex.Lock()
+	defer ac.mutex.Unlock()
+
+	now := time.Now()
+	for _, inv := range ac.Invitations {
+		if inv.ID == id && now.Before(inv.Expires) {
+			return inv, true
+		}
+	}
+	return invite.Issued{}, false
+}
+
+// Forget an invitation once it has been used, and those that expired, and persist it
+func (ac *AppConfig) UseInvitation(id string) error {
+	ac.mutex.Lock()
+	now := time.Now()
+	kept := []invite.Issued{}
+	for _, inv := range ac.Invitations {
+		if inv.ID != id && now.Before(inv.Expires) {
+			kept = append(kept, inv)
+		}
+	}
+	ac.Invitations = kept
+	ac.mutex.Unlock()
+
+	return ac.Persist()
+}
+
+// The invitation token of a project we joined and have not been admitted to yet, empty if none
+func (ac *AppConfig) PendingJoin(project string) string {
+	ac.mutex.Lock()
+	defer ac.mutex.Unlock()
+	return ac.PendingJoins[project]
+}
+
+// Set the invitation token a project was joined with, empty once admitted, and persist it
+func (ac *AppConfig) SetPendingJoin(project string, token string) error {
+	ac.mutex.Lock()
+	if token == "" {
+		delete(ac.PendingJoins, project)
+	} else {
+		if ac.PendingJoins == nil {
+			ac.PendingJoins = make(map[string]string)
+		}
+		ac.PendingJoins[project] = token
+	}
+	ac.mutex.Unlock()
+
+	return ac.Persist()
+}
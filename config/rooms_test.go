@@ -0,0 +1,46 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/shykerbogdan/mpc-wallet/user"
+)
+
+func TestIsTrustedSigner(t *testing.T) {
+	ac, err := New("ethereum", "goerli", "P", "alice", "0x1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ac.Save(filepath.Join(t.TempDir(), "P-alice.json")); err != nil {
+		t.Fatal(err)
+	}
+	defer ac.Close()
+	bob, err := user.NewMe("bob", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mallory, err := user.NewMe("mallory", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a new project trusts everyone in its room
+	if !ac.IsTrustedSigner("P", mallory.User) {
+		t.Error("new project does not trust mallory")
+	}
+	if ac.IsTrustedSigner("P", user.User{}) {
+		t.Error("new project trusts a user without identity key")
+	}
+
+	// once it has members only them
+	if err := ac.AddMembers("P", []user.User{bob.User}); err != nil {
+		t.Fatal(err)
+	}
+	if !ac.IsTrustedSigner("P", bob.User) {
+		t.Error("member bob is not trusted")
+	}
+	if ac.IsTrustedSigner("P", mallory.User) {
+		t.Error("mallory is trusted after bob became a member")
+	}
+}
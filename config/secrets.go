@@ -0,0 +1,243 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	libp2pcrypto "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/shykerbogdan/mpc-wallet/secretstore"
+	"github.com/shykerbogdan/mpc-wallet/user"
+)
+
+// With a secret store other than the file backend, the secrets are not written to the config file. The
+// identity key is put into the store and Me has its name as IdentPrivKeyName instead, and the key shares
+// are sealed by the store and wallets have them as SealedKeyData instead of KeyData. This is done on the
+// JSON of the file, so the Go types and everything else marshalling them, like backups, are unaffected.
+
+// Replaced in tests
+var openSecretStore = secretstore.Open
+
+// A key share and what sealing it gave, so the store is not asked to seal it again on every Persist
+type sealedShare struct {
+	keydata []byte
+	sealed  []byte
+}
+
+// Where the secrets of the config are kept, the file backend unless it has a secret store
+func (ac *AppConfig) SecretStoreConfig() secretstore.Config {
+	if ac.SecretStore == nil {
+		return secretstore.Config{Backend: secretstore.BackendFile}
+	}
+	return *ac.SecretStore
+}
+
+// Move the secrets of the config to another secret store and persist it. The secrets are left in the
+// previous store, as the backups of the config file still need them.
+func (ac *AppConfig) SetSecretStore(c secretstore.Config) error {
+	if c.Backend == "" {
+		c.Backend = secretstore.BackendFile
+	}
+	if c.Namespace == "" && !c.IsFile() {
+		c.Namespace = ac.Project + "-" + ac.Me.Nick
+	}
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	store, err := openSecretStore(c)
+	if err != nil {
+		return err
+	}
+
+	ac.mutex.Lock()
+	previous, previousConfig, previousSealed := ac.secrets, ac.SecretStore, ac.sealed
+	ac.secrets = store
+	ac.sealed = make(map[string]sealedShare)
+	ac.identStored = false
+	ac.SecretStore = nil
+	if !c.IsFile() {
+		ac.SecretStore = &c
+	}
+	ac.mutex.Unlock()
+
+	if err := ac.Persist(); err != nil {
+		ac.mutex.Lock()
+		ac.secrets, ac.SecretStore, ac.sealed = previous, previousConfig, previousSealed
+		ac.identStored = previous != nil
+		ac.mutex.Unlock()
+		if store != nil {
+			store.Close()
+		}
+		return err
+	}
+
+	if previous != nil {
+		previous.Close()
+	}
+	return nil
+}
+
+// Open the secret store of the JSON of a config file and put the secrets kept in it back into the JSON
+func openSecrets(jsonb []byte) ([]byte, secretstore.Store, map[string]sealedShare, error) {
+	var peek struct {
+		SecretStore *secretstore.Config
+	}
+	if err := json.Unmarshal(jsonb, &peek); err != nil {
+		return nil, nil, nil, err
+	}
+	if peek.SecretStore == nil || peek.SecretStore.IsFile() {
+		return jsonb, nil, nil, nil
+	}
+
+	store, err := openSecretStore(*peek.SecretStore)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("error opening secret store %s: %w", peek.SecretStore, err)
+	}
+
+	unsealed, sealed, err := unsealSecrets(store, jsonb)
+	if err != nil {
+		store.Close()
+		return nil, nil, nil, err
+	}
+	return unsealed, store, sealed, nil
+}
+
+func unsealSecrets(store secretstore.Store, jsonb []byte) ([]byte, map[string]sealedShare, error) {
+	var cfg map[string]json.RawMessage
+	if err := json.Unmarshal(jsonb, &cfg); err != nil {
+		return nil, nil, err
+	}
+
+	var me map[string]json.RawMessage
+	if err := json.Unmarshal(cfg["Me"], &me); err != nil {
+		return nil, nil, fmt.Errorf("invalid identity: %w", err)
+	}
+	if raw, ok := me["IdentPrivKeyName"]; ok {
+		var name string
+		if err := json.Unmarshal(raw, &name); err != nil {
+			return nil, nil, fmt.Errorf("invalid IdentPrivKeyName: %w", err)
+		}
+		key, err := store.Get(name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error getting identity key %s from the secret store: %w", name, err)
+		}
+		me["IdentPrivKey"] = json.RawMessage(strconv.Quote(libp2pcrypto.ConfigEncodeKey(key)))
+		delete(me, "IdentPrivKeyName")
+	}
+
+	var wallets map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(cfg["Wallets"], &wallets); err != nil {
+		return nil, nil, fmt.Errorf("invalid wallets: %w", err)
+	}
+	sealed := make(map[string]sealedShare)
+	for name, w := range wallets {
+		raw, ok := w["SealedKeyData"]
+		if !ok {
+			continue
+		}
+		var share sealedShare
+		if err := json.Unmarshal(raw, &share.sealed); err != nil {
+			return nil, nil, fmt.Errorf("invalid SealedKeyData of wallet %s: %w", name, err)
+		}
+		keydata, err := store.Open(share.sealed)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error opening key share of wallet %s: %w", name, err)
+		}
+		share.keydata = keydata
+		sealed[name] = share
+		if err := setField(w, "KeyData", keydata); err != nil {
+			return nil, nil, err
+		}
+		delete(w, "SealedKeyData")
+	}
+
+	if err := setField(cfg, "Me", me); err != nil {
+		return nil, nil, err
+	}
+	if err := setField(cfg, "Wallets", wallets); err != nil {
+		return nil, nil, err
+	}
+	unsealed, err := json.Marshal(cfg)
+	return unsealed, sealed, err
+}
+
+// Take the secrets out of the JSON of the config, putting them into its secret store
+func (ac *AppConfig) sealSecrets(jsonb []byte) ([]byte, error) {
+	var cfg map[string]json.RawMessage
+	if err := json.Unmarshal(jsonb, &cfg); err != nil {
+		return nil, err
+	}
+
+	var me map[string]json.RawMessage
+	if err := json.Unmarshal(cfg["Me"], &me); err != nil {
+		return nil, err
+	}
+	if !ac.identStored {
+		key, err := libp2pcrypto.MarshalPrivateKey(ac.Me.IdentPrivKey)
+		if err != nil {
+			return nil, fmt.Errorf("error marshaling identity key: %w", err)
+		}
+		if err := ac.secrets.Put(identityKeyName(ac.Me), key); err != nil {
+			return nil, fmt.Errorf("error putting identity key into the secret store: %w", err)
+		}
+		ac.identStored = true
+	}
+	delete(me, "IdentPrivKey")
+	if err := setField(me, "IdentPrivKeyName", identityKeyName(ac.Me)); err != nil {
+		return nil, err
+	}
+
+	var wallets map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(cfg["Wallets"], &wallets); err != nil {
+		return nil, err
+	}
+	for name, w := range wallets {
+		var keydata []byte
+		if err := json.Unmarshal(w["KeyData"], &keydata); err != nil {
+			return nil, fmt.Errorf("invalid KeyData of wallet %s: %w", name, err)
+		}
+
+		share, ok := ac.sealed[name]
+		if !ok || !bytes.Equal(share.keydata, keydata) {
+			sealed, err := ac.secrets.Seal(keydata)
+			if err != nil {
+				return nil, fmt.Errorf("error sealing key share of wallet %s: %w", name, err)
+			}
+			share = sealedShare{keydata: keydata, sealed: sealed}
+			ac.sealed[name] = share
+		}
+
+		delete(w, "KeyData")
+		if err := setField(w, "SealedKeyData", share.sealed); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := setField(cfg, "Me", me); err != nil {
+		return nil, err
+	}
+	if err := setField(cfg, "Wallets", wallets); err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(cfg, "", "  ")
+}
+
+// Name of the identity key in the secret store. A replaced identity key is kept under its own name, as
+// the backups of the config file made before still refer to the previous one.
+func identityKeyName(me user.Me) string {
+	if me.PreviousIdentPubKey == nil {
+		return secretstore.IdentityKeyName
+	}
+	return secretstore.IdentityKeyName + "-" + me.PeerID().Pretty()
+}
+
+func setField(obj map[string]json.RawMessage, name string, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	obj[name] = raw
+	return nil
+}
@@ -0,0 +1,140 @@
+package config
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/shykerbogdan/mpc-wallet/secretstore"
+)
+
+// A secret store in memory, sealing by xoring with a fixed key so tests can tell sealed data apart
+type fakeStore struct {
+	secrets map[string][]byte
+	closed  bool
+}
+
+func (s *fakeStore) Put(name string, secret []byte) error {
+	s.secrets[name] = append([]byte{}, secret...)
+	return nil
+}
+
+func (s *fakeStore) Get(name string) ([]byte, error) {
+	secret, ok := s.secrets[name]
+	if !ok {
+		return nil, secretstore.ErrNotFound
+	}
+	return secret, nil
+}
+
+func (s *fakeStore) Delete(name string) error {
+	delete(s.secrets, name)
+	return nil
+}
+
+func (s *fakeStore) xor(data []byte) []byte {
+	key := sha256.Sum256([]byte("share-key"))
+	out := make([]byte, len(data))
+	for i := range data {
+		out[i] = data[i] ^ key[i%len(key)]
+	}
+	return out
+}
+
+func (s *fakeStore) Seal(plaintext []byte) ([]byte, error) { return s.xor(plaintext), nil }
+func (s *fakeStore) Open(sealed []byte) ([]byte, error)    { return s.xor(sealed), nil }
+func (s *fakeStore) Close() error                          { s.closed = true; return nil }
+
+func useFakeStore(t *testing.T) *fakeStore {
+	store := &fakeStore{secrets: make(map[string][]byte)}
+	openSecretStore = func(c secretstore.Config) (secretstore.Store, error) {
+		if c.IsFile() {
+			return nil, nil
+		}
+		store.closed = false
+		return store, nil
+	}
+	t.Cleanup(func() { openSecretStore = secretstore.Open })
+	return store
+}
+
+func TestSecretStoreKeepsSecretsOutOfFile(t *testing.T) {
+	store := useFakeStore(t)
+	filename := tempConfig(t)
+
+	ac, err := Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keydata := ac.FindWallet("w1").KeyData
+
+	if err := ac.SetSecretStore(secretstore.Config{Backend: secretstore.BackendKeyring}); err != nil {
+		t.Fatal(err)
+	}
+	if ns := ac.SecretStoreConfig().Namespace; ns != "P-alice" {
+		t.Errorf("namespace is %q, want P-alice", ns)
+	}
+	if _, ok := store.secrets[identityKeyName(ac.Me)]; !ok {
+		t.Error("identity key was not put into the secret store")
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte(`"IdentPrivKey"`)) || bytes.Contains(data, []byte(`"KeyData"`)) {
+		t.Fatalf("config file still holds secrets:\n%s", data)
+	}
+	ac.Close()
+	if !store.closed {
+		t.Error("secret store was not closed")
+	}
+
+	again, err := Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer again.Close()
+	if !bytes.Equal(again.FindWallet("w1").KeyData, keydata) {
+		t.Error("key share did not survive the secret store")
+	}
+	if !again.Me.IdentPrivKey.Equals(ac.Me.IdentPrivKey) {
+		t.Error("identity key did not survive the secret store")
+	}
+	if err := again.FindWallet("w1").VerifyKeyShare(); err != nil {
+		t.Error(err)
+	}
+
+	// And back into the file
+	if err := again.SetSecretStore(secretstore.Config{Backend: secretstore.BackendFile}); err != nil {
+		t.Fatal(err)
+	}
+	data, err = ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte(`"IdentPrivKey"`)) || !bytes.Contains(data, []byte(`"KeyData"`)) || bytes.Contains(data, []byte(`"SecretStore"`)) {
+		t.Fatalf("secrets were not moved back into the config file:\n%s", data)
+	}
+}
+
+func TestSecretStoreMissingIdentity(t *testing.T) {
+	store := useFakeStore(t)
+	filename := tempConfig(t)
+
+	ac, err := Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ac.SetSecretStore(secretstore.Config{Backend: secretstore.BackendKeyring}); err != nil {
+		t.Fatal(err)
+	}
+	ac.Close()
+
+	delete(store.secrets, identityKeyName(ac.Me))
+	if _, err := LoadReadOnly(filename); !errors.Is(err, secretstore.ErrNotFound) {
+		t.Errorf("Load without the identity key in the store: got %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,180 @@
+package invite
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shykerbogdan/mpc-wallet/user"
+)
+
+// How long an invitation can be used by default
+const DefaultTTL = time.Hour * 72
+
+// Length of a room key
+const RoomKeySize = 32
+
+// Tokens are the invitation as base64 JSON after this prefix, so they can be told apart from other strings
+const tokenPrefix = "thresher-invite-v1:"
+
+var (
+	ErrInvalidToken = errors.New("invalid invitation token")
+	ErrExpired      = errors.New("invitation has expired")
+)
+
+// An Invitation to join the room of a project, signed with the identity key of the inviter. Everything
+// a new signer needs to set up a config and find the room is in it, including the room key, so tokens
+// must be handed over privately.
+type Invitation struct {
+	ID             string
+	Project        string
+	Blockchain     string
+	Network        string
+	P2PNetwork     string
+	RelayURL       string   `json:",omitempty"`
+	BootstrapAddrs []string `json:",omitempty"`
+	// The pre-shared key of the room, see config.AppConfig.RoomName
+	RoomKey []byte
+	Inviter user.User
+	// The nick the invitation is for, any nick may use it if empty
+	Nick    string `json:",omitempty"`
+	Expires time.Time
+	Sig     []byte
+}
+
+// An invitation we issued and that has not been used yet, only the inviter keeps these
+type Issued struct {
+	ID      string
+	Project string
+	Nick    string `json:",omitempty"`
+	Expires time.Time
+}
+
+// Create an invitation to the room of a project with the room key, signed by inviter
+func New(inviter user.Me, project string, blockchain string, network string, p2pnetwork string, relayURL string, bootstrapaddrs []string, roomKey []byte, nick string, ttl time.Duration) (*Invitation, error) {
+	if len(roomKey) != RoomKeySize {
+		return nil, fmt.Errorf("room key must be %d bytes", RoomKeySize)
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	inv := &Invitation{
+		ID:             hex.EncodeToString(id),
+		Project:        project,
+		Blockchain:     blockchain,
+		Network:        network,
+		P2PNetwork:     p2pnetwork,
+		RelayURL:       relayURL,
+		BootstrapAddrs: bootstrapaddrs,
+		RoomKey:        roomKey,
+		Inviter:        inviter.User,
+		Nick:           nick,
+		Expires:        time.Now().UTC().Add(ttl).Truncate(time.Second),
+	}
+
+	payload, err := inv.payload()
+	if err != nil {
+		return nil, err
+	}
+	inv.Sig, err = inviter.IdentPrivKey.Sign(payload)
+	if err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// A new random room key
+func NewRoomKey() ([]byte, error) {
+	key := make([]byte, RoomKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// What the inviter signs, the invitation without its signature
+func (inv Invitation) payload() ([]byte, error) {
+	inv.Sig = nil
+	b, err := json.Marshal(inv)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte("thresher invitation:"), b...), nil
+}
+
+// Check the invitation is signed by its inviter and has not expired
+func (inv Invitation) Verify(now time.Time) error {
+	if err := inv.VerifySignature(); err != nil {
+		return err
+	}
+	if now.After(inv.Expires) {
+		return fmt.Errorf("%w on %s", ErrExpired, inv.Expires.Local().Format(time.RFC1123))
+	}
+	return nil
+}
+
+// Check the invitation is signed by its inviter, expired or not
+func (inv Invitation) VerifySignature() error {
+	if inv.Inviter.IdentPubKey == nil {
+		return fmt.Errorf("%w: no inviter", ErrInvalidToken)
+	}
+	if len(inv.RoomKey) != RoomKeySize {
+		return fmt.Errorf("%w: room key must be %d bytes", ErrInvalidToken, RoomKeySize)
+	}
+	payload, err := inv.payload()
+	if err != nil {
+		return err
+	}
+	ok, err := inv.Inviter.IdentPubKey.Verify(payload, inv.Sig)
+	if err != nil || !ok {
+		return fmt.Errorf("%w: not signed by %s", ErrInvalidToken, inv.Inviter.Nick)
+	}
+	return nil
+}
+
+// Whether the invitation lets nick join
+func (inv Invitation) Admits(nick string) bool {
+	return inv.Nick == "" || inv.Nick == nick
+}
+
+// The record the inviter keeps of the invitation
+func (inv Invitation) Issued() Issued {
+	return Issued{ID: inv.ID, Project: inv.Project, Nick: inv.Nick, Expires: inv.Expires}
+}
+
+// The invitation as a token to hand to the invitee
+func (inv Invitation) Encode() (string, error) {
+	b, err := json.Marshal(inv)
+	if err != nil {
+		return "", err
+	}
+	return tokenPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Decode an invitation token and verify its signature. Whether it expired is up to the caller, see Verify.
+func Decode(token string) (*Invitation, error) {
+	token = strings.TrimSpace(token)
+	if !strings.HasPrefix(token, tokenPrefix) {
+		return nil, ErrInvalidToken
+	}
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(token, tokenPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	inv := &Invitation{}
+	if err := json.Unmarshal(b, inv); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if err := inv.VerifySignature(); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
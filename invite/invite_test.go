@@ -0,0 +1,81 @@
+package invite
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shykerbogdan/mpc-wallet/user"
+)
+
+func newInvitation(t *testing.T, nick string, ttl time.Duration) (*Invitation, user.Me) {
+	alice, err := user.NewMe("alice", "0x1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := NewRoomKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	inv, err := New(alice, "P", "ethereum", "goerli", "chatnet", "", []string{"/ip4/10.0.0.1/tcp/4001"}, key, nick, ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return inv, alice
+}
+
+func TestTokenRoundTrip(t *testing.T) {
+	inv, alice := newInvitation(t, "bob", DefaultTTL)
+	token, err := inv.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := Decode(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := decoded.Verify(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.ID != inv.ID || decoded.Project != "P" || !decoded.Inviter.IdentPubKey.Equals(alice.IdentPubKey) {
+		t.Errorf("decoded invitation %+v does not match %+v", decoded, inv)
+	}
+	if !decoded.Admits("bob") || decoded.Admits("mallory") {
+		t.Error("invitation for bob admits the wrong nicks")
+	}
+}
+
+func TestTamperedToken(t *testing.T) {
+	inv, _ := newInvitation(t, "", DefaultTTL)
+	inv.Project = "Q"
+	token, err := inv.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Decode(token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("tampered token: got %v, want ErrInvalidToken", err)
+	}
+
+	if _, err := Decode(strings.TrimPrefix(token, tokenPrefix)); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("token without prefix: got %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestExpiredToken(t *testing.T) {
+	inv, _ := newInvitation(t, "", time.Hour)
+	token, err := inv.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Still decodes, so an admission after it expired can be checked
+	decoded, err := Decode(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := decoded.Verify(time.Now().Add(2 * time.Hour)); !errors.Is(err, ErrExpired) {
+		t.Errorf("expired invitation: got %v, want ErrExpired", err)
+	}
+}
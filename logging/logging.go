@@ -0,0 +1,272 @@
+// Package logging is a leveled, structured logger shaped like log/slog. Neither log/slog nor
+// golang.org/x/exp/slog builds with the Go 1.17 the module targets, both need generics, so moving to slog
+// once the module does should mostly be a matter of renaming. Every record carries the component it comes
+// from and key/value attributes, and is written as text or JSON. Attributes go through Redact, so secret
+// material never reaches the log.
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+type Level int
+
+// The same levels as slog, so they can be compared and offset the same way
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+func (l Level) String() string {
+	switch {
+	case l < LevelInfo:
+		return "DEBUG"
+	case l < LevelWarn:
+		return "INFO"
+	case l < LevelError:
+		return "WARN"
+	default:
+		return "ERROR"
+	}
+}
+
+// Parse a level name as given to --log-level
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q, use debug, info, warn or error", s)
+}
+
+// Output formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Where and how all loggers write
+var output = struct {
+	sync.Mutex
+	w     io.Writer
+	level Level
+	json  bool
+}{w: os.Stderr, level: LevelInfo}
+
+// The standard library logger, used by dependencies and a few early startup errors
+var stdlog = New("std")
+
+// Write all logs to w, including those of the standard library logger
+func SetOutput(w io.Writer) {
+	output.Lock()
+	output.w = w
+	output.Unlock()
+	log.SetFlags(0)
+	log.SetOutput(stdWriter{})
+}
+
+// Drop records below level
+func SetLevel(level Level) {
+	output.Lock()
+	output.level = level
+	output.Unlock()
+}
+
+// Write records as FormatText or FormatJSON
+func SetFormat(format string) error {
+	switch format {
+	case FormatText, FormatJSON:
+	default:
+		return fmt.Errorf("unknown log format %q, use %s or %s", format, FormatText, FormatJSON)
+	}
+	output.Lock()
+	output.json = format == FormatJSON
+	output.Unlock()
+	return nil
+}
+
+// Whether records at level are written
+func Enabled(level Level) bool {
+	output.Lock()
+	defer output.Unlock()
+	return level >= output.level
+}
+
+// A Logger for one component of the app, with the attributes added by With
+type Logger struct {
+	component string
+	attrs     []interface{}
+}
+
+// A logger for component, e.g. p2p, chat, protocol or wallet
+func New(component string) *Logger {
+	return &Logger{component: component}
+}
+
+// A logger which adds the key/value pairs in args to every record
+func (l *Logger) With(args ...interface{}) *Logger {
+	attrs := make([]interface{}, 0, len(l.attrs)+len(args))
+	attrs = append(attrs, l.attrs...)
+	attrs = append(attrs, args...)
+	return &Logger{component: l.component, attrs: attrs}
+}
+
+// Log msg with alternating keys and values in args, like slog
+func (l *Logger) Log(level Level, msg string, args ...interface{}) {
+	if !Enabled(level) {
+		return
+	}
+	rec := record{time: time.Now(), level: level, component: l.component, msg: msg}
+	rec.addAttrs(l.attrs)
+	rec.addAttrs(args)
+
+	output.Lock()
+	defer output.Unlock()
+	if output.json {
+		output.w.Write(rec.json())
+	} else {
+		output.w.Write(rec.text())
+	}
+}
+
+func (l *Logger) Debug(msg string, args ...interface{}) { l.Log(LevelDebug, msg, args...) }
+func (l *Logger) Info(msg string, args ...interface{})  { l.Log(LevelInfo, msg, args...) }
+func (l *Logger) Warn(msg string, args ...interface{})  { l.Log(LevelWarn, msg, args...) }
+func (l *Logger) Error(msg string, args ...interface{}) { l.Log(LevelError, msg, args...) }
+
+// Log an error and exit, for the failures the app cannot go on without
+func (l *Logger) Fatal(msg string, args ...interface{}) {
+	l.Error(msg, args...)
+	os.Exit(1)
+}
+
+type attr struct {
+	key   string
+	value interface{}
+}
+
+type record struct {
+	time      time.Time
+	level     Level
+	component string
+	msg       string
+	attrs     []attr
+}
+
+// A key without a value, or a value without a key, is kept under this key like slog does
+const badKey = "!BADKEY"
+
+func (r *record) addAttrs(args []interface{}) {
+	for len(args) > 0 {
+		key, ok := args[0].(string)
+		if !ok || len(args) == 1 {
+			r.attrs = append(r.attrs, attr{badKey, Redact(badKey, args[0])})
+			args = args[1:]
+			continue
+		}
+		r.attrs = append(r.attrs, attr{key, Redact(key, args[1])})
+		args = args[2:]
+	}
+}
+
+const timeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// key=value pairs on one line, like the slog TextHandler
+func (r *record) text() []byte {
+	var b bytes.Buffer
+	b.WriteString("time=" + r.time.Format(timeFormat))
+	b.WriteString(" level=" + r.level.String())
+	if r.component != "" {
+		b.WriteString(" component=" + quoteText(r.component))
+	}
+	b.WriteString(" msg=" + quoteText(r.msg))
+	for _, a := range r.attrs {
+		b.WriteString(" " + quoteText(a.key) + "=" + quoteText(textValue(a.value)))
+	}
+	b.WriteByte('\n')
+	return b.Bytes()
+}
+
+// One JSON object per line, like the slog JSONHandler
+func (r *record) json() []byte {
+	var b bytes.Buffer
+	b.WriteString(`{"time":` + jsonString(r.time.Format(timeFormat)))
+	b.WriteString(`,"level":` + jsonString(r.level.String()))
+	if r.component != "" {
+		b.WriteString(`,"component":` + jsonString(r.component))
+	}
+	b.WriteString(`,"msg":` + jsonString(r.msg))
+	for _, a := range r.attrs {
+		b.WriteString("," + jsonString(a.key) + ":")
+		b.Write(jsonValue(a.value))
+	}
+	b.WriteString("}\n")
+	return b.Bytes()
+}
+
+func textValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return fmt.Sprintf("%x", v)
+	}
+	return fmt.Sprint(v)
+}
+
+func quoteText(s string) string {
+	if s == "" {
+		return `""`
+	}
+	for _, r := range s {
+		if r == '=' || r == '"' || unicode.IsSpace(r) || !unicode.IsPrint(r) {
+			return strconv.Quote(s)
+		}
+	}
+	return s
+}
+
+func jsonString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+func jsonValue(v interface{}) []byte {
+	switch v := v.(type) {
+	case error:
+		return []byte(jsonString(v.Error()))
+	case fmt.Stringer:
+		return []byte(jsonString(v.String()))
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return []byte(jsonString(fmt.Sprint(v)))
+	}
+	return b
+}
+
+// Writes each line of the standard library logger as an info record
+type stdWriter struct{}
+
+func (stdWriter) Write(p []byte) (int, error) {
+	stdlog.Info(strings.TrimRight(string(p), "\n"))
+	return len(p), nil
+}
@@ -0,0 +1,166 @@
+package logging
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+
+	libp2pcrypto "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/shykerbogdan/mpc-wallet/user"
+	"github.com/taurusgroup/multi-party-sig/pkg/math/curve"
+	"github.com/taurusgroup/multi-party-sig/pkg/math/sample"
+	mpsconfig "github.com/taurusgroup/multi-party-sig/protocols/cmp/config"
+)
+
+// Capture the logs written at level in format
+func capture(t *testing.T, level Level, format string) *bytes.Buffer {
+	var b bytes.Buffer
+	SetOutput(&b)
+	SetLevel(level)
+	if err := SetFormat(format); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		SetLevel(LevelInfo)
+		_ = SetFormat(FormatText)
+	})
+	return &b
+}
+
+type walletLike struct {
+	Name    string
+	KeyData []byte
+}
+
+func TestRedact(t *testing.T) {
+	me, err := user.NewMe("alice", "0x1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	share := sample.Scalar(rand.Reader, curve.Secp256k1{})
+	keydata := []byte{0xde, 0xad, 0xbe, 0xef}
+
+	b := capture(t, LevelDebug, FormatText)
+	logger := New("test")
+	logger.Info("secrets",
+		"keydata", keydata,
+		"Identity-PrivKey", me.IdentPrivKey,
+		"key", me.IdentPrivKey,
+		"wallet", walletLike{Name: "w", KeyData: keydata},
+		"config", &mpsconfig.Config{ECDSA: share},
+		"share", share,
+		"x", share,
+	)
+	sharebytes, err := share.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, secret := range []string{"deadbeef", fmt.Sprintf("%x", sharebytes), fmt.Sprint(share)} {
+		if strings.Contains(out, secret) {
+			t.Errorf("secret %s was logged: %s", secret, out)
+		}
+	}
+	if n := strings.Count(out, Redacted); n != 7 {
+		t.Errorf("%d values redacted, want 7: %s", n, out)
+	}
+
+	// Me logs as its user
+	b.Reset()
+	logger.Info("me", "me", me)
+	if !strings.Contains(b.String(), "alice") || strings.Contains(b.String(), Redacted) {
+		t.Errorf("me logged as %s", b.String())
+	}
+}
+
+// Types referring to each other, only one of them holds a secret directly
+type recursiveA struct {
+	B   *recursiveB
+	Key libp2pcrypto.PrivKey
+}
+
+type recursiveB struct {
+	A *recursiveA
+}
+
+type listNode struct {
+	Next *listNode
+	Name string
+}
+
+func TestRecursiveTypes(t *testing.T) {
+	// Looking at A first meets B while A is still being looked at
+	for _, v := range []interface{}{recursiveA{}, recursiveB{}, &recursiveA{}, &recursiveB{}} {
+		if !holdsSecret(reflect.TypeOf(v)) {
+			t.Errorf("%T does not hold a secret", v)
+		}
+	}
+	if holdsSecret(reflect.TypeOf(listNode{})) {
+		t.Error("recursive type without secrets holds a secret")
+	}
+}
+
+func TestLevels(t *testing.T) {
+	b := capture(t, LevelWarn, FormatText)
+	logger := New("test").With("project", "P")
+	logger.Info("dropped")
+	logger.Warn("kept", "n", 1)
+	logger.Error("has spaces", "err", errors.New("it failed"))
+
+	want := []string{
+		`level=WARN component=test msg=kept project=P n=1`,
+		`level=ERROR component=test msg="has spaces" project=P err="it failed"`,
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("got %d lines, want %d: %s", len(lines), len(want), b.String())
+	}
+	for i, line := range lines {
+		if !strings.HasSuffix(line, want[i]) {
+			t.Errorf("line %d is %s, want it to end in %s", i, line, want[i])
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	b := capture(t, LevelInfo, FormatJSON)
+	New("chat").Info("hello", "project", "P", "count", 2, "keydata", []byte{1}, "odd")
+	log.Print("from the standard logger")
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2: %s", len(lines), b.String())
+	}
+	rec := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"level": "INFO", "component": "chat", "msg": "hello", "project": "P", "count": 2.0, "keydata": Redacted, badKey: "odd"}
+	for k, v := range want {
+		if rec[k] != v {
+			t.Errorf("%s is %v, want %v", k, rec[k], v)
+		}
+	}
+
+	if err := json.Unmarshal([]byte(lines[1]), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec["component"] != "std" || rec["msg"] != "from the standard logger" {
+		t.Errorf("standard logger wrote %s", lines[1])
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	if l, err := ParseLevel("DEBUG"); err != nil || l != LevelDebug {
+		t.Errorf("ParseLevel(DEBUG) = %v, %v", l, err)
+	}
+	if _, err := ParseLevel("loud"); err == nil {
+		t.Error("unknown level was accepted")
+	}
+}
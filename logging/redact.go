@@ -0,0 +1,150 @@
+package logging
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+
+	libp2pcrypto "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/taurusgroup/multi-party-sig/pkg/math/curve"
+	"github.com/taurusgroup/multi-party-sig/pkg/paillier"
+)
+
+// What is logged in place of a secret
+const Redacted = "[REDACTED]"
+
+// LogValuer is implemented by types which log as another value, e.g. themselves without their secrets
+type LogValuer interface {
+	LogValue() interface{}
+}
+
+// Keys and field names which hold secrets, lower case without separators
+var secretNames = map[string]bool{
+	"keydata":      true,
+	"identprivkey": true,
+	"privkey":      true,
+	"privatekey":   true,
+	"prvkey":       true,
+	"secret":       true,
+	"secrets":      true,
+	"share":        true,
+	"shares":       true,
+	"keyshare":     true,
+	"seed":         true,
+	"mnemonic":     true,
+	"password":     true,
+	"passphrase":   true,
+	"pin":          true,
+	"roomkey":      true,
+	"token":        true,
+	"jointoken":    true,
+	"ecdsa":        true,
+	"elgamal":      true,
+	"paillier":     true,
+}
+
+// Whether a key or field name holds a secret
+func isSecretName(name string) bool {
+	name = strings.ToLower(strings.NewReplacer("_", "", "-", "", ".", "").Replace(name))
+	return secretNames[name] || strings.HasSuffix(name, "privkey") || strings.HasSuffix(name, "secret")
+}
+
+// Types which are secret whatever they are called: private keys, cmp key shares and Paillier keys
+var secretTypes = []reflect.Type{
+	reflect.TypeOf((*libp2pcrypto.PrivKey)(nil)).Elem(),
+	reflect.TypeOf((*curve.Scalar)(nil)).Elem(),
+	reflect.TypeOf(paillier.SecretKey{}),
+	reflect.TypeOf(ecdsa.PrivateKey{}),
+}
+
+func isSecretType(t reflect.Type) bool {
+	for _, st := range secretTypes {
+		if t == st || (st.Kind() == reflect.Interface && t.Implements(st)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Types known to hold a secret somewhere inside them, or not. Only final results are stored.
+var secretTypeCache sync.Map
+
+// Whether values of type t can hold a secret, by their type and the names and types of their fields
+func holdsSecret(t reflect.Type) bool {
+	holds, _ := holdsSecretVisiting(t, make(map[reflect.Type]int))
+	return holds
+}
+
+// Look at t with the types being looked at in visiting, by their depth. A type met again is assumed not to
+// hold a secret, its other fields are looked at where it was met first. The result relies on that assumption
+// for the types from depth low on, so it is only final, and cached, once we are back at the shallowest of them.
+func holdsSecretVisiting(t reflect.Type, visiting map[reflect.Type]int) (holds bool, low int) {
+	if v, ok := secretTypeCache.Load(t); ok {
+		return v.(bool), math.MaxInt32
+	}
+	if depth, ok := visiting[t]; ok {
+		return false, depth
+	}
+	depth := len(visiting)
+	visiting[t] = depth
+	defer delete(visiting, t)
+
+	low = math.MaxInt32
+	visit := func(inner reflect.Type) {
+		if holds {
+			return
+		}
+		h, l := holdsSecretVisiting(inner, visiting)
+		holds = h
+		if l < low {
+			low = l
+		}
+	}
+
+	holds = isSecretType(t)
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		visit(t.Elem())
+	case reflect.Map:
+		visit(t.Key())
+		visit(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField() && !holds; i++ {
+			f := t.Field(i)
+			holds = isSecretName(f.Name)
+			visit(f.Type)
+		}
+	}
+
+	// A secret is found whatever the types still being looked at turn out to hold
+	if holds || low >= depth {
+		secretTypeCache.Store(t, holds)
+		low = math.MaxInt32
+	}
+	return holds, low
+}
+
+// Redact returns what to log for the attribute key with value v. Secrets, and values holding a secret,
+// are replaced with Redacted unless they implement LogValuer, whose LogValue is redacted in turn.
+func Redact(key string, v interface{}) interface{} {
+	if isSecretName(key) {
+		return Redacted
+	}
+	for i := 0; i < 10; i++ {
+		lv, ok := v.(LogValuer)
+		if !ok {
+			break
+		}
+		v = lv.LogValue()
+	}
+	if v == nil {
+		return nil
+	}
+	if t := reflect.TypeOf(v); holdsSecret(t) {
+		return fmt.Sprintf("%s %s", Redacted, t)
+	}
+	return v
+}
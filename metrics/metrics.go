@@ -0,0 +1,209 @@
+// Package metrics exposes Prometheus metrics and a health endpoint for signers that stay online, see
+// Serve. The collectors are updated by the room loops, the protocols and the blockchain backend calls.
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/shykerbogdan/mpc-wallet/logging"
+)
+
+const namespace = "thresher"
+
+var logger = logging.New("metrics")
+
+// Results of protocol sessions
+const (
+	ResultStarted   = "started"
+	ResultSucceeded = "succeeded"
+	ResultFailed    = "failed"
+)
+
+var (
+	ConnectedPeers = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "connected_peers",
+		Help:      "Peers connected to the room of a project over libp2p pubsub or the relay.",
+	}, []string{"project"})
+
+	RoomParticipants = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "room_participants",
+		Help:      "Signers seen in the room of a project recently.",
+	}, []string{"project"})
+
+	PendingProposals = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pending_proposals",
+		Help:      "Proposals of a project waiting for approvals or running.",
+	}, []string{"project"})
+
+	ProtocolSessions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "protocol_sessions_total",
+		Help:      "Protocol sessions by type (keygen, sign, reshare) and result (started, succeeded, failed).",
+	}, []string{"type", "result"})
+
+	ProtocolRoundSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "protocol_round_seconds",
+		Help:      "Time from the start of a protocol round until we send the messages of the next.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"type"})
+
+	PublishErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "publish_errors_total",
+		Help:      "Messages which could not be published to the room of a project.",
+	}, []string{"project"})
+
+	DroppedMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dropped_messages_total",
+		Help:      "Received messages of a project dropped because the protocol or the UI fell too far behind, by inbox.",
+	}, []string{"project", "inbox"})
+
+	RPCSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rpc_request_seconds",
+		Help:      "Latency of the blockchain backend calls by method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+
+	RPCErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rpc_errors_total",
+		Help:      "Failed blockchain backend calls by method.",
+	}, []string{"method"})
+)
+
+// The registry Serve exposes, with the Go runtime and process metrics next to ours
+var Registry = prometheus.NewRegistry()
+
+func init() {
+	Registry.MustRegister(
+		ConnectedPeers,
+		RoomParticipants,
+		PendingProposals,
+		ProtocolSessions,
+		ProtocolRoundSeconds,
+		PublishErrors,
+		DroppedMessages,
+		RPCSeconds,
+		RPCErrors,
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+}
+
+// Record the latency and outcome of a blockchain backend call which started at start
+func ObserveRPC(method string, start time.Time, err error) {
+	RPCSeconds.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	if err != nil {
+		RPCErrors.WithLabelValues(method).Inc()
+	}
+}
+
+// How long /healthz waits for the checks
+const healthTimeout = time.Second * 10
+
+// The checks of /healthz by name, e.g. p2p and rpc
+var health = struct {
+	sync.Mutex
+	checks map[string]func() error
+}{checks: map[string]func() error{}}
+
+// Add a check to /healthz, which is healthy when all checks return nil. A check with the same name is replaced.
+func AddHealthCheck(name string, check func() error) {
+	health.Lock()
+	defer health.Unlock()
+	health.checks[name] = check
+}
+
+type healthReport struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// Run all checks at once, a check that does not return in time fails
+func checkHealth(ctx context.Context) healthReport {
+	health.Lock()
+	names := make([]string, 0, len(health.checks))
+	checks := make([]func() error, 0, len(health.checks))
+	for name := range health.checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		checks = append(checks, health.checks[name])
+	}
+	health.Unlock()
+
+	results := make([]chan error, len(checks))
+	for i, check := range checks {
+		results[i] = make(chan error, 1)
+		go func(check func() error, result chan<- error) {
+			result <- check()
+		}(check, results[i])
+	}
+
+	report := healthReport{Status: "ok", Checks: map[string]string{}}
+	for i, name := range names {
+		var err error
+		select {
+		case err = <-results[i]:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		if err != nil {
+			report.Status = "unhealthy"
+			report.Checks[name] = err.Error()
+		} else {
+			report.Checks[name] = "ok"
+		}
+	}
+	return report
+}
+
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), healthTimeout)
+	defer cancel()
+
+	report := checkHealth(ctx)
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(report)
+}
+
+// Handler serves /metrics and /healthz
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(Registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/healthz", healthHandler)
+	return mux
+}
+
+// Serve /metrics and /healthz on addr in the background, returning once it listens
+func Serve(addr string) (*http.Server, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{Handler: Handler(), ReadHeaderTimeout: time.Second * 10}
+	go func() {
+		if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			logger.Error("Metrics server stopped", "addr", addr, "err", err)
+		}
+	}()
+	return srv, nil
+}
@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHealthz(t *testing.T) {
+	srv := httptest.NewServer(Handler())
+	defer srv.Close()
+
+	get := func() (int, healthReport) {
+		res, err := http.Get(srv.URL + "/healthz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		report := healthReport{}
+		if err := json.NewDecoder(res.Body).Decode(&report); err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, report
+	}
+
+	AddHealthCheck("p2p", func() error { return nil })
+	AddHealthCheck("rpc", func() error { return nil })
+	if code, report := get(); code != http.StatusOK || report.Checks["p2p"] != "ok" || report.Checks["rpc"] != "ok" {
+		t.Errorf("healthy node reported %d %+v", code, report)
+	}
+
+	AddHealthCheck("rpc", func() error { return errors.New("connection refused") })
+	code, report := get()
+	if code != http.StatusServiceUnavailable || report.Status != "unhealthy" {
+		t.Errorf("unhealthy node reported %d %+v", code, report)
+	}
+	if report.Checks["p2p"] != "ok" || report.Checks["rpc"] != "connection refused" {
+		t.Errorf("checks reported as %+v", report.Checks)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	ObserveRPC("balance", time.Now(), nil)
+	ObserveRPC("balance", time.Now(), errors.New("timeout"))
+	ProtocolSessions.WithLabelValues("sign", ResultStarted).Inc()
+
+	srv := httptest.NewServer(Handler())
+	defer srv.Close()
+	res, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`thresher_rpc_request_seconds_count{method="balance"} 2`,
+		`thresher_rpc_errors_total{method="balance"} 1`,
+		`thresher_protocol_sessions_total{result="started",type="sign"} 1`,
+		`go_goroutines`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics do not contain %s", want)
+		}
+	}
+}
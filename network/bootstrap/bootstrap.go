@@ -0,0 +1,253 @@
+// Package bootstrap runs a private libp2p bootstrap server, the node all signers of a project connect to
+// first so they can find each other, optionally relaying for signers behind NATs.
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/shykerbogdan/mpc-wallet/logging"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/host"
+	p2pnet "github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/routing"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+var logger = logging.New("bootstrap")
+
+// How often the peers are saved to the peers file while the server runs
+const savePeersInterval = time.Minute * 5
+
+// Options of the server
+type Options struct {
+	// Addrs to listen on, see DefaultListenAddrs
+	ListenAddrs []ma.Multiaddr
+	// Addrs announced to the peers instead of the listen and observed addrs, e.g. the public addr of a
+	// server behind port forwarding. When empty libp2p works them out itself.
+	AnnounceAddrs []ma.Multiaddr
+	// Run a circuit relay v2 service, so signers behind NATs can be reached through the server
+	RelayService bool
+	// Only these peers may connect, anyone may when empty
+	Allow []peer.ID
+	// The known peers are kept in this file between runs, not kept when empty
+	PeersFile string
+}
+
+// Listen on TCP and QUIC on port and WebSocket on port+1, over IPv4 and IPv6
+func DefaultListenAddrs(port int) []ma.Multiaddr {
+	formats := []string{
+		"/ip4/0.0.0.0/tcp/%d",
+		"/ip6/::/tcp/%d",
+		"/ip4/0.0.0.0/udp/%d/quic",
+		"/ip6/::/udp/%d/quic",
+	}
+	addrs := make([]ma.Multiaddr, 0, len(formats)+2)
+	for _, format := range formats {
+		addrs = append(addrs, ma.StringCast(fmt.Sprintf(format, port)))
+	}
+	addrs = append(addrs,
+		ma.StringCast(fmt.Sprintf("/ip4/0.0.0.0/tcp/%d/ws", port+1)),
+		ma.StringCast(fmt.Sprintf("/ip6/::/tcp/%d/ws", port+1)),
+	)
+	return addrs
+}
+
+// Parse multiaddrs given on the command line
+func ParseAddrs(addrs []string) ([]ma.Multiaddr, error) {
+	mas := make([]ma.Multiaddr, 0, len(addrs))
+	for _, s := range addrs {
+		a, err := ma.NewMultiaddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid multiaddr %s: %w", s, err)
+		}
+		mas = append(mas, a)
+	}
+	return mas, nil
+}
+
+// Parse peer ids given on the command line
+func ParsePeerIDs(ids []string) ([]peer.ID, error) {
+	peers := make([]peer.ID, 0, len(ids))
+	for _, s := range ids {
+		id, err := peer.Decode(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid peer id %s: %w", s, err)
+		}
+		peers = append(peers, id)
+	}
+	return peers, nil
+}
+
+// Server is a running bootstrap server
+type Server struct {
+	Host   host.Host
+	KadDHT *dht.IpfsDHT
+
+	opts   Options
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Start a bootstrap server with the libp2p identity prvkey
+func NewServer(prvkey crypto.PrivKey, opts Options) (*Server, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// The inner function will set kaddht var when it runs during libp2p.New
+	var kaddht *dht.IpfsDHT
+	routingopt := libp2p.Routing(func(h host.Host) (routing.PeerRouting, error) {
+		var err error
+		kaddht, err = dht.New(
+			ctx,
+			h,
+			dht.Mode(dht.ModeServer),
+			dht.BootstrapPeers(),
+		)
+		return kaddht, err
+	})
+
+	// The server should run with direct access to the Internet, so we do not wait for AutoNAT to find out
+	libp2popts := []libp2p.Option{
+		routingopt,
+		libp2p.Identity(prvkey),
+		libp2p.ListenAddrs(opts.ListenAddrs...),
+		libp2p.ForceReachabilityPublic(),
+		libp2p.EnableNATService(),
+		libp2p.NATPortMap(),
+	}
+	if len(opts.AnnounceAddrs) > 0 {
+		announce := opts.AnnounceAddrs
+		libp2popts = append(libp2popts, libp2p.AddrsFactory(func([]ma.Multiaddr) []ma.Multiaddr {
+			return announce
+		}))
+	}
+	if opts.RelayService {
+		libp2popts = append(libp2popts, libp2p.EnableRelayService())
+	}
+	if len(opts.Allow) > 0 {
+		libp2popts = append(libp2popts, libp2p.ConnectionGater(newAllowGater(opts.Allow)))
+	}
+
+	h, err := libp2p.New(libp2popts...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	h.Network().Notify(&notifee{})
+
+	s := &Server{
+		Host:   h,
+		KadDHT: kaddht,
+		opts:   opts,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	if opts.PeersFile != "" {
+		peers, err := loadPeers(opts.PeersFile)
+		if err != nil {
+			logger.Warn("Could not load the known peers", "file", opts.PeersFile, "err", err)
+		}
+		s.reconnect(ctx, peers)
+	}
+	go s.savePeersLoop(ctx)
+	return s, nil
+}
+
+// The addrs of the server including /p2p/<id>, to hand to the signers as their bootstrap addrs
+func (s *Server) Addrs() []ma.Multiaddr {
+	addrs, err := peer.AddrInfoToP2pAddrs(&peer.AddrInfo{ID: s.Host.ID(), Addrs: s.Host.Addrs()})
+	if err != nil {
+		// only fails without addrs
+		return nil
+	}
+	return addrs
+}
+
+// Stop the server, saving the known peers
+func (s *Server) Close() error {
+	s.cancel()
+	<-s.done
+	if err := s.KadDHT.Close(); err != nil {
+		logger.Warn("Error closing the DHT", "err", err)
+	}
+	return s.Host.Close()
+}
+
+// Add the peers of the previous run to the peerstore and connect to them in the background,
+// which fills the routing table of the DHT again
+func (s *Server) reconnect(ctx context.Context, peers []peer.AddrInfo) {
+	for _, pi := range peers {
+		if pi.ID == s.Host.ID() {
+			continue
+		}
+		s.Host.Peerstore().AddAddrs(pi.ID, pi.Addrs, savedAddrTTL)
+		go func(pi peer.AddrInfo) {
+			ctx, cancel := context.WithTimeout(ctx, time.Second*30)
+			defer cancel()
+			if err := s.Host.Connect(ctx, pi); err != nil {
+				logger.Debug("Could not reconnect to a known peer", "peer", pi.ID, "err", err)
+			}
+		}(pi)
+	}
+	if len(peers) > 0 {
+		logger.Info("Loaded the known peers", "count", len(peers), "file", s.opts.PeersFile)
+	}
+}
+
+func (s *Server) savePeersLoop(ctx context.Context) {
+	defer close(s.done)
+	if s.opts.PeersFile == "" {
+		<-ctx.Done()
+		return
+	}
+
+	ticker := time.NewTicker(savePeersInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			s.savePeers()
+			return
+		}
+		s.savePeers()
+	}
+}
+
+func (s *Server) savePeers() {
+	if err := savePeers(s.opts.PeersFile, s.Host); err != nil {
+		logger.Warn("Could not save the known peers", "file", s.opts.PeersFile, "err", err)
+	}
+}
+
+// notifee logs the connections of the peers
+type notifee struct{}
+
+var _ p2pnet.Notifiee = &notifee{}
+
+// Listen is called when network starts listening on an addr
+func (n *notifee) Listen(p2pnet.Network, ma.Multiaddr) {}
+
+// ListenClose is called when network stops listening on an addr
+func (n *notifee) ListenClose(p2pnet.Network, ma.Multiaddr) {}
+
+// Connected is called when a connection opened
+func (n *notifee) Connected(network p2pnet.Network, conn p2pnet.Conn) {
+	logger.Info("Peer connected", "peer", conn.RemotePeer(), "addr", conn.RemoteMultiaddr())
+}
+
+// Disconnected is called when a connection closed
+func (n *notifee) Disconnected(network p2pnet.Network, conn p2pnet.Conn) {
+	logger.Info("Peer disconnected", "peer", conn.RemotePeer(), "addr", conn.RemoteMultiaddr())
+}
+
+// OpenedStream is called when a stream opened
+func (n *notifee) OpenedStream(network p2pnet.Network, stream p2pnet.Stream) {}
+
+// ClosedStream is called when a stream closed
+func (n *notifee) ClosedStream(network p2pnet.Network, stream p2pnet.Stream) {}
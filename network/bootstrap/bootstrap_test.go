@@ -0,0 +1,89 @@
+package bootstrap
+
+import (
+	"context"
+	"crypto/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func newPeer(t *testing.T) host.Host {
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.Close() })
+	return h
+}
+
+func TestServer(t *testing.T) {
+	prvkey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowed := newPeer(t)
+	other := newPeer(t)
+	peersfile := filepath.Join(t.TempDir(), "peers.json")
+
+	server, err := NewServer(prvkey, Options{
+		ListenAddrs:   []ma.Multiaddr{ma.StringCast("/ip4/127.0.0.1/tcp/0")},
+		AnnounceAddrs: []ma.Multiaddr{ma.StringCast("/dns4/boot.example.com/tcp/4001")},
+		Allow:         []peer.ID{allowed.ID()},
+		PeersFile:     peersfile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addrs := server.Addrs()
+	if len(addrs) != 1 || addrs[0].String() != "/dns4/boot.example.com/tcp/4001/p2p/"+server.Host.ID().Pretty() {
+		t.Errorf("server announces %v", addrs)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	listening := peer.AddrInfo{ID: server.Host.ID(), Addrs: server.Host.Network().ListenAddresses()}
+	if err := allowed.Connect(ctx, listening); err != nil {
+		t.Errorf("allowed peer could not connect: %v", err)
+	}
+	if err := other.Connect(ctx, listening); err == nil {
+		t.Error("peer which is not allowed connected")
+	}
+
+	if err := server.Close(); err != nil {
+		t.Fatal(err)
+	}
+	peers, err := loadPeers(peersfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 1 || peers[0].ID != allowed.ID() || len(peers[0].Addrs) == 0 {
+		t.Errorf("saved peers %v, want %s", peers, allowed.ID())
+	}
+}
+
+func TestDefaultListenAddrs(t *testing.T) {
+	var got []string
+	for _, a := range DefaultListenAddrs(4001) {
+		got = append(got, a.String())
+	}
+	want := "/ip4/0.0.0.0/tcp/4001 /ip6/::/tcp/4001 /ip4/0.0.0.0/udp/4001/quic /ip6/::/udp/4001/quic /ip4/0.0.0.0/tcp/4002/ws /ip6/::/tcp/4002/ws"
+	if strings.Join(got, " ") != want {
+		t.Errorf("default listen addrs are %v", got)
+	}
+
+	if _, err := ParseAddrs([]string{"/ip4/1.2.3.4/tcp"}); err == nil {
+		t.Error("invalid multiaddr was accepted")
+	}
+	if _, err := ParsePeerIDs([]string{"alice"}); err == nil {
+		t.Error("invalid peer id was accepted")
+	}
+}
@@ -0,0 +1,49 @@
+package bootstrap
+
+import (
+	"github.com/libp2p/go-libp2p-core/connmgr"
+	"github.com/libp2p/go-libp2p-core/control"
+	p2pnet "github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// allowGater only lets the peers of the allow-list connect. Inbound connections are checked once the
+// remote peer has proven its id in the security handshake.
+type allowGater struct {
+	allow map[peer.ID]bool
+}
+
+var _ connmgr.ConnectionGater = &allowGater{}
+
+func newAllowGater(peers []peer.ID) *allowGater {
+	allow := make(map[peer.ID]bool, len(peers))
+	for _, id := range peers {
+		allow[id] = true
+	}
+	return &allowGater{allow: allow}
+}
+
+func (g *allowGater) InterceptPeerDial(p peer.ID) bool {
+	return g.allow[p]
+}
+
+func (g *allowGater) InterceptAddrDial(p peer.ID, _ ma.Multiaddr) bool {
+	return g.allow[p]
+}
+
+func (g *allowGater) InterceptAccept(p2pnet.ConnMultiaddrs) bool {
+	return true
+}
+
+func (g *allowGater) InterceptSecured(_ p2pnet.Direction, p peer.ID, conn p2pnet.ConnMultiaddrs) bool {
+	if !g.allow[p] {
+		logger.Debug("Refused a peer which is not allowed", "peer", p, "addr", conn.RemoteMultiaddr())
+		return false
+	}
+	return true
+}
+
+func (g *allowGater) InterceptUpgraded(p2pnet.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
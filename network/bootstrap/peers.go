@@ -0,0 +1,65 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/peerstore"
+)
+
+// Addrs loaded from the peers file are kept like addrs learned from other peers
+var savedAddrTTL = peerstore.AddressTTL
+
+// Load the peers saved by a previous run, there are none yet on the first run
+func loadPeers(filename string) ([]peer.AddrInfo, error) {
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var peers []peer.AddrInfo
+	if err := json.Unmarshal(data, &peers); err != nil {
+		return nil, err
+	}
+	return peers, nil
+}
+
+// Save the peers of the peerstore which have addrs, replacing the file at once
+func savePeers(filename string, h host.Host) error {
+	ps := h.Peerstore()
+	peers := []peer.AddrInfo{}
+	for _, id := range ps.PeersWithAddrs() {
+		if id == h.ID() {
+			continue
+		}
+		if pi := ps.PeerInfo(id); len(pi.Addrs) > 0 {
+			peers = append(peers, pi)
+		}
+	}
+	data, err := json.MarshalIndent(peers, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filename)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
@@ -7,11 +7,9 @@ import (
 	"github.com/taurusgroup/multi-party-sig/pkg/protocol"
 )
 
-// TODO this code is from the MPS lib, figure out how to use it for local tests
-
 // Network simulates a point-to-point network between different parties using Go channels.
 // The same network is used by all processes, and can be reused for different protocols.
-// When used with test.Handler, no interaction from the user is required beyond creating the network.
+// Adapted from the MPS lib, this is what the simulation package runs its in-memory parties over.
 type Network struct {
 	parties          party.IDSlice
 	listenChannels   map[party.ID]chan *protocol.Message
@@ -3,19 +3,27 @@ package chat
 import (
 	"context"
 	"encoding/json"
+	"encoding/hex"
 	"fmt"
-	"log"
 	"math/big"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/libp2p/go-libp2p-core/peer"
-	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/shykerbogdan/mpc-wallet/addressbook"
+	"github.com/shykerbogdan/mpc-wallet/audit"
 	"github.com/shykerbogdan/mpc-wallet/config"
+	"github.com/shykerbogdan/mpc-wallet/logging"
+	"github.com/shykerbogdan/mpc-wallet/metrics"
+	"github.com/shykerbogdan/mpc-wallet/proposal"
 	"github.com/shykerbogdan/mpc-wallet/protocols"
 	"github.com/shykerbogdan/mpc-wallet/user"
+	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet"
+	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet/txpreview"
+	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet/types"
 	"github.com/taurusgroup/multi-party-sig/pkg/protocol"
 )
 
@@ -37,23 +45,50 @@ const (
 	// messageTypeProtocol is published when a new protocol message is contained within the chat message
 	messageTypeProtocol messageType = "chat.protocol"
 
-	messageTypeStartKeygen messageType = "chat.startkeygen"
-	messageTypeStartSign   messageType = "chat.startsign"
-	messageTypeStartSendTx messageType = "chat.startsendtx"
+	// messageTypeProposal is published with a new proposal, or with our approval of it
+	messageTypeProposal messageType = "chat.proposal"
+
+	// messageTypeStartProposal is published by the proposer once enough approvers are online
+	messageTypeStartProposal messageType = "chat.startproposal"
+
+	// messageTypeJoinRequest is published by a new signer with their invitation until they are admitted
+	messageTypeJoinRequest messageType = "chat.join"
+
+	// messageTypeAdmit is published by the inviter once they confirmed a join request
+	messageTypeAdmit messageType = "chat.admit"
+
+	// messageTypeHistoryRequest asks the other signers to publish their recent messages again
+	messageTypeHistoryRequest messageType = "chat.history"
+
+	// messageTypeConfirmRequest asks the other signers of an unconfirmed wallet to confirm its keygen again
+	messageTypeConfirmRequest messageType = "chat.confirm"
 )
 
 // TODO Stuffing everything into one msg struct for now, better way?
 type chatmessage struct {
-	Type             messageType       `json:"type"` // chat, command, protocol
-	SenderID         string            `json:"senderid"`
-	SenderName       string            `json:"sendername"`
-	StartKeygen      startkeygencmd    `json:"startkeygen,omitempty"`
-	StartSign        startsigncmd      `json:"startsign,omitempty"`
-	StartSendTx      startsendtxcmd    `json:"startsendtx,omitempty"`
-	UserMessage      string            `json:"usermessage,omitempty"`
-	ProtocolMessage  *protocol.Message `json:"protmessage,omitempty"`
-	AdvertiseMessage user.User         `json:"advmsg,omitempty"`
-	EventMessage     string            `json:"evtmsg,omitempty"`
+	Type messageType `json:"type"` // chat, command, protocol
+	// Set when publishing, the session of the sender in the room and the sequence number of the message in it
+	ID               string             `json:"id,omitempty"`
+	Session          string             `json:"session,omitempty"`
+	Seq              uint64             `json:"seq,omitempty"`
+	SenderID         string             `json:"senderid"`
+	SenderName       string             `json:"sendername"`
+	Proposal         *proposal.Proposal `json:"proposal,omitempty"`
+	StartProposal    startproposalcmd   `json:"startproposal,omitempty"`
+	UserMessage      string             `json:"usermessage,omitempty"`
+	ProtocolMessage  *protocol.Message  `json:"protmessage,omitempty"`
+	AdvertiseMessage user.User          `json:"advmsg,omitempty"`
+	EventMessage     string             `json:"evtmsg,omitempty"`
+	// The invitation token of a join request or admission, and the members of the project on admission
+	JoinToken string      `json:"jointoken,omitempty"`
+	Members   []user.User `json:"members,omitempty"`
+	// The messages a history request asks for
+	History *historyrequest `json:"history,omitempty"`
+	// The wallet a confirm request is for
+	ConfirmWallet string `json:"confirmwallet,omitempty"`
+	// Warnings about a proposal for the approver, and the simulation of its transaction, not sent
+	Warnings []string           `json:"-"`
+	Preview  *txpreview.Preview `json:"-"`
 }
 
 type participant struct {
@@ -64,24 +99,42 @@ type participant struct {
 	addedAt  time.Time
 }
 
+// The commands of the proposals, the signers are the approvers the proposal is started with
+
 type startkeygencmd struct {
 	Name      string
 	Threshold int
-	Signers   []user.User
 }
 
 type startsigncmd struct {
 	Name    string
 	Message string
-	Signers []user.User
 }
 
 type startsendtxcmd struct {
-	Name     string
-	Amount   uint64
+	Name string
+	// In wei, as decimal string like GasPrice
+	Amount   string
 	DestAddr string
+	// The ENS name DestAddr was resolved from, so the other signers can check it
+	DestENS string `json:",omitempty"`
+	// Hex calldata of a contract call
+	Data     string `json:",omitempty"`
 	Memo     string
-	Signers  []user.User
+	Nonce    uint64
+	GasPrice string
+	GasLimit uint64
+	// The fee strategy GasPrice was picked with, for the other signers to see
+	Fees string `json:",omitempty"`
+}
+
+// Move wallet Name to the new Signers and Threshold, the Dealers are the current signers dealing their shares
+type startresharecmd struct {
+	Name      string
+	Address   string
+	Threshold int
+	Signers   []user.User
+	Dealers   []user.User
 }
 
 // A structure that represents a chat log displayed locally and not published
@@ -90,6 +143,7 @@ type logLevelType string
 const (
 	logLevelDebug logLevelType = "🚧 "
 	logLevelInfo  logLevelType = "ℹ️ "
+	logLevelWarn  logLevelType = "⚠️ "
 	logLevelError logLevelType = "❗️ "
 )
 
@@ -98,9 +152,25 @@ type chatlog struct {
 	msg   string
 }
 
+var logger = logging.New("chat")
+
+// Write a chat log of the room of project to the log file as well
+func (cl chatlog) write(project string) {
+	level := logging.LevelInfo
+	switch cl.level {
+	case logLevelDebug:
+		level = logging.LevelDebug
+	case logLevelWarn:
+		level = logging.LevelWarn
+	case logLevelError:
+		level = logging.LevelError
+	}
+	logger.Log(level, cl.msg, "project", project)
+}
+
 // A structure that represents a PubSub Chat Room
 type ChatRoom struct {
-	// Represents the P2P Host for the ChatRoom
+	// Represents the P2P Host for the ChatRoom, nil when the room runs over a relay
 	Host *P2P
 
 	InboundChat          chan chatmessage
@@ -109,11 +179,26 @@ type ChatRoom struct {
 	InboundProtocol      chan *protocol.Message
 	OutboundProtocol     chan *protocol.Message
 	Logs                 chan chatlog
+	// Join requests with invitations we issued, waiting for us to confirm them
+	InboundJoin chan joinrequest
 
 	cfg *config.AppConfig
+	// The project of the room, only its wallets are used in it
+	project string
+
+	// Records proposals, approvals, chat and protocol results, nil if it could not be opened
+	audit *audit.Log
+
+	// Proposals we are an approver of, and the callers waiting for the result of our own proposals
+	proposals     *proposal.Store
+	waiters       map[string]chan proposalResult
+	waitersMutex  sync.Mutex
+	proposalMutex sync.Mutex
 
 	peerid       peer.ID
 	participants map[peer.ID]*participant
+	// The invitations whose join requests were seen this session, so each is only dealt with once
+	joinRequests map[string]bool
 
 	mutex sync.RWMutex
 
@@ -121,63 +206,118 @@ type ChatRoom struct {
 	psctx context.Context
 	// Represents the chat room lifecycle cancellation function
 	pscancel context.CancelFunc
-	// Represents the PubSub Topic of the ChatRoom
-	pstopic *pubsub.Topic
-	// Represents the PubSub Subscription for the topic
-	psub *pubsub.Subscription
+	// Carries the room messages, libp2p pubsub or a relay server
+	transport Transport
+
+	// Our recent messages and the messages we received, to deliver them reliably over the transport
+	history  *history
+	received *receivedLog
+	// The received messages waiting for the protocol, and for the room and the UI
+	protocolInbox *inbox
+	controlInbox  *inbox
 }
 
 // A constructor function that generates and returns a new
-// ChatRoom for a given P2PHost, username and roomname
-func JoinChatRoom(p2phost *P2P, cfg *config.AppConfig) (*ChatRoom, error) {
-
-	// Create a PubSub topic with the Project name
-	topic, err := p2phost.PubSub.Join(cfg.Project)
+// ChatRoom of a project for a given P2PHost, the room name of the project is the pubsub topic
+func JoinChatRoom(p2phost *P2P, cfg *config.AppConfig, project string) (*ChatRoom, error) {
+	transport, err := newPubSubTransport(p2phost, cfg.RoomName(project))
 	if err != nil {
 		return nil, err
 	}
 
-	// Subscribe to the PubSub topic
-	sub, err := topic.Subscribe()
+	chatroom, err := NewChatRoom(transport, cfg, project)
 	if err != nil {
 		return nil, err
 	}
+	chatroom.Host = p2phost
+
+	return chatroom, nil
+}
+
+// Generates and returns a new ChatRoom of a project on top of any Transport, e.g. a relay.Client. The
+// messages are sealed with the room key of the project, if it has one.
+func NewChatRoom(transport Transport, cfg *config.AppConfig, project string) (*ChatRoom, error) {
+	if roomKey := cfg.RoomKey(project); roomKey != nil {
+		sealed, err := newRoomKeyTransport(transport, roomKey)
+		if err != nil {
+			return nil, err
+		}
+		transport = sealed
+	}
 
 	// Create cancellable context
 	pubsubctx, cancel := context.WithCancel(context.Background())
 
+	auditfile := cfg.ProjectAuditFile(project)
+	auditlog, err := audit.Open(auditfile, cfg.Me)
+	if err != nil {
+		logger.Error("Error opening the audit log, nothing will be recorded", "project", project, "file", auditfile, "err", err)
+	}
+
+	proposalsfile := cfg.ProjectProposalsFile(project)
+	proposals, err := proposal.Open(proposalsfile)
+	if err != nil {
+		logger.Error("Error opening the proposals, starting without them", "project", project, "file", proposalsfile, "err", err)
+	}
+
 	const channel_size = 10
 	// Create a ChatRoom object
 	chatroom := &ChatRoom{
-		Host: p2phost,
-
 		InboundChat:          make(chan chatmessage, channel_size),
 		OutboundChat:         make(chan chatmessage, channel_size),
 		InboundProtocolStart: make(chan chatmessage, channel_size),
+		InboundJoin:          make(chan joinrequest, channel_size),
 		InboundProtocol:      make(chan *protocol.Message, channel_size),
 		OutboundProtocol:     make(chan *protocol.Message, channel_size),
 		Logs:                 make(chan chatlog, channel_size),
 
-		psctx:    pubsubctx,
-		pscancel: cancel,
-		pstopic:  topic,
-		psub:     sub,
+		psctx:     pubsubctx,
+		pscancel:  cancel,
+		transport: transport,
+
+		history:       newHistory(),
+		received:      newReceivedLog(),
+		protocolInbox: newInbox(project, "protocol", false),
+		controlInbox:  newInbox(project, "control", true),
 
 		cfg:          cfg,
-		peerid:       p2phost.Host.ID(),
+		project:      project,
+		audit:        auditlog,
+		proposals:    proposals,
+		waiters:      make(map[string]chan proposalResult),
+		peerid:       transport.ID(),
 		participants: make(map[peer.ID]*participant),
+		joinRequests: make(map[string]bool),
 	}
 
 	go chatroom.SubLoop()
 	go chatroom.PubLoop()
+	go chatroom.protocolLoop()
+	go chatroom.controlLoop()
 	go chatroom.advertiseLoop()
 	go chatroom.refreshParticipantsLoop()
+	go chatroom.proposalLoop()
+	go chatroom.joinLoop()
+
+	// Catch up on what was said before we joined
+	chatroom.requestHistory("", 0)
 
 	return chatroom, nil
 }
 
+// Show a log in the room unless the UI is behind, for the loops moving the messages which must not wait for it.
+// It is written to the log file either way.
+func (cr *ChatRoom) tryLog(cl chatlog) {
+	select {
+	case cr.Logs <- cl:
+	default:
+		cl.write(cr.project)
+	}
+}
+
 // A method of ChatRoom that publishes a chatmessage
-// to the PubSub topic until the pubsub context closes
+// to the transport until the pubsub context closes.
+// The messages are numbered, and the ones the other signers may miss are kept to publish them again.
 func (cr *ChatRoom) PubLoop() {
 	for {
 		select {
@@ -185,26 +325,34 @@ func (cr *ChatRoom) PubLoop() {
 			return
 
 		case message := <-cr.OutboundChat:
+			cr.history.stamp(&message)
 			messagebytes, err := json.Marshal(message)
 			// log.Printf("DEBUG publish json: %+v", string(messagebytes))
 			if err != nil {
-				cr.Logs <- chatlog{level: logLevelError, msg: "could not marshal JSON"}
+				cr.tryLog(chatlog{level: logLevelError, msg: "could not marshal JSON"})
 				continue
 			}
+			if message.isRetained() {
+				cr.history.add(message.Seq, messagebytes, time.Now())
+			}
 
-			// Publish the message to the topic
-			err = cr.pstopic.Publish(cr.psctx, messagebytes)
+			// Publish the message to the topic, the others ask for it again if it got lost
+			err = cr.transport.Publish(cr.psctx, messagebytes)
 			if err != nil {
-				cr.Logs <- chatlog{level: logLevelError, msg: "could not publish to topic"}
+				metrics.PublishErrors.WithLabelValues(cr.project).Inc()
+				cr.tryLog(chatlog{level: logLevelError, msg: fmt.Sprintf("could not publish to topic: %v", err)})
 				continue
 			}
+
+			cr.recordMessage(cr.cfg.Me.Nick, message)
 		}
 	}
 }
 
 // A method of ChatRoom that continously reads from the subscription
 // until either the subscription or pubsub context closes.
-// The recieved message is parsed sent into the inbound channel
+// The recieved messages are deduplicated and queued for the protocol or the room, so neither a running
+// protocol nor a slow UI hold up reading the subscription.
 func (cr *ChatRoom) SubLoop() {
 	// Start loop
 	for {
@@ -213,56 +361,145 @@ func (cr *ChatRoom) SubLoop() {
 			return
 
 		default:
-			// Read a message from the subscription
-			message, err := cr.psub.Next(cr.psctx)
+			// Read a message from the subscription, messages from self are already skipped
+			from, data, err := cr.transport.Next(cr.psctx)
 			if err != nil {
-				// Close the messages queue (subscription has closed)
-				close(cr.InboundChat)
-				cr.Logs <- chatlog{level: logLevelError, msg: "subscription has closed"}
+				// Close the messages queues (subscription has closed)
+				cr.protocolInbox.close()
+				cr.controlInbox.close()
+				cr.tryLog(chatlog{level: logLevelError, msg: "subscription has closed"})
 				return
 			}
 
-			// if message is from self then do nothing
-			if message.ReceivedFrom == cr.peerid {
-				continue
-			}
-
 			cm := &chatmessage{}
-			err = json.Unmarshal(message.Data, cm)
+			err = json.Unmarshal(data, cm)
 			if err != nil {
-				cr.Logs <- chatlog{level: logLevelError, msg: fmt.Sprintf("could not unmarshal chat message: %v", err)}
+				cr.tryLog(chatlog{level: logLevelError, msg: fmt.Sprintf("could not unmarshal chat message: %v", err)})
 				continue
 			}
 
-			switch cm.Type {
-			case messageTypeChatMessage:
-				cr.InboundChat <- *cm
-			case messageTypeStartKeygen:
-				if cr.doSignersIncludeMe(cm.StartKeygen.Signers) {
-					cr.InboundProtocolStart <- *cm
+			if cm.Session != "" {
+				isNew, after, missed := cr.received.accept(from, cm.Session, cm.Seq, time.Now())
+				if missed {
+					cr.requestHistory(cm.Session, after)
 				}
-			case messageTypeStartSign:
-				if cr.doSignersIncludeMe(cm.StartSign.Signers) {
-					cr.InboundProtocolStart <- *cm
-				}
-			case messageTypeStartSendTx:
-				if cr.doSignersIncludeMe(cm.StartSendTx.Signers) {
-					cr.InboundProtocolStart <- *cm
+				if !isNew {
+					continue
 				}
+			}
+
+			switch cm.Type {
+			case messageTypeHistoryRequest:
+				cr.replayHistory(from, cm.History)
 			case messageTypeProtocol:
 				if cr.isProtocolMsgForMe(cm) {
-					cr.Logs <- chatlog{level: logLevelDebug, msg: fmt.Sprintf("Processing mpc-cmp protocol msg round %v...", cm.ProtocolMessage.RoundNumber)}
-					cr.InboundProtocol <- cm.ProtocolMessage
+					cr.protocolInbox.push(cr.psctx, inboundMessage{from: from, cm: cm})
 				}
-			case messageTypeAdvertise:
-				cr.AddParticipant(message.ReceivedFrom, cm.AdvertiseMessage)
 			default:
-				cr.Logs <- chatlog{level: logLevelInfo, msg: fmt.Sprintf("received unknown msg type %v", cm.Type)}
+				cr.controlInbox.push(cr.psctx, inboundMessage{from: from, cm: cm})
 			}
 		}
 	}
 }
 
+// Hand the protocol messages to the running protocol
+func (cr *ChatRoom) protocolLoop() {
+	for {
+		m, ok := cr.protocolInbox.pop(cr.psctx)
+		if !ok {
+			return
+		}
+		logger.Debug("Processing mpc-cmp protocol msg", "project", cr.project, "round", m.cm.ProtocolMessage.RoundNumber, "id", m.cm.ID)
+		select {
+		case cr.InboundProtocol <- m.cm.ProtocolMessage:
+		case <-cr.psctx.Done():
+			return
+		}
+	}
+}
+
+// Handle the other messages, which may wait for the UI
+func (cr *ChatRoom) controlLoop() {
+	for {
+		m, ok := cr.controlInbox.pop(cr.psctx)
+		if !ok {
+			if cr.psctx.Err() == nil {
+				// the subscription has closed
+				close(cr.InboundChat)
+			}
+			return
+		}
+		from, cm := m.from, m.cm
+
+		switch cm.Type {
+		case messageTypeChatMessage:
+			cr.recordMessage(cm.SenderName, *cm)
+			cr.InboundChat <- *cm
+		case messageTypeProposal:
+			cr.receiveProposal(cm.Proposal)
+		case messageTypeStartProposal:
+			cr.receiveStartProposal(from, cm.Proposal, cm.StartProposal)
+		case messageTypeAdvertise:
+			cr.AddParticipant(from, cm.AdvertiseMessage)
+		case messageTypeJoinRequest:
+			cr.receiveJoinRequest(from, cm)
+		case messageTypeAdmit:
+			cr.receiveAdmission(from, cm)
+		case messageTypeConfirmRequest:
+			cr.receiveConfirmRequest(from, cm.ConfirmWallet)
+		default:
+			cr.Logs <- chatlog{level: logLevelInfo, msg: fmt.Sprintf("received unknown msg type %v", cm.Type)}
+		}
+	}
+}
+
+// Ask the sender of session for its messages after seq, all senders for all their messages when session is empty
+func (cr *ChatRoom) requestHistory(session string, after uint64) {
+	logger.Debug("Requesting the history", "project", cr.project, "session", session, "after", after)
+	go func() {
+		select {
+		case cr.OutboundChat <- chatmessage{Type: messageTypeHistoryRequest, SenderName: cr.cfg.Me.Nick, History: &historyrequest{Session: session, After: after}}:
+		case <-cr.psctx.Done():
+		}
+	}()
+}
+
+// Publish our recent messages again if another signer asks for them, as they were, so those who have them drop them
+func (cr *ChatRoom) replayHistory(from peer.ID, req *historyrequest) {
+	if req == nil || (req.Session != "" && req.Session != cr.history.session) {
+		return
+	}
+	replay := cr.history.replay(from, req.After, time.Now())
+	if len(replay) == 0 {
+		return
+	}
+	logger.Debug("Publishing the history again", "project", cr.project, "after", req.After, "count", len(replay))
+	go func() {
+		for _, data := range replay {
+			if err := cr.transport.Publish(cr.psctx, data); err != nil {
+				metrics.PublishErrors.WithLabelValues(cr.project).Inc()
+				logger.Warn("Could not publish the history again", "project", cr.project, "err", err)
+				return
+			}
+		}
+	}()
+}
+
+// The project of the room
+func (cr *ChatRoom) Project() string {
+	return cr.project
+}
+
+// The wallet of the room's project with the name, nil if there is none
+func (cr *ChatRoom) findWallet(name string) *ethwallet.Wallet {
+	return cr.cfg.FindProjectWallet(cr.project, name)
+}
+
+// The sorted names of the wallets of the room's project
+func (cr *ChatRoom) walletNames() []string {
+	return cr.cfg.ProjectWalletNames(cr.project)
+}
+
 func (cr *ChatRoom) isProtocolMsgForMe(cm *chatmessage) bool {
 	return cm.Type == messageTypeProtocol && cm.ProtocolMessage != nil && cm.ProtocolMessage.IsFor(cr.cfg.Me.PartyID())
 }
@@ -276,76 +513,183 @@ func (cr *ChatRoom) doSignersIncludeMe(signers []user.User) bool {
 	return false
 }
 
-func (cr *ChatRoom) runProtocolKeygen(walletname string, threshold int, signers []user.User) {
+// Run the keygen protocol and save the new wallet
+func (cr *ChatRoom) keygen(walletname string, threshold int, signers []user.User) (*ethwallet.Wallet, error) {
+	if err := user.CheckPartyIDs(signers); err != nil {
+		return nil, err
+	}
 	net := NewNetwork(cr)
-	wallet := cr.cfg.NewEmptyWallet(walletname, threshold, signers)
-	err := protocols.RunKeygen(wallet, net)
+	wallet := cr.cfg.NewEmptyWallet(cr.project, walletname, threshold, signers)
+	err := protocols.RunKeygen(cr.cfg.Me, wallet, net, protocols.DefaultConfirmTimeout)
+	if err != nil && wallet.Unconfirmed {
+		// Our share can not be made again, keep it until the signers confirm it
+		cr.record(audit.EventError, cr.cfg.Me.Nick, walletname, fmt.Sprintf("keygen not confirmed: %v", err), nil)
+		if err := cr.cfg.AddWallet(wallet); err != nil {
+			return nil, fmt.Errorf("error saving unconfirmed keygen result to wallet: %w", err)
+		}
+		cr.Logs <- chatlog{level: logLevelWarn, msg: fmt.Sprintf("Wallet '%s' has been generated but not confirmed by all signers. Its share is kept but can not sign until /confirm %s succeeds with the other signers online.", walletname, walletname)}
+		return nil, fmt.Errorf("error running keygen protocol: %w", err)
+	}
 	if err != nil {
-		log.Fatalf("Error running keygen protocol: %v", err)
+		cr.record(audit.EventError, cr.cfg.Me.Nick, walletname, fmt.Sprintf("keygen failed: %v", err), nil)
+		return nil, fmt.Errorf("error running keygen protocol: %w", err)
 	}
 
 	err = cr.cfg.AddWallet(wallet)
 	if err != nil {
-		log.Fatalf("Error saving keygen protocol result to wallet: %v", err)
+		return nil, fmt.Errorf("error saving keygen protocol result to wallet: %w", err)
 	}
 
-	cr.Logs <- chatlog{level: logLevelInfo, msg: fmt.Sprintf("Wallet '%s' has been generated.", walletname)}
+	fingerprint := protocols.Fingerprint(wallet)
+	cr.record(audit.EventKeygen, cr.cfg.Me.Nick, walletname, fmt.Sprintf("generated %v-of-%v wallet", threshold+1, len(signers)), map[string]string{
+		"address":     wallet.GetFormattedAddress(),
+		"signers":     nicksOf(signers),
+		"fingerprint": fingerprint,
+	})
+
+	cr.Logs <- chatlog{level: logLevelInfo, msg: fmt.Sprintf("Wallet '%s' has been generated and confirmed by all signers. Compare its fingerprint with them out-of-band: %s", walletname, fingerprint)}
+	return wallet, nil
 }
 
-func (cr *ChatRoom) runProtocolSign(walletname string, msghash []byte, signers []user.User) []byte {
+// Run the signing protocol, returning the eth signature (r | s | v)
+func (cr *ChatRoom) sign(walletname string, msghash []byte, signers []user.User) ([]byte, error) {
 	net := NewNetwork(cr)
-	wallet := cr.cfg.FindWallet(walletname)
+	wallet := cr.findWallet(walletname)
+	if wallet == nil {
+		return nil, fmt.Errorf("wallet %s not found", walletname)
+	}
 	sig, err := protocols.RunSign(wallet, msghash, signers, net)
 	if err != nil {
-		log.Fatalf("Error running signing protocol: %v", err)
+		cr.record(audit.EventError, cr.cfg.Me.Nick, walletname, fmt.Sprintf("signing failed: %v", err), nil)
+		return nil, fmt.Errorf("error running signing protocol: %w", err)
 	}
 
 	ethsig, err := wallet.MpcSigToEthSig(msghash, sig)
 	if err != nil {
-		log.Fatalf("Error recovering ethsig: %v", err)
+		return nil, fmt.Errorf("error recovering ethsig: %w", err)
 	}
 
-	return ethsig
+	cr.record(audit.EventSignature, cr.cfg.Me.Nick, walletname, "signed hash", map[string]string{
+		"hash":      hex.EncodeToString(msghash),
+		"signature": hex.EncodeToString(ethsig),
+		"signers":   nicksOf(signers),
+	})
+
+	return ethsig, nil
 }
 
-func (cr *ChatRoom) runProtocolSendTx(walletname string, destaddr string, amount uint64, memo string, signers []user.User) {
-	ew := cr.cfg.FindWallet(walletname)
-	//ether, err := ew.GetBalance()
+// The transaction a sendtx proposal is for. The proposer fixes the nonce and gas, so every signer
+// builds and signs the exact same transaction.
+func (cr *ChatRoom) sendTxTransaction(cmd startsendtxcmd) (*ethwallet.Wallet, *types.Transaction, error) {
+	ew := cr.findWallet(cmd.Name)
+	if ew == nil {
+		return nil, nil, fmt.Errorf("wallet %s not found", cmd.Name)
+	}
+	gasPrice, ok := new(big.Int).SetString(cmd.GasPrice, 10)
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid gas price %s", cmd.GasPrice)
+	}
+	value, ok := new(big.Int).SetString(cmd.Amount, 10)
+	if !ok || value.Sign() < 0 {
+		return nil, nil, fmt.Errorf("invalid amount %s", cmd.Amount)
+	}
 
-	// if err != nil {
-	// 	cr.Logs <- chatlog{level: logLevelError, msg: fmt.Sprintf("Error fetching wallet balance %v", err)}
-	// }
-	// tx, err := ew.createNormalTransaction(to, value, []byte{}, big.NewInt(0), 0)
-	
-	ew.GetCommonAddress()
-	to := common.HexToAddress(destaddr)
-	value := *big.NewInt(int64(amount))
-	
-	tx, err := ew.CreateNormalTransaction(&to, &value, []byte{}, big.NewInt(int64(0)) , 0)
+	to, err := addressbook.ParseAddress(cmd.DestAddr)
 	if err != nil {
-		cr.Logs <- chatlog{level: logLevelError, msg: fmt.Sprintf("Error CreateTx %v", err)}
+		return nil, nil, err
+	}
+	data := []byte{}
+	if cmd.Data != "" {
+		if data, err = hexutil.Decode(cmd.Data); err != nil {
+			return nil, nil, fmt.Errorf("invalid calldata: %w", err)
+		}
 	}
-	txHash := tx.ToSignHash(ew.Config.NetworkID)
+	tx := ew.NewTransaction(&to, value, data, gasPrice, cmd.GasLimit, cmd.Nonce)
+	return ew, tx, nil
+}
 
-	ethsig := cr.runProtocolSign(walletname, txHash, signers)
+// Run the reshare protocol and replace the wallet with our new share, or remove it if we are no longer a signer.
+// Returns nil if the wallet was removed.
+func (cr *ChatRoom) reshare(cmd startresharecmd) (*ethwallet.Wallet, error) {
+	old := cr.findWallet(cmd.Name)
+	if old != nil && old.Address != cmd.Address {
+		return nil, fmt.Errorf("wallet %s has address %s, not %s", cmd.Name, old.Address, cmd.Address)
+	}
 
-	tx.R = new(big.Int).SetBytes(ethsig[:32])
-	tx.S = new(big.Int).SetBytes(ethsig[32:64])
-	tx.V = new(big.Int).SetBytes(ethsig[64:])
+	if err := user.CheckPartyIDs(cmd.Signers); err != nil {
+		return nil, err
+	}
 
-	rawTx := tx.ToRawTx()
-	txID, err := ew.PublishTx(rawTx)
+	r := protocols.Reshare{Address: cmd.Address, Threshold: cmd.Threshold}
+	for _, u := range cmd.Signers {
+		r.Signers = append(r.Signers, u.PartyID())
+	}
+	for _, u := range cmd.Dealers {
+		r.Dealers = append(r.Dealers, u.PartyID())
+	}
 
+	var next *ethwallet.Wallet
+	if cr.doSignersIncludeMe(cmd.Signers) {
+		next = cr.cfg.NewEmptyWallet(cr.project, cmd.Name, cmd.Threshold, cmd.Signers)
+		if old != nil {
+			next.Config = old.Config
+			next.CreatedAt = old.CreatedAt
+			next.Fees = old.Fees
+			next.MaxFee = old.MaxFee
+		}
+	}
+
+	err := protocols.RunReshare(cr.cfg.Me.PartyID(), r, old, next, NewNetwork(cr))
 	if err != nil {
-		msg := fmt.Sprintf("[red]😱 Transaction Failed!")
-		cr.Logs <- chatlog{level: logLevelInfo, msg: msg}
-		cr.OutboundChat <- chatmessage{Type: messageTypeChatMessage, SenderName: cr.cfg.Me.Nick, UserMessage: msg}
-	} else {
-		scannerURL := ew.ConstructEtherscanUrl(ew.Config.NetworkName, txID)
-		msg := fmt.Sprintf("[blue]🎉 Transaction Confirmed![-] %s", scannerURL)
-			cr.Logs <- chatlog{level: logLevelInfo, msg: msg}
-			cr.OutboundChat <- chatmessage{Type: messageTypeChatMessage, SenderName: cr.cfg.Me.Nick, UserMessage: msg}
+		cr.record(audit.EventError, cr.cfg.Me.Nick, cmd.Name, fmt.Sprintf("reshare failed: %v", err), nil)
+		return nil, fmt.Errorf("error running reshare protocol: %w", err)
+	}
+
+	data := map[string]string{
+		"address":   cmd.Address,
+		"threshold": fmt.Sprint(cmd.Threshold),
+		"signers":   nicksOf(cmd.Signers),
+		"dealers":   nicksOf(cmd.Dealers),
+	}
+	if next == nil {
+		if err := cr.cfg.RemoveWallet(cmd.Name); err != nil {
+			return nil, fmt.Errorf("error removing reshared wallet: %w", err)
+		}
+		cr.record(audit.EventReshare, cr.cfg.Me.Nick, cmd.Name, "reshared to other signers, share removed", data)
+		cr.Logs <- chatlog{level: logLevelInfo, msg: fmt.Sprintf("Wallet '%s' has been reshared to %s and removed.", cmd.Name, nicksOf(cmd.Signers))}
+		return nil, nil
+	}
+
+	if err := cr.cfg.AddWallet(next); err != nil {
+		return nil, fmt.Errorf("error saving reshare protocol result to wallet: %w", err)
 	}
+	cr.record(audit.EventReshare, cr.cfg.Me.Nick, cmd.Name, fmt.Sprintf("reshared to %v-of-%v wallet", cmd.Threshold+1, len(cmd.Signers)), data)
+	cr.Logs <- chatlog{level: logLevelInfo, msg: fmt.Sprintf("Wallet '%s' has been reshared to %s.", cmd.Name, nicksOf(cmd.Signers))}
+
+	return next, nil
+}
+
+// Add an entry to the audit log
+func (cr *ChatRoom) record(t audit.EventType, actor string, wallet string, message string, data map[string]string) {
+	if err := cr.audit.Append(t, actor, wallet, message, data); err != nil {
+		logger.Error("Error writing to the audit log", "project", cr.project, "err", err)
+	}
+}
+
+// Record chat messages and proposals sent or received in the audit log
+func (cr *ChatRoom) recordMessage(actor string, cm chatmessage) {
+	switch cm.Type {
+	case messageTypeChatMessage:
+		cr.record(audit.EventChat, actor, "", cm.UserMessage, nil)
+	}
+}
+
+func nicksOf(users []user.User) string {
+	nicks := []string{}
+	for _, u := range users {
+		nicks = append(nicks, u.Nick)
+	}
+	return strings.Join(nicks, ",")
 }
 
 func (cr *ChatRoom) AddParticipant(peerid peer.ID, u user.User) {
@@ -356,6 +700,12 @@ func (cr *ChatRoom) AddParticipant(peerid peer.ID, u user.User) {
 		return
 	}
 
+	// A signer who replaced their identity key advertises the new key with the previous key's signature of it.
+	// Only take it from the holder of the new key, the peer id is derived from the key.
+	if u.PreviousIdentPubKey != nil && u.PeerID() == peerid {
+		cr.replaceSigner(u)
+	}
+
 	p := participant{
 		peerid:   peerid,
 		User:     u,
@@ -378,9 +728,31 @@ func (cr *ChatRoom) AddParticipant(peerid peer.ID, u user.User) {
 		// 	msg = fmt.Sprintf("ALERT %s has joined the chat with an unverified nick %s", cr.participants[peerid].Nick, cr.participants[peerid].Address)
 		// }
 		cr.Logs <- chatlog{level: logLevelInfo, msg: msg}
+		if len(cr.cfg.ProjectMembers(cr.project)) > 0 && !cr.isKnownSigner(u) {
+			cr.Logs <- chatlog{level: logLevelWarn, msg: fmt.Sprintf("%s is not a registered member of project %s.", u.Nick, cr.project)}
+		}
+
+		go cr.resyncProposals(u.Nick)
 	}
 }
 
+// Update the wallets of a signer whose identity key was replaced
+func (cr *ChatRoom) replaceSigner(u user.User) {
+	wallets, err := cr.cfg.ReplaceSigner(u)
+	if err != nil {
+		cr.Logs <- chatlog{level: logLevelError, msg: fmt.Sprintf("could not save the new identity key of %s: %v", u.Nick, err)}
+		return
+	}
+	if len(wallets) == 0 {
+		return
+	}
+	cr.Logs <- chatlog{level: logLevelInfo, msg: fmt.Sprintf("%s has a new identity key, updated wallets %s.", u.Nick, strings.Join(wallets, ", "))}
+	cr.record(audit.EventIdentity, u.Nick, "", "replaced identity key", map[string]string{
+		"peerid":  u.PeerID().Pretty(),
+		"wallets": strings.Join(wallets, ","),
+	})
+}
+
 func (cr *ChatRoom) ParticipantList() []*participant {
 	cr.mutex.RLock()
 	defer cr.mutex.RUnlock()
@@ -399,8 +771,7 @@ func (cr *ChatRoom) ParticipantList() []*participant {
 
 func (cr *ChatRoom) Exit() {
 	defer cr.pscancel()
-	cr.psub.Cancel()
-	cr.pstopic.Close()
+	cr.transport.Close()
 }
 
 // Publish our nick to the channel participants
@@ -409,8 +780,16 @@ func (cr *ChatRoom) advertiseLoop() {
 	defer ticker.Stop()
 
 	for {
-		<-ticker.C
-		cr.OutboundChat <- chatmessage{Type: messageTypeAdvertise, SenderName: cr.cfg.Me.Nick, AdvertiseMessage: cr.cfg.Me.User}
+		select {
+		case <-cr.psctx.Done():
+			return
+		case <-ticker.C:
+		}
+		select {
+		case cr.OutboundChat <- chatmessage{Type: messageTypeAdvertise, SenderName: cr.cfg.Me.Nick, AdvertiseMessage: cr.cfg.Me.User}:
+		case <-cr.psctx.Done():
+			return
+		}
 	}
 }
 
@@ -419,7 +798,14 @@ func (cr *ChatRoom) refreshParticipantsLoop() {
 	defer ticker.Stop()
 
 	for {
-		<-ticker.C
+		select {
+		case <-cr.psctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// participants whose ttl expired, logged once the lock is released
+		left := []string{}
 		func() {
 			cr.mutex.Lock()
 			defer cr.mutex.Unlock()
@@ -428,12 +814,20 @@ func (cr *ChatRoom) refreshParticipantsLoop() {
 				if time.Since(participant.addedAt) <= participant.ttl {
 					continue
 				}
-
-				cr.Logs <- chatlog{level: logLevelInfo, msg: fmt.Sprintf("%s has left the chat.", cr.participants[peerID].Nick)}
-				// participant ttl expired
+				left = append(left, participant.Nick)
 				delete(cr.participants, peerID)
 			}
+			metrics.RoomParticipants.WithLabelValues(cr.project).Set(float64(len(cr.participants)))
 		}()
+		for _, nick := range left {
+			select {
+			case cr.Logs <- chatlog{level: logLevelInfo, msg: fmt.Sprintf("%s has left the chat.", nick)}:
+			case <-cr.psctx.Done():
+				return
+			}
+		}
+		metrics.ConnectedPeers.WithLabelValues(cr.project).Set(float64(cr.transport.Peers()))
+		metrics.PendingProposals.WithLabelValues(cr.project).Set(float64(len(cr.proposals.Active())))
 	}
 }
 
@@ -0,0 +1,121 @@
+package chat
+
+import (
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shykerbogdan/mpc-wallet/config"
+	"github.com/shykerbogdan/mpc-wallet/proposal"
+	"github.com/shykerbogdan/mpc-wallet/user"
+	"github.com/taurusgroup/multi-party-sig/pkg/protocol"
+)
+
+// A config of nick taking part in projects P and Q
+func newTestConfig(t *testing.T, nick string) *config.AppConfig {
+	cfg, err := config.New("ethereum", "goerli", "P", nick, "0x1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Save(filepath.Join(t.TempDir(), "P-"+nick+".json")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cfg.Close() })
+	if err := cfg.AddProject("Q"); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// Each signer joins the rooms of both projects with one identity
+func TestProjectRooms(t *testing.T) {
+	p, q := &hub{}, &hub{}
+	alicecfg, bobcfg := newTestConfig(t, "alice"), newTestConfig(t, "bob")
+	aliceP, aliceQ := joinTestRoom(t, p, alicecfg, "P"), joinTestRoom(t, q, alicecfg, "Q")
+	bobP, bobQ := joinTestRoom(t, p, bobcfg, "P"), joinTestRoom(t, q, bobcfg, "Q")
+
+	aliceP.OutboundChat <- chatmessage{Type: messageTypeChatMessage, SenderName: "alice", UserMessage: "in P"}
+	if msg := nextChat(t, bobP); msg.UserMessage != "in P" {
+		t.Errorf("bob received %s in P", msg.UserMessage)
+	}
+
+	aliceQ.OutboundChat <- chatmessage{Type: messageTypeProtocol, SenderName: "alice", ProtocolMessage: &protocol.Message{From: alicecfg.Me.PartyID(), RoundNumber: 2, Broadcast: true}}
+	select {
+	case msg := <-bobQ.InboundProtocol:
+		if msg.RoundNumber != 2 {
+			t.Errorf("bob received round %d in Q", msg.RoundNumber)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("protocol message not received in Q")
+	}
+
+	// a proposal in P is only stored and shown in bob's room of P
+	approvers := []user.User{alicecfg.Me.User, bobcfg.Me.User}
+	prop, err := proposal.New(proposal.TypeKeygen, "w", "generate w", alicecfg.Me, 2, approvers, startkeygencmd{Name: "w", Threshold: 1}, proposal.DefaultTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliceP.broadcastProposal(prop)
+	select {
+	case msg := <-bobP.InboundProtocolStart:
+		if msg.Proposal.ID != prop.ID {
+			t.Errorf("bob asked to approve %s in P", msg.Proposal.ShortID())
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("proposal not received in P")
+	}
+	if bobP.proposals.Get(prop.ID) == nil {
+		t.Error("proposal not stored in P")
+	}
+	if bobQ.proposals.Get(prop.ID) != nil {
+		t.Error("proposal of P stored in Q")
+	}
+	if bobcfg.ProjectProposalsFile("P") == bobcfg.ProjectProposalsFile("Q") {
+		t.Error("projects share a proposals file")
+	}
+
+	// nothing of P showed up in Q or the other way round
+	select {
+	case msg := <-bobQ.InboundChat:
+		t.Errorf("chat of P received in Q: %s", msg.UserMessage)
+	case msg := <-bobP.InboundProtocol:
+		t.Errorf("protocol message of Q received in P: round %d", msg.RoundNumber)
+	case msg := <-bobQ.InboundProtocolStart:
+		t.Errorf("proposal of P received in Q: %s", msg.Proposal.ShortID())
+	case <-time.After(time.Millisecond * 200):
+	}
+
+	// the wallets of Q are only used in its room, and their names are taken in P as well
+	if err := bobcfg.AddWallet(bobcfg.NewEmptyWallet("Q", "w", 1, approvers)); err != nil {
+		t.Fatal(err)
+	}
+	if bobP.findWallet("w") != nil || bobQ.findWallet("w") == nil {
+		t.Error("wallet of Q found in P")
+	}
+	if names := bobP.walletNames(); len(names) != 0 {
+		t.Errorf("P has wallets %v", names)
+	}
+	if err := bobP.checkNewWalletName("w"); err == nil || !strings.Contains(err.Error(), "project Q") {
+		t.Errorf("new wallet w in P: %v", err)
+	}
+}
+
+// The loops of a room return once it is exited, for rooms joined and left while running
+func TestExitStopsLoops(t *testing.T) {
+	cfg := newTestConfig(t, "alice")
+	before := runtime.NumGoroutine()
+
+	cr := joinTestRoom(t, &hub{}, cfg, "Q")
+	cr.Exit()
+
+	deadline := time.Now().Add(time.Second * 5)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<16)
+			t.Fatalf("%d goroutines left after exit, %d before:\n%s", runtime.NumGoroutine(), before, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
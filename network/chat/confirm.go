@@ -0,0 +1,58 @@
+package chat
+
+import (
+	"fmt"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/shykerbogdan/mpc-wallet/audit"
+	"github.com/shykerbogdan/mpc-wallet/protocols"
+)
+
+// Run the keygen confirmation of an unconfirmed wallet again, asking its other signers to send theirs. They
+// all have to be online.
+func (cr *ChatRoom) ConfirmWallet(walletname string) error {
+	w := cr.findWallet(walletname)
+	if w == nil {
+		return fmt.Errorf("wallet %s not found", walletname)
+	}
+	if !w.Unconfirmed {
+		return fmt.Errorf("wallet %s has already been confirmed by all signers", walletname)
+	}
+
+	cr.OutboundChat <- chatmessage{Type: messageTypeConfirmRequest, SenderName: cr.cfg.Me.Nick, ConfirmWallet: walletname}
+	if err := protocols.RunConfirm(cr.cfg.Me, w, NewNetwork(cr), protocols.DefaultConfirmTimeout); err != nil {
+		cr.record(audit.EventError, cr.cfg.Me.Nick, walletname, fmt.Sprintf("keygen not confirmed: %v", err), nil)
+		return err
+	}
+	if err := cr.cfg.AddWallet(w); err != nil {
+		return fmt.Errorf("error saving the confirmed wallet: %w", err)
+	}
+
+	fingerprint := protocols.Fingerprint(w)
+	cr.record(audit.EventKeygen, cr.cfg.Me.Nick, walletname, "keygen confirmed by all signers", map[string]string{
+		"address":     w.GetFormattedAddress(),
+		"fingerprint": fingerprint,
+	})
+	cr.Logs <- chatlog{level: logLevelInfo, msg: fmt.Sprintf("Wallet '%s' has been confirmed by all signers. Compare its fingerprint with them out-of-band: %s", walletname, fingerprint)}
+	return nil
+}
+
+// Another signer of a wallet asks for our keygen confirmation of it
+func (cr *ChatRoom) receiveConfirmRequest(from peer.ID, walletname string) {
+	w := cr.findWallet(walletname)
+	if w == nil {
+		return
+	}
+	for _, u := range w.Others {
+		if u.PeerID() != from {
+			continue
+		}
+		cr.Logs <- chatlog{level: logLevelInfo, msg: fmt.Sprintf("%s asked to confirm the keygen of wallet '%s' again", u.Nick, walletname)}
+		go func() {
+			if err := protocols.SendConfirmation(cr.cfg.Me, w, NewNetwork(cr)); err != nil {
+				cr.Logs <- chatlog{level: logLevelError, msg: fmt.Sprintf("Error confirming wallet '%s': %v", walletname, err)}
+			}
+		}()
+		return
+	}
+}
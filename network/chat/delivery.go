@@ -0,0 +1,288 @@
+package chat
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/shykerbogdan/mpc-wallet/metrics"
+)
+
+// The transports deliver best-effort, a signer that joins late or whose gossip dropped a message would miss it.
+// Every message we publish carries the random session of our room and the next sequence number in it. Each
+// signer keeps its recent messages, and publishes them again when another signer asks for the history of its
+// session: when joining the room, when hearing from a session it does not know yet, and when it sees a gap in
+// the sequence numbers. The receivers drop the messages they already have.
+
+const (
+	// How many of our messages we keep, and for how long, to publish them again
+	historySize = 256
+	historyTTL  = time.Minute * 10
+
+	// We do not publish the history again for a signer asking again this soon, e.g. for the same gap
+	replayInterval = time.Second * 2
+
+	// How many messages are queued for the protocol or for the UI. Beyond this the UI inbox drops the oldest
+	// message, the protocol inbox holds up reading the transport.
+	inboxSize = 1024
+)
+
+// Asks the sender of Session for its messages after After, the senders of all sessions when Session is empty
+type historyrequest struct {
+	Session string `json:"session,omitempty"`
+	After   uint64 `json:"after,omitempty"`
+}
+
+// Whether a message is kept to publish it again. Advertisements and join requests are published periodically
+// anyway.
+func (cm *chatmessage) isRetained() bool {
+	switch cm.Type {
+	case messageTypeChatMessage, messageTypeProtocol, messageTypeProposal, messageTypeStartProposal, messageTypeAdmit:
+		return true
+	}
+	return false
+}
+
+func newSession() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+type historyEntry struct {
+	seq  uint64
+	data []byte
+	at   time.Time
+}
+
+// The messages we published in our session of the room
+type history struct {
+	session string
+	seq     uint64
+	entries []historyEntry
+
+	// The last history request we answered of each signer
+	replays map[peer.ID]replayState
+
+	mutex sync.Mutex
+}
+
+type replayState struct {
+	at    time.Time
+	after uint64
+}
+
+func newHistory() *history {
+	return &history{session: newSession(), replays: make(map[peer.ID]replayState)}
+}
+
+// Number the next message we publish
+func (h *history) stamp(cm *chatmessage) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.seq++
+	cm.Session = h.session
+	cm.Seq = h.seq
+	cm.ID = fmt.Sprintf("%s-%d", h.session, h.seq)
+}
+
+// Keep a message we published
+func (h *history) add(seq uint64, data []byte, now time.Time) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.entries = append(h.entries, historyEntry{seq: seq, data: data, at: now})
+	if len(h.entries) > historySize {
+		h.entries = h.entries[len(h.entries)-historySize:]
+	}
+}
+
+// The messages to publish again for a history request of from, oldest first. None if we just published them for it.
+func (h *history) replay(from peer.ID, after uint64, now time.Time) [][]byte {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for id, last := range h.replays {
+		if now.Sub(last.at) >= replayInterval {
+			delete(h.replays, id)
+		}
+	}
+	if last, ok := h.replays[from]; ok && after >= last.after {
+		return nil
+	}
+
+	replay := [][]byte{}
+	for _, e := range h.entries {
+		if e.seq > after && now.Sub(e.at) <= historyTTL {
+			replay = append(replay, e.data)
+		}
+	}
+	if len(replay) > 0 {
+		h.replays[from] = replayState{at: now, after: after}
+	}
+	return replay
+}
+
+// What we received from a session of another signer
+type senderState struct {
+	highest uint64
+	seen    map[uint64]bool
+	heard   time.Time
+}
+
+// The sessions of the other signers we received messages from
+type receivedLog struct {
+	senders map[string]*senderState
+	mutex   sync.Mutex
+}
+
+func newReceivedLog() *receivedLog {
+	return &receivedLog{senders: make(map[string]*senderState)}
+}
+
+// Whether a message is new, and if we missed messages of its session, the sequence number after which to ask for
+// them. The messages of a session we hear from for the first time may have been missed from the start.
+func (r *receivedLog) accept(from peer.ID, session string, seq uint64, now time.Time) (isNew bool, missedAfter uint64, missed bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	key := from.Pretty() + "/" + session
+	s, known := r.senders[key]
+	if !known {
+		s = &senderState{seen: make(map[uint64]bool)}
+		r.senders[key] = s
+	}
+	s.heard = now
+
+	// Older messages can no longer be published again, so they were seen or are lost
+	if seq+2*historySize <= s.highest || s.seen[seq] {
+		return false, 0, false
+	}
+	s.seen[seq] = true
+
+	switch {
+	case !known && seq > 1:
+		missedAfter, missed = 0, true
+	case known && seq > s.highest+1:
+		missedAfter, missed = s.highest, true
+	}
+	if seq > s.highest {
+		s.highest = seq
+		for old := range s.seen {
+			if old+2*historySize <= s.highest {
+				delete(s.seen, old)
+			}
+		}
+	}
+
+	// Forget the sessions we have not heard from for a while, e.g. of signers that restarted
+	for k, other := range r.senders {
+		if now.Sub(other.heard) > historyTTL {
+			delete(r.senders, k)
+		}
+	}
+	return true, missedAfter, missed
+}
+
+type inboundMessage struct {
+	from peer.ID
+	cm   *chatmessage
+}
+
+// A queue of received messages, so the loop reading the transport does not wait for the protocol or a slow UI.
+// A full lossy inbox drops the oldest message. Any lost protocol message would stall the protocol for good, so
+// a full inbox that is not lossy makes push wait for room instead, which holds up reading the transport until
+// the protocol catches up.
+type inbox struct {
+	project string
+	name    string
+	lossy   bool
+
+	items  []inboundMessage
+	closed bool
+	mutex  sync.Mutex
+	ready  chan struct{}
+	room   chan struct{}
+}
+
+func newInbox(project string, name string, lossy bool) *inbox {
+	return &inbox{project: project, name: name, lossy: lossy, ready: make(chan struct{}, 1), room: make(chan struct{}, 1)}
+}
+
+// Queue a message. Blocks while a full inbox that is not lossy has no room, until ctx is done.
+func (q *inbox) push(ctx context.Context, m inboundMessage) {
+	waiting := false
+	for {
+		q.mutex.Lock()
+		if len(q.items) < inboxSize || q.lossy {
+			break
+		}
+		q.mutex.Unlock()
+		if !waiting {
+			waiting = true
+			logger.Warn("Inbox is full, waiting for room", "project", q.project, "inbox", q.name)
+		}
+		select {
+		case <-q.room:
+		case <-ctx.Done():
+			return
+		}
+	}
+
+	if len(q.items) >= inboxSize {
+		dropped := q.items[0]
+		q.items = q.items[1:]
+		metrics.DroppedMessages.WithLabelValues(q.project, q.name).Inc()
+		logger.Warn("Inbox is full, dropped the oldest message", "project", q.project, "inbox", q.name, "type", dropped.cm.Type, "id", dropped.cm.ID)
+	}
+	q.items = append(q.items, m)
+	q.mutex.Unlock()
+	wake(q.ready)
+}
+
+// No more messages will be pushed, pop returns false once the queued ones are taken
+func (q *inbox) close() {
+	q.mutex.Lock()
+	q.closed = true
+	q.mutex.Unlock()
+	wake(q.ready)
+}
+
+func wake(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+// Block until the next message, false once the inbox is closed and empty or ctx is done
+func (q *inbox) pop(ctx context.Context) (inboundMessage, bool) {
+	for {
+		q.mutex.Lock()
+		if len(q.items) > 0 {
+			m := q.items[0]
+			q.items = q.items[1:]
+			if len(q.items) > 0 {
+				wake(q.ready)
+			}
+			wake(q.room)
+			q.mutex.Unlock()
+			return m, true
+		}
+		closed := q.closed
+		q.mutex.Unlock()
+		if closed {
+			return inboundMessage{}, false
+		}
+
+		select {
+		case <-q.ready:
+		case <-ctx.Done():
+			return inboundMessage{}, false
+		}
+	}
+}
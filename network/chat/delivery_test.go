@@ -0,0 +1,328 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/shykerbogdan/mpc-wallet/config"
+	"github.com/taurusgroup/multi-party-sig/pkg/protocol"
+)
+
+func TestReceivedLog(t *testing.T) {
+	r := newReceivedLog()
+	now := time.Now()
+	alice := peer.ID("alice")
+
+	steps := []struct {
+		seq         uint64
+		isNew       bool
+		missed      bool
+		missedAfter uint64
+	}{
+		{seq: 3, isNew: true, missed: true, missedAfter: 0}, // first heard mid-session
+		{seq: 4, isNew: true},
+		{seq: 4, isNew: false},                              // duplicate
+		{seq: 7, isNew: true, missed: true, missedAfter: 4}, // gap
+		{seq: 1, isNew: true},                               // from the history
+		{seq: 1, isNew: false},
+	}
+	for i, s := range steps {
+		isNew, after, missed := r.accept(alice, "s1", s.seq, now)
+		if isNew != s.isNew || missed != s.missed || after != s.missedAfter {
+			t.Errorf("step %d: seq %d is new %v, missed %v after %d", i, s.seq, isNew, missed, after)
+		}
+	}
+
+	// A new session of the same peer, e.g. after a restart, starts over
+	if isNew, _, missed := r.accept(alice, "s2", 1, now); !isNew || missed {
+		t.Errorf("first message of a new session is new %v, missed %v", isNew, missed)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	h := newHistory()
+	now := time.Now()
+	for i := 0; i < historySize+10; i++ {
+		cm := chatmessage{Type: messageTypeChatMessage}
+		h.stamp(&cm)
+		if cm.Seq != uint64(i+1) || cm.ID != fmt.Sprintf("%s-%d", h.session, i+1) {
+			t.Fatalf("message %d stamped %d %s", i, cm.Seq, cm.ID)
+		}
+		h.add(cm.Seq, []byte(fmt.Sprint(cm.Seq)), now.Add(-historyTTL))
+	}
+	h.add(h.seq+1, []byte("recent"), now)
+
+	bob, carol := peer.ID("bob"), peer.ID("carol")
+	if replay := h.replay(bob, 0, now.Add(time.Second)); len(replay) != 1 || string(replay[0]) != "recent" {
+		t.Errorf("replayed %d messages, want only the recent one", len(replay))
+	}
+	if replay := h.replay(bob, 0, now.Add(time.Second*2)); len(replay) != 0 {
+		t.Error("replayed again right away")
+	}
+	if replay := h.replay(carol, 0, now.Add(time.Second*2)); len(replay) != 1 {
+		t.Error("did not replay for another signer")
+	}
+	if replay := h.replay(bob, 0, now.Add(time.Second*2+replayInterval)); len(replay) != 1 {
+		t.Error("did not replay after the interval")
+	}
+	if len(h.entries) != historySize {
+		t.Errorf("kept %d messages, want %d", len(h.entries), historySize)
+	}
+}
+
+func TestInbox(t *testing.T) {
+	ctx := context.Background()
+	q := newInbox("P", "test", true)
+	for i := 0; i < inboxSize+2; i++ {
+		q.push(ctx, inboundMessage{cm: &chatmessage{Seq: uint64(i)}})
+	}
+	q.close()
+
+	m, ok := q.pop(ctx)
+	if !ok || m.cm.Seq != 2 {
+		t.Fatalf("first message is %d, the oldest should have been dropped", m.cm.Seq)
+	}
+	n := 1
+	for {
+		if _, ok := q.pop(ctx); !ok {
+			break
+		}
+		n++
+	}
+	if n != inboxSize {
+		t.Errorf("popped %d messages, want %d", n, inboxSize)
+	}
+}
+
+func TestProtocolInbox(t *testing.T) {
+	ctx := context.Background()
+	q := newInbox("P", "protocol", false)
+	for i := 0; i < inboxSize; i++ {
+		q.push(ctx, inboundMessage{cm: &chatmessage{Seq: uint64(i)}})
+	}
+
+	// a full inbox holds up the next message until there is room, and loses none
+	pushed := make(chan struct{})
+	go func() {
+		q.push(ctx, inboundMessage{cm: &chatmessage{Seq: inboxSize}})
+		close(pushed)
+	}()
+	select {
+	case <-pushed:
+		t.Fatal("pushed to a full inbox")
+	case <-time.After(time.Millisecond * 100):
+	}
+	if m, ok := q.pop(ctx); !ok || m.cm.Seq != 0 {
+		t.Fatalf("first message is %d", m.cm.Seq)
+	}
+	select {
+	case <-pushed:
+	case <-time.After(time.Second * 5):
+		t.Fatal("push still waiting after a pop")
+	}
+	for i := 1; i <= inboxSize; i++ {
+		if m, ok := q.pop(ctx); !ok || m.cm.Seq != uint64(i) {
+			t.Fatalf("message %d is %d", i, m.cm.Seq)
+		}
+	}
+
+	// a push waiting for room gives up once the room is left
+	for i := 0; i < inboxSize; i++ {
+		q.push(ctx, inboundMessage{cm: &chatmessage{}})
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		cancel()
+	}()
+	q.push(cancelled, inboundMessage{cm: &chatmessage{}})
+}
+
+// Connects the transports of the rooms in memory, like pubsub without the gossip
+type hub struct {
+	members []*hubTransport
+	mutex   sync.Mutex
+}
+
+type hubMessage struct {
+	from peer.ID
+	data []byte
+}
+
+type hubTransport struct {
+	id      peer.ID
+	hub     *hub
+	inbound chan hubMessage
+	done    chan struct{}
+	close   sync.Once
+}
+
+func (h *hub) join(id peer.ID) *hubTransport {
+	t := &hubTransport{id: id, hub: h, inbound: make(chan hubMessage, 1000), done: make(chan struct{})}
+	h.mutex.Lock()
+	h.members = append(h.members, t)
+	h.mutex.Unlock()
+	return t
+}
+
+func (t *hubTransport) ID() peer.ID {
+	return t.id
+}
+
+func (t *hubTransport) Publish(ctx context.Context, data []byte) error {
+	t.hub.mutex.Lock()
+	defer t.hub.mutex.Unlock()
+	for _, m := range t.hub.members {
+		if m != t {
+			m.inbound <- hubMessage{from: t.id, data: data}
+		}
+	}
+	return nil
+}
+
+func (t *hubTransport) Next(ctx context.Context) (peer.ID, []byte, error) {
+	select {
+	case m := <-t.inbound:
+		return m.from, m.data, nil
+	case <-t.done:
+		return "", nil, errors.New("closed")
+	case <-ctx.Done():
+		return "", nil, ctx.Err()
+	}
+}
+
+func (t *hubTransport) Peers() int {
+	t.hub.mutex.Lock()
+	defer t.hub.mutex.Unlock()
+	return len(t.hub.members) - 1
+}
+
+func (t *hubTransport) Close() {
+	t.close.Do(func() { close(t.done) })
+}
+
+func newTestRoom(t *testing.T, h *hub, nick string) *ChatRoom {
+	cfg, err := config.New("ethereum", "goerli", "P", nick, "0x1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Save(filepath.Join(t.TempDir(), "P-"+nick+".json")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cfg.Close() })
+	return joinTestRoom(t, h, cfg, "P")
+}
+
+// Join the room of a project on the hub, the hub stands for the pubsub topic of the project
+func joinTestRoom(t *testing.T, h *hub, cfg *config.AppConfig, project string) *ChatRoom {
+	cr, err := NewChatRoom(h.join(cfg.Me.PeerID()), cfg, project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cr.Exit)
+
+	// Nobody looks at the logs
+	go func() {
+		for {
+			select {
+			case <-cr.Logs:
+			case <-cr.psctx.Done():
+				return
+			}
+		}
+	}()
+	return cr
+}
+
+func nextChat(t *testing.T, cr *ChatRoom) chatmessage {
+	select {
+	case msg := <-cr.InboundChat:
+		return msg
+	case <-time.After(time.Second * 5):
+		t.Fatal("no chat message received")
+	}
+	return chatmessage{}
+}
+
+func TestLateJoiner(t *testing.T) {
+	h := &hub{}
+	alice := newTestRoom(t, h, "alice")
+	alice.OutboundChat <- chatmessage{Type: messageTypeChatMessage, SenderName: "alice", UserMessage: "before bob"}
+	// wait until it is published
+	time.Sleep(time.Millisecond * 100)
+
+	bob := newTestRoom(t, h, "bob")
+	if msg := nextChat(t, bob); msg.UserMessage != "before bob" || msg.ID == "" {
+		t.Errorf("bob received %+v", msg)
+	}
+
+	// carol joins and asks for the history as well, bob does not get alice's message again
+	carol := newTestRoom(t, h, "carol")
+	if msg := nextChat(t, carol); msg.UserMessage != "before bob" {
+		t.Errorf("carol received %s", msg.UserMessage)
+	}
+	alice.OutboundChat <- chatmessage{Type: messageTypeChatMessage, SenderName: "alice", UserMessage: "after carol"}
+	if msg := nextChat(t, bob); msg.UserMessage != "after carol" {
+		t.Errorf("bob received %s again", msg.UserMessage)
+	}
+}
+
+func TestSlowUI(t *testing.T) {
+	h := &hub{}
+	alice := newTestRoom(t, h, "alice")
+	bob := newTestRoom(t, h, "bob")
+
+	// bob's UI does not read the chat
+	for i := 0; i < 30; i++ {
+		alice.OutboundChat <- chatmessage{Type: messageTypeChatMessage, SenderName: "alice", UserMessage: fmt.Sprint(i)}
+	}
+	alice.OutboundChat <- chatmessage{Type: messageTypeProtocol, SenderName: "alice", ProtocolMessage: &protocol.Message{From: alice.cfg.Me.PartyID(), RoundNumber: 1}}
+
+	select {
+	case msg := <-bob.InboundProtocol:
+		if msg.RoundNumber != 1 {
+			t.Errorf("received round %d", msg.RoundNumber)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("protocol message held up by the chat")
+	}
+
+	// the chat is not lost
+	for i := 0; i < 30; i++ {
+		if msg := nextChat(t, bob); msg.UserMessage != fmt.Sprint(i) {
+			t.Fatalf("chat message %d is %s", i, msg.UserMessage)
+		}
+	}
+}
+
+func TestSlowProtocol(t *testing.T) {
+	h := &hub{}
+	alice := newTestRoom(t, h, "alice")
+	bob := newTestRoom(t, h, "bob")
+
+	// bob's protocol falls far behind, more than fit in the inbox
+	const n = inboxSize + 100
+	go func() {
+		for i := 0; i < n; i++ {
+			alice.OutboundChat <- chatmessage{Type: messageTypeProtocol, SenderName: "alice", ProtocolMessage: &protocol.Message{From: alice.cfg.Me.PartyID(), RoundNumber: 1, Data: []byte(fmt.Sprint(i)), Broadcast: true}}
+		}
+	}()
+	time.Sleep(time.Millisecond * 500)
+
+	for i := 0; i < n; i++ {
+		select {
+		case msg := <-bob.InboundProtocol:
+			if string(msg.Data) != fmt.Sprint(i) {
+				t.Fatalf("protocol message %d is %s", i, msg.Data)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("protocol message %d lost", i)
+		}
+	}
+}
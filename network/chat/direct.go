@@ -0,0 +1,88 @@
+package chat
+
+import (
+	"context"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/peerstore"
+	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
+)
+
+// Only thresher hosts answer each other on the local network
+const mdnsServiceName = "_thresher._udp"
+
+// How often we check that we are still connected to the static peers
+const staticPeersInterval = time.Second * 10
+
+// Tag protecting the connections to the static peers from the connection manager
+const staticPeerTag = "thresher-static"
+
+// Find the other signers on the local network with mDNS and connect to them
+func (p2p *P2P) startMDNS() error {
+	service := mdns.NewMdnsService(p2p.Host, mdnsServiceName, &mdnsNotifee{host: p2p.Host})
+	if err := service.Start(); err != nil {
+		return err
+	}
+	p2plog.Info("Looking for peers on the local network with mDNS")
+	return nil
+}
+
+type mdnsNotifee struct {
+	host host.Host
+}
+
+func (n *mdnsNotifee) HandlePeerFound(pi peer.AddrInfo) {
+	if pi.ID == n.host.ID() || n.host.Network().Connectedness(pi.ID) == network.Connected {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	if err := n.host.Connect(ctx, pi); err != nil {
+		p2plog.Debug("Could not connect to the peer found with mDNS", "peer", pi.ID.Pretty(), "err", err)
+		return
+	}
+	p2plog.Info("Connected to a peer on the local network", "peer", pi.ID.Pretty())
+}
+
+// Stay connected to the static peers, reconnecting to those which went away. Their connections are kept
+// by the connection manager.
+func (p2p *P2P) connectStaticPeers(peers []peer.AddrInfo) {
+	for _, pi := range peers {
+		p2p.Host.Peerstore().AddAddrs(pi.ID, pi.Addrs, peerstore.PermanentAddrTTL)
+		p2p.Host.ConnManager().Protect(pi.ID, staticPeerTag)
+	}
+
+	connect := func() {
+		for _, pi := range peers {
+			if p2p.Host.Network().Connectedness(pi.ID) == network.Connected {
+				continue
+			}
+			go func(pi peer.AddrInfo) {
+				ctx, cancel := context.WithTimeout(p2p.Ctx, staticPeersInterval)
+				defer cancel()
+				if err := p2p.Host.Connect(ctx, pi); err != nil {
+					p2plog.Debug("Could not connect to the static peer", "peer", pi.ID.Pretty(), "err", err)
+					return
+				}
+				p2plog.Info("Connected to the static peer", "peer", pi.ID.Pretty())
+			}(pi)
+		}
+	}
+
+	connect()
+	go func() {
+		ticker := time.NewTicker(staticPeersInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				connect()
+			case <-p2p.Ctx.Done():
+				return
+			}
+		}
+	}()
+}
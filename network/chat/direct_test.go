@@ -0,0 +1,86 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/shykerbogdan/mpc-wallet/user"
+)
+
+func newDirectP2P(t *testing.T, nick string, peers ...string) *P2P {
+	me, err := user.NewMe(nick, "0x1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p2p := NewP2P(me, []string{"room"}, P2POptions{
+		ListenAddrs: []string{"/ip4/127.0.0.1/tcp/0"},
+		Peers:       peers,
+		NoDHT:       true,
+	})
+	t.Cleanup(func() { p2p.Host.Close() })
+	return p2p
+}
+
+func p2pAddr(p2p *P2P) string {
+	return fmt.Sprintf("%s/p2p/%s", p2p.Host.Addrs()[0], p2p.Host.ID().Pretty())
+}
+
+func TestStaticPeers(t *testing.T) {
+	// the first host has to be given a peer too, it does not need to be online
+	other := newDirectP2P(t, "carol", "/ip4/127.0.0.1/tcp/1/p2p/12D3KooWPqiDWH8Sg149w7d9oWCuPxbV8SBvixY61RFCbVBZg4wa")
+	alice := newDirectP2P(t, "alice", p2pAddr(other))
+	if alice.KadDHT != nil || alice.Discovery != nil {
+		t.Fatal("host has a DHT with NoDHT")
+	}
+
+	otherTopic, err := other.PubSub.Join("room")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := otherTopic.Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Cancel()
+	aliceTopic, err := alice.PubSub.Join("room")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	for alice.Host.Network().Connectedness(other.Host.ID()) != network.Connected || len(aliceTopic.ListPeers()) == 0 {
+		select {
+		case <-ctx.Done():
+			t.Fatal("did not connect to the static peer")
+		case <-time.After(time.Millisecond * 50):
+		}
+	}
+
+	if err := aliceTopic.Publish(ctx, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := sub.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.Data) != "hello" {
+		t.Errorf("received %s", msg.Data)
+	}
+}
+
+func TestParsePeerAddrs(t *testing.T) {
+	id := "12D3KooWPqiDWH8Sg149w7d9oWCuPxbV8SBvixY61RFCbVBZg4wa"
+	peers := parsePeerAddrs([]string{
+		"/ip4/192.168.1.20/tcp/4001/p2p/" + id,
+		"/ip6/fe80::1/tcp/4001/p2p/" + id,
+		"/ip4/192.168.1.21/tcp/4001",
+		"not an addr",
+	})
+	if len(peers) != 1 || peers[0].ID.Pretty() != id || len(peers[0].Addrs) != 2 {
+		t.Errorf("parsed %v", peers)
+	}
+}
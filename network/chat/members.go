@@ -0,0 +1,192 @@
+package chat
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/shykerbogdan/mpc-wallet/audit"
+	"github.com/shykerbogdan/mpc-wallet/invite"
+	"github.com/shykerbogdan/mpc-wallet/user"
+)
+
+// How often a new signer asks to be admitted until they are
+const joinRequestInterval = time.Second * 10
+
+// A new signer asking to join the project with an invitation we issued
+type joinrequest struct {
+	User       user.User
+	Invitation *invite.Invitation
+	Token      string
+}
+
+// Until we are admitted to the project of the room, ask the inviter to admit us
+func (cr *ChatRoom) joinLoop() {
+	ticker := time.NewTicker(joinRequestInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cr.psctx.Done():
+			return
+		case <-ticker.C:
+			token := cr.cfg.PendingJoin(cr.project)
+			if token == "" {
+				return
+			}
+			cr.OutboundChat <- chatmessage{Type: messageTypeJoinRequest, SenderName: cr.cfg.Me.Nick, AdvertiseMessage: cr.cfg.Me.User, JoinToken: token}
+		}
+	}
+}
+
+// Whether u is a registered member of the project of the room, or a co-signer of one of its wallets
+func (cr *ChatRoom) isKnownSigner(u user.User) bool {
+	return cr.cfg.IsKnownSigner(cr.project, u)
+}
+
+// Decode the invitation of a join request or admission, which must be for the project of the room
+func (cr *ChatRoom) roomInvitation(token string) (*invite.Invitation, error) {
+	inv, err := invite.Decode(token)
+	if err != nil {
+		return nil, err
+	}
+	if inv.Project != cr.project {
+		return nil, fmt.Errorf("invitation is for project %s", inv.Project)
+	}
+	return inv, nil
+}
+
+// Whether the join request or admission of an invitation has been seen this session, marking it seen
+func (cr *ChatRoom) seenJoinRequest(id string) bool {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	seen := cr.joinRequests[id]
+	cr.joinRequests[id] = true
+	return seen
+}
+
+// A new signer asks to join. Only the inviter can admit them, the others just learn about it.
+func (cr *ChatRoom) receiveJoinRequest(from peer.ID, cm *chatmessage) {
+	u := cm.AdvertiseMessage
+	if u.IdentPubKey == nil || u.PeerID() != from {
+		return
+	}
+	inv, err := cr.roomInvitation(cm.JoinToken)
+	if err != nil {
+		if !cr.seenJoinRequest(cm.JoinToken) {
+			cr.Logs <- chatlog{level: logLevelWarn, msg: fmt.Sprintf("%s asks to join with an invalid invitation: %v", u.Nick, err)}
+		}
+		return
+	}
+	mine := inv.Inviter.IdentPubKey.Equals(cr.cfg.Me.IdentPubKey)
+
+	// The new signer missed the admission, e.g. by not being online yet
+	if cr.cfg.IsMember(cr.project, u) {
+		if mine {
+			cr.admit(u, cm.JoinToken)
+		}
+		return
+	}
+	if cr.seenJoinRequest(inv.ID) {
+		return
+	}
+
+	if !mine {
+		cr.Logs <- chatlog{level: logLevelInfo, msg: fmt.Sprintf("%s asks to join project %s with an invitation of %s.", u.Nick, cr.project, inv.Inviter.Nick)}
+		return
+	}
+	if err := inv.Verify(time.Now()); err != nil {
+		cr.Logs <- chatlog{level: logLevelWarn, msg: fmt.Sprintf("%s asks to join with your invitation %s: %v", u.Nick, inv.ID, err)}
+		return
+	}
+	if _, ok := cr.cfg.FindInvitation(inv.ID); !ok {
+		cr.Logs <- chatlog{level: logLevelWarn, msg: fmt.Sprintf("%s asks to join with your invitation %s, which has already been used", u.Nick, inv.ID)}
+		return
+	}
+	if !inv.Admits(u.Nick) {
+		cr.Logs <- chatlog{level: logLevelWarn, msg: fmt.Sprintf("%s asks to join with your invitation for %s", u.Nick, inv.Nick)}
+		return
+	}
+
+	select {
+	case cr.InboundJoin <- joinrequest{User: u, Invitation: inv, Token: cm.JoinToken}:
+	default:
+		cr.Logs <- chatlog{level: logLevelWarn, msg: fmt.Sprintf("Too many join requests, dropped the one of %s", u.Nick)}
+	}
+}
+
+// Confirm or decline a join request with one of our invitations. Either way the invitation is used up.
+func (cr *ChatRoom) decideJoinRequest(req joinrequest, admit bool) error {
+	if err := cr.cfg.UseInvitation(req.Invitation.ID); err != nil {
+		return err
+	}
+	if !admit {
+		cr.record(audit.EventRejection, cr.cfg.Me.Nick, "", fmt.Sprintf("declined %s joining", req.User.Nick), map[string]string{
+			"invitation": req.Invitation.ID,
+			"peerid":     req.User.PeerID().Pretty(),
+		})
+		return nil
+	}
+
+	if err := cr.cfg.AddMembers(cr.project, []user.User{req.User}); err != nil {
+		return err
+	}
+	cr.record(audit.EventMember, cr.cfg.Me.Nick, "", fmt.Sprintf("admitted %s", req.User.Nick), map[string]string{
+		"invitation": req.Invitation.ID,
+		"peerid":     req.User.PeerID().Pretty(),
+	})
+	cr.admit(req.User, req.Token)
+	return nil
+}
+
+// Tell the room u is admitted, and u who the members are
+func (cr *ChatRoom) admit(u user.User, token string) {
+	members := append([]user.User{cr.cfg.Me.User}, cr.cfg.ProjectMembers(cr.project)...)
+	cr.OutboundChat <- chatmessage{Type: messageTypeAdmit, SenderName: cr.cfg.Me.Nick, AdvertiseMessage: u, JoinToken: token, Members: members}
+}
+
+// The inviter admitted a new signer, which may be us. Members take the word of the inviter if they know
+// the inviter as a member or co-signer.
+func (cr *ChatRoom) receiveAdmission(from peer.ID, cm *chatmessage) {
+	u := cm.AdvertiseMessage
+	inv, err := cr.roomInvitation(cm.JoinToken)
+	if err != nil || u.IdentPubKey == nil || inv.Inviter.PeerID() != from || !inv.Admits(u.Nick) {
+		return
+	}
+
+	if u.IdentPubKey.Equals(cr.cfg.Me.IdentPubKey) {
+		if cr.cfg.PendingJoin(cr.project) == "" {
+			return
+		}
+		if err := cr.cfg.AddMembers(cr.project, cm.Members); err != nil {
+			cr.Logs <- chatlog{level: logLevelError, msg: fmt.Sprintf("could not register the members of project %s: %v", cr.project, err)}
+			return
+		}
+		if err := cr.cfg.SetPendingJoin(cr.project, ""); err != nil {
+			cr.Logs <- chatlog{level: logLevelError, msg: fmt.Sprintf("could not save joining project %s: %v", cr.project, err)}
+			return
+		}
+		cr.record(audit.EventMember, inv.Inviter.Nick, "", fmt.Sprintf("admitted %s", u.Nick), map[string]string{"invitation": inv.ID})
+		cr.Logs <- chatlog{level: logLevelInfo, msg: fmt.Sprintf("%s admitted you to project %s.", inv.Inviter.Nick, cr.project)}
+		return
+	}
+
+	if cr.cfg.IsMember(cr.project, u) {
+		return
+	}
+	if !cr.isKnownSigner(inv.Inviter) {
+		if !cr.seenJoinRequest("admit:" + inv.ID) {
+			cr.Logs <- chatlog{level: logLevelWarn, msg: fmt.Sprintf("%s admitted %s, but %s is not a member you know, ignoring it.", inv.Inviter.Nick, u.Nick, inv.Inviter.Nick)}
+		}
+		return
+	}
+	if err := cr.cfg.AddMembers(cr.project, []user.User{u}); err != nil {
+		cr.Logs <- chatlog{level: logLevelError, msg: fmt.Sprintf("could not register %s as a member: %v", u.Nick, err)}
+		return
+	}
+	cr.record(audit.EventMember, inv.Inviter.Nick, "", fmt.Sprintf("admitted %s", u.Nick), map[string]string{
+		"invitation": inv.ID,
+		"peerid":     u.PeerID().Pretty(),
+	})
+	cr.Logs <- chatlog{level: logLevelInfo, msg: fmt.Sprintf("%s admitted %s to project %s.", inv.Inviter.Nick, u.Nick, cr.project)}
+}
@@ -4,11 +4,11 @@ import (
 	"context"
 	"crypto/sha256"
 	"fmt"
-	"log"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/shykerbogdan/mpc-wallet/logging"
 	"github.com/shykerbogdan/mpc-wallet/user"
 
 	"github.com/ipfs/go-cid"
@@ -26,15 +26,40 @@ import (
 	"github.com/multiformats/go-multihash"
 )
 
+var p2plog = logging.New("p2p")
+
 // A structure that represents a P2P Host
 type P2P struct {
-	Ctx          context.Context
-	Me           user.Me
-	Host         core.Host
-	KadDHT       *dht.IpfsDHT
-	Discovery    *discovery.RoutingDiscovery
-	PubSub       *pubsub.PubSub
-	ChatroomName string
+	Ctx       context.Context
+	Me        user.Me
+	Host      core.Host
+	KadDHT    *dht.IpfsDHT
+	Discovery *discovery.RoutingDiscovery
+	PubSub    *pubsub.PubSub
+	// The rooms the host finds peers for, one pubsub topic each
+	ChatroomNames []string
+
+	opts P2POptions
+}
+
+// How the host finds the other signers
+type P2POptions struct {
+	// Bootstrap peers of the DHT, the public libp2p ones when empty
+	BootstrapAddrs []string
+	// Addrs to listen on, the libp2p defaults when empty
+	ListenAddrs []string
+	// Peers to stay connected to directly, e.g. the other signers of a ceremony on a LAN
+	Peers []string
+	// Find the other signers on the local network with mDNS
+	MDNS bool
+	// Do not use the Kademlia DHT, so no bootstrap peer and no internet is needed. The other signers are
+	// then only found through Peers and MDNS.
+	NoDHT bool
+}
+
+// Whether the signers can be found without the DHT
+func (o P2POptions) hasDirectPeers() bool {
+	return o.MDNS || len(o.Peers) > 0
 }
 
 /*
@@ -43,37 +68,52 @@ A constructor function that generates and returns a P2P object.
 Constructs a libp2p host with TLS encrypted secure transportation that works over a TCP
 transport connection using a Yamux Stream Multiplexer and uses UPnP for the NAT traversal.
 
-A Kademlia DHT is then bootstrapped on this host using the specified peers
-and a Peer Discovery service is created from this Kademlia DHT. The PubSub handler is then
-created on the host using the peer discovery service created prior.
+Unless disabled, a Kademlia DHT is then bootstrapped on this host using the specified peers
+and a Peer Discovery service is created from this Kademlia DHT. The host also connects to the
+static peers and the peers found with mDNS. The PubSub handler is then created on the host using
+the peer discovery service created prior, if any.
 */
-func NewP2P(me user.Me, chatroomname string, bootstrapaddrs []string, listenaddrs []string) *P2P {
+func NewP2P(me user.Me, chatroomnames []string, opts P2POptions) *P2P {
+	if opts.NoDHT && !opts.hasDirectPeers() {
+		p2plog.Fatal("Without the DHT, static peers or mDNS are needed to find the other signers")
+	}
 	ctx := context.Background()
 
-	nodehost, kaddht := setupHostAndDHT(ctx, bootstrapaddrs, listenaddrs, me)
-	log.Printf("Created the P2P Host and the Kademlia DHT")
-
-	bootstrapDHT(ctx, nodehost, kaddht, bootstrapaddrs)
-	log.Printf("Bootstrapped the Kademlia DHT and Connected to Bootstrap Peers %s", strings.Join(bootstrapaddrs, ", "))
+	nodehost, kaddht := setupHostAndDHT(ctx, opts, me)
+	p2phost := &P2P{
+		Ctx:           ctx,
+		Me:            me,
+		Host:          nodehost,
+		KadDHT:        kaddht,
+		ChatroomNames: chatroomnames,
+		opts:          opts,
+	}
 
-	routingdiscovery := discovery.NewRoutingDiscovery(kaddht)
-	log.Printf("Created the Peer Discovery Service")
+	if len(opts.Peers) > 0 {
+		p2phost.connectStaticPeers(parsePeerAddrs(opts.Peers))
+	}
+	if opts.MDNS {
+		if err := p2phost.startMDNS(); err != nil {
+			p2plog.Fatal("Failed to start mDNS discovery", "err", err)
+		}
+	}
 
-	pubsubhandler := setupPubSub(ctx, nodehost, routingdiscovery)
-	log.Printf("Created the PubSub Handler")
+	if kaddht != nil {
+		p2plog.Debug("Created the P2P host and the Kademlia DHT")
+		bootstrapDHT(ctx, nodehost, kaddht, opts)
+		p2plog.Info("Bootstrapped the Kademlia DHT", "bootstrap", strings.Join(opts.BootstrapAddrs, ", "))
 
-	p2phost := &P2P{
-		Ctx:          ctx,
-		Me:           me,
-		Host:         nodehost,
-		KadDHT:       kaddht,
-		Discovery:    routingdiscovery,
-		PubSub:       pubsubhandler,
-		ChatroomName: chatroomname,
+		p2phost.Discovery = discovery.NewRoutingDiscovery(kaddht)
+		p2plog.Debug("Created the peer discovery service")
+	} else {
+		p2plog.Info("Created the P2P host without the DHT", "peers", strings.Join(opts.Peers, ", "), "mdns", opts.MDNS)
 	}
 
+	p2phost.PubSub = setupPubSub(ctx, nodehost, p2phost.Discovery)
+	p2plog.Debug("Created the pubsub handler")
+
 	//log.Printf("Host libp2p protocols: %s", strings.Join(nodehost.Mux().Protocols(), ", "))
-	log.Printf("Connected to libp2p network with peerID %s listening on %v", p2phost.Host.ID().Pretty(), p2phost.Host.Addrs())
+	p2plog.Info("Connected to the libp2p network", "peerid", p2phost.Host.ID().Pretty(), "addrs", p2phost.Host.Addrs())
 
 	return p2phost
 }
@@ -84,23 +124,29 @@ func NewP2P(me user.Me, chatroomname string, bootstrapaddrs []string, listenaddr
 // The peer discovery is handled by a go-routine that will read from a channel
 // of peer address information until the peer channel closes
 func (p2p *P2P) AdvertiseConnect() {
-	ttl, err := p2p.Discovery.Advertise(p2p.Ctx, p2p.ChatroomName)
-	if err != nil {
-		log.Fatalf("Failed to Advertise Service! %v", err)
+	if p2p.Discovery == nil {
+		return
+	}
+	for _, name := range p2p.ChatroomNames {
+		ttl, err := p2p.Discovery.Advertise(p2p.Ctx, name)
+		if err != nil {
+			p2plog.Fatal("Failed to advertise the service", "room", name, "err", err)
+		}
+		p2plog.Info("Advertised the service", "room", name, "ttl", ttl)
 	}
-	log.Printf("Advertised the %s Service.", p2p.ChatroomName)
 	// Sleep to give time for the advertisment to propogate
 	time.Sleep(time.Second * 5)
-	log.Printf("Service Time-to-Live is %s", ttl)
 
-	peerchan, err := p2p.Discovery.FindPeers(p2p.Ctx, p2p.ChatroomName)
-	if err != nil {
-		log.Fatalf("P2P Peer Discovery Failed! %v", err)
-	}
-	log.Printf("Discovered Service Peers.")
+	for _, name := range p2p.ChatroomNames {
+		peerchan, err := p2p.Discovery.FindPeers(p2p.Ctx, name)
+		if err != nil {
+			p2plog.Fatal("Peer discovery failed", "room", name, "err", err)
+		}
+		p2plog.Info("Looking for service peers", "room", name)
 
-	go handlePeerDiscovery(p2p.Host, peerchan)
-	log.Printf("Started Peer Connection Handler.")
+		go handlePeerDiscovery(p2p.Host, peerchan)
+	}
+	p2plog.Debug("Started the peer connection handler")
 }
 
 // A method of P2P to connect to service peers.
@@ -109,80 +155,103 @@ func (p2p *P2P) AdvertiseConnect() {
 // The peer discovery is handled by a go-routine that will read from a channel
 // of peer address information until the peer channel closes
 func (p2p *P2P) AnnounceConnect() {
-	// Generate the Service CID
-	cidvalue := generateCID(p2p.ChatroomName)
-	log.Printf("Generated the Service CID.")
-
-	// Announce that this host can provide the service CID
-	err := p2p.KadDHT.Provide(p2p.Ctx, cidvalue, true)
-	if err != nil {
-		log.Fatalf("Failed to Announce Service CID! %v", err)
+	if p2p.KadDHT == nil {
+		return
+	}
+	cids := []cid.Cid{}
+	for _, name := range p2p.ChatroomNames {
+		// Generate the Service CID
+		cidvalue := generateCID(name)
+
+		// Announce that this host can provide the service CID
+		err := p2p.KadDHT.Provide(p2p.Ctx, cidvalue, true)
+		if err != nil && p2p.opts.hasDirectPeers() {
+			p2plog.Warn("Failed to announce the service CID, only finding the signers directly", "room", name, "err", err)
+			continue
+		} else if err != nil {
+			p2plog.Fatal("Failed to announce the service CID", "room", name, "err", err)
+		}
+		p2plog.Info("Announced the service", "room", name)
+		cids = append(cids, cidvalue)
 	}
-	log.Printf("Announced the %s Service.", p2p.ChatroomName)
 	// Sleep to give time for the advertisment to propogate
 	time.Sleep(time.Second * 5)
 
-	peerchan := p2p.KadDHT.FindProvidersAsync(p2p.Ctx, cidvalue, 0)
-	log.Printf("Discovered Service Peers.")
-
-	go handlePeerDiscovery(p2p.Host, peerchan)
-	log.Printf("Started Peer Connection Handler.")
+	for _, cidvalue := range cids {
+		peerchan := p2p.KadDHT.FindProvidersAsync(p2p.Ctx, cidvalue, 0)
+		go handlePeerDiscovery(p2p.Host, peerchan)
+	}
+	p2plog.Debug("Started the peer connection handler")
 }
 
 func bootstrapPeers(addrs []string) []peer.AddrInfo {
 	if len(addrs) == 0 {
 		return dht.GetDefaultBootstrapPeerAddrInfos()
 	}
+	return parsePeerAddrs(addrs)
+}
+
+// Parse /p2p/<id> multiaddrs, several addrs of the same peer are merged. Invalid addrs are skipped.
+func parsePeerAddrs(addrs []string) []peer.AddrInfo {
 	var mas []multiaddr.Multiaddr
 	for _, s := range addrs {
 		ma, err := multiaddr.NewMultiaddr(s)
-		if err != nil {
-			panic(err)
+		if err == nil {
+			_, err = peer.AddrInfoFromP2pAddr(ma)
 		}
-		mas = append(mas, ma)
-	}
-
-	ds := make([]peer.AddrInfo, 0, len(mas))
-	for i := range mas {
-		info, err := peer.AddrInfoFromP2pAddr(mas[i])
 		if err != nil {
-			log.Printf("failed to convert bootstrapper address %v to peer addr info %v", mas[i].String(), err)
+			p2plog.Warn("Could not convert the address to peer addr info", "addr", s, "err", err)
 			continue
 		}
-		ds = append(ds, *info)
+		mas = append(mas, ma)
 	}
 
+	ds, err := peer.AddrInfosFromP2pAddrs(mas...)
+	if err != nil {
+		// the addrs were all checked above
+		panic(err)
+	}
 	return ds
 }
 
-// Using a DHT is probably overkill for this application, where we will have few peers and they will not be online very long
-func setupHostAndDHT(ctx context.Context, bootstrapaddrs []string, listenaddrs []string, me user.Me) (host.Host, *dht.IpfsDHT) {
+// Using a DHT is probably overkill for this application, where we will have few peers and they will not be online very long.
+// The DHT is nil with NoDHT.
+func setupHostAndDHT(ctx context.Context, p2popts P2POptions, me user.Me) (host.Host, *dht.IpfsDHT) {
 	var err error
-	// If bootstrapaddrs is empty, then we use the default public libp2p bootstrap peers
-	bootstrappeers := bootstrapPeers(bootstrapaddrs)
-
-	// The inner function will set kaddht var when it runs during libp2p.New
-	var kaddht *dht.IpfsDHT
-	routing := libp2p.Routing(func(h host.Host) (routing.PeerRouting, error) {
-		kaddht, err = dht.New(
-			ctx,
-			h,
-			dht.Mode(dht.ModeAutoServer),
-			dht.BootstrapPeers(bootstrappeers...),
-		)
-		return kaddht, err
-	})
-
 	// libp2p defaults are /ip4/0.0.0.0/tcp/0, /ip6/::/tcp/0, enable relay, /yamux/1.0.0, /mplex/6.7.0, tls, noise, tcp, ws, empty peerstore
-	libhost, err := libp2p.New(
-		routing,
+	opts := []libp2p.Option{
 		libp2p.ConnectionManager(connmgr.NewConnManager(50, 100, time.Minute)),
 		libp2p.Identity(me.IdentPrivKey),
 		libp2p.NATPortMap(), // attempts to use UPNP to open a port
-		libp2p.EnableAutoRelay(),
-	)
+	}
+	if len(p2popts.ListenAddrs) > 0 {
+		opts = append(opts, libp2p.ListenAddrStrings(p2popts.ListenAddrs...))
+	}
+
+	// The inner function will set kaddht var when it runs during libp2p.New
+	var kaddht *dht.IpfsDHT
+	if !p2popts.NoDHT {
+		// If there are no bootstrap addrs, then we use the default public libp2p bootstrap peers
+		bootstrappeers := bootstrapPeers(p2popts.BootstrapAddrs)
+		routing := libp2p.Routing(func(h host.Host) (routing.PeerRouting, error) {
+			kaddht, err = dht.New(
+				ctx,
+				h,
+				dht.Mode(dht.ModeAutoServer),
+				dht.BootstrapPeers(bootstrappeers...),
+			)
+			return kaddht, err
+		})
+		opts = append(opts, routing, libp2p.EnableAutoRelay())
+
+		// Behind a NAT, reserve a slot on our own bootstrap servers when they run the relay service
+		if len(p2popts.BootstrapAddrs) > 0 {
+			opts = append(opts, libp2p.StaticRelays(bootstrappeers))
+		}
+	}
+	libhost, err := libp2p.New(opts...)
 	if err != nil {
-		log.Fatalf("Failed to Create the P2P Host! %v", err)
+		p2plog.Fatal("Failed to create the P2P host", "err", err)
 	}
 
 	return libhost, kaddht
@@ -190,6 +259,7 @@ func setupHostAndDHT(ctx context.Context, bootstrapaddrs []string, listenaddrs [
 
 // A function that generates a PubSub Handler object and returns it
 // Requires a node host and a routing discovery service.
+// Without the DHT there is no discovery service, and pubsub only uses the peers we are connected to.
 func setupPubSub(ctx context.Context, nodehost host.Host, routingdiscovery *discovery.RoutingDiscovery) *pubsub.PubSub {
 	// TODO investigate using custom protocol so we dont join the default public gossip net?
 	// https://github.com/libp2p/go-libp2p-pubsub/pull/413/files
@@ -205,25 +275,31 @@ func setupPubSub(ctx context.Context, nodehost host.Host, routingdiscovery *disc
 	// }
 	// pubsubhandler, err := pubsub.NewGossipSub(ctx, nodehost, [pubsub.WithDiscovery(routingdiscovery), pubsub.WithGossipSubProtocols(protos, features)])
 
-	pubsubhandler, err := pubsub.NewGossipSub(ctx, nodehost, pubsub.WithDiscovery(routingdiscovery))
+	opts := []pubsub.Option{}
+	if routingdiscovery != nil {
+		opts = append(opts, pubsub.WithDiscovery(routingdiscovery))
+	}
+	pubsubhandler, err := pubsub.NewGossipSub(ctx, nodehost, opts...)
 	if err != nil {
-		log.Fatalf("PubSub Handler Creation Failed! %v", err)
+		p2plog.Fatal("Failed to create the pubsub handler", "err", err)
 	}
 
 	return pubsubhandler
 }
 
 // A function that bootstraps a given Kademlia DHT to satisfy the IPFS router
-// interface and connects to all the bootstrap peers provided
-func bootstrapDHT(ctx context.Context, nodehost host.Host, kaddht *dht.IpfsDHT, bootstrapaddrs []string) {
+// interface and connects to all the bootstrap peers provided. Without any bootstrap peer we can only go
+// on when the signers can also be found directly.
+func bootstrapDHT(ctx context.Context, nodehost host.Host, kaddht *dht.IpfsDHT, opts P2POptions) {
+	bootstrapaddrs := opts.BootstrapAddrs
 	bootstrappeers := bootstrapPeers(bootstrapaddrs)
 
 	// Bootstrap the DHT to satisfy the IPFS Router interface
 	if err := kaddht.Bootstrap(ctx); err != nil {
-		log.Fatalf("Failed to Bootstrap the Kademlia! %v", err)
+		p2plog.Fatal("Failed to bootstrap the Kademlia DHT", "err", err)
 	}
 
-	log.Printf("Set the Kademlia DHT into Bootstrap Mode.")
+	p2plog.Debug("Set the Kademlia DHT into bootstrap mode")
 
 	var wg sync.WaitGroup
 
@@ -251,10 +327,12 @@ func bootstrapDHT(ctx context.Context, nodehost host.Host, kaddht *dht.IpfsDHT,
 
 	wg.Wait()
 
-	log.Printf("Connected to %d out of %d Bootstrap Peers.", connectedbootpeers, totalbootpeers)
-	if connectedbootpeers < 1 {
+	p2plog.Info("Connected to bootstrap peers", "connected", connectedbootpeers, "total", totalbootpeers)
+	if connectedbootpeers < 1 && opts.hasDirectPeers() {
+		p2plog.Warn("Failed to connect to any of the bootstrap peers, only finding the signers directly", "bootstrap", strings.Join(bootstrapaddrs, ", "))
+	} else if connectedbootpeers < 1 {
 		fmt.Printf("\n\nFailed to connect to any of the bootstrap peers!\n%v \nExiting.\n", bootstrapaddrs)
-		log.Fatalf("\n\nFailed to connect to any of the bootstrap peers!\n%v \nExiting.\n", bootstrapaddrs)
+		p2plog.Fatal("Failed to connect to any of the bootstrap peers", "bootstrap", strings.Join(bootstrapaddrs, ", "))
 	}
 }
 
@@ -268,11 +346,14 @@ func handlePeerDiscovery(nodehost host.Host, peerchan <-chan peer.AddrInfo) {
 			continue
 		}
 
-		log.Printf("handlePeerDiscovery %s", peer.String())
+		p2plog.Debug("Discovered a peer", "peer", peer.String())
 
 		// Connect to the peer
-		err := nodehost.Connect(context.Background(), peer)
-		log.Printf("Connect to peer: %v  %v", peer.String(), err)
+		if err := nodehost.Connect(context.Background(), peer); err != nil {
+			p2plog.Debug("Could not connect to the peer", "peer", peer.ID.Pretty(), "err", err)
+		} else {
+			p2plog.Info("Connected to a peer", "peer", peer.ID.Pretty())
+		}
 	}
 }
 
@@ -291,7 +372,7 @@ func generateCID(namestring string) cid.Cid {
 	// Generate a Multihash from the base58 string
 	mulhash, err := multihash.FromB58String(string(b58string))
 	if err != nil {
-		log.Fatalf("Failed to Generate Service CID! %v", err)
+		p2plog.Fatal("Failed to generate the service CID", "err", err)
 	}
 
 	// Generate a CID from the Multihash
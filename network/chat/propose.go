@@ -0,0 +1,447 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/shykerbogdan/mpc-wallet/addressbook"
+	"github.com/shykerbogdan/mpc-wallet/audit"
+	"github.com/shykerbogdan/mpc-wallet/proposal"
+	"github.com/shykerbogdan/mpc-wallet/user"
+	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet"
+	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet/fees"
+	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet/txpreview"
+	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet/units"
+)
+
+var (
+	ErrSignersUnavailable = errors.New("not all signers are online")
+	ErrBroadcastFailed    = errors.New("broadcasting transaction failed")
+	ErrSimulationReverted = errors.New("transaction reverts in simulation")
+	ErrInsufficientFunds  = errors.New("insufficient funds")
+)
+
+// Propose a new wallet to the other signers and run keygen once all of them approved it and are online
+func (cr *ChatRoom) ProposeKeygen(walletname string, threshold int, signers []user.User, ttl time.Duration) (*ethwallet.Wallet, error) {
+	if err := cr.checkNewWalletName(walletname); err != nil {
+		return nil, err
+	}
+	if err := user.CheckPartyIDs(signers); err != nil {
+		return nil, err
+	}
+	cmd := startkeygencmd{
+		Name:      walletname,
+		Threshold: threshold,
+	}
+	summary := fmt.Sprintf("generate %v-of-%v wallet %s with signers %s", threshold+1, len(signers), walletname, nicksOf(signers))
+
+	_, result, err := cr.propose(proposal.TypeKeygen, walletname, summary, len(signers), signers, cmd, ttl)
+	if err != nil {
+		return nil, err
+	}
+	r, err := cr.waitProposal(result)
+	return r.wallet, err
+}
+
+// Wallet names are unique across the projects of the config, a new wallet must not replace one of any room
+func (cr *ChatRoom) checkNewWalletName(name string) error {
+	if w := cr.cfg.FindWallet(name); w != nil {
+		return fmt.Errorf("wallet %s already exists in project %s", name, cr.cfg.WalletProject(w))
+	}
+	return nil
+}
+
+// Propose signing a text message to the other signers, returning the eth signature (r | s | v) once enough
+// of them approved it and were online to sign
+func (cr *ChatRoom) ProposeSign(walletname string, message string, signers []user.User, ttl time.Duration) ([]byte, error) {
+	ew := cr.findWallet(walletname)
+	if ew == nil {
+		return nil, fmt.Errorf("wallet %s not found", walletname)
+	}
+	if err := ew.CheckConfirmed(); err != nil {
+		return nil, err
+	}
+
+	cmd := startsigncmd{
+		Name:    walletname,
+		Message: message,
+	}
+	summary := fmt.Sprintf("sign a text message with wallet %s: %s", walletname, message)
+
+	_, result, err := cr.propose(proposal.TypeSign, walletname, summary, ew.Threshold+1, signers, cmd, ttl)
+	if err != nil {
+		return nil, err
+	}
+	r, err := cr.waitProposal(result)
+	return r.ethsig, err
+}
+
+// A transaction to propose
+type SendTxRequest struct {
+	Wallet string
+	Dest   addressbook.Destination
+	// In wei
+	Amount *big.Int
+	// Calldata of a contract call, empty for a plain transfer
+	Data []byte
+	Memo string
+	// Overrides the fee strategy of the wallet if set
+	Fees *fees.Strategy
+	// Propose the transaction even though it reverts in simulation
+	Force bool
+}
+
+func (req SendTxRequest) feeStrategy(ew *ethwallet.Wallet) fees.Strategy {
+	if req.Fees != nil {
+		return *req.Fees
+	}
+	return ew.Fees
+}
+
+// Simulate the transaction of a request on the current state of the chain
+func (cr *ChatRoom) PreviewSendTx(req SendTxRequest) (*txpreview.Preview, error) {
+	ew := cr.findWallet(req.Wallet)
+	if ew == nil {
+		return nil, fmt.Errorf("wallet %s not found", req.Wallet)
+	}
+	to := req.Dest.Address
+	tx, preview, err := ew.CreateSimulatedTransaction(&to, req.Amount, req.Data, req.feeStrategy(ew))
+	if err != nil {
+		return nil, err
+	}
+	if err := ew.CheckMaxFee(tx); err != nil {
+		preview.Warnings = append(preview.Warnings, err.Error())
+	}
+	return preview, nil
+}
+
+// Propose a transaction to the other signers, sign it once enough of them approved it and are online, and
+// broadcast it, returning the txid. Fails with ErrSimulationReverted if the transaction reverts on the current
+// state, unless the request is forced.
+func (cr *ChatRoom) ProposeSendTx(req SendTxRequest, signers []user.User, ttl time.Duration) (string, error) {
+	ew := cr.findWallet(req.Wallet)
+	if ew == nil {
+		return "", fmt.Errorf("wallet %s not found", req.Wallet)
+	}
+	if err := ew.CheckConfirmed(); err != nil {
+		return "", err
+	}
+
+	to := req.Dest.Address
+	strategy := req.feeStrategy(ew)
+	tx, preview, err := ew.CreateSimulatedTransaction(&to, req.Amount, req.Data, strategy)
+	if err != nil {
+		return "", fmt.Errorf("error creating transaction: %w", err)
+	}
+	if err := ew.CheckMaxFee(tx); err != nil {
+		return "", err
+	}
+	cr.Logs <- chatlog{level: logLevelInfo, msg: fmt.Sprintf("Transaction preview:\n%s", cr.formatPreview(preview))}
+	if preview.Reverted && !req.Force {
+		return "", fmt.Errorf("%w: %s", ErrSimulationReverted, preview.RevertReason)
+	}
+
+	cmd := startsendtxcmd{
+		Name:     req.Wallet,
+		Amount:   req.Amount.String(),
+		DestAddr: req.Dest.Address.Hex(),
+		DestENS:  req.Dest.ENS,
+		Memo:     req.Memo,
+		Nonce:    tx.Nonce,
+		GasPrice: tx.GasPrice.String(),
+		GasLimit: tx.GasLimit,
+		Fees:     strategy.String(),
+	}
+	if len(req.Data) > 0 {
+		cmd.Data = hexutil.Encode(req.Data)
+	}
+	summary := fmt.Sprintf("send %s from wallet %s to %s", units.FormatEther(req.Amount), req.Wallet, req.Dest)
+	if preview.Call != nil {
+		summary = fmt.Sprintf("%s calling %s %s", summary, preview.Call.Contract, preview.Call.Method)
+	} else if len(req.Data) > 0 {
+		summary = fmt.Sprintf("%s calling an unknown function", summary)
+	}
+	summary = fmt.Sprintf("%s, paying at most %s in fees (%s gwei, %s)", summary, units.FormatEther(fees.MaxFee(tx.GasPrice, tx.GasLimit)), units.Format(tx.GasPrice, units.Gwei), cmd.Fees)
+	if req.Memo != "" {
+		summary = fmt.Sprintf("%s with memo %s", summary, req.Memo)
+	}
+
+	p, result, err := cr.propose(proposal.TypeSendTx, req.Wallet, summary, ew.Threshold+1, signers, cmd, ttl)
+	if err == nil && preview.Reverted {
+		cr.record(audit.EventOverride, cr.cfg.Me.Nick, req.Wallet, "proposed although the transaction reverts in simulation", map[string]string{
+			"proposal": p.ID,
+			"reverts":  preview.RevertReason,
+		})
+	}
+	if err != nil {
+		return "", err
+	}
+	r, err := cr.waitProposal(result)
+	return r.txid, err
+}
+
+// Sign the transaction of a sendtx proposal with the other signers. The proposer also broadcasts it and
+// returns the txid.
+func (cr *ChatRoom) sendTx(cmd startsendtxcmd, signers []user.User, broadcast bool) (string, error) {
+	ew, tx, err := cr.sendTxTransaction(cmd)
+	if err != nil {
+		return "", fmt.Errorf("error creating transaction: %w", err)
+	}
+
+	// Rather fail now than after the signing rounds, when the node refuses the transaction
+	balance, err := ew.GetBalance()
+	if err != nil {
+		logger.Warn("Error checking the balance", "project", cr.project, "wallet", cmd.Name, "err", err)
+	} else if !ethwallet.CheckValueEnough(tx.Value, tx.GasPrice, tx.GasLimit, balance) {
+		return "", fmt.Errorf("%w: wallet %s has %s, the transaction needs up to %s", ErrInsufficientFunds, cmd.Name, units.FormatEther(balance), units.FormatEther(new(big.Int).Add(tx.Value, fees.MaxFee(tx.GasPrice, tx.GasLimit))))
+	}
+
+	ethsig, err := cr.sign(cmd.Name, tx.ToSignHash(ew.Config.NetworkID), signers)
+	if err != nil {
+		return "", err
+	}
+	if err := cr.cfg.RecordSend(common.HexToAddress(cmd.DestAddr)); err != nil {
+		logger.Error("Error recording the send in the address book", "project", cr.project, "to", cmd.DestAddr, "err", err)
+	}
+	if !broadcast {
+		return "", nil
+	}
+	tx.SetSignature(ethsig)
+
+	txID, err := ew.PublishTx(tx.ToRawTx())
+	if err != nil {
+		cr.record(audit.EventError, cr.cfg.Me.Nick, cmd.Name, fmt.Sprintf("broadcasting transaction failed: %v", err), map[string]string{
+			"to":     cmd.DestAddr,
+			"amount": cmd.Amount,
+		})
+		msg := fmt.Sprintf("[red]😱 Transaction Failed!")
+		cr.Logs <- chatlog{level: logLevelInfo, msg: msg}
+		cr.OutboundChat <- chatmessage{Type: messageTypeChatMessage, SenderName: cr.cfg.Me.Nick, UserMessage: msg}
+		return "", fmt.Errorf("%w: %v", ErrBroadcastFailed, err)
+	}
+
+	cr.record(audit.EventBroadcast, cr.cfg.Me.Nick, cmd.Name, "transaction broadcast", map[string]string{
+		"txid":   txID,
+		"to":     cmd.DestAddr,
+		"amount": cmd.Amount,
+	})
+	scannerURL := ew.ConstructEtherscanUrl(ew.Config.NetworkName, txID)
+	msg := fmt.Sprintf("[blue]🎉 Transaction Confirmed![-] %s", scannerURL)
+	cr.Logs <- chatlog{level: logLevelInfo, msg: msg}
+	cr.OutboundChat <- chatmessage{Type: messageTypeChatMessage, SenderName: cr.cfg.Me.Nick, UserMessage: msg}
+
+	return txID, nil
+}
+
+// Simulate the transaction of a sendtx proposal ourselves, rather than trusting the preview of the proposer
+func (cr *ChatRoom) previewSendTx(cmd startsendtxcmd) (*txpreview.Preview, error) {
+	ew, tx, err := cr.sendTxTransaction(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return ew.Simulate(tx), nil
+}
+
+// Gas prices of proposals over this many times our fast gas price are flagged to approvers
+const gasPriceWarnFactor = 2
+
+// Warnings about the fee a proposed transaction pays: above the maximum fee of our wallet, or a gas price way
+// above what we would pay
+func (cr *ChatRoom) feeWarnings(cmd startsendtxcmd) []string {
+	ew, tx, err := cr.sendTxTransaction(cmd)
+	if err != nil {
+		return nil
+	}
+	warnings := []string{}
+	if err := ew.CheckMaxFee(tx); err != nil {
+		warnings = append(warnings, err.Error())
+	}
+	fast, err := ew.PickGasPrice(fees.Strategy{Speed: fees.Fast})
+	if err != nil {
+		logger.Warn("Error getting the gas price", "project", cr.project, "wallet", cmd.Name, "err", err)
+	} else if tx.GasPrice.Cmp(new(big.Int).Mul(fast, big.NewInt(gasPriceWarnFactor))) > 0 {
+		warnings = append(warnings, fmt.Sprintf("the gas price of %s gwei is more than %d times the fast gas price of %s gwei", units.Format(tx.GasPrice, units.Gwei), gasPriceWarnFactor, units.Format(fast, units.Gwei)))
+	}
+	return warnings
+}
+
+// Describe a transaction preview, with accounts named by our wallets and address book
+func (cr *ChatRoom) formatPreview(preview *txpreview.Preview) string {
+	return preview.Format(func(addr common.Address) string {
+		for _, name := range cr.cfg.SortedWalletNames() {
+			if w := cr.cfg.FindWallet(name); w != nil && common.HexToAddress(w.Address) == addr {
+				return "wallet " + name
+			}
+		}
+		if e := cr.cfg.AddressBook.FindAddress(addr); e != nil && e.Label != "" {
+			return e.Label
+		}
+		return ""
+	})
+}
+
+// Resolve a destination typed by the user, an address book label, ENS name or address
+func (cr *ChatRoom) ResolveDestination(walletname string, input string) (addressbook.Destination, error) {
+	var resolve addressbook.Resolver
+	if ew := cr.findWallet(walletname); ew != nil {
+		resolve = ew.ResolveName
+	}
+	return cr.cfg.AddressBook.Resolve(input, resolve)
+}
+
+// Warnings for an approver about the destination of a sendtx proposal, checked against our own address book
+// and ENS lookup rather than trusting the proposer
+func (cr *ChatRoom) destinationWarnings(cmd startsendtxcmd) []string {
+	addr, err := addressbook.ParseAddress(cmd.DestAddr)
+	if err != nil {
+		return []string{fmt.Sprintf("invalid destination: %v", err)}
+	}
+
+	warnings := []string{}
+	if e := cr.cfg.AddressBook.FindAddress(addr); e == nil {
+		warnings = append(warnings, fmt.Sprintf("%s is a first-time destination, it is not in your address book", addr.Hex()))
+	}
+	if cmd.DestENS != "" {
+		ew := cr.findWallet(cmd.Name)
+		if ew == nil {
+			warnings = append(warnings, fmt.Sprintf("could not check ENS name %s without the wallet", cmd.DestENS))
+		} else if resolved, err := ew.ResolveName(cmd.DestENS); err != nil {
+			warnings = append(warnings, fmt.Sprintf("could not check ENS name %s: %v", cmd.DestENS, err))
+		} else if resolved != addr {
+			warnings = append(warnings, fmt.Sprintf("ENS name %s resolves to %s for you, not %s", cmd.DestENS, resolved.Hex(), addr.Hex()))
+		}
+	}
+	return warnings
+}
+
+// Propose moving a wallet to a new set of signers and threshold, keeping its address, which runs once all
+// new signers and dealers approved it and are online. Without dealers, we and the current signers that stay
+// on deal our shares. Returns nil if we are not one of the new signers.
+func (cr *ChatRoom) ProposeReshare(walletname string, threshold int, signers []user.User, dealers []user.User, ttl time.Duration) (*ethwallet.Wallet, error) {
+	ew := cr.findWallet(walletname)
+	if ew == nil {
+		return nil, fmt.Errorf("wallet %s not found", walletname)
+	}
+	if dealers == nil {
+		dealers = ReshareDealers(ew, signers)
+		if !cr.doSignersIncludeMe(dealers) {
+			dealers = append([]user.User{cr.cfg.Me.User}, dealers...)
+		}
+	}
+	if len(dealers) <= ew.Threshold {
+		return nil, fmt.Errorf("wallet threshold requires at least %v current signers to deal their shares", ew.Threshold+1)
+	}
+	if err := user.CheckPartyIDs(signers); err != nil {
+		return nil, err
+	}
+
+	cmd := startresharecmd{
+		Name:      walletname,
+		Address:   ew.Address,
+		Threshold: threshold,
+		Signers:   signers,
+		Dealers:   dealers,
+	}
+	summary := fmt.Sprintf("move wallet %s (%s) to a %v-of-%v wallet with signers %s, dealt by %s", walletname, ew.Address, threshold+1, len(signers), nicksOf(signers), nicksOf(dealers))
+	if !cr.doSignersIncludeMe(signers) {
+		summary += ", the proposer leaves"
+	}
+
+	// Everyone taking part has to approve
+	approvers := append([]user.User{}, signers...)
+	for _, d := range dealers {
+		if !containsNick(approvers, d.Nick) {
+			approvers = append(approvers, d)
+		}
+	}
+	if !cr.doSignersIncludeMe(approvers) {
+		approvers = append([]user.User{cr.cfg.Me.User}, approvers...)
+	}
+
+	_, result, err := cr.propose(proposal.TypeReshare, walletname, summary, len(approvers), approvers, cmd, ttl)
+	if err != nil {
+		return nil, err
+	}
+	r, err := cr.waitProposal(result)
+	return r.wallet, err
+}
+
+func containsNick(users []user.User, nick string) bool {
+	for _, u := range users {
+		if u.Nick == nick {
+			return true
+		}
+	}
+	return false
+}
+
+// The current signers of the wallet that are also new signers
+func ReshareDealers(w *ethwallet.Wallet, signers []user.User) []user.User {
+	current := make(map[string]bool)
+	for _, u := range append([]user.User{w.Me}, w.Others...) {
+		current[u.Nick] = true
+	}
+
+	dealers := []user.User{}
+	for _, u := range signers {
+		if current[u.Nick] {
+			dealers = append(dealers, u)
+		}
+	}
+	return dealers
+}
+
+// Wait until all the nicks have joined the room and return them as users, for proposing without the UI
+func (cr *ChatRoom) WaitForParticipants(nicks []string, timeout time.Duration) ([]user.User, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		online := make(map[string]user.User)
+		for _, p := range cr.ParticipantList() {
+			online[p.Nick] = p.User
+		}
+
+		users := []user.User{}
+		missing := []string{}
+		for _, nick := range nicks {
+			if u, ok := online[nick]; ok {
+				users = append(users, u)
+			} else {
+				missing = append(missing, nick)
+			}
+		}
+		if len(missing) == 0 {
+			return users, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%w, still waiting for %v", ErrSignersUnavailable, missing)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// Without a UI nobody reads the inbound channels of the room, so write them to the log instead
+func (cr *ChatRoom) DrainEvents() {
+	go func() {
+		for {
+			select {
+			case msg, ok := <-cr.InboundChat:
+				if !ok {
+					return
+				}
+				logger.Info(msg.UserMessage, "project", cr.project, "from", msg.SenderName)
+			case msg := <-cr.InboundProtocolStart:
+				logger.Info("Proposal is waiting for approval in the wallet UI", "project", cr.project, "proposal", msg.Proposal.ShortID(), "from", msg.SenderName, "summary", msg.Proposal.Summary)
+			case req := <-cr.InboundJoin:
+				logger.Info("A new signer asks to join with your invitation, confirm it in the wallet UI", "project", cr.project, "nick", req.User.Nick)
+			case cl := <-cr.Logs:
+				cl.write(cr.project)
+			case <-cr.psctx.Done():
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,550 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/shykerbogdan/mpc-wallet/audit"
+	"github.com/shykerbogdan/mpc-wallet/proposal"
+	"github.com/shykerbogdan/mpc-wallet/user"
+	"github.com/shykerbogdan/mpc-wallet/utils"
+	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet"
+	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet/txpreview"
+)
+
+var (
+	ErrProposalRejected = errors.New("proposal has been rejected")
+	ErrProposalExpired  = errors.New("proposal expired before enough approvers were online")
+)
+
+// How often pending proposals are checked for expiry, and started if enough approvers are online
+const proposalCheckInterval = time.Second * 5
+
+// The result of running one of our own proposals
+type proposalResult struct {
+	wallet *ethwallet.Wallet
+	ethsig []byte
+	txid   string
+	err    error
+}
+
+// Tell the approvers about the start of a proposal and who runs the protocol
+type startproposalcmd struct {
+	ID      string
+	Signers []user.User
+}
+
+// Create a proposal approved by us and broadcast it to the approvers. The returned channel receives the result
+// once the protocol has run, or the error if the proposal is rejected or expires.
+func (cr *ChatRoom) propose(t proposal.Type, wallet string, summary string, required int, approvers []user.User, cmd interface{}, ttl time.Duration) (*proposal.Proposal, <-chan proposalResult, error) {
+	if !cr.doSignersIncludeMe(approvers) {
+		approvers = append([]user.User{cr.cfg.Me.User}, approvers...)
+	}
+	p, err := proposal.New(t, wallet, summary, cr.cfg.Me, required, approvers, cmd, ttl)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := cr.proposals.Put(p); err != nil {
+		logger.Error("Error saving the proposal", "project", cr.project, "proposal", p.ShortID(), "err", err)
+	}
+
+	result := make(chan proposalResult, 1)
+	cr.waitersMutex.Lock()
+	cr.waiters[p.ID] = result
+	cr.waitersMutex.Unlock()
+
+	cr.recordProposal(cr.cfg.Me.Nick, p)
+	cr.Logs <- chatlog{level: logLevelInfo, msg: fmt.Sprintf("Proposal %s is waiting for %d of %s to approve: %s", p.ShortID(), p.Required, nicksOf(p.Approvers), p.Summary)}
+	cr.broadcastProposal(p)
+	cr.tryStart(p.ID)
+
+	return p, result, nil
+}
+
+// Wait for the result of one of our proposals
+func (cr *ChatRoom) waitProposal(result <-chan proposalResult) (proposalResult, error) {
+	select {
+	case r := <-result:
+		return r, r.err
+	case <-cr.psctx.Done():
+		return proposalResult{}, errors.New("chat room closed before the proposal completed")
+	}
+}
+
+// Approve or reject a pending proposal, by ID or unique ID prefix. A transaction is simulated again before we
+// approve it, and approving fails with ErrSimulationReverted if it reverts, unless forced.
+func (cr *ChatRoom) DecideProposal(idprefix string, approve bool, force bool) (*proposal.Proposal, error) {
+	p, err := cr.proposals.Find(idprefix)
+	if err != nil {
+		return nil, err
+	}
+	if p.Status != proposal.StatusPending {
+		return nil, fmt.Errorf("proposal %s is %s", p.ShortID(), p.Status)
+	}
+
+	if approve && p.Type == proposal.TypeKeygen {
+		if err := cr.checkNewWalletName(p.Wallet); err != nil {
+			return nil, err
+		}
+	}
+	if approve && p.Type == proposal.TypeSendTx {
+		cmd := startsendtxcmd{}
+		if err := p.UnmarshalCommand(&cmd); err != nil {
+			return nil, fmt.Errorf("invalid transaction: %w", err)
+		}
+		preview, err := cr.previewSendTx(cmd)
+		if err != nil {
+			return nil, err
+		}
+		if preview.Reverted && !force {
+			return nil, fmt.Errorf("%w: %s", ErrSimulationReverted, preview.RevertReason)
+		}
+		// Our maximum fee is a policy of ours, forcing does not override it
+		if err := cr.findWallet(cmd.Name).CheckMaxFee(preview.Tx); err != nil {
+			return nil, err
+		}
+		if preview.Reverted {
+			cr.record(audit.EventOverride, cr.cfg.Me.Nick, p.Wallet, "approved although the transaction reverts in simulation", map[string]string{
+				"proposal": p.ID,
+				"reverts":  preview.RevertReason,
+			})
+		}
+	}
+
+	p, err = cr.proposals.Update(p.ID, func(p *proposal.Proposal) error {
+		return p.Approve(cr.cfg.Me, approve)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if approve {
+		cr.record(audit.EventApproval, cr.cfg.Me.Nick, p.Wallet, p.Summary, map[string]string{"proposal": p.ID})
+	} else {
+		cr.record(audit.EventRejection, cr.cfg.Me.Nick, p.Wallet, p.Summary, map[string]string{"proposal": p.ID})
+	}
+	cr.broadcastProposal(p)
+
+	if p.IsRejected() {
+		cr.finishProposal(p.ID, proposal.StatusRejected, proposalResult{err: ErrProposalRejected})
+	}
+	return p, nil
+}
+
+// The proposals waiting for approvals or running, oldest first
+func (cr *ChatRoom) Proposals() []*proposal.Proposal {
+	return cr.proposals.Active()
+}
+
+func (cr *ChatRoom) broadcastProposal(p *proposal.Proposal) {
+	cr.OutboundChat <- chatmessage{Type: messageTypeProposal, SenderName: cr.cfg.Me.Nick, Proposal: p}
+}
+
+func (cr *ChatRoom) recordProposal(actor string, p *proposal.Proposal) {
+	cr.record(audit.EventProposal, actor, p.Wallet, p.Summary, map[string]string{
+		"proposal":  p.ID,
+		"type":      string(p.Type),
+		"required":  fmt.Sprint(p.Required),
+		"approvers": nicksOf(p.Approvers),
+		"expires":   p.Expires.Format(time.RFC3339),
+	})
+}
+
+// A proposal, or new approvals of one, from another approver
+func (cr *ChatRoom) receiveProposal(p *proposal.Proposal) {
+	if p == nil {
+		return
+	}
+	if _, ok := p.Approver(cr.cfg.Me.Nick); !ok {
+		return
+	}
+	if err := p.Verify(); err != nil {
+		cr.Logs <- chatlog{level: logLevelError, msg: fmt.Sprintf("Ignoring invalid proposal: %v", err)}
+		return
+	}
+
+	known := cr.proposals.Get(p.ID)
+	if known == nil {
+		if p.IsExpired(time.Now()) {
+			return
+		}
+		if err := cr.checkApprovers(p); err != nil {
+			cr.Logs <- chatlog{level: logLevelError, msg: fmt.Sprintf("Ignoring proposal %s from %s: %v", p.ShortID(), p.Proposer, err)}
+			return
+		}
+		p.Status = proposal.StatusPending
+		if err := cr.proposals.Put(p); err != nil {
+			logger.Error("Error saving the proposal", "project", cr.project, "proposal", p.ShortID(), "err", err)
+		}
+		cr.recordProposal(p.Proposer, p)
+
+		if _, decided := p.Decision(cr.cfg.Me.Nick); !decided {
+			go cr.notifyProposal(p)
+		}
+	} else {
+		added := []proposal.Approval{}
+		known, err := cr.proposals.Update(p.ID, func(known *proposal.Proposal) error {
+			var err error
+			added, err = known.Merge(p)
+			return err
+		})
+		if err != nil {
+			cr.Logs <- chatlog{level: logLevelError, msg: fmt.Sprintf("Ignoring approvals of proposal %s: %v", p.ShortID(), err)}
+			return
+		}
+		for _, a := range added {
+			if a.Approve {
+				cr.Logs <- chatlog{level: logLevelInfo, msg: fmt.Sprintf("%s approved proposal %s (%d of %d)", a.Nick, known.ShortID(), len(known.Approved()), known.Required)}
+			} else {
+				cr.Logs <- chatlog{level: logLevelInfo, msg: fmt.Sprintf("%s rejected proposal %s", a.Nick, known.ShortID())}
+			}
+		}
+		p = known
+	}
+
+	if p.Status == proposal.StatusPending && p.IsRejected() {
+		cr.finishProposal(p.ID, proposal.StatusRejected, proposalResult{err: ErrProposalRejected})
+		return
+	}
+	if p.Proposer == cr.cfg.Me.Nick {
+		cr.tryStart(p.ID)
+	}
+}
+
+// Check the approvers of a proposal are the signers we know by their nicks, since an approval is only
+// verified with the key the proposal lists for its approver, and that it needs the approvals its protocol
+// needs. The approvers of a sign or send transaction proposal are the signers of the wallet, those of a
+// keygen or reshare proposal may also be members of the project, or anyone while the project has none.
+func (cr *ChatRoom) checkApprovers(p *proposal.Proposal) error {
+	cmd := struct{ Name string }{}
+	if err := p.UnmarshalCommand(&cmd); err != nil || cmd.Name != p.Wallet {
+		return fmt.Errorf("command is not for wallet %s", p.Wallet)
+	}
+
+	members := cr.cfg.ProjectMembers(cr.project)
+	known := []user.User{}
+	switch p.Type {
+	case proposal.TypeKeygen:
+		known = members
+		if p.Required != len(p.Approvers) {
+			return fmt.Errorf("keygen needs all %d signers to approve, not %d", len(p.Approvers), p.Required)
+		}
+	case proposal.TypeSign, proposal.TypeSendTx, proposal.TypeReshare:
+		w := cr.findWallet(p.Wallet)
+		if w == nil {
+			return fmt.Errorf("wallet %s not found", p.Wallet)
+		}
+		known = append([]user.User{}, w.Others...)
+		if p.Type == proposal.TypeReshare {
+			known = append(known, members...)
+			if p.Required != len(p.Approvers) {
+				return fmt.Errorf("reshare needs all %d signers and dealers to approve, not %d", len(p.Approvers), p.Required)
+			}
+		} else if p.Required != w.Threshold+1 {
+			return fmt.Errorf("wallet %s needs %d signers, not %d", p.Wallet, w.Threshold+1, p.Required)
+		}
+	default:
+		return fmt.Errorf("unknown proposal type %s", p.Type)
+	}
+	anyone := len(members) == 0 && (p.Type == proposal.TypeKeygen || p.Type == proposal.TypeReshare)
+
+	for _, a := range p.Approvers {
+		if a.Nick == cr.cfg.Me.Nick {
+			if !sameIdentity(a, cr.cfg.Me.User) {
+				return fmt.Errorf("approver %s is not us", a.Nick)
+			}
+			continue
+		}
+		u, ok := findNick(known, a.Nick)
+		if !ok && !anyone {
+			return fmt.Errorf("approver %s is not a known signer", a.Nick)
+		}
+		if ok && !sameIdentity(a, u) {
+			return fmt.Errorf("approver %s does not have the identity key we know %s by", a.Nick, a.Nick)
+		}
+	}
+	return nil
+}
+
+// Whether two users have the same identity key
+func sameIdentity(a user.User, b user.User) bool {
+	return a.IdentPubKey != nil && b.IdentPubKey != nil && a.IdentPubKey.Equals(b.IdentPubKey)
+}
+
+func findNick(users []user.User, nick string) (user.User, bool) {
+	for _, u := range users {
+		if u.Nick == nick {
+			return u, true
+		}
+	}
+	return user.User{}, false
+}
+
+// Ask for the approval of a new proposal, with warnings about it and the simulation of its transaction,
+// which may take network lookups to find
+func (cr *ChatRoom) notifyProposal(p *proposal.Proposal) {
+	warnings := []string{}
+	var preview *txpreview.Preview
+	if p.Type == proposal.TypeKeygen {
+		if err := cr.checkNewWalletName(p.Wallet); err != nil {
+			warnings = append(warnings, fmt.Sprintf("%v, it can not be approved", err))
+		}
+	}
+	if p.Type == proposal.TypeSendTx {
+		cmd := startsendtxcmd{}
+		if err := p.UnmarshalCommand(&cmd); err != nil {
+			warnings = append(warnings, fmt.Sprintf("invalid transaction: %v", err))
+		} else {
+			warnings = cr.destinationWarnings(cmd)
+			warnings = append(warnings, cr.feeWarnings(cmd)...)
+			if preview, err = cr.previewSendTx(cmd); err != nil {
+				warnings = append(warnings, fmt.Sprintf("could not simulate the transaction: %v", err))
+			} else if preview.Reverted {
+				warnings = append(warnings, fmt.Sprintf("the transaction reverts in simulation: %s. Approving it needs /approve %s force", preview.RevertReason, p.ShortID()))
+			}
+		}
+	}
+
+	cr.Logs <- chatlog{level: logLevelInfo, msg: fmt.Sprintf("New proposal %s from %s: %s. Use /approve %s or /reject %s", p.ShortID(), p.Proposer, p.Summary, p.ShortID(), p.ShortID())}
+	if preview != nil {
+		cr.Logs <- chatlog{level: logLevelInfo, msg: fmt.Sprintf("Proposal %s preview:\n%s", p.ShortID(), cr.formatPreview(preview))}
+	}
+	for _, w := range warnings {
+		cr.Logs <- chatlog{level: logLevelWarn, msg: fmt.Sprintf("Proposal %s: %s", p.ShortID(), w)}
+	}
+	cr.InboundProtocolStart <- chatmessage{Type: messageTypeProposal, SenderName: p.Proposer, Proposal: p, Warnings: warnings, Preview: preview}
+}
+
+// Start one of our proposals once it has enough approvals from approvers that are online. The proposer and the
+// approvers it prefers to sign with run the protocol.
+func (cr *ChatRoom) tryStart(id string) {
+	cr.proposalMutex.Lock()
+	defer cr.proposalMutex.Unlock()
+
+	p := cr.proposals.Get(id)
+	if p == nil || p.Proposer != cr.cfg.Me.Nick || p.Status != proposal.StatusPending {
+		return
+	}
+	if p.IsRejected() {
+		cr.finishProposal(p.ID, proposal.StatusRejected, proposalResult{err: ErrProposalRejected})
+		return
+	}
+	if p.IsExpired(time.Now()) {
+		cr.finishProposal(p.ID, proposal.StatusExpired, proposalResult{err: ErrProposalExpired})
+		return
+	}
+
+	online := cr.onlinePeers()
+
+	approved := []user.User{}
+	for _, nick := range p.Approved() {
+		u, _ := p.Approver(nick)
+		if nick != cr.cfg.Me.Nick && online[u.PeerID()] {
+			approved = append(approved, u)
+		}
+	}
+	if w := cr.findWallet(p.Wallet); w != nil {
+		w.SortByPreference(approved)
+	} else {
+		sort.Slice(approved, func(i, j int) bool {
+			return approved[i].Nick < approved[j].Nick
+		})
+	}
+
+	signers := []user.User{cr.cfg.Me.User}
+	for _, u := range approved {
+		if len(signers) == p.Required {
+			break
+		}
+		signers = append(signers, u)
+	}
+	if len(signers) < p.Required {
+		return
+	}
+
+	p, err := cr.proposals.Update(p.ID, func(p *proposal.Proposal) error {
+		p.Status = proposal.StatusStarted
+		return nil
+	})
+	if err != nil {
+		logger.Error("Error saving the proposal", "project", cr.project, "proposal", id, "err", err)
+		return
+	}
+
+	cr.Logs <- chatlog{level: logLevelInfo, msg: fmt.Sprintf("Starting proposal %s with signers %s", p.ShortID(), nicksOf(signers))}
+	cr.OutboundChat <- chatmessage{
+		Type:          messageTypeStartProposal,
+		SenderName:    cr.cfg.Me.Nick,
+		Proposal:      p,
+		StartProposal: startproposalcmd{ID: p.ID, Signers: signers},
+	}
+
+	go cr.runProposal(p, signers)
+}
+
+// The proposer started a proposal, run the protocol if we approved it and are one of its signers
+func (cr *ChatRoom) receiveStartProposal(from peer.ID, p *proposal.Proposal, cmd startproposalcmd) {
+	known := cr.proposals.Get(cmd.ID)
+	if known == nil || known.Status != proposal.StatusPending {
+		return
+	}
+	proposer, _ := known.Approver(known.Proposer)
+	if proposer.PeerID() != from {
+		cr.Logs <- chatlog{level: logLevelError, msg: fmt.Sprintf("Ignoring start of proposal %s, not sent by its proposer %s", known.ShortID(), known.Proposer)}
+		return
+	}
+
+	// The proposer has all approvals, take the ones we missed
+	if p != nil && p.ID == known.ID {
+		if merged, err := cr.proposals.Update(known.ID, func(known *proposal.Proposal) error {
+			_, err := known.Merge(p)
+			return err
+		}); err == nil {
+			known = merged
+		}
+	}
+
+	approved := make(map[string]bool)
+	for _, nick := range known.Approved() {
+		approved[nick] = true
+	}
+	if len(cmd.Signers) != known.Required {
+		cr.Logs <- chatlog{level: logLevelError, msg: fmt.Sprintf("Ignoring start of proposal %s with %d signers instead of %d", known.ShortID(), len(cmd.Signers), known.Required)}
+		return
+	}
+	for _, s := range cmd.Signers {
+		if !approved[s.Nick] {
+			cr.Logs <- chatlog{level: logLevelError, msg: fmt.Sprintf("Ignoring start of proposal %s, %s did not approve it", known.ShortID(), s.Nick)}
+			return
+		}
+		// The signer has to be the approver, not someone else by its nick
+		if a, _ := known.Approver(s.Nick); !sameIdentity(a, s) {
+			cr.Logs <- chatlog{level: logLevelError, msg: fmt.Sprintf("Ignoring start of proposal %s, signer %s is not its approver", known.ShortID(), s.Nick)}
+			return
+		}
+	}
+
+	if !cr.doSignersIncludeMe(cmd.Signers) {
+		cr.Logs <- chatlog{level: logLevelInfo, msg: fmt.Sprintf("Proposal %s has been started by %s with signers %s", known.ShortID(), known.Proposer, nicksOf(cmd.Signers))}
+		cr.finishProposal(known.ID, proposal.StatusDone, proposalResult{})
+		return
+	}
+
+	known, err := cr.proposals.Update(known.ID, func(p *proposal.Proposal) error {
+		p.Status = proposal.StatusStarted
+		return nil
+	})
+	if err != nil {
+		logger.Error("Error saving the proposal", "project", cr.project, "proposal", cmd.ID, "err", err)
+		return
+	}
+
+	cr.Logs <- chatlog{level: logLevelInfo, msg: fmt.Sprintf("Running proposal %s with signers %s", known.ShortID(), nicksOf(cmd.Signers))}
+	go cr.runProposal(known, cmd.Signers)
+}
+
+// Run the protocol of a started proposal
+func (cr *ChatRoom) runProposal(p *proposal.Proposal, signers []user.User) {
+	result := proposalResult{}
+
+	switch p.Type {
+	case proposal.TypeKeygen:
+		cmd := startkeygencmd{}
+		if result.err = p.UnmarshalCommand(&cmd); result.err == nil {
+			result.wallet, result.err = cr.keygen(cmd.Name, cmd.Threshold, signers)
+		}
+	case proposal.TypeSign:
+		cmd := startsigncmd{}
+		if result.err = p.UnmarshalCommand(&cmd); result.err == nil {
+			result.ethsig, result.err = cr.sign(cmd.Name, utils.DigestAvaMsg(cmd.Message), signers)
+		}
+	case proposal.TypeSendTx:
+		cmd := startsendtxcmd{}
+		if result.err = p.UnmarshalCommand(&cmd); result.err == nil {
+			result.txid, result.err = cr.sendTx(cmd, signers, p.Proposer == cr.cfg.Me.Nick)
+		}
+	case proposal.TypeReshare:
+		cmd := startresharecmd{}
+		if result.err = p.UnmarshalCommand(&cmd); result.err == nil {
+			result.wallet, result.err = cr.reshare(cmd)
+		}
+	default:
+		result.err = fmt.Errorf("unknown proposal type %s", p.Type)
+	}
+
+	if result.err != nil {
+		cr.Logs <- chatlog{level: logLevelError, msg: fmt.Sprintf("Proposal %s failed: %v", p.ShortID(), result.err)}
+		cr.finishProposal(p.ID, proposal.StatusFailed, result)
+		return
+	}
+	cr.finishProposal(p.ID, proposal.StatusDone, result)
+}
+
+// Set the final status of a proposal and hand the result to the caller waiting for it, if any
+func (cr *ChatRoom) finishProposal(id string, status proposal.Status, result proposalResult) {
+	p, err := cr.proposals.Update(id, func(p *proposal.Proposal) error {
+		p.Status = status
+		return nil
+	})
+	if err != nil {
+		logger.Error("Error saving the proposal", "project", cr.project, "proposal", id, "err", err)
+	}
+	if p != nil && status == proposal.StatusRejected {
+		cr.Logs <- chatlog{level: logLevelInfo, msg: fmt.Sprintf("Proposal %s has been rejected: %s", p.ShortID(), p.Summary)}
+	} else if p != nil && status == proposal.StatusExpired {
+		cr.Logs <- chatlog{level: logLevelInfo, msg: fmt.Sprintf("Proposal %s has expired: %s", p.ShortID(), p.Summary)}
+	}
+
+	cr.waitersMutex.Lock()
+	waiter, ok := cr.waiters[id]
+	delete(cr.waiters, id)
+	cr.waitersMutex.Unlock()
+
+	if ok {
+		waiter <- result
+	}
+}
+
+// Send the pending proposals a participant that just joined is an approver of, so they get to see proposals
+// made while they were offline and we learn about their approvals
+func (cr *ChatRoom) resyncProposals(nick string) {
+	for _, p := range cr.proposals.Active() {
+		if _, ok := p.Approver(nick); ok && p.Status == proposal.StatusPending {
+			cr.broadcastProposal(p)
+		}
+	}
+}
+
+// Expire pending proposals, and start ours once enough approvers come online
+func (cr *ChatRoom) proposalLoop() {
+	// A protocol that was running when we stopped can not be resumed
+	for _, p := range cr.proposals.Active() {
+		if p.Status == proposal.StatusStarted {
+			cr.finishProposal(p.ID, proposal.StatusFailed, proposalResult{})
+		}
+	}
+
+	ticker := time.NewTicker(proposalCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cr.psctx.Done():
+			return
+		case <-ticker.C:
+			for _, p := range cr.proposals.Active() {
+				if p.Status != proposal.StatusPending {
+					continue
+				}
+				if p.Proposer == cr.cfg.Me.Nick {
+					cr.tryStart(p.ID)
+				} else if p.IsExpired(time.Now()) {
+					cr.finishProposal(p.ID, proposal.StatusExpired, proposalResult{err: ErrProposalExpired})
+				}
+			}
+		}
+	}
+}
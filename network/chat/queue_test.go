@@ -0,0 +1,145 @@
+package chat
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shykerbogdan/mpc-wallet/proposal"
+	"github.com/shykerbogdan/mpc-wallet/user"
+	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet"
+)
+
+func newTestMe(t *testing.T, nick string) user.Me {
+	me, err := user.NewMe(nick, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return me
+}
+
+// A proposal of alice to sign with wallet w of alice, bob and the signer of the room
+func newTestProposal(t *testing.T, cr *ChatRoom, required int) (*proposal.Proposal, user.Me, user.Me) {
+	alice, bob := newTestMe(t, "alice"), newTestMe(t, "bob")
+	w := &ethwallet.Wallet{Name: "w", Threshold: required - 1, Me: cr.cfg.Me.User, Others: []user.User{alice.User, bob.User}}
+	if err := cr.cfg.AddWallet(w); err != nil {
+		t.Fatal(err)
+	}
+	return signProposal(t, alice, required, alice.User, bob.User, cr.cfg.Me.User), alice, bob
+}
+
+func signProposal(t *testing.T, proposer user.Me, required int, approvers ...user.User) *proposal.Proposal {
+	p, err := proposal.New(proposal.TypeSign, "w", "sign hello", proposer, required, approvers, startsigncmd{Name: "w", Message: "hello"}, proposal.DefaultTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// A copy of p with the decision of me added
+func withDecision(t *testing.T, p *proposal.Proposal, me user.Me, approve bool) *proposal.Proposal {
+	c := *p
+	c.Approvals = append([]proposal.Approval{}, p.Approvals...)
+	if err := c.Approve(me, approve); err != nil {
+		t.Fatal(err)
+	}
+	return &c
+}
+
+func TestReceiveApprovals(t *testing.T) {
+	carol := newTestRoom(t, &hub{}, "carol")
+	p, _, bob := newTestProposal(t, carol, 3)
+	withBob := withDecision(t, p, bob, true)
+
+	// bob's approval arrives before the proposal itself, then both again
+	for _, received := range []*proposal.Proposal{withBob, p, withBob, p} {
+		carol.receiveProposal(received)
+	}
+
+	select {
+	case msg := <-carol.InboundProtocolStart:
+		if msg.Proposal.ID != p.ID {
+			t.Errorf("asked to approve %s", msg.Proposal.ShortID())
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("not asked to approve the proposal")
+	}
+	select {
+	case <-carol.InboundProtocolStart:
+		t.Error("asked to approve the proposal again")
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	known := carol.proposals.Get(p.ID)
+	if approvers := known.Approved(); len(approvers) != 2 || len(known.Approvals) != 2 {
+		t.Errorf("proposal approved by %v with %d decisions", approvers, len(known.Approvals))
+	}
+	if known.Status != proposal.StatusPending {
+		t.Errorf("proposal is %s", known.Status)
+	}
+
+	// a later decision of bob does not replace the first
+	carol.receiveProposal(withDecision(t, p, bob, false))
+	if known := carol.proposals.Get(p.ID); known.IsRejected() || len(known.Approvals) != 2 {
+		t.Error("bob's approval replaced")
+	}
+
+	// the approvals are saved with the proposal
+	reopened, err := proposal.Open(carol.cfg.ProjectProposalsFile("P"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved := reopened.Get(p.ID); saved == nil || len(saved.Approved()) != 2 {
+		t.Errorf("saved proposal %v", saved)
+	}
+}
+
+func TestReceiveRejection(t *testing.T) {
+	carol := newTestRoom(t, &hub{}, "carol")
+	p, _, bob := newTestProposal(t, carol, 3)
+	rejected := withDecision(t, p, bob, false)
+
+	carol.receiveProposal(p)
+	carol.receiveProposal(rejected)
+	carol.receiveProposal(rejected)
+	if known := carol.proposals.Get(p.ID); known.Status != proposal.StatusRejected {
+		t.Errorf("proposal is %s after bob rejected it", known.Status)
+	}
+	if _, err := carol.DecideProposal(p.ID, true, false); err == nil {
+		t.Error("rejected proposal approved")
+	}
+
+	// a rejected proposal received again, e.g. from another signer, stays rejected
+	carol.receiveProposal(p)
+	if known := carol.proposals.Get(p.ID); known.Status != proposal.StatusRejected || !known.IsRejected() {
+		t.Errorf("proposal is %s after receiving it again", known.Status)
+	}
+}
+
+func TestReceiveForgedApprovers(t *testing.T) {
+	carol := newTestRoom(t, &hub{}, "carol")
+	p, alice, bob := newTestProposal(t, carol, 3)
+	carol.receiveProposal(p)
+	<-carol.InboundProtocolStart
+
+	// another key in the name of carol approves for her, or in the name of bob
+	fakeCarol, fakeBob := newTestMe(t, "carol"), newTestMe(t, "bob")
+	forged := signProposal(t, alice, 3, alice.User, bob.User, fakeCarol.User)
+	forged = withDecision(t, forged, fakeCarol, true)
+	cases := map[string]*proposal.Proposal{
+		"approved in our name":     forged,
+		"approved in bob's name":   withDecision(t, signProposal(t, alice, 3, alice.User, fakeBob.User, carol.cfg.Me.User), fakeBob, true),
+		"fewer approvals required": signProposal(t, alice, 2, alice.User, bob.User, carol.cfg.Me.User),
+		"an unknown approver":      signProposal(t, alice, 3, alice.User, newTestMe(t, "mallory").User, carol.cfg.Me.User),
+	}
+	for name, p := range cases {
+		carol.receiveProposal(p)
+		if carol.proposals.Get(p.ID) != nil {
+			t.Errorf("proposal with %s stored", name)
+		}
+	}
+	select {
+	case msg := <-carol.InboundProtocolStart:
+		t.Errorf("asked to approve %s", msg.Proposal.Summary)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
@@ -0,0 +1,83 @@
+package chat
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/shykerbogdan/mpc-wallet/user"
+	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet"
+)
+
+// A co-signer of a wallet and whether they are in the room right now
+type CoSigner struct {
+	user.User
+	Online bool
+}
+
+// The peer IDs of the participants in the room
+func (cr *ChatRoom) onlinePeers() map[peer.ID]bool {
+	online := make(map[peer.ID]bool)
+	for _, p := range cr.ParticipantList() {
+		online[p.peerid] = true
+	}
+	return online
+}
+
+// The other signers of the wallet in order of preference. Only a participant with the peer ID of the
+// signer counts as online, not just anyone using their nick.
+func (cr *ChatRoom) CoSigners(w *ethwallet.Wallet) []CoSigner {
+	others := append([]user.User{}, w.Others...)
+	w.SortByPreference(others)
+
+	online := cr.onlinePeers()
+	cosigners := []CoSigner{}
+	for _, u := range others {
+		cosigners = append(cosigners, CoSigner{User: u, Online: online[u.PeerID()]})
+	}
+	return cosigners
+}
+
+// Pick the co-signers to sign with: enough for the wallet threshold together with us, online ones first and
+// each in order of preference. Returns how many of them are not online yet, 0 if we can start right away.
+func SelectCoSigners(w *ethwallet.Wallet, cosigners []CoSigner) (selected []CoSigner, missing int) {
+	for _, online := range []bool{true, false} {
+		for _, c := range cosigners {
+			if len(selected) == w.Threshold {
+				break
+			}
+			if c.Online == online {
+				selected = append(selected, c)
+			}
+		}
+	}
+	for _, c := range selected {
+		if !c.Online {
+			missing++
+		}
+	}
+	return selected, missing
+}
+
+// Describe whether the chosen co-signers make a quorum, and who is still missing
+func QuorumStatus(w *ethwallet.Wallet, chosen []CoSigner) string {
+	needed := w.Threshold
+	online := []string{}
+	offline := []string{}
+	for _, c := range chosen {
+		if c.Online {
+			online = append(online, c.Nick)
+		} else {
+			offline = append(offline, c.Nick)
+		}
+	}
+
+	switch {
+	case len(chosen) < needed:
+		return fmt.Sprintf("[red]Choose %d more signer(s), the wallet needs %d of %d[-]", needed-len(chosen), w.Threshold+1, len(w.Others)+1)
+	case len(online) >= needed:
+		return fmt.Sprintf("[green]Ready to sign with %s[-]", strings.Join(online, ", "))
+	default:
+		return fmt.Sprintf("[yellow]Waiting for %d of %s to come online, signing starts once they approve[-]", needed-len(online), strings.Join(offline, ", "))
+	}
+}
@@ -0,0 +1,106 @@
+package chat
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shykerbogdan/mpc-wallet/user"
+	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet"
+)
+
+// A wallet of ours needing 3 of 5 signers, preferring dave and then bob as co-signers
+func newTestWallet(t *testing.T) *ethwallet.Wallet {
+	others := []user.User{}
+	for _, nick := range []string{"bob", "carol", "dave", "erin"} {
+		me, err := user.NewMe(nick, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		others = append(others, me.User)
+	}
+	return &ethwallet.Wallet{Threshold: 2, Others: others, PreferredSigners: []string{"dave", "bob"}}
+}
+
+func cosignersOnline(w *ethwallet.Wallet, online ...string) []CoSigner {
+	isOnline := make(map[string]bool)
+	for _, nick := range online {
+		isOnline[nick] = true
+	}
+	others := append([]user.User{}, w.Others...)
+	w.SortByPreference(others)
+	cosigners := []CoSigner{}
+	for _, u := range others {
+		cosigners = append(cosigners, CoSigner{User: u, Online: isOnline[u.Nick]})
+	}
+	return cosigners
+}
+
+func nicksOfCoSigners(cosigners []CoSigner) string {
+	nicks := []string{}
+	for _, c := range cosigners {
+		nicks = append(nicks, c.Nick)
+	}
+	return strings.Join(nicks, ", ")
+}
+
+func TestSelectCoSigners(t *testing.T) {
+	w := newTestWallet(t)
+	cases := []struct {
+		online   []string
+		selected string
+		missing  int
+		status   string
+	}{
+		{[]string{"bob", "carol", "dave", "erin"}, "dave, bob", 0, "Ready to sign with dave, bob"},
+		{[]string{"carol", "erin"}, "carol, erin", 0, "Ready to sign with carol, erin"},
+		{[]string{"bob", "erin"}, "bob, erin", 0, "Ready to sign with bob, erin"},
+		{[]string{"erin"}, "erin, dave", 1, "Waiting for 1 of dave to come online"},
+		{nil, "dave, bob", 2, "Waiting for 2 of dave, bob to come online"},
+	}
+	for _, c := range cases {
+		selected, missing := SelectCoSigners(w, cosignersOnline(w, c.online...))
+		if got := nicksOfCoSigners(selected); got != c.selected || missing != c.missing {
+			t.Errorf("online %v: selected %s missing %d, want %s missing %d", c.online, got, missing, c.selected, c.missing)
+		}
+		if status := QuorumStatus(w, selected); !strings.Contains(status, c.status) {
+			t.Errorf("online %v: status %q, want %q", c.online, status, c.status)
+		}
+	}
+
+	chosen := cosignersOnline(w, "bob")[:1]
+	if status := QuorumStatus(w, chosen); !strings.Contains(status, "Choose 1 more signer(s), the wallet needs 3 of 5") {
+		t.Errorf("status of too few signers %q", status)
+	}
+}
+
+func TestCoSignersOnline(t *testing.T) {
+	cr := newTestRoom(t, &hub{}, "alice")
+	w := newTestWallet(t)
+
+	// bob is in the room, and someone else calling themselves dave
+	bob := w.Others[0]
+	impostor, err := user.NewMe("dave", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cr.mutex.Lock()
+	for _, u := range []user.User{bob, impostor.User} {
+		cr.participants[u.PeerID()] = &participant{User: u, peerid: u.PeerID(), ttl: participantTTL, addedAt: time.Now()}
+	}
+	cr.mutex.Unlock()
+
+	cosigners := cr.CoSigners(w)
+	if got := nicksOfCoSigners(cosigners); got != "dave, bob, carol, erin" {
+		t.Errorf("co-signers in order %s", got)
+	}
+	for _, c := range cosigners {
+		if c.Online != (c.Nick == "bob") {
+			t.Errorf("%s online %v", c.Nick, c.Online)
+		}
+	}
+	selected, missing := SelectCoSigners(w, cosigners)
+	if got := nicksOfCoSigners(selected); got != "bob, dave" || missing != 1 {
+		t.Errorf("selected %s with %d missing", got, missing)
+	}
+}
@@ -0,0 +1,133 @@
+package chat
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+)
+
+// A Transport carries the messages published in a ChatRoom to the other participants.
+// "chatnet" rooms use libp2p pubsub, "relay" rooms use a relay server (see network/relay).
+type Transport interface {
+	// The peer id the other participants see our messages coming from
+	ID() peer.ID
+	// Send data to all other participants
+	Publish(ctx context.Context, data []byte) error
+	// Block until the next message from another participant arrives
+	Next(ctx context.Context) (peer.ID, []byte, error)
+	// How many other peers the messages currently reach
+	Peers() int
+	Close()
+}
+
+// A Transport over a libp2p pubsub topic
+type pubsubTransport struct {
+	id    peer.ID
+	topic *pubsub.Topic
+	sub   *pubsub.Subscription
+}
+
+func newPubSubTransport(p2phost *P2P, topicname string) (*pubsubTransport, error) {
+	// Create a PubSub topic with the Project name
+	topic, err := p2phost.PubSub.Join(topicname)
+	if err != nil {
+		return nil, err
+	}
+
+	// Subscribe to the PubSub topic
+	sub, err := topic.Subscribe()
+	if err != nil {
+		return nil, err
+	}
+
+	return &pubsubTransport{id: p2phost.Host.ID(), topic: topic, sub: sub}, nil
+}
+
+func (t *pubsubTransport) ID() peer.ID {
+	return t.id
+}
+
+func (t *pubsubTransport) Publish(ctx context.Context, data []byte) error {
+	return t.topic.Publish(ctx, data)
+}
+
+func (t *pubsubTransport) Next(ctx context.Context) (peer.ID, []byte, error) {
+	for {
+		message, err := t.sub.Next(ctx)
+		if err != nil {
+			return "", nil, err
+		}
+
+		// ReceivedFrom is the peer that forwarded the message to us, GetFrom is its signed author
+		from := message.GetFrom()
+
+		// if message is from self then do nothing
+		if from == t.id {
+			continue
+		}
+
+		return from, message.Data, nil
+	}
+}
+
+func (t *pubsubTransport) Peers() int {
+	return len(t.topic.ListPeers())
+}
+
+func (t *pubsubTransport) Close() {
+	t.sub.Cancel()
+	t.topic.Close()
+}
+
+// A Transport sealing the messages of another one with the pre-shared key of the room, so only those
+// holding the key can read them or post to the room. Messages that do not open are dropped.
+type roomKeyTransport struct {
+	Transport
+	aead cipher.AEAD
+}
+
+func newRoomKeyTransport(t Transport, roomKey []byte) (*roomKeyTransport, error) {
+	key := sha256.Sum256(append([]byte("thresher-room-key:"), roomKey...))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &roomKeyTransport{Transport: t, aead: aead}, nil
+}
+
+func (t *roomKeyTransport) Publish(ctx context.Context, data []byte) error {
+	nonce := make([]byte, t.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	return t.Transport.Publish(ctx, t.aead.Seal(nonce, nonce, data, nil))
+}
+
+func (t *roomKeyTransport) Next(ctx context.Context) (peer.ID, []byte, error) {
+	for {
+		from, sealed, err := t.Transport.Next(ctx)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(sealed) < t.aead.NonceSize() {
+			logger.Warn("Dropped a message without the room key", "from", from.Pretty())
+			continue
+		}
+		data, err := t.aead.Open(nil, sealed[:t.aead.NonceSize()], sealed[t.aead.NonceSize():], nil)
+		if err != nil {
+			logger.Warn("Dropped a message without the room key", "from", from.Pretty())
+			continue
+		}
+		return from, data, nil
+	}
+}
@@ -2,26 +2,35 @@ package chat
 
 import (
 	"fmt"
-	"log"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
-	"github.com/shykerbogdan/mpc-wallet/network"
+	"github.com/shykerbogdan/mpc-wallet/proposal"
+	"github.com/shykerbogdan/mpc-wallet/protocols"
 	"github.com/shykerbogdan/mpc-wallet/user"
-	"github.com/shykerbogdan/mpc-wallet/utils"
 	"github.com/shykerbogdan/mpc-wallet/version"
+	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet"
+	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet/units"
 )
 
 var inputWidth = 70
 
-// A structure that represents the ChatRoom UI
+// A structure that represents the ChatRoom UI. It shows one of the project rooms at a time, the chat input
+// goes to the room shown and the forms propose in it.
 type UI struct {
+	// The room shown
 	*ChatRoom
 	*TermApp
 
-	net network.Network
+	rooms []*ChatRoom
+	// Messages in the rooms not shown since they were last shown
+	unread      map[string]int
+	unreadMutex sync.Mutex
+	switchRoom  chan string
 
 	MsgInputs chan string
 	CmdInputs chan UICommand
@@ -31,9 +40,12 @@ type UI struct {
 type TermApp struct {
 	TerminalApp    *tview.Application
 	pages          *tview.Pages
+	roomBox        *tview.TextView
 	participantBox *tview.TextView
 	keyBox         *tview.TextView
-	messageBox     *tview.TextView
+	proposalBox    *tview.TextView
+	messagePages   *tview.Pages
+	messageBoxes   map[string]*tview.TextView
 	helpBox        *tview.TextView
 	inputBox       *tview.InputField
 }
@@ -44,8 +56,8 @@ type UICommand struct {
 	cmdargs []string
 }
 
-// Create a new tview application
-func NewTerminalApp(blockchain string, roomname string, nick string, cmdchan chan UICommand, msgchan chan string) *TermApp {
+// Create a new tview application with a message box for each room
+func NewTerminalApp(blockchain string, roomnames []string, nick string, cmdchan chan UICommand, msgchan chan string) *TermApp {
 	app := tview.NewApplication()
 	app.SetBeforeDrawFunc(func(screen tcell.Screen) bool {
 		screen.Clear()
@@ -62,26 +74,39 @@ func NewTerminalApp(blockchain string, roomname string, nick string, cmdchan cha
 		SetTextColor(tcell.ColorWhite).
 		SetTextAlign(tview.AlignRight)
 
+	roombox := tview.NewTextView().
+		SetDynamicColors(true).
+		SetTextAlign(tview.AlignCenter)
+
 	titlebox := tview.NewFlex().SetDirection(tview.FlexColumn).
 		AddItem(titleleftbox, 0, 1, false).
+		AddItem(roombox, 0, 2, false).
 		AddItem(titlerightbox, 0, 1, false)
 
 	titlebox.
 		SetBorder(true).
 		SetBorderColor(tcell.ColorGreen)
 
-	messagebox := tview.NewTextView().
-		SetDynamicColors(true).
-		SetChangedFunc(func() {
-			app.Draw()
-		})
-
-	messagebox.
-		SetBorder(true).
-		SetBorderColor(tcell.ColorGreen).
-		SetTitle(fmt.Sprintf("ChatRoom-%s", roomname)).
-		SetTitleAlign(tview.AlignLeft).
-		SetTitleColor(tcell.ColorWhite)
+	messagepages := tview.NewPages()
+	messageboxes := make(map[string]*tview.TextView)
+	for _, roomname := range roomnames {
+		messagebox := tview.NewTextView().
+			SetDynamicColors(true).
+			SetChangedFunc(func() {
+				app.Draw()
+			})
+
+		messagebox.
+			SetBorder(true).
+			SetBorderColor(tcell.ColorGreen).
+			SetTitle(fmt.Sprintf("ChatRoom-%s", roomname)).
+			SetTitleAlign(tview.AlignLeft).
+			SetTitleColor(tcell.ColorWhite)
+
+		messageboxes[roomname] = messagebox
+		messagepages.AddPage(roomname, messagebox, true, false)
+	}
+	messagepages.SwitchToPage(roomnames[0])
 
 	participantbox := tview.NewTextView().SetDynamicColors(true)
 	participantbox.
@@ -99,8 +124,19 @@ func NewTerminalApp(blockchain string, roomname string, nick string, cmdchan cha
 		SetTitleAlign(tview.AlignLeft).
 		SetTitleColor(tcell.ColorWhite)
 
+	proposalbox := tview.NewTextView().SetDynamicColors(true)
+	proposalbox.
+		SetBorder(true).
+		SetBorderColor(tcell.ColorYellow).
+		SetTitle("Pending Proposals").
+		SetTitleAlign(tview.AlignLeft).
+		SetTitleColor(tcell.ColorWhite)
+
 	helpbox := tview.NewTextView().SetDynamicColors(true)
-	fmt.Fprintf(helpbox, "  [grey]Available Commands:[-] [yellow]F2[-] [grey]Generate new mpc wallet[-]  [yellow]F3[-]  [grey]Send Transaction[-]")
+	fmt.Fprintf(helpbox, "  [grey]Available Commands:[-] [yellow]F2[-] [grey]Generate new mpc wallet[-]  [yellow]F3[-]  [grey]Send Transaction[-]  [yellow]/approve <id> [force][-]  [yellow]/reject <id>[-]")
+	if len(roomnames) > 1 {
+		fmt.Fprintf(helpbox, "  [yellow]F5[-] [grey]Next room[-]  [yellow]/room <project>[-]")
+	}
 
 	input := tview.NewInputField().
 		SetLabel(nick + " > ").
@@ -154,11 +190,12 @@ func NewTerminalApp(blockchain string, roomname string, nick string, cmdchan cha
 
 	topflex := tview.NewFlex().SetDirection(tview.FlexColumn).
 		AddItem(keybox, 0, 1, false).
+		AddItem(proposalbox, 0, 1, false).
 		AddItem(participantbox, 0, 1, false)
 
 	middleflex := tview.NewFlex().SetDirection(tview.FlexRow).
 		AddItem(topflex, 0, 1, false).
-		AddItem(messagebox, 0, 1, false)
+		AddItem(messagepages, 0, 1, false)
 
 	// Create a flexbox to fit all the widgets
 	flex := tview.NewFlex().SetDirection(tview.FlexRow).
@@ -175,29 +212,39 @@ func NewTerminalApp(blockchain string, roomname string, nick string, cmdchan cha
 	return &TermApp{
 		TerminalApp:    app,
 		pages:          pages,
+		roomBox:        roombox,
 		participantBox: participantbox,
 		keyBox:         keybox,
-		messageBox:     messagebox,
+		proposalBox:    proposalbox,
+		messagePages:   messagepages,
+		messageBoxes:   messageboxes,
 		helpBox:        helpbox,
 		inputBox:       input,
 	}
 }
 
-// Create a new Chatroom UI
-func NewUI(cr *ChatRoom, net network.Network) *UI {
+// Create a new Chatroom UI for the rooms of our projects, showing the first one
+func NewUI(rooms []*ChatRoom) *UI {
 	// Initialize the command and message input channels
 	cmdchan := make(chan UICommand)
 	msgchan := make(chan string)
 
+	cr := rooms[0]
+	roomnames := []string{}
+	for _, room := range rooms {
+		roomnames = append(roomnames, room.Project())
+	}
 	blockchain := fmt.Sprintf("%s [%s]", cr.cfg.Blockchain, cr.cfg.Network)
-	app := NewTerminalApp(blockchain, cr.cfg.Project, cr.cfg.Me.Nick, cmdchan, msgchan)
+	app := NewTerminalApp(blockchain, roomnames, cr.cfg.Me.Nick, cmdchan, msgchan)
 
 	ui := &UI{
-		ChatRoom:  cr,
-		TermApp:   app,
-		net:       net,
-		MsgInputs: msgchan,
-		CmdInputs: cmdchan,
+		ChatRoom:   cr,
+		TermApp:    app,
+		rooms:      rooms,
+		unread:     make(map[string]int),
+		switchRoom: make(chan string),
+		MsgInputs:  msgchan,
+		CmdInputs:  cmdchan,
 	}
 
 	return ui
@@ -206,17 +253,79 @@ func NewUI(cr *ChatRoom, net network.Network) *UI {
 func (ui *UI) Run() error {
 	ui.TerminalApp.SetInputCapture(ui.globalKeyboardIntercept)
 	ui.fetchWalletBalances()
+	for _, cr := range ui.rooms {
+		go ui.roomEventHandler(cr)
+	}
 	go ui.startEventHandler()
 	defer ui.Close()
 	return ui.TerminalApp.Run()
 }
 
 func (ui *UI) Close() {
-	ui.pscancel()
+	for _, cr := range ui.rooms {
+		cr.pscancel()
+	}
+}
+
+// The room of a project, nil if we do not take part in it
+func (ui *UI) findRoom(project string) *ChatRoom {
+	for _, cr := range ui.rooms {
+		if cr.Project() == project {
+			return cr
+		}
+	}
+	return nil
+}
+
+// The room after the one shown, wrapping around
+func (ui *UI) nextRoom() string {
+	for i, cr := range ui.rooms {
+		if cr == ui.ChatRoom {
+			return ui.rooms[(i+1)%len(ui.rooms)].Project()
+		}
+	}
+	return ui.rooms[0].Project()
+}
+
+// Show the room of a project, called by the event handler only so the room does not change under it
+func (ui *UI) showRoom(project string) {
+	cr := ui.findRoom(project)
+	if cr == nil {
+		ui.Logs <- chatlog{level: logLevelError, msg: fmt.Sprintf("Not taking part in project %s, rooms are %s", project, strings.Join(ui.cfg.ProjectNames(), ", "))}
+		return
+	}
+	ui.ChatRoom = cr
+	ui.unreadMutex.Lock()
+	delete(ui.unread, project)
+	ui.unreadMutex.Unlock()
+	ui.messagePages.SwitchToPage(project)
+	ui.syncRooms()
+}
+
+// The message box of a room
+func (ui *UI) messageBox(cr *ChatRoom) *tview.TextView {
+	return ui.messageBoxes[cr.Project()]
+}
+
+// Count a new message in a room that is not shown
+func (ui *UI) markUnread(cr *ChatRoom) {
+	if cr == ui.ChatRoom {
+		return
+	}
+	ui.unreadMutex.Lock()
+	ui.unread[cr.Project()]++
+	ui.unreadMutex.Unlock()
+}
+
+// Publish a chat message in a room and show it as ours
+func (ui *UI) say(cr *ChatRoom, msg string) {
+	cr.OutboundChat <- chatmessage{Type: messageTypeChatMessage, SenderName: ui.cfg.Me.Nick, UserMessage: msg}
+	ui.displaySelfMessage(cr, msg)
 }
 
 func (ui *UI) generateKeyForm() {
-	participants := ui.ParticipantList()
+	cr := ui.ChatRoom
+	participants := cr.ParticipantList()
 
 	if len(participants) == 0 {
 		ui.message("No participants are online and available", "OK", "main", nil)
@@ -264,7 +373,7 @@ func (ui *UI) generateKeyForm() {
 			return
 		}
 
-		go ui.generateKey(name, threshold, signers)
+		go ui.generateKey(cr, name, threshold, signers)
 		ui.pages.RemovePage("form").ShowPage("main")
 	})
 
@@ -275,26 +384,18 @@ func (ui *UI) generateKeyForm() {
 	ui.pages.AddAndSwitchToPage("form", ui.modal(form, 80, 29), true).ShowPage("main")
 }
 
-func (ui *UI) generateKey(keyname string, threshold int, signers []user.User) {
+func (ui *UI) generateKey(cr *ChatRoom, keyname string, threshold int, signers []user.User) {
 	othernicks := []string{}
 	for _, s := range signers {
 		if s.Nick != ui.cfg.Me.Nick {
 			othernicks = append(othernicks, s.Nick)
 		}
 	}
-	ui.MsgInputs <- fmt.Sprintf("%s is proposing %v-of-%v wallet with other signers %s", ui.cfg.Me.Nick, threshold+1, len(signers), strings.Join(othernicks, ","))
+	ui.say(cr, fmt.Sprintf("%s is proposing %v-of-%v wallet with other signers %s", ui.cfg.Me.Nick, threshold+1, len(signers), strings.Join(othernicks, ",")))
 
-	ui.OutboundChat <- chatmessage{
-		Type:       messageTypeStartKeygen,
-		SenderName: ui.cfg.Me.Nick,
-		StartKeygen: startkeygencmd{
-			Name:      keyname,
-			Threshold: threshold,
-			Signers:   signers,
-		},
+	if _, err := cr.ProposeKeygen(keyname, threshold, signers, proposal.DefaultTTL); err != nil {
+		cr.Logs <- chatlog{level: logLevelError, msg: fmt.Sprintf("Error generating wallet %v", err)}
 	}
-
-	ui.runProtocolKeygen(keyname, threshold, signers)
 }
 
 // func (ui *UI) signMsgForm() {
@@ -383,17 +484,17 @@ func (ui *UI) generateKey(keyname string, threshold int, signers []user.User) {
 
 // Show the Send a TX form UI
 func (ui *UI) sendTxForm() {
-	participants := ui.ParticipantList()
-
-	if len(participants) == 0 {
-		ui.message("No participants are online and available", "OK", "main", nil)
+	cr := ui.ChatRoom
+	walletnames := cr.walletNames()
+	if len(walletnames) == 0 {
+		ui.message("There are no wallets yet, generate one first", "OK", "main", nil)
 		return
 	}
 
+	status := tview.NewTextView().SetDynamicColors(true)
+	status.SetBorderPadding(0, 0, 1, 1)
+
 	form := tview.NewForm()
-	form.SetBorder(true)
-	form.SetTitle("Sign and Send Transaction")
-	form.SetTitleAlign(tview.AlignLeft)
 	form.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
 		switch event.Key() {
 		case tcell.KeyEsc:
@@ -402,70 +503,166 @@ func (ui *UI) sendTxForm() {
 		return event
 	})
 
-	form.AddInputField("Wallet Name", "", inputWidth, nil, nil)
+	layout := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(form, 0, 1, true).
+		AddItem(status, 2, 0, false)
+	layout.SetBorder(true)
+	layout.SetTitle("Sign and Send Transaction")
+	layout.SetTitleAlign(tview.AlignLeft)
+
+	// The co-signers of the selected wallet, one checkbox each after the fixed form items
+	const fixedItems = 6
+	var w *ethwallet.Wallet
+	var cosigners []CoSigner
+	var checkboxes []*tview.Checkbox
+
+	chosen := func() []CoSigner {
+		list := []CoSigner{}
+		for i, cb := range checkboxes {
+			if cb.IsChecked() {
+				list = append(list, cosigners[i])
+			}
+		}
+		return list
+	}
+	updateStatus := func() {
+		status.SetText(QuorumStatus(w, chosen()))
+	}
+
+	form.AddDropDown("Wallet", walletnames, -1, func(walletname string, index int) {
+		w = cr.findWallet(walletname)
+		if w == nil {
+			return
+		}
+		for form.GetFormItemCount() > fixedItems {
+			form.RemoveFormItem(fixedItems)
+		}
+
+		cosigners = cr.CoSigners(w)
+		selected, _ := SelectCoSigners(w, cosigners)
+		isSelected := make(map[string]bool)
+		for _, c := range selected {
+			isSelected[c.Nick] = true
+		}
+
+		checkboxes = []*tview.Checkbox{}
+		for _, c := range cosigners {
+			label := fmt.Sprintf("%s [grey](offline)[-]", c.Nick)
+			if c.Online {
+				label = fmt.Sprintf("%s [green](online)[-]", c.Nick)
+			}
+			cb := tview.NewCheckbox().SetLabel(label).SetChecked(isSelected[c.Nick])
+			cb.SetChangedFunc(func(checked bool) { updateStatus() })
+			checkboxes = append(checkboxes, cb)
+			form.AddFormItem(cb)
+		}
+		updateStatus()
+	})
 	form.AddInputField("Dest Addr", "", inputWidth, nil, nil)
+	form.GetFormItemByLabel("Dest Addr").(*tview.InputField).SetPlaceholder("address, ENS name or address book label")
 	form.AddInputField("Amount", "", inputWidth, nil, nil)
+	form.GetFormItemByLabel("Amount").(*tview.InputField).SetPlaceholder("in eth, or with a unit like 20 gwei")
 	form.AddInputField("Memo", "", inputWidth, nil, nil)
-
-	form.AddCheckbox(ui.cfg.Me.Nick, true, nil)
-	for _, p := range participants {
-		form.AddCheckbox(p.Nick, false, nil)
-	}
+	form.AddInputField("Data", "", inputWidth, nil, nil)
+	form.GetFormItemByLabel("Data").(*tview.InputField).SetPlaceholder("hex calldata of a contract call, optional")
+	form.AddInputField("Fee", "", inputWidth, nil, nil)
+	form.GetFormItemByLabel("Fee").(*tview.InputField).SetPlaceholder("slow, normal or fast, or a gas price like 30 gwei")
 
 	form.AddButton("Sign and Send", func() {
-		walletname := form.GetFormItemByLabel("Wallet Name").(*tview.InputField).GetText()
 		destaddr := form.GetFormItemByLabel("Dest Addr").(*tview.InputField).GetText()
 		amount := form.GetFormItemByLabel("Amount").(*tview.InputField).GetText()
 		memo := form.GetFormItemByLabel("Memo").(*tview.InputField).GetText()
+		datahex := strings.TrimSpace(form.GetFormItemByLabel("Data").(*tview.InputField).GetText())
+		fee := form.GetFormItemByLabel("Fee").(*tview.InputField).GetText()
 
-		w := ui.cfg.FindWallet(walletname)
-		// Always include ourselves
-		signers := []user.User{ui.cfg.Me.User}
-		for _, p := range participants {
-			cb := form.GetFormItemByLabel(p.Nick).(*tview.Checkbox)
-			if cb.IsChecked() {
-				signers = append(signers, p.User)
-			}
+		if w == nil {
+			ui.message("Select a wallet to send from", "OK", "form", nil)
+			return
 		}
-
-		if len(signers) <= w.Threshold {
-			ui.message(fmt.Sprintf("Wallet threshold requires at least %v signers", w.Threshold+1), "OK", "main", nil)
+		others := chosen()
+		if len(others) < w.Threshold {
+			ui.message(fmt.Sprintf("Wallet threshold requires at least %v signers", w.Threshold+1), "OK", "form", nil)
 			return
 		}
 
+		signers := []user.User{ui.cfg.Me.User}
 		othernicks := []string{}
-		for _, s := range signers {
-			if s.Nick != ui.cfg.Me.Nick {
-				othernicks = append(othernicks, s.Nick)
-			}
+		for _, c := range others {
+			signers = append(signers, c.User)
+			othernicks = append(othernicks, c.Nick)
+		}
+		if err := ui.cfg.PreferSigners(w.Name, othernicks); err != nil {
+			logger.Error("Error saving the preferred signers", "wallet", w.Name, "err", err)
 		}
 
-		var amt float64
-		_, err := fmt.Sscan(amount, &amt)
+		wei, err := units.Parse(amount, units.Ether)
 		if err != nil {
-			ui.message(fmt.Sprintf("Error scanning amount %s into float64: %v", amount, err), "OK", "main", nil)
+			ui.message(fmt.Sprintf("Invalid amount: %v", err), "OK", "form", nil)
 			return
 		}
+		data := []byte{}
+		if datahex != "" {
+			if data, err = hexutil.Decode(datahex); err != nil {
+				ui.message(fmt.Sprintf("Invalid data, expected hex starting with 0x: %v", err), "OK", "form", nil)
+				return
+			}
+		}
 
-		ui.MsgInputs <- fmt.Sprintf("%s wants %s to send %f Ether from wallet %s to destination address %s", ui.cfg.Me.Nick, strings.Join(othernicks, ","), amt, walletname, destaddr)
-
-		amt64 := uint64(amt)
+		// An empty fee keeps the strategy of the wallet
+		strategy, err := w.Fees.Override(fee)
+		if err != nil {
+			ui.message(err.Error(), "OK", "form", nil)
+			return
+		}
 
-		go ui.sendTx(walletname, destaddr, amt64, memo, signers)
+		req := SendTxRequest{Wallet: w.Name, Amount: wei, Data: data, Memo: memo, Fees: &strategy}
+		go func() {
+			dest, err := cr.ResolveDestination(req.Wallet, destaddr)
+			if err != nil {
+				ui.message(fmt.Sprintf("Invalid destination: %v", err), "OK", "form", nil)
+				return
+			}
+			req.Dest = dest
+			status.SetText("[yellow]Simulating the transaction...[-]")
+			preview, err := cr.PreviewSendTx(req)
+			updateStatus()
+			if err != nil {
+				ui.message(fmt.Sprintf("Error creating transaction: %v", err), "OK", "form", nil)
+				return
+			}
 
-		ui.pages.RemovePage("form").ShowPage("main")
+			confirmMsg := fmt.Sprintf("Send %s from wallet %s to %s?\n\n%s", units.FormatEther(wei), req.Wallet, req.Dest, ui.formatPreview(preview))
+			if req.Dest.FirstTime {
+				confirmMsg += "\n\nWARNING: this is a first-time destination, it is not in your address book"
+			}
+			sendLabel := "Send"
+			if preview.Reverted {
+				confirmMsg += "\n\nWARNING: the transaction reverts on the current state and will most likely fail"
+				sendLabel = "Send anyway"
+				req.Force = true
+			}
+			ui.confirm(confirmMsg, sendLabel, "form", func() {
+				ui.pages.RemovePage("form").ShowPage("main")
+				go ui.sendTx(cr, req, signers)
+			}, nil)
+		}()
 	})
 
 	form.AddButton("Cancel", func() {
 		ui.pages.RemovePage("form").ShowPage("main")
 	})
 
-	ui.pages.AddAndSwitchToPage("form", ui.modal(form, 80, 29), true).ShowPage("main")
+	// Preselect the only wallet, otherwise let the user pick
+	if len(walletnames) == 1 {
+		form.GetFormItem(0).(*tview.DropDown).SetCurrentOption(0)
+	}
+
+	ui.pages.AddAndSwitchToPage("form", ui.modal(layout, 80, 31), true).ShowPage("main")
 }
 
-// Construct a Tx, and run the SendTx multi-party protocol.
-// Also notifies other signers to contruct the Tx and start the protocol.
-func (ui *UI) sendTx(walletname string, destaddr string, amount uint64, memo string, signers []user.User) {
+// Propose a Tx to the other signers in a room, which is signed and sent once enough of them approved it and are
+// online
+func (ui *UI) sendTx(cr *ChatRoom, req SendTxRequest, signers []user.User) {
 	othernicks := []string{}
 	for _, s := range signers {
 		if s.Nick != ui.cfg.Me.Nick {
@@ -473,22 +670,112 @@ func (ui *UI) sendTx(walletname string, destaddr string, amount uint64, memo str
 		}
 	}
 
-	amtDisplay := float64(amount) /// float64(units.Avax)
+	ui.say(cr, fmt.Sprintf("%s wants %s to send %s from wallet %s to %s", ui.cfg.Me.Nick, strings.Join(othernicks, ","), units.FormatEther(req.Amount), req.Wallet, req.Dest))
+
+	if _, err := cr.ProposeSendTx(req, signers, proposal.DefaultTTL); err != nil {
+		cr.Logs <- chatlog{level: logLevelError, msg: fmt.Sprintf("Error sending transaction %v", err)}
+	}
+}
+
+func (ui *UI) reshareForm() {
+	cr := ui.ChatRoom
+	participants := cr.ParticipantList()
+
+	form := tview.NewForm()
+	form.SetBorder(true)
+	form.SetTitle("Change Wallet Signers")
+	form.SetTitleAlign(tview.AlignLeft)
+	form.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch event.Key() {
+		case tcell.KeyEsc:
+			ui.pages.RemovePage("form").ShowPage("main")
+		}
+		return event
+	})
+
+	form.AddInputField("Wallet Name", "", inputWidth, nil, nil)
+	form.AddInputField("Maximum amount of parties corrupted", "", inputWidth, nil, nil)
+
+	form.AddCheckbox(ui.cfg.Me.Nick, true, nil)
+	for _, p := range participants {
+		form.AddCheckbox(p.Nick, false, nil)
+	}
+
+	form.AddButton("Reshare", func() {
+		name := form.GetFormItemByLabel("Wallet Name").(*tview.InputField).GetText()
+
+		thresholdstr := form.GetFormItemByLabel("Maximum amount of parties corrupted").(*tview.InputField).GetText()
+		var threshold int
+		fmt.Sscan(thresholdstr, &threshold)
+
+		w := cr.findWallet(name)
+		if w == nil {
+			ui.message(fmt.Sprintf("Wallet %s not found", name), "OK", "main", nil)
+			return
+		}
+
+		signers := []user.User{}
+		if form.GetFormItemByLabel(ui.cfg.Me.Nick).(*tview.Checkbox).IsChecked() {
+			signers = append(signers, ui.cfg.Me.User)
+		}
+		for _, p := range participants {
+			cb := form.GetFormItemByLabel(p.Nick).(*tview.Checkbox)
+			if cb.IsChecked() {
+				signers = append(signers, p.User)
+			}
+		}
+
+		if (threshold <= 0) || (threshold > len(signers)-1) {
+			ui.message("Threshold must be less than total signers", "OK", "main", nil)
+			return
+		}
+
+		go ui.reshareWallet(cr, name, threshold, signers)
+		ui.pages.RemovePage("form").ShowPage("main")
+	})
+
+	form.AddButton("Cancel", func() {
+		ui.pages.RemovePage("form").ShowPage("main")
+	})
+
+	ui.pages.AddAndSwitchToPage("form", ui.modal(form, 80, 29), true).ShowPage("main")
+}
 
-	ui.MsgInputs <- fmt.Sprintf("%s wants %s to send %f Ether from wallet %s to destination address %s", ui.cfg.Me.Nick, strings.Join(othernicks, ","), amtDisplay, walletname, destaddr)
+// Propose moving a wallet to new signers, the current signers that stay on deal their shares
+func (ui *UI) reshareWallet(cr *ChatRoom, walletname string, threshold int, signers []user.User) {
+	ui.say(cr, fmt.Sprintf("%s is proposing to move wallet %s to a %v-of-%v wallet with signers %s", ui.cfg.Me.Nick, walletname, threshold+1, len(signers), nicksOf(signers)))
 
-	ui.OutboundChat <- chatmessage{
-		Type:       messageTypeStartSendTx,
-		SenderName: ui.cfg.Me.Nick,
-		StartSendTx: startsendtxcmd{
-			Name:     walletname,
-			Amount:   amount,
-			DestAddr: destaddr,
-			Memo:     memo,
-			Signers:  signers,
-		},
+	if _, err := cr.ProposeReshare(walletname, threshold, signers, nil, proposal.DefaultTTL); err != nil {
+		cr.Logs <- chatlog{level: logLevelError, msg: fmt.Sprintf("Error resharing wallet %v", err)}
+	}
+}
+
+// Approve or reject a pending proposal of a room by ID prefix, as typed in /approve and /reject. Forcing
+// approves a transaction that reverts in simulation.
+func (ui *UI) decideProposal(cr *ChatRoom, idprefix string, approve bool, force bool) {
+	p, err := cr.DecideProposal(idprefix, approve, force)
+	if err != nil {
+		cr.Logs <- chatlog{level: logLevelError, msg: fmt.Sprintf("Error deciding proposal: %v", err)}
+		return
+	}
+	if approve {
+		cr.Logs <- chatlog{level: logLevelInfo, msg: fmt.Sprintf("You approved proposal %s (%d of %d)", p.ShortID(), len(p.Approved()), p.Required)}
+	} else {
+		cr.Logs <- chatlog{level: logLevelInfo, msg: fmt.Sprintf("You rejected proposal %s", p.ShortID())}
+	}
+}
+
+// Admit or decline a new signer asking to join with one of our invitations
+func (ui *UI) decideJoinRequest(cr *ChatRoom, req joinrequest, admit bool) {
+	if err := cr.decideJoinRequest(req, admit); err != nil {
+		cr.Logs <- chatlog{level: logLevelError, msg: fmt.Sprintf("Error deciding join request: %v", err)}
+		return
+	}
+	if admit {
+		cr.Logs <- chatlog{level: logLevelInfo, msg: fmt.Sprintf("You admitted %s to project %s", req.User.Nick, cr.Project())}
+	} else {
+		cr.Logs <- chatlog{level: logLevelInfo, msg: fmt.Sprintf("You declined %s joining project %s", req.User.Nick, cr.Project())}
 	}
-	ui.runProtocolSendTx(walletname, destaddr, amount, memo, signers)
 }
 
 // Popup modal message UI
@@ -507,8 +794,8 @@ func (ui *UI) message(message, doneLabel, page string, doneFunc func()) {
 	ui.pages.AddAndSwitchToPage("modal", ui.modal(modal, 80, 29), true).ShowPage("main")
 }
 
-// Popup modal confirmation UI
-func (ui *UI) confirm(message, doneLabel, page string, doneFunc func()) {
+// Popup modal confirmation UI, cancelFunc is called if the user declines
+func (ui *UI) confirm(message, doneLabel, page string, doneFunc func(), cancelFunc func()) {
 	modal := tview.NewModal().
 		SetText(message).
 		AddButtons([]string{doneLabel, "Cancel"}).
@@ -516,6 +803,8 @@ func (ui *UI) confirm(message, doneLabel, page string, doneFunc func()) {
 			ui.pages.RemovePage("modal").ShowPage(page)
 			if buttonLabel == doneLabel && doneFunc != nil {
 				doneFunc()
+			} else if buttonLabel != doneLabel && cancelFunc != nil {
+				cancelFunc()
 			}
 		})
 
@@ -534,92 +823,20 @@ func (ui *UI) startEventHandler() {
 		select {
 
 		case msg := <-ui.MsgInputs:
-			log.Printf("MsgInputs: %+v", msg)
-			ui.OutboundChat <- chatmessage{Type: messageTypeChatMessage, SenderName: ui.cfg.Me.Nick, UserMessage: msg}
-			ui.displaySelfMessage(msg)
+			logger.Debug("Chat input", "msg", msg)
+			ui.say(ui.ChatRoom, msg)
 
 		case cmd := <-ui.CmdInputs:
-			log.Printf("CmdInputs: %+v", cmd)
-			go ui.handleCommand(cmd)
-
-		case msg := <-ui.InboundChat:
-			ui.displayChatMessage(msg)
-
-		case msg := <-ui.InboundProtocolStart:
-			switch msg.Type {
-			case messageTypeStartKeygen:
-				othernicks := []string{}
-				for _, s := range msg.StartKeygen.Signers {
-					if s.Nick != ui.cfg.Me.Nick {
-						othernicks = append(othernicks, s.Nick)
-					}
-				}
-				confirmMsg := fmt.Sprintf("%s wants to generate a %v-of-%v wallet with other signers %s", msg.SenderName, msg.StartKeygen.Threshold+1, len(msg.StartKeygen.Signers), strings.Join(othernicks, ","))
-				ui.confirm(confirmMsg, "Generate!", "main", func() {
-					go ui.runProtocolKeygen(msg.StartKeygen.Name, msg.StartKeygen.Threshold, msg.StartKeygen.Signers)
-				})
-			case messageTypeStartSign:
-				othernicks := []string{}
-				for _, s := range msg.StartSign.Signers {
-					if s.Nick != ui.cfg.Me.Nick {
-						othernicks = append(othernicks, s.Nick)
-					}
-				}
-				confirmMsg := fmt.Sprintf("%s wants %s to sign a text message with wallet %s: %s", msg.SenderName, strings.Join(othernicks, ","), msg.StartSign.Name, msg.StartSign.Message)
-				ui.confirm(confirmMsg, "Sign!", "main", func() {
-					hash := utils.DigestAvaMsg(msg.StartSign.Message)
-					go ui.runProtocolSign(msg.StartSign.Name, hash, msg.StartSign.Signers)
-				})
-				// case messageTypeStartSendTx:
-				// 	othernicks := []string{}
-				// 	for _, s := range msg.StartSendTx.Signers {
-				// 		if s.Nick != ui.cfg.Me.Nick {
-				// 			othernicks = append(othernicks, s.Nick)
-				// 		}
-				// 	}
-
-				// 	// TODO is division the best way to do this?
-				// 	amtDisplay := float64(msg.StartSendTx.Amount) / float64(units.Avax)
-				// 	confirmMsg := fmt.Sprintf("%s wants %s to send %v AVAX to address %s", msg.SenderName, strings.Join(othernicks, ","), amtDisplay, msg.StartSendTx.DestAddr)
-				// 	if msg.StartSendTx.Memo != "" {
-				// 		confirmMsg = fmt.Sprintf("%s with memo %s", confirmMsg, msg.StartSendTx.Memo)
-				// 	}
-				// 	log.Println(confirmMsg)
-				// 	ui.confirm(confirmMsg, "Sign!", "main", func() {
-
-				// 		w := ui.cfg.FindWallet(msg.StartSendTx.Name)
-				// 		err := w.FetchUTXOs()
-				// 		if err != nil {
-				// 			ui.MsgInputs <- fmt.Sprintf("Error fetching wallet balance %v", err)
-				// 		}
-
-				// 		_, _, b, err := formatting.ParseAddress(msg.StartSendTx.DestAddr)
-				// 		if err != nil {
-				// 			ui.MsgInputs <- fmt.Sprintf("Error parsing dest addr %s: %v", msg.StartSendTx.DestAddr, err)
-				// 		}
-				// 		destid, err := ids.ToShortID(b)
-				// 		if err != nil {
-				// 			ui.MsgInputs <- fmt.Sprintf("Error parsing dest addr to id %s: %v", msg.StartSendTx.DestAddr, err)
-				// 		}
-
-				// 		tx, err := w.CreateTx(w.Config.AssetID, msg.StartSendTx.Amount, destid, msg.StartSendTx.Memo)
-				// 		if err != nil {
-				// 			ui.MsgInputs <- fmt.Sprintf("Error CreateTx %v", err)
-				// 		}
-				// 		unsignedBytes, err := w.GetUnsignedBytes(&tx.UnsignedTx)
-				// 		if err != nil {
-				// 			ui.MsgInputs <- fmt.Sprintf("Error GetUnsignedBytes %v", err)
-				// 		}
-				// 		msgHash := hashing.ComputeHash256(unsignedBytes)
-
-				// 		go ui.runProtocolSign(msg.StartSendTx.Name, msgHash, msg.StartSendTx.Signers)
-				// 	})
-			}
-		case log := <-ui.Logs:
-			ui.handleLogMessage(log)
+			logger.Debug("Command input", "cmd", cmd)
+			go ui.handleCommand(ui.ChatRoom, cmd)
+
+		case project := <-ui.switchRoom:
+			ui.showRoom(project)
 
 		case <-refreshticker.C:
+			ui.syncRooms()
 			ui.syncWallets()
+			ui.syncProposals()
 			ui.syncParticipants()
 			// TODO this causes flashing on windows
 			ui.TerminalApp.Draw()
@@ -627,13 +844,67 @@ func (ui *UI) startEventHandler() {
 		case <-fetchticker.C:
 		//	ui.fetchWalletBalances()
 
-		case <-ui.psctx.Done():
+		// All rooms are closed together
+		case <-ui.rooms[0].psctx.Done():
 			return
 		}
 	}
 }
 
-func (ui *UI) handleCommand(cmd UICommand) {
+// Listen to the messages, proposals and logs of a room, shown or not
+func (ui *UI) roomEventHandler(cr *ChatRoom) {
+	for {
+		select {
+		case msg := <-cr.InboundChat:
+			ui.displayChatMessage(cr, msg)
+			ui.markUnread(cr)
+
+		case msg := <-cr.InboundProtocolStart:
+			p := msg.Proposal
+			confirmMsg := fmt.Sprintf("%s proposes to %s", p.Proposer, p.Summary)
+			if len(ui.rooms) > 1 {
+				confirmMsg = fmt.Sprintf("In project %s, %s", cr.Project(), confirmMsg)
+			}
+			if cmd := (startresharecmd{}); p.Type == proposal.TypeReshare && p.UnmarshalCommand(&cmd) == nil && !cr.doSignersIncludeMe(cmd.Signers) {
+				confirmMsg += ". You will no longer be a signer and your share will be removed"
+			}
+			if msg.Preview != nil {
+				confirmMsg += "\n\n" + cr.formatPreview(msg.Preview)
+			}
+			for _, w := range msg.Warnings {
+				confirmMsg += "\n\nWARNING: " + w
+			}
+			confirmMsg = fmt.Sprintf("%s\n\nProposal %s needs %d approvals and expires %s. Approve it now, or later with /approve %s", confirmMsg, p.ShortID(), p.Required, p.Expires.Local().Format(time.RFC1123), p.ShortID())
+			// Approving a transaction that reverts is an explicit override
+			approveLabel, force := "Approve!", false
+			if msg.Preview != nil && msg.Preview.Reverted {
+				approveLabel, force = "Approve anyway", true
+			}
+			ui.markUnread(cr)
+			ui.confirm(confirmMsg, approveLabel, "main", func() {
+				go ui.decideProposal(cr, p.ID, true, force)
+			}, nil)
+
+		case req := <-cr.InboundJoin:
+			confirmMsg := fmt.Sprintf("%s (peer %s) asks to join project %s with your invitation.\n\nAdmit them as a member? They can then take part in new wallets.", req.User.Nick, req.User.PeerID().Pretty(), cr.Project())
+			ui.markUnread(cr)
+			ui.confirm(confirmMsg, "Admit", "main", func() {
+				go ui.decideJoinRequest(cr, req, true)
+			}, func() {
+				go ui.decideJoinRequest(cr, req, false)
+			})
+
+		case log := <-cr.Logs:
+			ui.handleLogMessage(cr, log)
+
+		case <-cr.psctx.Done():
+			return
+		}
+	}
+}
+
+// Handle a command typed in the room cr
+func (ui *UI) handleCommand(cr *ChatRoom, cmd UICommand) {
 	switch cmd.cmdtype {
 	case "/quit":
 		ui.TerminalApp.Stop()
@@ -648,26 +919,67 @@ func (ui *UI) handleCommand(cmd UICommand) {
 	case "/sendtx":
 		ui.sendTxForm()
 
+	case "/reshare":
+		ui.reshareForm()
+
+	case "/approve":
+		force := len(cmd.cmdargs) > 1 && cmd.cmdargs[1] == "force"
+		ui.decideProposal(cr, cmd.cmdargs[0], true, force)
+
+	case "/reject":
+		ui.decideProposal(cr, cmd.cmdargs[0], false, false)
+
+	case "/room":
+		ui.switchRoom <- cmd.cmdargs[0]
+
+	case "/confirm":
+		go func() {
+			if err := cr.ConfirmWallet(cmd.cmdargs[0]); err != nil {
+				cr.Logs <- chatlog{level: logLevelError, msg: fmt.Sprintf("Error confirming wallet: %v", err)}
+			}
+		}()
+
 	// Unsupported command
 	default:
-		ui.Logs <- chatlog{level: logLevelInfo, msg: fmt.Sprintf("unsupported command - %s", cmd.cmdtype)}
+		cr.Logs <- chatlog{level: logLevelInfo, msg: fmt.Sprintf("unsupported command - %s", cmd.cmdtype)}
 	}
 }
 
-func (ui *UI) displayChatMessage(msg chatmessage) {
+func (ui *UI) displayChatMessage(cr *ChatRoom, msg chatmessage) {
 	prompt := fmt.Sprintf("[blue]<%s>:[-]", msg.SenderName)
-	fmt.Fprintf(ui.messageBox, "%s %s\n", prompt, msg.UserMessage)
+	fmt.Fprintf(ui.messageBox(cr), "%s %s\n", prompt, msg.UserMessage)
 }
 
-func (ui *UI) displaySelfMessage(msg string) {
+func (ui *UI) displaySelfMessage(cr *ChatRoom, msg string) {
 	prompt := fmt.Sprintf("[green]<%s>:[-]", ui.cfg.Me.Nick)
-	fmt.Fprintf(ui.messageBox, "%s %s\n", prompt, msg)
+	fmt.Fprintf(ui.messageBox(cr), "%s %s\n", prompt, msg)
 }
 
-func (ui *UI) handleLogMessage(cl chatlog) {
+func (ui *UI) handleLogMessage(cr *ChatRoom, cl chatlog) {
 	// Write to UI
-	fmt.Fprintf(ui.messageBox, "[grey]%s[-]\n", cl.msg)
-	log.Printf("%s: %s", cl.level, cl.msg)
+	fmt.Fprintf(ui.messageBox(cr), "[grey]%s[-]\n", cl.msg)
+	cl.write(cr.Project())
+}
+
+// Show the rooms, the one shown highlighted and the others with their unread messages
+func (ui *UI) syncRooms() {
+	if len(ui.rooms) < 2 {
+		return
+	}
+	ui.unreadMutex.Lock()
+	names := []string{}
+	for _, cr := range ui.rooms {
+		switch n := ui.unread[cr.Project()]; {
+		case cr == ui.ChatRoom:
+			names = append(names, fmt.Sprintf("[black:green]%s[-:-]", cr.Project()))
+		case n > 0:
+			names = append(names, fmt.Sprintf("%s [yellow](%d)[-]", cr.Project(), n))
+		default:
+			names = append(names, cr.Project())
+		}
+	}
+	ui.unreadMutex.Unlock()
+	ui.roomBox.SetText(strings.Join(names, "  "))
 }
 
 func (ui *UI) syncParticipants() {
@@ -683,26 +995,50 @@ func (ui *UI) syncParticipants() {
 	}
 }
 
+func (ui *UI) syncProposals() {
+	// Clear() is not a threadsafe call
+	ui.proposalBox.Lock()
+	ui.proposalBox.Clear()
+	ui.proposalBox.Unlock()
+
+	for _, p := range ui.Proposals() {
+		decision := "[yellow]waiting for you[-]"
+		if approve, decided := p.Decision(ui.cfg.Me.Nick); decided && approve {
+			decision = "[green]approved[-]"
+		} else if decided {
+			decision = "[red]rejected[-]"
+		}
+		fmt.Fprintf(
+			ui.proposalBox,
+			"[blue]<%s>[-] [white]%s by %s[-]\n%s\n[grey]%d of %d approvals (%s), %s, %s\n\n",
+			p.ShortID(), p.Type, p.Proposer, p.Summary, len(p.Approved()), p.Required, strings.Join(p.Approved(), ","), p.Status, decision)
+	}
+}
+
 func (ui *UI) syncWallets() {
 	// Clear() is not a threadsafe call
 	ui.keyBox.Lock()
 	ui.keyBox.Clear()
 	ui.keyBox.Unlock()
 
-	for _, n := range ui.cfg.SortedWalletNames() {
-		w := ui.cfg.FindWallet(n)
+	for _, n := range ui.walletNames() {
+		w := ui.findWallet(n)
 		m := w.Threshold + 1
 		n := len(w.Others) + 1
 		signers := fmt.Sprint(strings.Join(w.AllPartyNicks(), ","))
 		bal := w.BalanceForDisplay(w.Config.AssetID)
 		fmt.Fprintf(
 			ui.keyBox,
-			"[blue]<%s>[-]\n[yellow]%s[-]\n[white]Balance:[-] [green]%s[-] [white] Ether[-]\n[grey]Signers: %s (%d of %d)\n",
-			w.Name, w.Address, bal, signers, m, n)
+			"[blue]<%s>[-]\n[yellow]%s[-]\n[white]Balance:[-] [green]%s[-] [white] Ether[-]\n[grey]Signers: %s (%d of %d)\nFingerprint: %s[-]\n",
+			w.Name, w.Address, bal, signers, m, n, protocols.Fingerprint(w))
+		if w.Unconfirmed {
+			fmt.Fprintf(ui.keyBox, "[red]Not confirmed by all signers, use /confirm %s[-]\n", w.Name)
+		}
 	}
 }
 
 func (ui *UI) fetchWalletBalances() {
+	// The wallets of all rooms
 	for _, n := range ui.cfg.SortedWalletNames() {
 		w := ui.cfg.FindWallet(n)
 		go w.FetchBalance()
@@ -722,6 +1058,11 @@ func (ui *UI) globalKeyboardIntercept(event *tcell.EventKey) *tcell.EventKey {
 		ui.generateKeyForm()
 	case tcell.KeyF3:
 		ui.sendTxForm()
+	case tcell.KeyF5:
+		// Only switch rooms from the main page, not under a form of the room
+		if front, _ := ui.pages.GetFrontPage(); front == "main" && len(ui.rooms) > 1 {
+			go func() { ui.switchRoom <- ui.nextRoom() }()
+		}
 		// case tcell.KeyF4:
 		//ui.signMsgForm()
 	}
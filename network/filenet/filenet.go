@@ -0,0 +1,319 @@
+package filenet
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shykerbogdan/mpc-wallet/logging"
+	"github.com/shykerbogdan/mpc-wallet/network"
+	"github.com/shykerbogdan/mpc-wallet/utils"
+	"github.com/taurusgroup/multi-party-sig/pkg/party"
+	"github.com/taurusgroup/multi-party-sig/pkg/protocol"
+)
+
+var logger = logging.New("filenet")
+
+const (
+	// Bundle files are named <session>-<sender>-<seq>.thb
+	BundleExt = ".thb"
+
+	bundleVersion = 1
+
+	// Outgoing messages are collected until the protocol has been quiet for this long,
+	// so that all messages of a round end up in a single bundle
+	flushDelay = time.Second
+
+	pollInterval = time.Second
+)
+
+// A Bundle holds all the protocol messages one party produced in a round
+type Bundle struct {
+	Version  int
+	Session  string
+	From     party.ID
+	Seq      int
+	Created  time.Time
+	Messages []*protocol.Message
+}
+
+// Network moves protocol messages between parties as encrypted bundle files, so a party that
+// is never online at the same time as the others (e.g. a cold signer on an air-gapped laptop)
+// can still take part. Outgoing bundles are written to a directory and incoming bundles are
+// picked up from the same directory as they are copied in, by whatever means the parties use.
+type Network struct {
+	self       party.ID
+	session    string
+	dir        string
+	passphrase []byte
+
+	// Called with the path of every bundle written, e.g. to also show it as a QR code
+	OnBundle func(path string, sealed []byte)
+
+	inbound chan *protocol.Message
+	outbox  []*protocol.Message
+	seq     int
+	seen    map[string]bool
+	// Bundle files that could not be read or imported, tried again once they change, e.g. when they were
+	// still being copied in
+	failed map[string]fileState
+	flush  *time.Timer
+	done   chan struct{}
+	closed bool
+	mutex  sync.Mutex
+}
+
+var _ network.Network = &Network{}
+
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+func stateOf(f os.FileInfo) fileState {
+	return fileState{size: f.Size(), modTime: f.ModTime()}
+}
+
+func (s fileState) changed(f os.FileInfo) bool {
+	return s.size != f.Size() || !s.modTime.Equal(f.ModTime())
+}
+
+// Session derives a short id from everything that identifies one protocol run,
+// so all parties compute the same id and bundles from other runs are ignored.
+func Session(parts ...string) string {
+	h := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(h[:6])
+}
+
+func NewNetwork(self party.ID, session string, dir string, passphrase []byte) (*Network, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	n := &Network{
+		self:       self,
+		session:    session,
+		dir:        dir,
+		passphrase: passphrase,
+		inbound:    make(chan *protocol.Message, 1000),
+		seen:       make(map[string]bool),
+		failed:     make(map[string]fileState),
+		done:       make(chan struct{}),
+	}
+
+	go n.pollLoop()
+
+	return n, nil
+}
+
+func (n *Network) Next(id party.ID) <-chan *protocol.Message {
+	return n.inbound
+}
+
+func (n *Network) Send(msg *protocol.Message) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if n.closed {
+		return
+	}
+
+	n.outbox = append(n.outbox, msg)
+	if n.flush == nil {
+		n.flush = time.AfterFunc(flushDelay, n.Flush)
+	} else {
+		n.flush.Reset(flushDelay)
+	}
+}
+
+// Write any pending outgoing messages to a new bundle file
+func (n *Network) Flush() {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if len(n.outbox) == 0 {
+		return
+	}
+
+	n.seq++
+	b := Bundle{
+		Version:  bundleVersion,
+		Session:  n.session,
+		From:     n.self,
+		Seq:      n.seq,
+		Created:  time.Now().UTC(),
+		Messages: n.outbox,
+	}
+	n.outbox = nil
+
+	path, sealed, err := n.writeBundle(b)
+	if err != nil {
+		logger.Error("Error writing bundle", "seq", b.Seq, "err", err)
+		return
+	}
+	logger.Info("Wrote bundle", "file", path, "messages", len(b.Messages))
+
+	if n.OnBundle != nil {
+		n.OnBundle(path, sealed)
+	}
+}
+
+// Flush what is left and stop watching for incoming bundles
+func (n *Network) Close() {
+	n.Flush()
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.closed {
+		return
+	}
+	n.closed = true
+	if n.flush != nil {
+		n.flush.Stop()
+	}
+	close(n.done)
+}
+
+// Import a bundle from sealed bytes, e.g. reassembled from scanned QR frames
+func (n *Network) Import(sealed []byte) error {
+	b, err := ReadBundle(sealed, n.passphrase)
+	if err != nil {
+		return err
+	}
+	if b.Session != n.session {
+		return fmt.Errorf("bundle belongs to session %s, not %s", b.Session, n.session)
+	}
+	if b.From == n.self {
+		return nil
+	}
+
+	for _, msg := range b.Messages {
+		if msg.From != b.From {
+			return errors.New("bundle contains a message from a different party")
+		}
+		if msg.IsFor(n.self) {
+			n.inbound <- msg
+		}
+	}
+	logger.Info("Imported bundle", "seq", b.Seq, "from", b.From, "messages", len(b.Messages))
+
+	return nil
+}
+
+// Decrypt and decode a sealed bundle
+func ReadBundle(sealed []byte, passphrase []byte) (Bundle, error) {
+	b := Bundle{}
+
+	data, err := utils.OpenWithPassphrase(sealed, passphrase)
+	if err != nil {
+		return b, err
+	}
+	if err := json.Unmarshal(data, &b); err != nil {
+		return b, err
+	}
+	if b.Version != bundleVersion {
+		return b, fmt.Errorf("unsupported bundle version %d", b.Version)
+	}
+	return b, nil
+}
+
+// The file name a bundle is stored under
+func (b Bundle) FileName() string {
+	return fmt.Sprintf("%s-%s-%03d%s", b.Session, b.From, b.Seq, BundleExt)
+}
+
+func (n *Network) writeBundle(b Bundle) (string, []byte, error) {
+	// Messages are encoded as JSON like on the chat network, since protocol.Message can not be cbor encoded as a nested value
+	data, err := json.Marshal(b)
+	if err != nil {
+		return "", nil, err
+	}
+
+	sealed, err := utils.SealWithPassphrase(data, n.passphrase)
+	if err != nil {
+		return "", nil, err
+	}
+
+	name := b.FileName()
+	path := filepath.Join(n.dir, name)
+
+	// Write under a temp name so a half written bundle is never picked up by another party
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, sealed, 0600); err != nil {
+		return "", nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return "", nil, err
+	}
+
+	n.seen[name] = true
+	return path, sealed, nil
+}
+
+// Look for new bundles of this session from other parties
+func (n *Network) pollLoop() {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.done:
+			return
+		case <-ticker.C:
+			for _, f := range n.newBundles() {
+				name := f.Name()
+				sealed, err := ioutil.ReadFile(filepath.Join(n.dir, name))
+				if err == nil {
+					err = n.Import(sealed)
+				}
+
+				n.mutex.Lock()
+				if err != nil {
+					n.failed[name] = stateOf(f)
+				} else {
+					n.seen[name] = true
+					delete(n.failed, name)
+				}
+				n.mutex.Unlock()
+				if err != nil {
+					logger.Warn("Error importing bundle, trying again once the file changes", "file", name, "err", err)
+				}
+			}
+		}
+	}
+}
+
+// The bundle files of this session not imported yet, leaving out those that failed and did not change since,
+// sorted by name
+func (n *Network) newBundles() []os.FileInfo {
+	files, err := ioutil.ReadDir(n.dir)
+	if err != nil {
+		logger.Error("Error reading the bundle dir", "dir", n.dir, "err", err)
+		return nil
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	bundles := []os.FileInfo{}
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || n.seen[name] || !strings.HasSuffix(name, BundleExt) || !strings.HasPrefix(name, n.session+"-") {
+			continue
+		}
+		if state, failed := n.failed[name]; failed && !state.changed(f) {
+			continue
+		}
+		bundles = append(bundles, f)
+	}
+
+	return bundles
+}
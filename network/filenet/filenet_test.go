@@ -0,0 +1,150 @@
+package filenet
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shykerbogdan/mpc-wallet/protocols"
+	"github.com/shykerbogdan/mpc-wallet/user"
+	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet"
+	"github.com/taurusgroup/multi-party-sig/pkg/party"
+	"github.com/taurusgroup/multi-party-sig/pkg/protocol"
+)
+
+var testPassphrase = []byte("passphrase")
+
+// Both parties keep their bundles in the same directory, as if copied over right away
+func TestKeygen(t *testing.T) {
+	dir := t.TempDir()
+	mes := []user.Me{}
+	for _, nick := range []string{"alice", "bob"} {
+		me, err := user.NewMe(nick, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		mes = append(mes, me)
+	}
+	session := Session("keygen", "w")
+
+	wallets := []*ethwallet.Wallet{}
+	errs := make(chan error, len(mes))
+	for i, me := range mes {
+		net, err := NewNetwork(me.PartyID(), session, dir, testPassphrase)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer net.Close()
+		w := ethwallet.NewEmptyWallet("goerli", "w", 1, me.User, []user.User{mes[1-i].User})
+		wallets = append(wallets, w)
+		go func(me user.Me) { errs <- protocols.RunKeygen(me, w, net, time.Minute) }(me)
+	}
+	for range mes {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if wallets[0].Address == "" || wallets[0].Address != wallets[1].Address {
+		t.Errorf("keygen over bundles gave addresses %s and %s", wallets[0].Address, wallets[1].Address)
+	}
+}
+
+// Write a bundle with one message from alice to bob, returning it sealed
+func sealedBundle(t *testing.T, session string) []byte {
+	net, err := NewNetwork("alice", session, t.TempDir(), testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer net.Close()
+	var sealed []byte
+	net.OnBundle = func(path string, b []byte) { sealed = b }
+	net.Send(&protocol.Message{From: "alice", To: "bob", Protocol: "test", RoundNumber: 1, Data: []byte("hello")})
+	net.Flush()
+	if sealed == nil {
+		t.Fatal("no bundle written")
+	}
+	return sealed
+}
+
+func TestBundle(t *testing.T) {
+	session := Session("sign", "w")
+	sealed := sealedBundle(t, session)
+
+	b, err := ReadBundle(sealed, testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Session != session || b.From != "alice" || b.Seq != 1 || len(b.Messages) != 1 {
+		t.Errorf("read bundle %s of %s seq %d with %d messages", b.Session, b.From, b.Seq, len(b.Messages))
+	}
+	if _, err := ReadBundle(sealed, []byte("wrong")); err == nil {
+		t.Error("bundle read with a wrong passphrase")
+	}
+
+	bob, err := NewNetwork(party.ID("bob"), session, t.TempDir(), testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bob.Close()
+	if err := bob.Import(sealed); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-bob.Next("bob"):
+		if string(msg.Data) != "hello" {
+			t.Errorf("bob received %q", msg.Data)
+		}
+	default:
+		t.Error("imported message not delivered")
+	}
+
+	other, err := NewNetwork(party.ID("bob"), Session("sign", "other"), t.TempDir(), testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if err := other.Import(sealed); err == nil || !strings.Contains(err.Error(), "belongs to session") {
+		t.Errorf("bundle of another session imported: %v", err)
+	}
+}
+
+// A bundle still being copied in is imported once the copy completes
+func TestPartialBundle(t *testing.T) {
+	session := Session("sign", "w")
+	sealed := sealedBundle(t, session)
+	b, err := ReadBundle(sealed, testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	bob, err := NewNetwork(party.ID("bob"), session, dir, testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bob.Close()
+
+	path := filepath.Join(dir, b.FileName())
+	if err := ioutil.WriteFile(path, sealed[:len(sealed)/2], 0600); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-bob.Next("bob"):
+		t.Fatal("message of a truncated bundle delivered")
+	case <-time.After(pollInterval * 3):
+	}
+
+	if err := ioutil.WriteFile(path, sealed, 0600); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-bob.Next("bob"):
+		if string(msg.Data) != "hello" {
+			t.Errorf("bob received %q", msg.Data)
+		}
+	case <-time.After(pollInterval * 5):
+		t.Fatal("completed bundle not imported")
+	}
+}
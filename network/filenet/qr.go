@@ -0,0 +1,105 @@
+package filenet
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// Bundles are usually too large for a single QR code, so they are split into frames
+// of the form THB:<index>/<total>:<base64 chunk> which are shown one after the other
+const (
+	qrFramePrefix = "THB:"
+	qrChunkSize   = 400
+)
+
+// Split a sealed bundle into QR frame payloads
+func QRFrames(sealed []byte) []string {
+	encoded := base64.StdEncoding.EncodeToString(sealed)
+
+	total := (len(encoded) + qrChunkSize - 1) / qrChunkSize
+	frames := make([]string, 0, total)
+	for i := 0; i < total; i++ {
+		end := (i + 1) * qrChunkSize
+		if end > len(encoded) {
+			end = len(encoded)
+		}
+		frames = append(frames, fmt.Sprintf("%s%d/%d:%s", qrFramePrefix, i+1, total, encoded[i*qrChunkSize:end]))
+	}
+	return frames
+}
+
+// Reassemble a sealed bundle from scanned QR frame payloads, in any order, one per line.
+// Duplicate frames are fine since scanners will usually see each frame more than once.
+func AssembleQRFrames(lines []string) ([]byte, error) {
+	chunks := map[int]string{}
+	total := 0
+
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, qrFramePrefix) {
+			continue
+		}
+		var i, n int
+		parts := strings.SplitN(strings.TrimPrefix(line, qrFramePrefix), ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid QR frame %q", line)
+		}
+		if _, err := fmt.Sscanf(parts[0], "%d/%d", &i, &n); err != nil || i < 1 || i > n {
+			return nil, fmt.Errorf("invalid QR frame header %q", parts[0])
+		}
+		if total != 0 && n != total {
+			return nil, errors.New("QR frames belong to different bundles")
+		}
+		total = n
+		chunks[i] = parts[1]
+	}
+
+	if total == 0 {
+		return nil, errors.New("no QR frames found")
+	}
+
+	var sb strings.Builder
+	for i := 1; i <= total; i++ {
+		chunk, ok := chunks[i]
+		if !ok {
+			return nil, fmt.Errorf("QR frame %d of %d is missing", i, total)
+		}
+		sb.WriteString(chunk)
+	}
+
+	return base64.StdEncoding.DecodeString(sb.String())
+}
+
+// Render a QR code for the terminal, two modules per character row using half blocks.
+// Light modules are drawn so the code scans correctly on the usual dark terminal background.
+func RenderQR(content string) (string, error) {
+	q, err := qrcode.New(content, qrcode.Low)
+	if err != nil {
+		return "", err
+	}
+	bitmap := q.Bitmap()
+
+	var sb strings.Builder
+	for y := 0; y < len(bitmap); y += 2 {
+		for x := range bitmap[y] {
+			top := !bitmap[y][x]
+			bottom := y+1 < len(bitmap) && !bitmap[y+1][x]
+			switch {
+			case top && bottom:
+				sb.WriteString("█")
+			case top:
+				sb.WriteString("▀")
+			case bottom:
+				sb.WriteString("▄")
+			default:
+				sb.WriteString(" ")
+			}
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,45 @@
+package filenet
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestQRFrames(t *testing.T) {
+	sealed := bytes.Repeat([]byte("sealed bundle "), 100)
+	frames := QRFrames(sealed)
+	if len(frames) < 3 {
+		t.Fatalf("%d frames, want several", len(frames))
+	}
+
+	assembled, err := AssembleQRFrames(frames)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(assembled, sealed) {
+		t.Fatal("frames do not round trip")
+	}
+
+	// scanned out of order, with repeats and other lines in between
+	scanned := []string{"", "some other code"}
+	for i := len(frames) - 1; i >= 0; i-- {
+		scanned = append(scanned, "  "+frames[i], frames[i])
+	}
+	if assembled, err := AssembleQRFrames(scanned); err != nil || !bytes.Equal(assembled, sealed) {
+		t.Errorf("frames out of order: %v", err)
+	}
+
+	missing := append(append([]string{}, frames[:1]...), frames[2:]...)
+	if _, err := AssembleQRFrames(missing); err == nil || !strings.Contains(err.Error(), "frame 2 of") {
+		t.Errorf("missing frame: %v", err)
+	}
+
+	others := QRFrames(sealed[:len(sealed)/2])
+	if _, err := AssembleQRFrames(append(frames[:1:1], others[1:]...)); err == nil {
+		t.Error("frames of different bundles assembled")
+	}
+	if _, err := AssembleQRFrames(nil); err == nil {
+		t.Error("assembled no frames")
+	}
+}
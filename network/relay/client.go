@@ -0,0 +1,285 @@
+package relay
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	libp2pcrypto "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/shykerbogdan/mpc-wallet/user"
+	"golang.org/x/crypto/nacl/box"
+)
+
+var ErrClosed = errors.New("relay connection closed")
+
+// A message received from another member of the room
+type delivery struct {
+	from peer.ID
+	data []byte
+}
+
+// Client is a member of a room on a relay server. Everything published is encrypted separately to
+// each other member with nacl box, using box keys that are generated for every connection and
+// signed with our libp2p identity key, so the relay can neither read nor forge messages. As the relay
+// decides who is in the room, we only encrypt to the members whose identity key we trust, otherwise
+// the relay could add a member of its own and read along.
+type Client struct {
+	id      peer.ID
+	room    string
+	ws      *websocket.Conn
+	boxpub  *[32]byte
+	boxpriv *[32]byte
+
+	// Whether an identity key is one of the signers of the room
+	trusted func(libp2pcrypto.PubKey) bool
+
+	// The other members, keyed by their peer id
+	members map[peer.ID]roomMember
+
+	inbound chan delivery
+	done    chan struct{}
+	err     error
+
+	writeMutex sync.Mutex
+	mutex      sync.RWMutex
+}
+
+// The identity and box key of another member of the room
+type roomMember struct {
+	pubkey libp2pcrypto.PubKey
+	boxkey *[32]byte
+}
+
+// Connect to the relay at relayurl (e.g. wss://relay.example.com/ws) and join room as me. Messages are
+// only published to the members whose identity key trusted accepts.
+func Dial(relayurl string, room string, me user.Me, trusted func(libp2pcrypto.PubKey) bool) (*Client, error) {
+	u, err := url.Parse(relayurl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid relay url %s: %w", relayurl, err)
+	}
+	q := u.Query()
+	q.Set("room", room)
+	u.RawQuery = q.Encode()
+
+	ws, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to relay %s: %w", relayurl, err)
+	}
+	ws.SetReadLimit(maxFrameSize)
+
+	c, err := handshake(ws, room, me)
+	if err != nil {
+		ws.Close()
+		return nil, err
+	}
+	c.trusted = trusted
+
+	go c.readLoop()
+
+	return c, nil
+}
+
+func handshake(ws *websocket.Conn, room string, me user.Me) (*Client, error) {
+	_ = ws.SetReadDeadline(time.Now().Add(helloTimeout))
+	challenge := frame{}
+	if err := ws.ReadJSON(&challenge); err != nil {
+		return nil, err
+	}
+	if challenge.Type != frameTypeChallenge || len(challenge.Challenge) == 0 {
+		return nil, fmt.Errorf("unexpected relay frame %s", challenge.Type)
+	}
+
+	boxpub, boxpriv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	m, err := newMember(me.IdentPrivKey, room, boxpub[:], challenge.Challenge)
+	if err != nil {
+		return nil, err
+	}
+	if err := ws.WriteJSON(frame{Type: frameTypeHello, Member: m}); err != nil {
+		return nil, err
+	}
+	_ = ws.SetReadDeadline(time.Time{})
+
+	return &Client{
+		id:      me.PeerID(),
+		room:    room,
+		ws:      ws,
+		boxpub:  boxpub,
+		boxpriv: boxpriv,
+		members: make(map[peer.ID]roomMember),
+		inbound: make(chan delivery, 100),
+		done:    make(chan struct{}),
+	}, nil
+}
+
+// Our peer id as seen by the other members
+func (c *Client) ID() peer.ID {
+	return c.id
+}
+
+// Send data to every other trusted member currently in the room
+func (c *Client) Publish(ctx context.Context, data []byte) error {
+	for id, boxkey := range c.recipients() {
+		nonce := [24]byte{}
+		if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+			return err
+		}
+		sealed := box.Seal(nil, data, &nonce, boxkey, c.boxpriv)
+
+		if err := c.write(frame{Type: frameTypeMessage, To: id, Nonce: nonce[:], Box: sealed}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Block until the next message from another member arrives
+func (c *Client) Next(ctx context.Context) (peer.ID, []byte, error) {
+	select {
+	case d := <-c.inbound:
+		return d.from, d.data, nil
+	case <-c.done:
+		return "", nil, c.err
+	case <-ctx.Done():
+		return "", nil, ctx.Err()
+	}
+}
+
+// How many other trusted members are in the room, none once the connection is lost
+func (c *Client) Peers() int {
+	if c.Err() != nil {
+		return 0
+	}
+	return len(c.recipients())
+}
+
+// The box keys of the other members we publish to. Trust is checked every time, as signers are admitted
+// to the project while we are in the room.
+func (c *Client) recipients() map[peer.ID]*[32]byte {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	recipients := make(map[peer.ID]*[32]byte, len(c.members))
+	for id, m := range c.members {
+		if c.trusted(m.pubkey) {
+			recipients[id] = m.boxkey
+		}
+	}
+	return recipients
+}
+
+// Why the connection to the relay was lost, nil while it is up
+func (c *Client) Err() error {
+	select {
+	case <-c.done:
+		return c.err
+	default:
+		return nil
+	}
+}
+
+func (c *Client) Close() {
+	c.writeMutex.Lock()
+	_ = c.ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	c.writeMutex.Unlock()
+	c.ws.Close()
+}
+
+func (c *Client) write(f frame) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	_ = c.ws.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return c.ws.WriteJSON(f)
+}
+
+func (c *Client) readLoop() {
+	for {
+		f := frame{}
+		if err := c.ws.ReadJSON(&f); err != nil {
+			c.err = ErrClosed
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				c.err = fmt.Errorf("%w: %v", ErrClosed, err)
+			}
+			close(c.done)
+			return
+		}
+
+		switch f.Type {
+		case frameTypeMembers:
+			c.setMembers(f.Members)
+		case frameTypeMessage:
+			data, err := c.open(f)
+			if err != nil {
+				logger.Warn("Dropping a message", "room", c.room, "from", f.From, "err", err)
+				continue
+			}
+			c.inbound <- delivery{from: f.From, data: data}
+		case frameTypeError:
+			logger.Error("Relay error", "room", c.room, "err", f.Error)
+		}
+	}
+}
+
+// Decrypt a message, which only opens with the box key of the member it claims to be from. Messages of
+// members we do not trust are dropped, as we do not publish to them either.
+func (c *Client) open(f frame) ([]byte, error) {
+	c.mutex.RLock()
+	m, ok := c.members[f.From]
+	c.mutex.RUnlock()
+	if !ok {
+		return nil, errors.New("unknown member")
+	}
+	if !c.trusted(m.pubkey) {
+		return nil, errors.New("member is not a signer of the project")
+	}
+	if len(f.Nonce) != 24 {
+		return nil, errors.New("invalid nonce")
+	}
+	nonce := [24]byte{}
+	copy(nonce[:], f.Nonce)
+	data, ok := box.Open(nil, f.Box, &nonce, m.boxkey, c.boxpriv)
+	if !ok {
+		return nil, errors.New("message failed to decrypt")
+	}
+	return data, nil
+}
+
+// Replace the known members with the ones in the list whose signatures check out
+func (c *Client) setMembers(list []member) {
+	members := make(map[peer.ID]roomMember)
+	for _, m := range list {
+		id, boxkey, err := m.verify(c.room)
+		if err != nil {
+			logger.Warn("Ignoring a member with an invalid signature", "room", c.room, "err", err)
+			continue
+		}
+		if id == c.id {
+			continue
+		}
+		pubkey, err := libp2pcrypto.UnmarshalPublicKey(m.IdentPubKey)
+		if err != nil {
+			continue
+		}
+		members[id] = roomMember{pubkey: pubkey, boxkey: boxkey}
+	}
+
+	c.mutex.Lock()
+	previous := c.members
+	c.members = members
+	c.mutex.Unlock()
+
+	for id, m := range members {
+		if _, known := previous[id]; !known && !c.trusted(m.pubkey) {
+			logger.Warn("A member the relay lists is not a signer of the project, not publishing to it", "room", c.room, "member", id)
+		}
+	}
+}
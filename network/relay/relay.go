@@ -0,0 +1,106 @@
+package relay
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+
+	libp2pcrypto "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/shykerbogdan/mpc-wallet/logging"
+)
+
+var logger = logging.New("relay")
+
+// Frame types exchanged over the websocket between the relay server and its clients
+const (
+	// server -> client: a random challenge the client must sign to join a room
+	frameTypeChallenge = "challenge"
+	// client -> server: the signed member info of the client
+	frameTypeHello = "hello"
+	// server -> clients: everyone currently in the room, sent whenever it changes
+	frameTypeMembers = "members"
+	// client -> server -> client: an end-to-end encrypted message for a single member
+	frameTypeMessage = "msg"
+	// server -> client: something went wrong, the connection is closed after this
+	frameTypeError = "error"
+)
+
+// Messages larger than this are rejected, keygen messages with their proofs are the largest
+const maxFrameSize = 4 << 20
+
+type frame struct {
+	Type      string   `json:"type"`
+	Challenge []byte   `json:"challenge,omitempty"`
+	Member    *member  `json:"member,omitempty"`
+	Members   []member `json:"members,omitempty"`
+	From      peer.ID  `json:"from,omitempty"`
+	To        peer.ID  `json:"to,omitempty"`
+	Nonce     []byte   `json:"nonce,omitempty"`
+	Box       []byte   `json:"box,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// A member is a client in a room. The libp2p identity key of the client signs the short lived
+// box key that other members encrypt to, together with the room and the challenge of the server,
+// so that members can verify each other without having to trust the relay.
+type member struct {
+	IdentPubKey []byte `json:"identpubkey"`
+	BoxKey      []byte `json:"boxkey"`
+	Challenge   []byte `json:"challenge"`
+	Sig         []byte `json:"sig"`
+}
+
+var errInvalidMember = errors.New("invalid relay member signature")
+
+// The room id the relay sees instead of the project name
+func RoomID(project string) string {
+	h := sha256.Sum256([]byte("thresher-relay-room:" + project))
+	return hex.EncodeToString(h[:16])
+}
+
+func signedPayload(room string, boxkey []byte, challenge []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString("thresher-relay-member:")
+	b.WriteString(room)
+	b.WriteByte(0)
+	b.Write(boxkey)
+	b.Write(challenge)
+	return b.Bytes()
+}
+
+func newMember(privkey libp2pcrypto.PrivKey, room string, boxkey []byte, challenge []byte) (*member, error) {
+	pubkey, err := libp2pcrypto.MarshalPublicKey(privkey.GetPublic())
+	if err != nil {
+		return nil, err
+	}
+	sig, err := privkey.Sign(signedPayload(room, boxkey, challenge))
+	if err != nil {
+		return nil, err
+	}
+	return &member{IdentPubKey: pubkey, BoxKey: boxkey, Challenge: challenge, Sig: sig}, nil
+}
+
+// Check the signature of the member and return its peer id and box key
+func (m *member) verify(room string) (peer.ID, *[32]byte, error) {
+	if len(m.BoxKey) != 32 {
+		return "", nil, errInvalidMember
+	}
+	pubkey, err := libp2pcrypto.UnmarshalPublicKey(m.IdentPubKey)
+	if err != nil {
+		return "", nil, err
+	}
+	ok, err := pubkey.Verify(signedPayload(room, m.BoxKey, m.Challenge), m.Sig)
+	if err != nil || !ok {
+		return "", nil, errInvalidMember
+	}
+	id, err := peer.IDFromPublicKey(pubkey)
+	if err != nil {
+		return "", nil, err
+	}
+
+	boxkey := &[32]byte{}
+	copy(boxkey[:], m.BoxKey)
+	return id, boxkey, nil
+}
@@ -0,0 +1,166 @@
+package relay
+
+import (
+	"context"
+	"crypto/rand"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	libp2pcrypto "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/shykerbogdan/mpc-wallet/user"
+	"golang.org/x/crypto/nacl/box"
+)
+
+const testRoom = "room"
+
+func newTestMe(t *testing.T, nick string) user.Me {
+	me, err := user.NewMe(nick, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return me
+}
+
+// Trust only the identity keys of the signers
+func trusting(signers ...user.Me) func(libp2pcrypto.PubKey) bool {
+	return func(k libp2pcrypto.PubKey) bool {
+		for _, s := range signers {
+			if s.IdentPubKey.Equals(k) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func dialTest(t *testing.T, url string, me user.Me, trusted func(libp2pcrypto.PubKey) bool) *Client {
+	c, err := Dial(url, testRoom, me, trusted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+func newTestServer(t *testing.T) string {
+	srv := httptest.NewServer(NewServer())
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+// Wait until the client knows n other members of the room
+func waitMembers(t *testing.T, c *Client, n int) {
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		c.mutex.RLock()
+		known := len(c.members)
+		c.mutex.RUnlock()
+		if known == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("client knows %d members, want %d", known, n)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func nextWithin(c *Client, d time.Duration) (peer.ID, []byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	return c.Next(ctx)
+}
+
+func TestRoundTrip(t *testing.T) {
+	url := newTestServer(t)
+	alice, bob := newTestMe(t, "alice"), newTestMe(t, "bob")
+	ac := dialTest(t, url, alice, trusting(bob))
+	bc := dialTest(t, url, bob, trusting(alice))
+	waitMembers(t, ac, 1)
+	waitMembers(t, bc, 1)
+
+	if ac.Peers() != 1 {
+		t.Errorf("alice has %d peers", ac.Peers())
+	}
+	if err := ac.Publish(context.Background(), []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	from, data, err := nextWithin(bc, time.Second*5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != alice.PeerID() || string(data) != "hello" {
+		t.Errorf("bob received %q from %s", data, from)
+	}
+}
+
+func TestForgedFrom(t *testing.T) {
+	url := newTestServer(t)
+	alice, bob, mallory := newTestMe(t, "alice"), newTestMe(t, "bob"), newTestMe(t, "mallory")
+	dialTest(t, url, alice, trusting(bob))
+	bc := dialTest(t, url, bob, trusting(alice, mallory))
+	mc := dialTest(t, url, mallory, trusting(alice, bob))
+	waitMembers(t, bc, 2)
+	waitMembers(t, mc, 2)
+
+	// mallory, another signer, claims to be alice, the server sends it as from mallory
+	mc.mutex.RLock()
+	bobkey := mc.members[bob.PeerID()].boxkey
+	mc.mutex.RUnlock()
+	nonce := [24]byte{}
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		t.Fatal(err)
+	}
+	sealed := box.Seal(nil, []byte("from alice"), &nonce, bobkey, mc.boxpriv)
+	if err := mc.write(frame{Type: frameTypeMessage, From: alice.PeerID(), To: bob.PeerID(), Nonce: nonce[:], Box: sealed}); err != nil {
+		t.Fatal(err)
+	}
+	from, _, err := nextWithin(bc, time.Second*5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != mallory.PeerID() {
+		t.Errorf("message of mallory received from %s", from)
+	}
+
+	// a relay passing the forged sender on does not get it past bob, it does not open with alice's key
+	if _, err := bc.open(frame{Type: frameTypeMessage, From: alice.PeerID(), Nonce: nonce[:], Box: sealed}); err == nil {
+		t.Error("message sealed by mallory opened as from alice")
+	}
+}
+
+func TestUnknownMember(t *testing.T) {
+	url := newTestServer(t)
+	alice, bob, mallory := newTestMe(t, "alice"), newTestMe(t, "bob"), newTestMe(t, "mallory")
+	ac := dialTest(t, url, alice, trusting(bob))
+	bc := dialTest(t, url, bob, trusting(alice))
+	// the relay lets its own member into the room
+	mc := dialTest(t, url, mallory, trusting(alice, bob))
+	waitMembers(t, ac, 2)
+	waitMembers(t, bc, 2)
+
+	if ac.Peers() != 1 {
+		t.Errorf("alice publishes to %d peers", ac.Peers())
+	}
+	if err := ac.Publish(context.Background(), []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if _, data, err := nextWithin(bc, time.Second*5); err != nil || string(data) != "secret" {
+		t.Fatalf("bob received %q: %v", data, err)
+	}
+	if _, data, err := nextWithin(mc, time.Millisecond*500); err == nil {
+		t.Errorf("unknown member received %q", data)
+	}
+
+	// and what it sends is not taken
+	if err := mc.Publish(context.Background(), []byte("from mallory")); err != nil {
+		t.Fatal(err)
+	}
+	if _, data, err := nextWithin(bc, time.Millisecond*500); err == nil {
+		t.Errorf("bob received %q from the unknown member", data)
+	}
+}
@@ -0,0 +1,241 @@
+package relay
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+const (
+	// A client has this long to answer the challenge
+	helloTimeout = time.Second * 10
+
+	// Connections that did not answer a ping for this long are dropped
+	pongTimeout  = time.Second * 60
+	pingInterval = time.Second * 30
+	writeTimeout = time.Second * 10
+
+	// Frames queued for a client that does not keep up are dropped along with the client
+	sendQueueSize = 256
+)
+
+// Server is a small relay that forwards end-to-end encrypted messages between the members of a room,
+// for signers that can not reach each other over libp2p, e.g. behind strict corporate firewalls.
+// It only ever sees room ids, the public identity keys of the members and ciphertext.
+type Server struct {
+	upgrader websocket.Upgrader
+
+	rooms map[string]map[peer.ID]*serverConn
+	mutex sync.Mutex
+}
+
+type serverConn struct {
+	ws     *websocket.Conn
+	room   string
+	id     peer.ID
+	member member
+	send   chan frame
+	closed bool
+}
+
+func NewServer() *Server {
+	return &Server{
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  32 * 1024,
+			WriteBufferSize: 32 * 1024,
+		},
+		rooms: make(map[string]map[peer.ID]*serverConn),
+	}
+}
+
+// Handle a client websocket connection, the room to join is given as the room query parameter
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	room := r.URL.Query().Get("room")
+	if room == "" {
+		http.Error(w, "room is required", http.StatusBadRequest)
+		return
+	}
+
+	ws, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logger.Warn("Error upgrading the connection", "remote", r.RemoteAddr, "err", err)
+		return
+	}
+	ws.SetReadLimit(maxFrameSize)
+
+	c, err := s.handshake(ws, room)
+	if err != nil {
+		logger.Warn("Handshake failed", "remote", r.RemoteAddr, "err", err)
+		_ = ws.WriteJSON(frame{Type: frameTypeError, Error: err.Error()})
+		ws.Close()
+		return
+	}
+
+	logger.Info("Member joined", "member", c.id, "room", room, "remote", r.RemoteAddr)
+	go c.writeLoop()
+	s.join(c)
+	s.readLoop(c)
+	s.leave(c)
+	logger.Info("Member left", "member", c.id, "room", room)
+}
+
+// Make the client prove it holds the private key of the identity it claims
+func (s *Server) handshake(ws *websocket.Conn, room string) (*serverConn, error) {
+	challenge := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, challenge); err != nil {
+		return nil, err
+	}
+	if err := ws.WriteJSON(frame{Type: frameTypeChallenge, Challenge: challenge}); err != nil {
+		return nil, err
+	}
+
+	_ = ws.SetReadDeadline(time.Now().Add(helloTimeout))
+	hello := frame{}
+	if err := ws.ReadJSON(&hello); err != nil {
+		return nil, err
+	}
+	if hello.Type != frameTypeHello || hello.Member == nil || !bytes.Equal(hello.Member.Challenge, challenge) {
+		return nil, errInvalidMember
+	}
+	id, _, err := hello.Member.verify(room)
+	if err != nil {
+		return nil, err
+	}
+
+	return &serverConn{
+		ws:     ws,
+		room:   room,
+		id:     id,
+		member: *hello.Member,
+		send:   make(chan frame, sendQueueSize),
+	}, nil
+}
+
+func (s *Server) join(c *serverConn) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	members, ok := s.rooms[c.room]
+	if !ok {
+		members = make(map[peer.ID]*serverConn)
+		s.rooms[c.room] = members
+	}
+	// The same identity connecting again replaces its previous, probably dead, connection
+	if old, ok := members[c.id]; ok {
+		old.close()
+	}
+	members[c.id] = c
+
+	s.broadcastMembers(c.room)
+}
+
+func (s *Server) leave(c *serverConn) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	c.close()
+
+	members := s.rooms[c.room]
+	if members[c.id] != c {
+		return
+	}
+	delete(members, c.id)
+	if len(members) == 0 {
+		delete(s.rooms, c.room)
+		return
+	}
+
+	s.broadcastMembers(c.room)
+}
+
+// Must be called with the mutex held
+func (s *Server) broadcastMembers(room string) {
+	f := frame{Type: frameTypeMembers}
+	for _, c := range s.rooms[room] {
+		f.Members = append(f.Members, c.member)
+	}
+	for _, c := range s.rooms[room] {
+		c.queue(f)
+	}
+}
+
+func (s *Server) readLoop(c *serverConn) {
+	_ = c.ws.SetReadDeadline(time.Now().Add(pongTimeout))
+	c.ws.SetPongHandler(func(string) error {
+		return c.ws.SetReadDeadline(time.Now().Add(pongTimeout))
+	})
+
+	for {
+		f := frame{}
+		if err := c.ws.ReadJSON(&f); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				logger.Warn("Error reading from member", "member", c.id, "err", err)
+			}
+			return
+		}
+		if f.Type != frameTypeMessage {
+			continue
+		}
+
+		// Never trust the sender the client claims, it is whoever answered the challenge
+		f.From = c.id
+
+		s.mutex.Lock()
+		to, ok := s.rooms[c.room][f.To]
+		if ok {
+			to.queue(f)
+		}
+		s.mutex.Unlock()
+	}
+}
+
+// Queueing and closing must be done with the server mutex held
+func (c *serverConn) queue(f frame) {
+	if c.closed {
+		return
+	}
+	select {
+	case c.send <- f:
+	default:
+		logger.Warn("Member is not keeping up, dropping it", "member", c.id)
+		c.close()
+	}
+}
+
+func (c *serverConn) close() {
+	if !c.closed {
+		c.closed = true
+		close(c.send)
+	}
+}
+
+func (c *serverConn) writeLoop() {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	defer c.ws.Close()
+
+	for {
+		select {
+		case f, ok := <-c.send:
+			_ = c.ws.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if !ok {
+				_ = c.ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				return
+			}
+			if err := c.ws.WriteJSON(f); err != nil {
+				return
+			}
+		case <-ticker.C:
+			_ = c.ws.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := c.ws.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
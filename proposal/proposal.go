@@ -0,0 +1,278 @@
+package proposal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shykerbogdan/mpc-wallet/user"
+)
+
+type Type string
+
+const (
+	TypeKeygen  Type = "keygen"
+	TypeSign    Type = "sign"
+	TypeSendTx  Type = "sendtx"
+	TypeReshare Type = "reshare"
+)
+
+type Status string
+
+const (
+	// Waiting for approvals
+	StatusPending Status = "pending"
+	// Enough approvers were online and the protocol has been started
+	StatusStarted  Status = "started"
+	StatusDone     Status = "done"
+	StatusFailed   Status = "failed"
+	StatusRejected Status = "rejected"
+	StatusExpired  Status = "expired"
+)
+
+// How long a proposal waits for approvals by default
+const DefaultTTL = time.Hour * 24
+
+// An Approval or rejection of a proposal, signed with the identity key of the approver
+type Approval struct {
+	Nick    string
+	Approve bool
+	Sig     []byte
+}
+
+// A Proposal to run a protocol, which starts once Required of the Approvers have approved it and are online.
+// The ID is the hash of the proposal, so approving the ID approves exactly this proposal, and the proposer
+// approves it when creating it. Proposals are broadcast to the approvers again whenever one of them joins,
+// so approvals can be collected over time.
+type Proposal struct {
+	ID        string
+	Type      Type
+	Wallet    string
+	Summary   string
+	Proposer  string
+	Created   time.Time
+	Expires   time.Time
+	Required  int
+	Approvers []user.User
+	// The parameters of the protocol to run, depending on the Type
+	Command   json.RawMessage
+	Approvals []Approval
+	// Local state of the proposal, not part of the ID
+	Status Status
+}
+
+// Create a new proposal, approved by the proposer
+func New(t Type, wallet string, summary string, proposer user.Me, required int, approvers []user.User, command interface{}, ttl time.Duration) (*Proposal, error) {
+	if required <= 0 || required > len(approvers) {
+		return nil, fmt.Errorf("proposal needs %d approvals but only has %d approvers", required, len(approvers))
+	}
+	cmd, err := json.Marshal(command)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	p := &Proposal{
+		Type:      t,
+		Wallet:    wallet,
+		Summary:   summary,
+		Proposer:  proposer.Nick,
+		Created:   now,
+		Expires:   now.Add(ttl),
+		Required:  required,
+		Approvers: approvers,
+		Command:   cmd,
+		Status:    StatusPending,
+	}
+	digest, err := p.digest()
+	if err != nil {
+		return nil, err
+	}
+	p.ID = hex.EncodeToString(digest)
+
+	if err := p.Approve(proposer, true); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *Proposal) digest() ([]byte, error) {
+	data, err := json.Marshal(struct {
+		Type      Type
+		Wallet    string
+		Summary   string
+		Proposer  string
+		Created   time.Time
+		Expires   time.Time
+		Required  int
+		Approvers []user.User
+		Command   json.RawMessage
+	}{p.Type, p.Wallet, p.Summary, p.Proposer, p.Created, p.Expires, p.Required, p.Approvers, p.Command})
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(data)
+	return digest[:], nil
+}
+
+func approvalPayload(id string, approve bool) []byte {
+	return []byte(fmt.Sprintf("thresher-proposal:%s:%t", id, approve))
+}
+
+// A short form of the ID for display and for typing into /approve and /reject
+func (p *Proposal) ShortID() string {
+	if len(p.ID) < 8 {
+		return p.ID
+	}
+	return p.ID[:8]
+}
+
+func (p *Proposal) Approver(nick string) (user.User, bool) {
+	for _, u := range p.Approvers {
+		if u.Nick == nick {
+			return u, true
+		}
+	}
+	return user.User{}, false
+}
+
+// Whether nick approved (true) or rejected (false) the proposal, and whether it decided at all
+func (p *Proposal) Decision(nick string) (approve bool, decided bool) {
+	for _, a := range p.Approvals {
+		if a.Nick == nick {
+			return a.Approve, true
+		}
+	}
+	return false, false
+}
+
+// Approve or reject the proposal as me
+func (p *Proposal) Approve(me user.Me, approve bool) error {
+	if _, ok := p.Approver(me.Nick); !ok {
+		return fmt.Errorf("%s is not an approver of proposal %s", me.Nick, p.ShortID())
+	}
+	if approved, decided := p.Decision(me.Nick); decided {
+		if approved {
+			return fmt.Errorf("proposal %s has already been approved by %s", p.ShortID(), me.Nick)
+		}
+		return fmt.Errorf("proposal %s has already been rejected by %s", p.ShortID(), me.Nick)
+	}
+
+	sig, err := me.IdentPrivKey.Sign(approvalPayload(p.ID, approve))
+	if err != nil {
+		return err
+	}
+	p.Approvals = append(p.Approvals, Approval{Nick: me.Nick, Approve: approve, Sig: sig})
+	return nil
+}
+
+// Check the ID matches the proposal, every approval is signed by its approver and the proposer approved it
+func (p *Proposal) Verify() error {
+	digest, err := p.digest()
+	if err != nil {
+		return err
+	}
+	if p.ID != hex.EncodeToString(digest) {
+		return errors.New("proposal does not match its ID")
+	}
+	if p.Required <= 0 || p.Required > len(p.Approvers) {
+		return fmt.Errorf("proposal %s needs %d approvals but only has %d approvers", p.ShortID(), p.Required, len(p.Approvers))
+	}
+
+	seen := make(map[string]bool)
+	for _, a := range p.Approvals {
+		if seen[a.Nick] {
+			return fmt.Errorf("proposal %s has more than one decision by %s", p.ShortID(), a.Nick)
+		}
+		seen[a.Nick] = true
+		if err := p.verifyApproval(a); err != nil {
+			return err
+		}
+	}
+
+	if approved, _ := p.Decision(p.Proposer); !approved {
+		return fmt.Errorf("proposal %s is not approved by its proposer %s", p.ShortID(), p.Proposer)
+	}
+	return nil
+}
+
+func (p *Proposal) verifyApproval(a Approval) error {
+	u, ok := p.Approver(a.Nick)
+	if !ok {
+		return fmt.Errorf("%s is not an approver of proposal %s", a.Nick, p.ShortID())
+	}
+	valid, err := u.IdentPubKey.Verify(approvalPayload(p.ID, a.Approve), a.Sig)
+	if err != nil || !valid {
+		return fmt.Errorf("invalid signature of %s on proposal %s", a.Nick, p.ShortID())
+	}
+	return nil
+}
+
+// Add the approvals of another copy of the same proposal that we do not have yet, returning the new ones
+func (p *Proposal) Merge(other *Proposal) ([]Approval, error) {
+	if other.ID != p.ID {
+		return nil, errors.New("can not merge different proposals")
+	}
+
+	added := []Approval{}
+	for _, a := range other.Approvals {
+		if _, decided := p.Decision(a.Nick); decided {
+			continue
+		}
+		if err := p.verifyApproval(a); err != nil {
+			return nil, err
+		}
+		p.Approvals = append(p.Approvals, a)
+		added = append(added, a)
+	}
+	return added, nil
+}
+
+// Nicks of the approvers that approved the proposal
+func (p *Proposal) Approved() []string {
+	nicks := []string{}
+	for _, a := range p.Approvals {
+		if a.Approve {
+			nicks = append(nicks, a.Nick)
+		}
+	}
+	return nicks
+}
+
+// Too many approvers rejected the proposal for it to ever get enough approvals
+func (p *Proposal) IsRejected() bool {
+	rejected := 0
+	for _, a := range p.Approvals {
+		if !a.Approve {
+			rejected++
+		}
+	}
+	return len(p.Approvers)-rejected < p.Required
+}
+
+func (p *Proposal) IsExpired(now time.Time) bool {
+	return now.After(p.Expires)
+}
+
+// Waiting for approvals or running the protocol
+func (p *Proposal) IsOpen() bool {
+	return p.Status == StatusPending || p.Status == StatusStarted
+}
+
+func (p *Proposal) UnmarshalCommand(v interface{}) error {
+	return json.Unmarshal(p.Command, v)
+}
+
+func (p *Proposal) String() string {
+	return fmt.Sprintf("%s %s by %s: %s (%d of %d approvals, %s)", p.ShortID(), p.Type, p.Proposer, p.Summary, len(p.Approved()), p.Required, p.Status)
+}
+
+func (p *Proposal) copy() *Proposal {
+	c := *p
+	c.Approvers = append([]user.User{}, p.Approvers...)
+	c.Approvals = append([]Approval{}, p.Approvals...)
+	return &c
+}
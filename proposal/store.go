@@ -0,0 +1,152 @@
+package proposal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Store keeps the proposals we are an approver of in a file next to the config, so pending proposals
+// survive a restart. Proposals are handed out as copies and only changed through Update.
+type Store struct {
+	filename  string
+	mutex     sync.Mutex
+	proposals map[string]*Proposal
+}
+
+// Open the proposals file, which is created on the first write. If it can not be read the returned
+// store is still usable but starts empty, and the error says why.
+func Open(filename string) (*Store, error) {
+	s := &Store{
+		filename:  filename,
+		proposals: make(map[string]*Proposal),
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+
+	list := []*Proposal{}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return s, fmt.Errorf("proposals file %s can not be decoded: %w", filename, err)
+	}
+	for _, p := range list {
+		s.proposals[p.ID] = p
+	}
+	return s, nil
+}
+
+// Add a proposal, or replace the one with the same ID
+func (s *Store) Put(p *Proposal) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.proposals[p.ID] = p.copy()
+	return s.persist()
+}
+
+func (s *Store) Get(id string) *Proposal {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	p, ok := s.proposals[id]
+	if !ok {
+		return nil
+	}
+	return p.copy()
+}
+
+// Find the proposal with the given ID or unique ID prefix, as typed by a user
+func (s *Store) Find(prefix string) (*Proposal, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if prefix == "" {
+		return nil, errors.New("no proposal ID given")
+	}
+	var found *Proposal
+	for id, p := range s.proposals {
+		if strings.HasPrefix(id, prefix) {
+			if found != nil {
+				return nil, fmt.Errorf("more than one proposal starts with %s", prefix)
+			}
+			found = p
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("proposal %s not found", prefix)
+	}
+	return found.copy(), nil
+}
+
+// Change a proposal in place and save it, fn returns an error to leave the proposal unchanged
+func (s *Store) Update(id string, fn func(p *Proposal) error) (*Proposal, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	p, ok := s.proposals[id]
+	if !ok {
+		return nil, fmt.Errorf("proposal %s not found", id)
+	}
+	c := p.copy()
+	if err := fn(c); err != nil {
+		return nil, err
+	}
+	s.proposals[id] = c
+	return c.copy(), s.persist()
+}
+
+// All proposals, oldest first
+func (s *Store) List() []*Proposal {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	list := []*Proposal{}
+	for _, p := range s.proposals {
+		list = append(list, p.copy())
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Created.Before(list[j].Created)
+	})
+	return list
+}
+
+// Proposals waiting for approvals or running, oldest first
+func (s *Store) Active() []*Proposal {
+	list := []*Proposal{}
+	for _, p := range s.List() {
+		if p.IsOpen() {
+			list = append(list, p)
+		}
+	}
+	return list
+}
+
+func (s *Store) persist() error {
+	if s.filename == "" {
+		return nil
+	}
+
+	list := []*Proposal{}
+	for _, p := range s.proposals {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Created.Before(list[j].Created)
+	})
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.filename, data, 0600)
+}
@@ -0,0 +1,107 @@
+package proposal
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shykerbogdan/mpc-wallet/user"
+)
+
+func newTestMes(t *testing.T, nicks ...string) ([]user.Me, []user.User) {
+	mes := []user.Me{}
+	users := []user.User{}
+	for _, nick := range nicks {
+		me, err := user.NewMe(nick, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		mes = append(mes, me)
+		users = append(users, me.User)
+	}
+	return mes, users
+}
+
+func TestStorePersists(t *testing.T) {
+	mes, users := newTestMes(t, "alice", "bob", "carol")
+	filename := filepath.Join(t.TempDir(), "proposals.json")
+	s, err := Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := New(TypeSign, "w", "sign hello", mes[0], 2, users, map[string]string{"Message": "hello"}, DefaultTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(p); err != nil {
+		t.Fatal(err)
+	}
+	done, err := New(TypeSign, "w", "sign bye", mes[0], 2, users, map[string]string{"Message": "bye"}, DefaultTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done.Status = StatusDone
+	if err := s.Put(done); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Update(p.ID, func(p *Proposal) error { return p.Approve(mes[1], true) }); err != nil {
+		t.Fatal(err)
+	}
+
+	// changing a proposal handed out does not change the store
+	got := s.Get(p.ID)
+	got.Status = StatusFailed
+	if s.Get(p.ID).Status != StatusPending {
+		t.Error("proposal changed outside of Update")
+	}
+
+	reopened, err := Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reopened.List()) != 2 {
+		t.Fatalf("reopened store has %d proposals, want 2", len(reopened.List()))
+	}
+	active := reopened.Active()
+	if len(active) != 1 || active[0].ID != p.ID {
+		t.Fatalf("reopened store has %d active proposals", len(active))
+	}
+	if approved := active[0].Approved(); len(approved) != 2 || approved[1] != "bob" {
+		t.Errorf("reopened proposal approved by %v", approved)
+	}
+	if err := active[0].Verify(); err != nil {
+		t.Errorf("reopened proposal does not verify: %v", err)
+	}
+	if found, err := reopened.Find(p.ID[:8]); err != nil || found.ID != p.ID {
+		t.Errorf("found %v by prefix: %v", found, err)
+	}
+}
+
+func TestStoreUnreadable(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "proposals.json")
+	if err := ioutil.WriteFile(filename, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := Open(filename)
+	if err == nil || !strings.Contains(err.Error(), "can not be decoded") {
+		t.Errorf("opened a damaged file: %v", err)
+	}
+	if s == nil || len(s.List()) != 0 {
+		t.Fatal("no empty store for a damaged file")
+	}
+
+	mes, users := newTestMes(t, "alice")
+	p, err := New(TypeSign, "w", "sign", mes[0], 1, users, nil, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(filename); err != nil {
+		t.Errorf("store not usable after a damaged file: %v", err)
+	}
+}
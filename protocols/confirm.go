@@ -0,0 +1,204 @@
+package protocols
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/shykerbogdan/mpc-wallet/network"
+	"github.com/shykerbogdan/mpc-wallet/user"
+	"github.com/shykerbogdan/mpc-wallet/utils"
+	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet"
+	"github.com/taurusgroup/multi-party-sig/pkg/party"
+	"github.com/taurusgroup/multi-party-sig/pkg/protocol"
+	"github.com/taurusgroup/multi-party-sig/protocols/cmp/config"
+)
+
+// After keygen every party broadcasts the fingerprint of the group public key and the signers it ended up with,
+// signed with its identity key. The new wallet can only sign once the fingerprints of all parties match ours,
+// until then it is kept Unconfirmed and RunConfirm runs the confirmation again. The fingerprint is shown as
+// words too, for the signers to compare out-of-band.
+
+// The protocol id of the confirmations, so the keygen handler does not take them
+const protocolConfirm = "thresher/keygen-confirm"
+
+// How many words of the fingerprint are shown
+const fingerprintWords = 6
+
+// How long the signers online together have to confirm keygen
+const DefaultConfirmTimeout = time.Minute * 2
+
+var (
+	ErrFingerprintMismatch = errors.New("keygen fingerprints do not match")
+	ErrNotConfirmed        = errors.New("keygen was not confirmed by all signers")
+)
+
+// What a party signs to confirm the result of keygen
+type keygenConfirmation struct {
+	Fingerprint []byte
+	Signature   []byte
+}
+
+// The fingerprint of the group public key, threshold and signers of a keygen result
+func fingerprint(c *config.Config) ([]byte, error) {
+	pub, err := c.PublicPoint().MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	h.Write([]byte("thresher keygen fingerprint v1"))
+	h.Write(pub)
+	binary.Write(h, binary.BigEndian, uint32(c.Threshold))
+	for _, id := range c.PartyIDs() {
+		binary.Write(h, binary.BigEndian, uint32(len(id)))
+		h.Write([]byte(id))
+	}
+	return h.Sum(nil), nil
+}
+
+// The fingerprint of a wallet as words, the same for all its signers
+func Fingerprint(w *ethwallet.Wallet) string {
+	c := w.GetUnwrappedKeyData()
+	fp, err := fingerprint(&c)
+	if err != nil {
+		return ""
+	}
+	return fingerprintString(fp)
+}
+
+func fingerprintString(fp []byte) string {
+	words := []string{}
+	for _, b := range fp[:fingerprintWords] {
+		words = append(words, utils.Words[b])
+	}
+	return strings.Join(words, " ")
+}
+
+func confirmationPayload(fp []byte) []byte {
+	return append([]byte("thresher keygen confirmation\x00"), fp...)
+}
+
+// Run the confirmation of an Unconfirmed wallet again, with all other signers sending theirs with
+// SendConfirmation. The wallet is marked confirmed once all fingerprints match ours.
+func RunConfirm(me user.Me, w *ethwallet.Wallet, net network.Network, timeout time.Duration) error {
+	c := w.GetUnwrappedKeyData()
+	if err := confirmKeygen(me, w, &c, net, nil, timeout); err != nil {
+		return err
+	}
+	w.Unconfirmed = false
+	logger.Info("Keygen confirmed by all signers", "wallet", w.Name)
+	return nil
+}
+
+// Send our confirmation of the keygen result of a wallet to a signer running RunConfirm
+func SendConfirmation(me user.Me, w *ethwallet.Wallet, net network.Network) error {
+	c := w.GetUnwrappedKeyData()
+	msg, fp, err := confirmation(me, w.Me.PartyID(), &c)
+	if err != nil {
+		return err
+	}
+	net.Send(msg)
+	logger.Info("Sent keygen confirmation", "wallet", w.Name, "fingerprint", fingerprintString(fp))
+	return nil
+}
+
+// Our signed confirmation of a keygen result, broadcast to the other signers, and its fingerprint
+func confirmation(me user.Me, selfid party.ID, c *config.Config) (*protocol.Message, []byte, error) {
+	fp, err := fingerprint(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	sig, err := me.IdentPrivKey.Sign(confirmationPayload(fp))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error signing the keygen confirmation: %w", err)
+	}
+	data, err := cbor.Marshal(keygenConfirmation{Fingerprint: fp, Signature: sig})
+	if err != nil {
+		return nil, nil, err
+	}
+	msg := &protocol.Message{
+		From:        selfid,
+		Protocol:    protocolConfirm,
+		RoundNumber: 1,
+		Data:        data,
+		Broadcast:   true,
+	}
+	return msg, fp, nil
+}
+
+// Broadcast our confirmation of the keygen result and wait up to timeout for those of all other signers.
+// Confirmations already taken from the network during keygen are passed in early.
+func confirmKeygen(me user.Me, w *ethwallet.Wallet, c *config.Config, net network.Network, early []*protocol.Message, timeout time.Duration) error {
+	selfid := w.Me.PartyID()
+	msg, fp, err := confirmation(me, selfid, c)
+	if err != nil {
+		return err
+	}
+	go net.Send(msg)
+	logger.Info("Confirming keygen", "wallet", w.Name, "fingerprint", fingerprintString(fp))
+
+	pending := make(map[party.ID]user.User)
+	for _, u := range w.Others {
+		pending[u.PartyID()] = u
+	}
+
+	accept := func(msg *protocol.Message) error {
+		if msg.Protocol != protocolConfirm {
+			return nil
+		}
+		u, ok := pending[msg.From]
+		if !ok {
+			return nil
+		}
+		confirmation := keygenConfirmation{}
+		if err := cbor.Unmarshal(msg.Data, &confirmation); err != nil {
+			return fmt.Errorf("invalid keygen confirmation from %s: %w", u.Nick, err)
+		}
+		if ok, err := u.IdentPubKey.Verify(confirmationPayload(confirmation.Fingerprint), confirmation.Signature); err != nil || !ok {
+			return fmt.Errorf("keygen confirmation from %s has an invalid signature", u.Nick)
+		}
+		if !bytes.Equal(confirmation.Fingerprint, fp) {
+			return fmt.Errorf("%w: %s has %s, we have %s", ErrFingerprintMismatch, u.Nick, fingerprintString(confirmation.Fingerprint), fingerprintString(fp))
+		}
+		logger.Debug("Keygen confirmed", "wallet", w.Name, "signer", u.Nick)
+		delete(pending, msg.From)
+		return nil
+	}
+
+	for _, msg := range early {
+		if err := accept(msg); err != nil {
+			return err
+		}
+	}
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for len(pending) > 0 {
+		select {
+		case msg, ok := <-net.Next(selfid):
+			if !ok {
+				return fmt.Errorf("%w: network closed waiting for %s", ErrNotConfirmed, pendingNicks(pending))
+			}
+			if err := accept(msg); err != nil {
+				return err
+			}
+		case <-deadline.C:
+			return fmt.Errorf("%w: %s did not confirm within %s", ErrNotConfirmed, pendingNicks(pending), timeout)
+		}
+	}
+	return nil
+}
+
+func pendingNicks(pending map[party.ID]user.User) string {
+	nicks := []string{}
+	for _, u := range pending {
+		nicks = append(nicks, u.Nick)
+	}
+	sort.Strings(nicks)
+	return strings.Join(nicks, ", ")
+}
@@ -0,0 +1,152 @@
+package protocols
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/shykerbogdan/mpc-wallet/network"
+	"github.com/shykerbogdan/mpc-wallet/network/channet"
+	"github.com/shykerbogdan/mpc-wallet/user"
+	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet"
+	"github.com/taurusgroup/multi-party-sig/pkg/party"
+	"github.com/taurusgroup/multi-party-sig/pkg/protocol"
+)
+
+// Run keygen between two new signers, returning their identities and wallets
+func keygen(t *testing.T) ([]user.Me, []*ethwallet.Wallet) {
+	mes := []user.Me{}
+	ids := party.IDSlice{}
+	for _, nick := range []string{"alice", "bob"} {
+		me, err := user.NewMe(nick, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		mes = append(mes, me)
+		ids = append(ids, me.PartyID())
+	}
+
+	net := channet.NewNetwork(party.NewIDSlice(ids))
+	wallets := []*ethwallet.Wallet{}
+	errs := make(chan error, len(mes))
+	for i, me := range mes {
+		w := ethwallet.NewEmptyWallet("goerli", "w", 1, me.User, []user.User{mes[1-i].User})
+		wallets = append(wallets, w)
+		go func(me user.Me) { errs <- RunKeygen(me, w, net, DefaultConfirmTimeout) }(me)
+	}
+	for range mes {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return mes, wallets
+}
+
+func TestConfirmKeygen(t *testing.T) {
+	mes, wallets := keygen(t)
+	alice, bob := wallets[0], wallets[1]
+
+	if alice.Address == "" || alice.Address != bob.Address {
+		t.Fatalf("addresses %s and %s", alice.Address, bob.Address)
+	}
+	fp := Fingerprint(alice)
+	if len(strings.Fields(fp)) != fingerprintWords || fp != Fingerprint(bob) {
+		t.Errorf("fingerprints %q and %q", fp, Fingerprint(bob))
+	}
+
+	// bob claims a different result, or alice's key signs for him
+	c := alice.GetUnwrappedKeyData()
+	confirmation := func(signer user.Me, fp []byte) *protocol.Message {
+		sig, err := signer.IdentPrivKey.Sign(confirmationPayload(fp))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := cbor.Marshal(keygenConfirmation{Fingerprint: fp, Signature: sig})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &protocol.Message{From: bob.Me.PartyID(), Protocol: protocolConfirm, RoundNumber: 1, Data: data, Broadcast: true}
+	}
+	other := make([]byte, 32)
+
+	net := channet.NewNetwork(party.NewIDSlice([]party.ID{alice.Me.PartyID()}))
+	err := confirmKeygen(mes[0], alice, &c, net, []*protocol.Message{confirmation(mes[1], other)}, time.Second)
+	if !errors.Is(err, ErrFingerprintMismatch) {
+		t.Errorf("different fingerprint confirmed: %v", err)
+	}
+	ours, _ := fingerprint(&c)
+	err = confirmKeygen(mes[0], alice, &c, net, []*protocol.Message{confirmation(mes[0], ours)}, time.Second)
+	if err == nil || !strings.Contains(err.Error(), "invalid signature") {
+		t.Errorf("confirmation signed by another key accepted: %v", err)
+	}
+
+	// bob never confirms
+	err = confirmKeygen(mes[0], alice, &c, net, nil, time.Millisecond*100)
+	if !errors.Is(err, ErrNotConfirmed) || !strings.Contains(err.Error(), "bob") {
+		t.Errorf("missing confirmation: %v", err)
+	}
+}
+
+// Drops the confirmations sent through it
+type droppingConfirmations struct {
+	network.Network
+}
+
+func (n droppingConfirmations) Send(msg *protocol.Message) {
+	if msg.Protocol != protocolConfirm {
+		n.Network.Send(msg)
+	}
+}
+
+func TestUnconfirmedKeygen(t *testing.T) {
+	mes := []user.Me{}
+	ids := party.IDSlice{}
+	for _, nick := range []string{"alice", "bob"} {
+		me, err := user.NewMe(nick, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		mes = append(mes, me)
+		ids = append(ids, me.PartyID())
+	}
+	alice := ethwallet.NewEmptyWallet("goerli", "w", 1, mes[0].User, []user.User{mes[1].User})
+	bob := ethwallet.NewEmptyWallet("goerli", "w", 1, mes[1].User, []user.User{mes[0].User})
+
+	// bob's confirmation never reaches alice
+	net := channet.NewNetwork(party.NewIDSlice(ids))
+	errs := make(chan error, 2)
+	go func() { errs <- RunKeygen(mes[0], alice, net, time.Second*2) }()
+	go func() { errs <- RunKeygen(mes[1], bob, droppingConfirmations{net}, DefaultConfirmTimeout) }()
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil && !errors.Is(err, ErrNotConfirmed) {
+			t.Fatal(err)
+		}
+	}
+
+	// alice keeps her share, but can not sign with it
+	if !alice.Unconfirmed || bob.Unconfirmed {
+		t.Fatalf("alice unconfirmed %t, bob unconfirmed %t", alice.Unconfirmed, bob.Unconfirmed)
+	}
+	if alice.Address == "" || alice.Address != bob.Address {
+		t.Fatalf("addresses %s and %s", alice.Address, bob.Address)
+	}
+	if _, err := RunSign(alice, make([]byte, 32), []user.User{alice.Me, bob.Me}, net); !errors.Is(err, ethwallet.ErrUnconfirmed) {
+		t.Errorf("unconfirmed wallet signed: %v", err)
+	}
+
+	// the confirmation is run again
+	net = channet.NewNetwork(party.NewIDSlice(ids))
+	// the network only queues messages once a party listens
+	net.Next(alice.Me.PartyID())
+	if err := SendConfirmation(mes[1], bob, net); err != nil {
+		t.Fatal(err)
+	}
+	if err := RunConfirm(mes[0], alice, net, time.Second*5); err != nil {
+		t.Fatal(err)
+	}
+	if alice.Unconfirmed {
+		t.Error("wallet still unconfirmed")
+	}
+}
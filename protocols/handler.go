@@ -1,12 +1,56 @@
 package protocols
 
 import (
+	"time"
+
+	"github.com/shykerbogdan/mpc-wallet/logging"
+	"github.com/shykerbogdan/mpc-wallet/metrics"
 	"github.com/shykerbogdan/mpc-wallet/network"
 	"github.com/taurusgroup/multi-party-sig/pkg/party"
 	"github.com/taurusgroup/multi-party-sig/pkg/protocol"
 )
 
-func handlerLoop(id party.ID, h protocol.Handler, network network.Network) {
+var logger = logging.New("protocol")
+
+// Protocol types for the metrics
+const (
+	typeKeygen  = "keygen"
+	typeSign    = "sign"
+	typeReshare = "reshare"
+)
+
+// Count a session of a protocol as started. Call the returned func with its outcome once it is done.
+func countSession(protocolType string) func(err error) {
+	metrics.ProtocolSessions.WithLabelValues(protocolType, metrics.ResultStarted).Inc()
+	return func(err error) {
+		result := metrics.ResultSucceeded
+		if err != nil {
+			result = metrics.ResultFailed
+		}
+		metrics.ProtocolSessions.WithLabelValues(protocolType, result).Inc()
+	}
+}
+
+// Times the rounds of a protocol, a round ends when we move on to the next
+type roundTimer struct {
+	protocolType string
+	start        time.Time
+}
+
+func newRoundTimer(protocolType string) *roundTimer {
+	return &roundTimer{protocolType: protocolType, start: time.Now()}
+}
+
+// The current round ended, the next starts now
+func (rt *roundTimer) next() {
+	now := time.Now()
+	metrics.ProtocolRoundSeconds.WithLabelValues(rt.protocolType).Observe(now.Sub(rt.start).Seconds())
+	rt.start = now
+}
+
+// Run a protocol handler until it is done. Returns the keygen confirmations of parties that finished before us.
+func handlerLoop(id party.ID, h protocol.Handler, network network.Network, timer *roundTimer) (early []*protocol.Message) {
+	current := 0
 	for {
 		select {
 
@@ -14,12 +58,21 @@ func handlerLoop(id party.ID, h protocol.Handler, network network.Network) {
 		case msg, ok := <-h.Listen():
 			if !ok {
 				// the channel was closed, indicating that the protocol is done executing.
-				return
+				timer.next()
+				return early
+			}
+			if int(msg.RoundNumber) != current {
+				current = int(msg.RoundNumber)
+				timer.next()
 			}
 			go network.Send(msg)
 
 		// incoming messages
 		case msg := <-network.Next(id):
+			if msg != nil && msg.Protocol == protocolConfirm {
+				early = append(early, msg)
+				continue
+			}
 			h.Accept(msg)
 		}
 	}
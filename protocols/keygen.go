@@ -1,10 +1,11 @@
 package protocols
 
 import (
-	"log"
+	"time"
 
 	"github.com/fxamacker/cbor/v2"
 	"github.com/shykerbogdan/mpc-wallet/network"
+	"github.com/shykerbogdan/mpc-wallet/user"
 	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet"
 	"github.com/taurusgroup/multi-party-sig/pkg/math/curve"
 	"github.com/taurusgroup/multi-party-sig/pkg/pool"
@@ -13,11 +14,17 @@ import (
 	"github.com/taurusgroup/multi-party-sig/protocols/cmp/config"
 )
 
-func RunKeygen(w *ethwallet.Wallet, net network.Network) error {
+// Run keygen for the empty wallet w, which is initialized once all signers confirmed they got the same result
+// within confirmTimeout. If they did not, the wallet is initialized as Unconfirmed with the error, so the share
+// can be saved and confirmed again with RunConfirm.
+func RunKeygen(me user.Me, w *ethwallet.Wallet, net network.Network, confirmTimeout time.Duration) (err error) {
+	done := countSession(typeKeygen)
+	defer func() { done(err) }()
+
 	selfid := w.Me.PartyID()
 	allids := w.AllPartyIDs()
 	threshold := w.Threshold
-	log.Printf("Starting Keygen protocol - selfid: %v, allids: %v threshold: %v", selfid, allids, threshold)
+	logger.Info("Starting keygen", "wallet", w.Name, "self", selfid, "parties", allids, "threshold", threshold)
 
 	pl := pool.NewPool(0)
 	defer pl.TearDown()
@@ -27,26 +34,26 @@ func RunKeygen(w *ethwallet.Wallet, net network.Network) error {
 		return err
 	}
 
-	handlerLoop(selfid, h, net)
+	early := handlerLoop(selfid, h, net, newRoundTimer(typeKeygen))
 
 	r, err := h.Result()
 	if err != nil {
 		return err
 	}
 
-	log.Print("Keygen protocol complete")
-
 	c := r.(*config.Config)
-
-	log.Printf("KeyData result: %v", c)
-
 	cb, err := cbor.Marshal(c)
-	log.Printf("KeyData marshal: %v", cb)
-
 	if err != nil {
 		return err
 	}
 
+	if err := confirmKeygen(me, w, c, net, early, confirmTimeout); err != nil {
+		w.Unconfirmed = true
+		w.Initialize(cb)
+		return err
+	}
+	logger.Info("Keygen complete", "wallet", w.Name)
+
 	w.Initialize(cb)
 
 	return nil
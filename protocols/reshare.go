@@ -0,0 +1,493 @@
+package protocols
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/shykerbogdan/mpc-wallet/network"
+	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet"
+	"github.com/taurusgroup/multi-party-sig/pkg/math/curve"
+	"github.com/taurusgroup/multi-party-sig/pkg/math/polynomial"
+	"github.com/taurusgroup/multi-party-sig/pkg/party"
+	"github.com/taurusgroup/multi-party-sig/pkg/pool"
+	"github.com/taurusgroup/multi-party-sig/pkg/protocol"
+	"github.com/taurusgroup/multi-party-sig/protocols/cmp"
+	"github.com/taurusgroup/multi-party-sig/protocols/cmp/config"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// Resharing moves the key of a wallet to a new set of signers and/or a new threshold, keeping its address.
+//
+// Each dealer, an old signer, weighs its share with its Lagrange coefficient so the dealers' weighted
+// shares sum to the secret key, and deals it to the new signers with a fresh polynomial of the new
+// threshold degree, broadcasting Feldman commitments to it. Every new signer adds up its sub-shares
+// into a new share of the same key. The new signers then run a cmp keygen among themselves only for
+// its Paillier, Pedersen and ElGamal parameters, and replace its random key share with the reshared one.
+// Finally the new signers broadcast a digest of the public data of the new key to check they all agree.
+const reshareProtocolID = "thresher/reshare"
+
+const (
+	// New signers broadcast a box key for receiving their sub-shares
+	reshareRoundBoxKey = 1
+	// Dealers broadcast the commitments to their polynomial and the sealed sub-shares
+	reshareRoundDeal = 2
+	// New signers broadcast a digest of the public data of the new key
+	reshareRoundDone = 3
+)
+
+// The parameters of a reshare, which every participant has to agree on
+type Reshare struct {
+	// Address of the wallet, which stays the same
+	Address string
+	// Old signers dealing their share, at least old threshold+1 of them
+	Dealers party.IDSlice
+	// The new signers
+	Signers party.IDSlice
+	// The new threshold
+	Threshold int
+}
+
+// The data of every reshare message, the round number of protocol.Message is reserved for the mps library
+type reshareEnvelope struct {
+	Round int
+	Body  []byte
+}
+
+type reshareBoxKey struct {
+	BoxKey []byte
+}
+
+type reshareDeal struct {
+	// Feldman commitments to the dealer's polynomial
+	Commitments []byte
+	BoxKey      []byte
+	// Nonce followed by the sealed reshareShare for every new signer
+	Shares map[party.ID][]byte
+}
+
+type reshareShare struct {
+	Share    []byte
+	ChainKey []byte
+}
+
+type reshareDone struct {
+	Digest []byte
+}
+
+// Run the reshare protocol as selfid. old is our current wallet, nil if we are not a dealer, and next is the
+// wallet that receives the new key share, nil if we are not one of the new signers.
+func RunReshare(selfid party.ID, r Reshare, old *ethwallet.Wallet, next *ethwallet.Wallet, net network.Network) (err error) {
+	done := countSession(typeReshare)
+	defer func() { done(err) }()
+
+	group := curve.Secp256k1{}
+	r.Dealers = party.NewIDSlice(r.Dealers)
+	r.Signers = party.NewIDSlice(r.Signers)
+	isDealer := r.Dealers.Contains(selfid)
+	isSigner := r.Signers.Contains(selfid)
+	logger.Info("Starting reshare", "self", selfid, "dealers", r.Dealers, "signers", r.Signers, "threshold", r.Threshold)
+
+	if !r.Dealers.Valid() || !r.Signers.Valid() {
+		return errors.New("reshare: dealers and signers must not contain duplicates")
+	}
+	if (r.Threshold <= 0) || (r.Threshold > len(r.Signers)-1) {
+		return fmt.Errorf("reshare: threshold %d is invalid for %d signers", r.Threshold, len(r.Signers))
+	}
+	if isDealer && old == nil {
+		return errors.New("reshare: a dealer needs the current wallet")
+	}
+	if isDealer {
+		if err := old.CheckConfirmed(); err != nil {
+			return fmt.Errorf("reshare: %w", err)
+		}
+	}
+	if isSigner && next == nil {
+		return errors.New("reshare: a new signer needs a wallet for the new share")
+	}
+	if !isDealer && !isSigner {
+		return fmt.Errorf("reshare: %s is neither a dealer nor a new signer", selfid)
+	}
+
+	in := newReshareInbox(selfid, r, net)
+
+	// Round 1
+	var boxpub, boxpriv *[32]byte
+	if isSigner {
+		var err error
+		boxpub, boxpriv, err = box.GenerateKey(rand.Reader)
+		if err != nil {
+			return err
+		}
+		if err := in.send(reshareRoundBoxKey, reshareBoxKey{BoxKey: boxpub[:]}); err != nil {
+			return err
+		}
+	}
+
+	// Round 2
+	deals := map[party.ID]*reshareDeal{}
+	if isDealer {
+		boxkeys := map[party.ID]*[32]byte{}
+		if isSigner {
+			boxkeys[selfid] = boxpub
+		}
+		msgs, err := in.collect(reshareRoundBoxKey, r.Signers)
+		if err != nil {
+			return err
+		}
+		for id, data := range msgs {
+			var bk reshareBoxKey
+			if err := cbor.Unmarshal(data, &bk); err != nil || len(bk.BoxKey) != 32 {
+				return fmt.Errorf("reshare: invalid box key from %s", id)
+			}
+			boxkeys[id] = new([32]byte)
+			copy(boxkeys[id][:], bk.BoxKey)
+		}
+
+		deal, err := dealShare(group, selfid, r, old, boxkeys)
+		if err != nil {
+			return err
+		}
+		if err := in.send(reshareRoundDeal, deal); err != nil {
+			return err
+		}
+		deals[selfid] = deal
+	}
+
+	var digest []byte
+	if isSigner {
+		msgs, err := in.collect(reshareRoundDeal, r.Dealers)
+		if err != nil {
+			return err
+		}
+		for id, data := range msgs {
+			deal := &reshareDeal{}
+			if err := cbor.Unmarshal(data, deal); err != nil {
+				return fmt.Errorf("reshare: invalid deal from %s: %w", id, err)
+			}
+			deals[id] = deal
+		}
+
+		c, err := receiveShares(group, selfid, r, old, deals, boxpriv, in)
+		if err != nil {
+			return err
+		}
+
+		cb, err := cbor.Marshal(c)
+		if err != nil {
+			return err
+		}
+		next.Initialize(cb)
+		if next.Address != r.Address {
+			return fmt.Errorf("reshare: new key is for address %s, not %s", next.Address, r.Address)
+		}
+		if err := next.VerifyKeyShare(); err != nil {
+			return fmt.Errorf("reshare: %w", err)
+		}
+
+		h := sha256.New()
+		if _, err := c.WriteTo(h); err != nil {
+			return err
+		}
+		digest = h.Sum(nil)
+		if err := in.send(reshareRoundDone, reshareDone{Digest: digest}); err != nil {
+			return err
+		}
+	}
+
+	// Round 3
+	msgs, err := in.collect(reshareRoundDone, r.Signers)
+	if err != nil {
+		return err
+	}
+	for id, data := range msgs {
+		var done reshareDone
+		if err := cbor.Unmarshal(data, &done); err != nil {
+			return fmt.Errorf("reshare: invalid digest from %s: %w", id, err)
+		}
+		if digest == nil {
+			digest = done.Digest
+		}
+		if !bytes.Equal(done.Digest, digest) {
+			return fmt.Errorf("reshare: %s ended up with a different key", id)
+		}
+	}
+
+	logger.Info("Reshare complete")
+	return nil
+}
+
+// Deal our Lagrange weighted share to the new signers
+func dealShare(group curve.Curve, selfid party.ID, r Reshare, old *ethwallet.Wallet, boxkeys map[party.ID]*[32]byte) (*reshareDeal, error) {
+	kd := old.GetUnwrappedKeyData()
+	if len(r.Dealers) <= kd.Threshold {
+		return nil, fmt.Errorf("reshare: wallet threshold requires at least %v dealers", kd.Threshold+1)
+	}
+	if !kd.PartyIDs().Contains(r.Dealers...) {
+		return nil, errors.New("reshare: dealers must be signers of the wallet")
+	}
+
+	lambda := polynomial.Lagrange(group, r.Dealers)[selfid]
+	weighted := group.NewScalar().Set(lambda).Mul(kd.ECDSA)
+	f := polynomial.NewPolynomial(group, r.Threshold, weighted)
+
+	commitments, err := polynomial.NewPolynomialExponent(f).MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	deal := &reshareDeal{Commitments: commitments, Shares: map[party.ID][]byte{}}
+	dealpub, dealpriv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	deal.BoxKey = dealpub[:]
+
+	for _, id := range r.Signers {
+		share, err := f.Evaluate(id.Scalar(group)).MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		data, err := cbor.Marshal(reshareShare{Share: share, ChainKey: kd.ChainKey})
+		if err != nil {
+			return nil, err
+		}
+
+		nonce := [24]byte{}
+		if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+			return nil, err
+		}
+		deal.Shares[id] = box.Seal(nonce[:], data, &nonce, boxkeys[id], dealpriv)
+	}
+
+	return deal, nil
+}
+
+// Check and add up the sub-shares dealt to us, and run keygen with the other new signers for a config
+// holding the new share
+func receiveShares(group curve.Curve, selfid party.ID, r Reshare, old *ethwallet.Wallet, deals map[party.ID]*reshareDeal, boxpriv *[32]byte, in *reshareInbox) (*config.Config, error) {
+	// If we hold the current key we can check each dealer dealt its actual weighted share
+	var oldkd *config.Config
+	if old != nil {
+		kd := old.GetUnwrappedKeyData()
+		oldkd = &kd
+	}
+	lambdas := polynomial.Lagrange(group, r.Dealers)
+
+	share := group.NewScalar()
+	var chainKey []byte
+	exponents := []*polynomial.Exponent{}
+	for _, id := range r.Dealers {
+		deal := deals[id]
+
+		exponent := polynomial.EmptyExponent(group)
+		if err := exponent.UnmarshalBinary(deal.Commitments); err != nil {
+			return nil, fmt.Errorf("reshare: invalid commitments from %s: %w", id, err)
+		}
+		if exponent.Degree() != r.Threshold {
+			return nil, fmt.Errorf("reshare: commitments from %s have the wrong degree", id)
+		}
+		if oldkd != nil {
+			public, ok := oldkd.Public[id]
+			if !ok {
+				return nil, fmt.Errorf("reshare: dealer %s is not a signer of the wallet", id)
+			}
+			if !exponent.Constant().Equal(group.NewScalar().Set(lambdas[id]).Act(public.ECDSA)) {
+				return nil, fmt.Errorf("reshare: %s did not deal its share of the key", id)
+			}
+		}
+
+		sealed := deal.Shares[selfid]
+		if len(sealed) < 24 || len(deal.BoxKey) != 32 {
+			return nil, fmt.Errorf("reshare: no share for us from %s", id)
+		}
+		nonce := [24]byte{}
+		copy(nonce[:], sealed)
+		dealpub := [32]byte{}
+		copy(dealpub[:], deal.BoxKey)
+		data, ok := box.Open(nil, sealed[24:], &nonce, &dealpub, boxpriv)
+		if !ok {
+			return nil, fmt.Errorf("reshare: share from %s can not be decrypted", id)
+		}
+
+		var rs reshareShare
+		if err := cbor.Unmarshal(data, &rs); err != nil {
+			return nil, fmt.Errorf("reshare: invalid share from %s: %w", id, err)
+		}
+		s := group.NewScalar()
+		if err := s.UnmarshalBinary(rs.Share); err != nil {
+			return nil, fmt.Errorf("reshare: invalid share from %s: %w", id, err)
+		}
+		if !s.ActOnBase().Equal(exponent.Evaluate(selfid.Scalar(group))) {
+			return nil, fmt.Errorf("reshare: share from %s does not match its commitments", id)
+		}
+		if chainKey == nil {
+			chainKey = rs.ChainKey
+		}
+		if !bytes.Equal(chainKey, rs.ChainKey) {
+			return nil, fmt.Errorf("reshare: chain key from %s does not match the other dealers", id)
+		}
+
+		share.Add(s)
+		exponents = append(exponents, exponent)
+	}
+
+	sum, err := polynomial.Sum(exponents)
+	if err != nil {
+		return nil, err
+	}
+
+	pl := pool.NewPool(0)
+	defer pl.TearDown()
+
+	h, err := protocol.NewMultiHandler(cmp.Keygen(group, selfid, r.Signers, r.Threshold, pl), in.ssid)
+	if err != nil {
+		return nil, err
+	}
+	in.handlerLoop(h)
+
+	res, err := h.Result()
+	if err != nil {
+		return nil, err
+	}
+
+	c := res.(*config.Config)
+	c.ECDSA = share
+	c.ChainKey = chainKey
+	for _, id := range r.Signers {
+		c.Public[id].ECDSA = sum.Evaluate(id.Scalar(group))
+	}
+
+	return c, nil
+}
+
+// Messages of the reshare protocol by round and sender. The network delivers everything to us, including
+// messages of the keygen that another signer may already have started, so those are kept for the keygen.
+type reshareInbox struct {
+	selfid  party.ID
+	ssid    []byte
+	net     network.Network
+	rounds  map[int]map[party.ID][]byte
+	pending []*protocol.Message
+	timer   *roundTimer
+}
+
+func newReshareInbox(selfid party.ID, r Reshare, net network.Network) *reshareInbox {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s|%s|%d|", reshareProtocolID, r.Address, r.Threshold)
+	r.Dealers.WriteTo(h)
+	r.Signers.WriteTo(h)
+
+	return &reshareInbox{
+		selfid: selfid,
+		ssid:   h.Sum(nil),
+		net:    net,
+		rounds: map[int]map[party.ID][]byte{},
+		timer:  newRoundTimer(typeReshare),
+	}
+}
+
+func (in *reshareInbox) send(round int, v interface{}) error {
+	body, err := cbor.Marshal(v)
+	if err != nil {
+		return err
+	}
+	data, err := cbor.Marshal(reshareEnvelope{Round: round, Body: body})
+	if err != nil {
+		return err
+	}
+	in.timer.next()
+	go in.net.Send(&protocol.Message{
+		SSID:      in.ssid,
+		From:      in.selfid,
+		Protocol:  reshareProtocolID,
+		Data:      data,
+		Broadcast: true,
+	})
+	return nil
+}
+
+// Keep a message if it belongs to this reshare, returning false if it belongs to another protocol
+func (in *reshareInbox) accept(msg *protocol.Message) bool {
+	if msg.Protocol != reshareProtocolID {
+		return false
+	}
+	var env reshareEnvelope
+	if bytes.Equal(msg.SSID, in.ssid) && cbor.Unmarshal(msg.Data, &env) == nil {
+		if in.rounds[env.Round] == nil {
+			in.rounds[env.Round] = map[party.ID][]byte{}
+		}
+		in.rounds[env.Round][msg.From] = env.Body
+	}
+	return true
+}
+
+// Wait for the messages of a round from all the parties except ourselves
+func (in *reshareInbox) collect(round int, from party.IDSlice) (map[party.ID][]byte, error) {
+	for {
+		msgs := map[party.ID][]byte{}
+		for _, id := range from {
+			if id == in.selfid {
+				continue
+			}
+			data, ok := in.rounds[round][id]
+			if !ok {
+				break
+			}
+			msgs[id] = data
+		}
+		if len(msgs) == len(from.Remove(in.selfid)) {
+			return msgs, nil
+		}
+
+		msg, ok := <-in.net.Next(in.selfid)
+		if !ok {
+			return nil, errors.New("reshare: network closed")
+		}
+		if !in.accept(msg) {
+			in.pending = append(in.pending, msg)
+		}
+	}
+}
+
+// Like handlerLoop, but feeds the handler the messages received so far and keeps the reshare messages
+func (in *reshareInbox) handlerLoop(h protocol.Handler) {
+	replay := make(chan *protocol.Message, len(in.pending))
+	for _, msg := range in.pending {
+		replay <- msg
+	}
+	close(replay)
+	in.pending = nil
+
+	current := 0
+	for {
+		select {
+		case msg, ok := <-h.Listen():
+			if !ok {
+				in.timer.next()
+				return
+			}
+			if int(msg.RoundNumber) != current {
+				current = int(msg.RoundNumber)
+				in.timer.next()
+			}
+			go in.net.Send(msg)
+
+		case msg, ok := <-replay:
+			if !ok {
+				replay = nil
+				continue
+			}
+			h.Accept(msg)
+
+		case msg := <-in.net.Next(in.selfid):
+			if !in.accept(msg) {
+				h.Accept(msg)
+			}
+		}
+	}
+}
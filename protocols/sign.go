@@ -1,8 +1,6 @@
 package protocols
 
 import (
-	"log"
-
 	"github.com/shykerbogdan/mpc-wallet/network"
 	"github.com/shykerbogdan/mpc-wallet/user"
 	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet"
@@ -13,7 +11,14 @@ import (
 	"github.com/taurusgroup/multi-party-sig/protocols/cmp"
 )
 
-func RunSign(w *ethwallet.Wallet, msghash []byte, signers []user.User, net network.Network) (*mpsecdsa.Signature, error) {
+func RunSign(w *ethwallet.Wallet, msghash []byte, signers []user.User, net network.Network) (signature *mpsecdsa.Signature, err error) {
+	done := countSession(typeSign)
+	defer func() { done(err) }()
+
+	if err := w.CheckConfirmed(); err != nil {
+		return nil, err
+	}
+
 	pl := pool.NewPool(0)
 	defer pl.TearDown()
 
@@ -21,6 +26,7 @@ func RunSign(w *ethwallet.Wallet, msghash []byte, signers []user.User, net netwo
 	for _, u := range signers {
 		partyIDs = append(partyIDs, u.PartyID())
 	}
+	logger.Info("Starting signing", "wallet", w.Name, "signers", partyIDs)
 
 	cfg := w.GetUnwrappedKeyData()
 
@@ -30,16 +36,15 @@ func RunSign(w *ethwallet.Wallet, msghash []byte, signers []user.User, net netwo
 		return nil, err
 	}
 
-	handlerLoop(cfg.ID, h, net)
+	handlerLoop(cfg.ID, h, net, newRoundTimer(typeSign))
 
 	signResult, err := h.Result()
 	if err != nil {
 		return nil, err
 	}
 
-	signature := signResult.(*mpsecdsa.Signature)
-
-	log.Printf("MPC lib signature: %s", signature)
+	signature = signResult.(*mpsecdsa.Signature)
+	logger.Info("Signing complete", "wallet", w.Name)
 
 	return signature, nil
 }
@@ -0,0 +1,86 @@
+package secretstore
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+
+	"github.com/zalando/go-keyring"
+)
+
+// Service the secrets are kept under in the keyring of the OS
+const keyringService = "thresher"
+
+// The keyring only holds strings, so secrets are kept base64 encoded. It has no way to encrypt, so the
+// share-encryption key is kept in the keyring as well and used in memory.
+type keyringStore struct {
+	namespace string
+}
+
+func openKeyring(c Config) (Store, error) {
+	return &keyringStore{namespace: c.Namespace}, nil
+}
+
+func (s *keyringStore) user(name string) string {
+	return s.namespace + "/" + name
+}
+
+func (s *keyringStore) Put(name string, secret []byte) error {
+	return keyring.Set(keyringService, s.user(name), base64.StdEncoding.EncodeToString(secret))
+}
+
+func (s *keyringStore) Get(name string) ([]byte, error) {
+	encoded, err := keyring.Get(keyringService, s.user(name))
+	if errors.Is(err, keyring.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(encoded)
+}
+
+func (s *keyringStore) Delete(name string) error {
+	err := keyring.Delete(keyringService, s.user(name))
+	if errors.Is(err, keyring.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// The share-encryption key, created when there is none yet
+func (s *keyringStore) shareKey() ([]byte, error) {
+	key, err := s.Get(shareKeyName)
+	if err == nil || !errors.Is(err, ErrNotFound) {
+		return key, err
+	}
+
+	key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := s.Put(shareKeyName, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (s *keyringStore) Seal(plaintext []byte) ([]byte, error) {
+	key, err := s.shareKey()
+	if err != nil {
+		return nil, err
+	}
+	return sealAESGCM(key, plaintext)
+}
+
+func (s *keyringStore) Open(sealed []byte) ([]byte, error) {
+	key, err := s.Get(shareKeyName)
+	if err != nil {
+		return nil, err
+	}
+	return openAESGCM(key, sealed)
+}
+
+func (s *keyringStore) Close() error {
+	return nil
+}
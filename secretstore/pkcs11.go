@@ -0,0 +1,258 @@
+//go:build cgo
+
+package secretstore
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+)
+
+// Application the data objects of thresher are marked with on the token
+const pkcs11Application = "thresher"
+
+// Secrets are private data objects on the token, readable after logging in. The share-encryption key is an
+// AES key generated on the token which is sensitive and not extractable, so sealing and opening happen on the
+// token and the key never leaves it.
+type pkcs11Store struct {
+	ctx       *pkcs11.Ctx
+	session   pkcs11.SessionHandle
+	namespace string
+	mutex     sync.Mutex
+}
+
+func openPKCS11(c Config) (Store, error) {
+	pin := os.Getenv(PINEnv)
+	if pin == "" {
+		return nil, fmt.Errorf("set %s to the user PIN of PKCS#11 token %s", PINEnv, c.TokenLabel)
+	}
+
+	ctx := pkcs11.New(c.Module)
+	if ctx == nil {
+		return nil, fmt.Errorf("PKCS#11 module %s can not be loaded", c.Module)
+	}
+	if err := ctx.Initialize(); err != nil {
+		ctx.Destroy()
+		return nil, fmt.Errorf("error initializing PKCS#11 module %s: %w", c.Module, err)
+	}
+
+	s := &pkcs11Store{ctx: ctx, namespace: c.Namespace}
+	if err := s.login(c.TokenLabel, pin); err != nil {
+		ctx.Finalize()
+		ctx.Destroy()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *pkcs11Store) login(tokenLabel string, pin string) error {
+	slots, err := s.ctx.GetSlotList(true)
+	if err != nil {
+		return fmt.Errorf("error listing PKCS#11 slots: %w", err)
+	}
+
+	for _, slot := range slots {
+		info, err := s.ctx.GetTokenInfo(slot)
+		if err != nil || strings.TrimRight(info.Label, " \x00") != tokenLabel {
+			continue
+		}
+
+		s.session, err = s.ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+		if err != nil {
+			return fmt.Errorf("error opening a session with PKCS#11 token %s: %w", tokenLabel, err)
+		}
+		err = s.ctx.Login(s.session, pkcs11.CKU_USER, pin)
+		if err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN)) {
+			s.ctx.CloseSession(s.session)
+			return fmt.Errorf("error logging in to PKCS#11 token %s: %w", tokenLabel, err)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("PKCS#11 token %s not found", tokenLabel)
+}
+
+func (s *pkcs11Store) label(name string) string {
+	return s.namespace + "/" + name
+}
+
+// Find the first object matching template, 0 if there is none
+func (s *pkcs11Store) find(template []*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
+	if err := s.ctx.FindObjectsInit(s.session, template); err != nil {
+		return 0, err
+	}
+	objects, _, err := s.ctx.FindObjects(s.session, 1)
+	if finalErr := s.ctx.FindObjectsFinal(s.session); err == nil {
+		err = finalErr
+	}
+	if err != nil || len(objects) == 0 {
+		return 0, err
+	}
+	return objects[0], nil
+}
+
+func (s *pkcs11Store) dataTemplate(name string) []*pkcs11.Attribute {
+	return []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_DATA),
+		pkcs11.NewAttribute(pkcs11.CKA_APPLICATION, pkcs11Application),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, s.label(name)),
+	}
+}
+
+func (s *pkcs11Store) Put(name string, secret []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.delete(name); err != nil {
+		return err
+	}
+
+	template := append(s.dataTemplate(name),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE, secret),
+	)
+	if _, err := s.ctx.CreateObject(s.session, template); err != nil {
+		return fmt.Errorf("error storing secret %s on PKCS#11 token: %w", name, err)
+	}
+	return nil
+}
+
+func (s *pkcs11Store) Get(name string) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	object, err := s.find(s.dataTemplate(name))
+	if err != nil {
+		return nil, err
+	}
+	if object == 0 {
+		return nil, ErrNotFound
+	}
+
+	attrs, err := s.ctx.GetAttributeValue(s.session, object, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_VALUE, nil)})
+	if err != nil {
+		return nil, fmt.Errorf("error reading secret %s from PKCS#11 token: %w", name, err)
+	}
+	return attrs[0].Value, nil
+}
+
+func (s *pkcs11Store) Delete(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.delete(name)
+}
+
+func (s *pkcs11Store) delete(name string) error {
+	object, err := s.find(s.dataTemplate(name))
+	if err != nil || object == 0 {
+		return err
+	}
+	return s.ctx.DestroyObject(s.session, object)
+}
+
+// The share-encryption key, generated on the token when there is none yet
+func (s *pkcs11Store) shareKey(create bool) (pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_AES),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, s.label(shareKeyName)),
+	}
+	key, err := s.find(template)
+	if err != nil || key != 0 {
+		return key, err
+	}
+	if !create {
+		return 0, ErrNotFound
+	}
+
+	template = append(template,
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE_LEN, 32),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_ENCRYPT, true),
+		pkcs11.NewAttribute(pkcs11.CKA_DECRYPT, true),
+	)
+	mechanism := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_KEY_GEN, nil)}
+	key, err = s.ctx.GenerateKey(s.session, mechanism, template)
+	if err != nil {
+		return 0, fmt.Errorf("error generating the share-encryption key on PKCS#11 token: %w", err)
+	}
+	return key, nil
+}
+
+// Seal with AES-GCM on the token, the nonce is prepended to the ciphertext
+func (s *pkcs11Store) Seal(plaintext []byte) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key, err := s.shareKey(true)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	params := pkcs11.NewGCMParams(nonce, nil, 128)
+	defer params.Free()
+
+	if err := s.ctx.EncryptInit(s.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_GCM, params)}, key); err != nil {
+		return nil, fmt.Errorf("error sealing with PKCS#11 token: %w", err)
+	}
+	ciphertext, err := s.ctx.Encrypt(s.session, plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("error sealing with PKCS#11 token: %w", err)
+	}
+
+	// Some tokens pick the nonce themselves
+	if iv := params.IV(); len(iv) == len(nonce) {
+		nonce = iv
+	}
+	return append(nonce, ciphertext...), nil
+}
+
+func (s *pkcs11Store) Open(sealed []byte) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key, err := s.shareKey(false)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < 12 {
+		return nil, errors.New("sealed data is too short")
+	}
+	params := pkcs11.NewGCMParams(sealed[:12], nil, 128)
+	defer params.Free()
+
+	if err := s.ctx.DecryptInit(s.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_GCM, params)}, key); err != nil {
+		return nil, fmt.Errorf("error opening with PKCS#11 token: %w", err)
+	}
+	plaintext, err := s.ctx.Decrypt(s.session, sealed[12:])
+	if err != nil {
+		return nil, fmt.Errorf("sealed data can not be decrypted with the share-encryption key of the PKCS#11 token: %w", err)
+	}
+	return plaintext, nil
+}
+
+func (s *pkcs11Store) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.ctx.Logout(s.session)
+	s.ctx.CloseSession(s.session)
+	err := s.ctx.Finalize()
+	s.ctx.Destroy()
+	return err
+}
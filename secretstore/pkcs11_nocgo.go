@@ -0,0 +1,10 @@
+//go:build !cgo
+
+package secretstore
+
+import "errors"
+
+// openPKCS11 fails in builds without cgo, which PKCS#11 modules need to be loaded
+func openPKCS11(c Config) (Store, error) {
+	return nil, errors.New("the pkcs11 secret store needs a build of thresher with cgo")
+}
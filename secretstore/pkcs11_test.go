@@ -0,0 +1,61 @@
+//go:build cgo
+
+package secretstore
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+// Runs against a SoftHSM token, set up with e.g.
+//
+//	softhsm2-util --init-token --free --label thresher-test --pin 1234 --so-pin 1234
+//	THRESHER_TEST_PKCS11_MODULE=/usr/lib/softhsm/libsofthsm2.so THRESHER_TEST_PKCS11_TOKEN=thresher-test \
+//	THRESHER_PKCS11_PIN=1234 go test ./secretstore
+func TestPKCS11Store(t *testing.T) {
+	module, token := os.Getenv("THRESHER_TEST_PKCS11_MODULE"), os.Getenv("THRESHER_TEST_PKCS11_TOKEN")
+	if module == "" || token == "" {
+		t.Skip("set THRESHER_TEST_PKCS11_MODULE and THRESHER_TEST_PKCS11_TOKEN to test against a PKCS#11 token")
+	}
+
+	store, err := Open(Config{Backend: BackendPKCS11, Namespace: t.Name(), Module: module, TokenLabel: token})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.Get(IdentityKeyName); err != nil && !errors.Is(err, ErrNotFound) {
+		t.Fatal(err)
+	}
+	for _, secret := range [][]byte{[]byte("first"), []byte("second")} {
+		if err := store.Put(IdentityKeyName, secret); err != nil {
+			t.Fatal(err)
+		}
+		got, err := store.Get(IdentityKeyName)
+		if err != nil || !bytes.Equal(got, secret) {
+			t.Fatalf("Get = %q, %v, want %q", got, err, secret)
+		}
+	}
+	if err := store.Delete(IdentityKeyName); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(IdentityKeyName); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of a deleted secret: got %v, want ErrNotFound", err)
+	}
+
+	plaintext := []byte("key share")
+	sealed, err := store.Seal(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened, err := store.Open(sealed)
+	if err != nil || !bytes.Equal(opened, plaintext) {
+		t.Fatalf("Open = %q, %v, want %q", opened, err, plaintext)
+	}
+	sealed[len(sealed)-1] ^= 1
+	if _, err := store.Open(sealed); err == nil {
+		t.Error("tampered sealed data was opened")
+	}
+}
@@ -0,0 +1,145 @@
+// Package secretstore keeps the secrets of thresher, the libp2p identity key and the key the mpc shares are
+// encrypted with, somewhere safer than the config file: the keyring of the OS, or a PKCS#11 token such as an
+// HSM, a smartcard or SoftHSM. With a hardware token the share-encryption key never leaves the device, so the
+// shares in the config file can only be decrypted on the device they were enrolled on.
+package secretstore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// The supported backends
+const (
+	// The secrets are kept in the config file itself, protected by its file permissions only
+	BackendFile = "file"
+	// The secrets are kept in the keyring of the OS: the Secret Service on linux, the Keychain on macOS and
+	// the Credential Manager on windows
+	BackendKeyring = "keyring"
+	// The secrets are kept on a PKCS#11 token
+	BackendPKCS11 = "pkcs11"
+)
+
+// Environment variable holding the user PIN of the PKCS#11 token
+const PINEnv = "THRESHER_PKCS11_PIN"
+
+// Names of the secrets in a store, prefixed by the namespace of the config
+const (
+	IdentityKeyName = "identity"
+	shareKeyName    = "share-key"
+)
+
+var ErrNotFound = errors.New("secret not found")
+
+// Where the secrets of a config are kept
+type Config struct {
+	// file, keyring or pkcs11
+	Backend string
+	// Prefix of the names of the secrets, so several configs can share a keyring or token, e.g. DAOTreasury-alice
+	Namespace string `json:",omitempty"`
+	// Path of the PKCS#11 module, e.g. /usr/lib/softhsm/libsofthsm2.so
+	Module string `json:",omitempty"`
+	// Label of the PKCS#11 token
+	TokenLabel string `json:",omitempty"`
+}
+
+// A Store keeps named secrets, and encrypts data with a key of its own which is created on first use
+type Store interface {
+	// Keep a secret under a name, replacing any secret of that name
+	Put(name string, secret []byte) error
+	// Get a secret, ErrNotFound if there is none of that name
+	Get(name string) ([]byte, error)
+	// Remove a secret, removing a secret which does not exist is not an error
+	Delete(name string) error
+	// Encrypt data with the share-encryption key of the store
+	Seal(plaintext []byte) ([]byte, error)
+	// Decrypt data sealed by Seal
+	Open(sealed []byte) ([]byte, error)
+	Close() error
+}
+
+func (c Config) IsFile() bool {
+	return c.Backend == "" || c.Backend == BackendFile
+}
+
+func (c Config) Validate() error {
+	switch {
+	case c.IsFile():
+		return nil
+	case c.Backend != BackendKeyring && c.Backend != BackendPKCS11:
+		return fmt.Errorf("unknown secret store backend %s, use %s, %s or %s", c.Backend, BackendFile, BackendKeyring, BackendPKCS11)
+	case c.Namespace == "":
+		return errors.New("secret store has no namespace")
+	case c.Backend == BackendPKCS11 && (c.Module == "" || c.TokenLabel == ""):
+		return errors.New("the pkcs11 secret store needs a module and a token label")
+	}
+	return nil
+}
+
+func (c Config) String() string {
+	switch {
+	case c.IsFile():
+		return BackendFile
+	case c.Backend == BackendPKCS11:
+		return fmt.Sprintf("%s token %s (%s) namespace %s", c.Backend, c.TokenLabel, c.Module, c.Namespace)
+	default:
+		return fmt.Sprintf("%s namespace %s", c.Backend, c.Namespace)
+	}
+}
+
+// Open the store of a config. The file backend has no store of its own, nil is returned for it.
+func Open(c Config) (Store, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	switch c.Backend {
+	case BackendKeyring:
+		return openKeyring(c)
+	case BackendPKCS11:
+		return openPKCS11(c)
+	}
+	return nil, nil
+}
+
+// Seal with AES-256-GCM, the nonce is prepended to the ciphertext
+func sealAESGCM(key []byte, plaintext []byte) ([]byte, error) {
+	aead, err := newAESGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func openAESGCM(key []byte, sealed []byte) ([]byte, error) {
+	aead, err := newAESGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("sealed data is too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("sealed data can not be decrypted with the share-encryption key: %w", err)
+	}
+	return plaintext, nil
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,58 @@
+package secretstore
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSealAESGCM(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	plaintext := []byte("key share")
+
+	sealed, err := sealAESGCM(key, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, plaintext) {
+		t.Fatal("sealed data contains the plaintext")
+	}
+
+	opened, err := openAESGCM(key, sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, plaintext) {
+		t.Errorf("opened %q, want %q", opened, plaintext)
+	}
+
+	sealed[len(sealed)-1] ^= 1
+	if _, err := openAESGCM(key, sealed); err == nil {
+		t.Error("tampered sealed data was opened")
+	}
+	if _, err := openAESGCM(bytes.Repeat([]byte{8}, 32), sealed[:len(sealed)-1]); err == nil {
+		t.Error("sealed data was opened with another key")
+	}
+	if _, err := openAESGCM(key, sealed[:4]); err == nil {
+		t.Error("truncated sealed data was opened")
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		config  Config
+		wantErr bool
+	}{
+		{config: Config{}},
+		{config: Config{Backend: BackendFile}},
+		{config: Config{Backend: BackendKeyring, Namespace: "P-alice"}},
+		{config: Config{Backend: BackendPKCS11, Namespace: "P-alice", Module: "/usr/lib/softhsm/libsofthsm2.so", TokenLabel: "thresher"}},
+		{config: Config{Backend: "tpm", Namespace: "P-alice"}, wantErr: true},
+		{config: Config{Backend: BackendKeyring}, wantErr: true},
+		{config: Config{Backend: BackendPKCS11, Namespace: "P-alice", TokenLabel: "thresher"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := tt.config.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("Validate(%+v) = %v, want error %v", tt.config, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,383 @@
+package simulation
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	gethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet/conn"
+	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet/types"
+	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet/utils"
+)
+
+// A fake Ethereum backend which speaks the same HTTP API as conn.EthConn, so wallets can
+// build, sign and broadcast transactions without a network. Broadcast transactions are decoded
+// and validated like a real node would (EIP-155 signature, nonce and balance) before being applied.
+type Backend struct {
+	ChainID  *big.Int
+	GasPrice *big.Int
+	GasLimit uint64
+
+	server   *httptest.Server
+	balances map[common.Address]*big.Int
+	names    map[string]common.Address
+	reverts  map[common.Address]string
+	tokens   map[common.Address]simulatedToken
+	nonces   map[common.Address]uint64
+	txs      []*gethtypes.Transaction
+	mutex    sync.Mutex
+}
+
+func NewBackend(chainID byte) *Backend {
+	b := &Backend{
+		ChainID:  big.NewInt(int64(chainID)),
+		GasPrice: big.NewInt(1000000000),
+		GasLimit: 21000,
+		balances: make(map[common.Address]*big.Int),
+		names:    make(map[string]common.Address),
+		reverts:  make(map[common.Address]string),
+		tokens:   make(map[common.Address]simulatedToken),
+		nonces:   make(map[common.Address]uint64),
+	}
+	b.server = httptest.NewServer(http.HandlerFunc(b.serveHTTP))
+	return b
+}
+
+// A connection to this backend, suitable for ethwallet.Wallet.SetConn
+func (b *Backend) Conn() *conn.EthConn {
+	return conn.NewEthConn(b.server.URL)
+}
+
+func (b *Backend) Close() {
+	b.server.Close()
+}
+
+// Credit an address with some wei
+func (b *Backend) Fund(addr common.Address, amount *big.Int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.balanceOf(addr).Add(b.balanceOf(addr), amount)
+}
+
+// Point an ENS name at an address
+func (b *Backend) RegisterName(name string, addr common.Address) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.names[name] = addr
+}
+
+func (b *Backend) Balance(addr common.Address) *big.Int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return new(big.Int).Set(b.balanceOf(addr))
+}
+
+// All transactions that were accepted by the backend, in order
+func (b *Backend) Transactions() []*gethtypes.Transaction {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return append([]*gethtypes.Transaction{}, b.txs...)
+}
+
+// Sender of an accepted transaction, recovered from its signature
+func (b *Backend) Sender(tx *gethtypes.Transaction) (common.Address, error) {
+	return gethtypes.Sender(gethtypes.NewEIP155Signer(b.ChainID), tx)
+}
+
+func (b *Backend) balanceOf(addr common.Address) *big.Int {
+	bal, ok := b.balances[addr]
+	if !ok {
+		bal = big.NewInt(0)
+		b.balances[addr] = bal
+	}
+	return bal
+}
+
+func (b *Backend) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	route := strings.TrimPrefix(r.URL.Path, "/")
+	address := common.HexToAddress(r.URL.Query().Get("address"))
+
+	var result interface{}
+	switch route {
+	case "block":
+		result = fmt.Sprint(len(b.txs))
+	case "balance":
+		result = b.balanceOf(address).String()
+	case "gasprice":
+		result = b.GasPrice.String()
+	case "nonce":
+		result = utils.UInt64ToHex(b.nonces[address])
+	case "estimategas":
+		result = utils.UInt64ToHex(b.GasLimit)
+	case "send":
+		var raw types.Raw
+		body, err := ioutil.ReadAll(r.Body)
+		if err == nil {
+			err = json.Unmarshal(body, &raw)
+		}
+		if err == nil {
+			result, err = b.applyRawTx(raw.Hex)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case "":
+		// JSON-RPC, for simulating calls and ENS lookups
+		b.serveRPC(w, r)
+		return
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	resultb, _ := json.Marshal(result)
+	_ = json.NewEncoder(w).Encode(conn.Response{Result: resultb})
+}
+
+// The address of the single ENS resolver of the backend, which the registry returns for all registered names
+var simulationResolver = common.HexToAddress("0x000000000000000000000000000000000000e115")
+
+// Selectors of the ERC-20 functions the backend answers for registered tokens
+var (
+	selectorSymbol   = []byte{0x95, 0xd8, 0x9b, 0x41}
+	selectorDecimals = []byte{0x31, 0x3c, 0xe5, 0x67}
+)
+
+// Selector of Error(string), the revert data of require and revert
+var selectorError = []byte{0x08, 0xc3, 0x79, 0xa0}
+
+// Make calls and transactions to a contract revert with the reason
+func (b *Backend) RevertCalls(contract common.Address, reason string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.reverts[contract] = reason
+}
+
+// Answer symbol() and decimals() of a token contract. Other calls to it succeed without changing balances.
+func (b *Backend) RegisterToken(contract common.Address, symbol string, decimals uint8) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.tokens[contract] = simulatedToken{symbol: symbol, decimals: decimals}
+}
+
+type simulatedToken struct {
+	symbol   string
+	decimals uint8
+}
+
+type rpcCall struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Gas      string `json:"gas"`
+	GasPrice string `json:"gasPrice"`
+	Value    string `json:"value"`
+	Data     string `json:"data"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    string `json:"data,omitempty"`
+}
+
+// Answer eth_call, eth_estimateGas and eth_feeHistory. Calls to the ENS registry and resolver look up the
+// registered names, calls to registered tokens answer symbol() and decimals(), and other calls return nothing.
+// The fee history has the gas price as base fee of every block and no priority fees.
+func (b *Backend) serveRPC(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID     int               `json:"id"`
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+	var call rpcCall
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err == nil && (len(req.Params) == 0 || (req.Method != "eth_call" && req.Method != "eth_estimateGas" && req.Method != "eth_feeHistory")) {
+		err = fmt.Errorf("unsupported method %s", req.Method)
+	}
+	if err == nil && req.Method == "eth_feeHistory" {
+		var history interface{}
+		if history, err = b.feeHistory(req.Params); err == nil {
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": history})
+			return
+		}
+	}
+	if err == nil {
+		err = json.Unmarshal(req.Params[0], &call)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      req.ID,
+	}
+	result, rpcErr := b.call(call)
+	if rpcErr != nil {
+		response["error"] = rpcErr
+	} else if req.Method == "eth_estimateGas" {
+		response["result"] = utils.UInt64ToHex(b.gasOf(call))
+	} else {
+		response["result"] = "0x" + hex.EncodeToString(result)
+	}
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+// The fee history of eth_feeHistory with params block count, newest block and reward percentiles
+func (b *Backend) feeHistory(params []json.RawMessage) (interface{}, error) {
+	var count string
+	var percentiles []float64
+	if len(params) < 3 {
+		return nil, errors.New("eth_feeHistory needs a block count, newest block and reward percentiles")
+	}
+	if err := json.Unmarshal(params[0], &count); err != nil {
+		return nil, fmt.Errorf("invalid block count: %w", err)
+	}
+	if err := json.Unmarshal(params[2], &percentiles); err != nil {
+		return nil, fmt.Errorf("invalid reward percentiles: %w", err)
+	}
+	blocks := hexToBig(count).Int64()
+
+	baseFees := []string{}
+	rewards := [][]string{}
+	for i := int64(0); i <= blocks; i++ {
+		baseFees = append(baseFees, "0x"+b.GasPrice.Text(16))
+		if i < blocks {
+			reward := []string{}
+			for range percentiles {
+				reward = append(reward, "0x0")
+			}
+			rewards = append(rewards, reward)
+		}
+	}
+	return map[string]interface{}{
+		"oldestBlock":   "0x1",
+		"baseFeePerGas": baseFees,
+		"reward":        rewards,
+	}, nil
+}
+
+// Run a call on the current state, failing like a node would if it reverts or the sender can not pay for it
+func (b *Backend) call(call rpcCall) ([]byte, *rpcError) {
+	to := common.HexToAddress(call.To)
+	data := utils.HexStrToBytes(call.Data)
+
+	if reason, ok := b.reverts[to]; ok {
+		reasonType, _ := abi.NewType("string", "", nil)
+		packed, _ := abi.Arguments{{Type: reasonType}}.Pack(reason)
+		return nil, &rpcError{
+			Code:    3,
+			Message: "execution reverted: " + reason,
+			Data:    "0x" + hex.EncodeToString(append(append([]byte{}, selectorError...), packed...)),
+		}
+	}
+
+	if call.From != "" {
+		cost := hexToBig(call.Value)
+		if call.GasPrice != "" && call.Gas != "" {
+			gas := new(big.Int).SetUint64(utils.HexStrToUInt64(call.Gas))
+			cost.Add(cost, gas.Mul(gas, hexToBig(call.GasPrice)))
+		}
+		if b.balanceOf(common.HexToAddress(call.From)).Cmp(cost) < 0 {
+			return nil, &rpcError{Code: -32000, Message: "insufficient funds for gas * price + value"}
+		}
+		if call.Gas != "" && utils.HexStrToUInt64(call.Gas) < b.gasOf(call) {
+			return nil, &rpcError{Code: -32000, Message: "out of gas"}
+		}
+	}
+
+	result := []byte{}
+	if to == conn.ENSRegistry || to == simulationResolver {
+		result = make([]byte, 32)
+		for name, addr := range b.names {
+			if len(data) != 36 || conn.NameHash(name) != common.BytesToHash(data[4:]) {
+				continue
+			}
+			if to == conn.ENSRegistry && bytes.Equal(data[:4], conn.SelectorResolver) {
+				copy(result[12:], simulationResolver.Bytes())
+			} else if to == simulationResolver && bytes.Equal(data[:4], conn.SelectorAddr) {
+				copy(result[12:], addr.Bytes())
+			}
+		}
+	}
+	if t, ok := b.tokens[to]; ok && len(data) == 4 {
+		if bytes.Equal(data, selectorDecimals) {
+			result = common.LeftPadBytes([]byte{t.decimals}, 32)
+		} else if bytes.Equal(data, selectorSymbol) {
+			symbolType, _ := abi.NewType("string", "", nil)
+			result, _ = abi.Arguments{{Type: symbolType}}.Pack(t.symbol)
+		}
+	}
+	return result, nil
+}
+
+func hexToBig(s string) *big.Int {
+	n, ok := new(big.Int).SetString(strings.TrimPrefix(s, "0x"), 16)
+	if !ok {
+		return new(big.Int)
+	}
+	return n
+}
+
+// The gas of a call, a transfer plus the calldata, and a fixed amount for calling a contract
+func (b *Backend) gasOf(call rpcCall) uint64 {
+	data := utils.HexStrToBytes(call.Data)
+	gas := b.GasLimit
+	for _, c := range data {
+		if c == 0 {
+			gas += 4
+		} else {
+			gas += 16
+		}
+	}
+	if len(data) > 0 {
+		gas += 30000
+	}
+	return gas
+}
+
+func (b *Backend) applyRawTx(rawhex string) (string, error) {
+	tx := new(gethtypes.Transaction)
+	if err := rlp.DecodeBytes(utils.HexStrToBytes(rawhex), tx); err != nil {
+		return "", fmt.Errorf("invalid raw transaction: %v", err)
+	}
+
+	from, err := b.Sender(tx)
+	if err != nil {
+		return "", fmt.Errorf("invalid transaction signature: %v", err)
+	}
+
+	if tx.Nonce() != b.nonces[from] {
+		return "", fmt.Errorf("invalid nonce for %s: have %d, want %d", from.Hex(), tx.Nonce(), b.nonces[from])
+	}
+
+	if b.balanceOf(from).Cmp(tx.Cost()) < 0 {
+		return "", fmt.Errorf("insufficient funds for %s: have %s, want %s", from.Hex(), b.balanceOf(from), tx.Cost())
+	}
+
+	b.balanceOf(from).Sub(b.balanceOf(from), tx.Cost())
+	if tx.To() != nil {
+		b.balanceOf(*tx.To()).Add(b.balanceOf(*tx.To()), tx.Value())
+	}
+	b.nonces[from]++
+	b.txs = append(b.txs, tx)
+
+	return tx.Hash().Hex(), nil
+}
@@ -0,0 +1,302 @@
+package simulation
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/shykerbogdan/mpc-wallet/network"
+	"github.com/shykerbogdan/mpc-wallet/network/channet"
+	"github.com/shykerbogdan/mpc-wallet/protocols"
+	"github.com/shykerbogdan/mpc-wallet/user"
+	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet"
+	ethcrypto "github.com/shykerbogdan/mpc-wallet/wallet/ethwallet/crypto"
+	"github.com/taurusgroup/multi-party-sig/pkg/party"
+)
+
+// How long a single protocol run may take before the harness gives up on it
+const DefaultTimeout = time.Minute * 5
+
+// A Party is one simulated signer, holding its own identity and its own copy of every wallet
+type Party struct {
+	Me      user.Me
+	Wallets map[string]*ethwallet.Wallet
+}
+
+// Harness runs N in-memory parties against each other over a channet.Network, with all
+// wallets connected to a fake Ethereum backend, so that keygen, signing and sending a
+// transaction can be exercised end-to-end without any network access.
+type Harness struct {
+	Network string
+	Backend *Backend
+	Timeout time.Duration
+
+	parties []*Party
+}
+
+// Create a harness with n parties for the given blockchain network (e.g. goerli)
+func NewHarness(n int, network string) (*Harness, error) {
+	if n < 2 {
+		return nil, errors.New("a simulation needs at least 2 parties")
+	}
+
+	h := &Harness{
+		Network: network,
+		Timeout: DefaultTimeout,
+	}
+
+	for i := 0; i < n; i++ {
+		if _, err := h.AddParty(); err != nil {
+			return nil, err
+		}
+	}
+
+	// The chain id of the fake backend has to match what the wallets will sign for
+	w := ethwallet.NewEmptyWallet(network, "", 0, user.User{}, nil)
+	h.Backend = NewBackend(w.Config.NetworkID)
+
+	return h, nil
+}
+
+func (h *Harness) Close() {
+	h.Backend.Close()
+}
+
+// Add a new party without any wallets, e.g. to reshare a wallet to
+func (h *Harness) AddParty() (*Party, error) {
+	me, err := user.NewMe(fmt.Sprintf("party%d", len(h.parties)+1), "")
+	if err != nil {
+		return nil, err
+	}
+	p := &Party{Me: me, Wallets: make(map[string]*ethwallet.Wallet)}
+	h.parties = append(h.parties, p)
+	return p, nil
+}
+
+func (h *Harness) Parties() []*Party {
+	return h.parties
+}
+
+// The first m parties, a convenient quorum for signing
+func (h *Harness) Quorum(m int) []*Party {
+	return h.parties[:m]
+}
+
+// Run the keygen protocol between all parties, returning the address of the new wallet.
+// Every party must end up with the same address.
+func (h *Harness) Keygen(name string, threshold int) (string, error) {
+	for _, p := range h.parties {
+		others := []user.User{}
+		for _, o := range h.parties {
+			if o != p {
+				others = append(others, o.Me.User)
+			}
+		}
+		p.Wallets[name] = ethwallet.NewEmptyWallet(h.Network, name, threshold, p.Me.User, others)
+	}
+
+	err := h.run(h.parties, func(p *Party, net network.Network) error {
+		return protocols.RunKeygen(p.Me, p.Wallets[name], net, protocols.DefaultConfirmTimeout)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	address := h.parties[0].Wallets[name].GetFormattedAddress()
+	for _, p := range h.parties {
+		w := p.Wallets[name]
+		if w.GetFormattedAddress() != address {
+			return "", fmt.Errorf("%s generated address %s, expected %s", p.Me.Nick, w.GetFormattedAddress(), address)
+		}
+		w.SetConn(h.Backend.Conn())
+	}
+
+	return address, nil
+}
+
+// Move a wallet to a new set of signers and threshold with the reshare protocol. The dealers must hold the
+// wallet, parties that are not in the new signers lose it and every new signer must end up with the same address.
+func (h *Harness) Reshare(name string, threshold int, dealers []*Party, signers []*Party) error {
+	address := dealers[0].Wallets[name].GetFormattedAddress()
+
+	r := protocols.Reshare{Address: address, Threshold: threshold}
+	participants := []*Party{}
+	inSigners := make(map[*Party]bool)
+	for _, p := range signers {
+		r.Signers = append(r.Signers, p.Me.PartyID())
+		participants = append(participants, p)
+		inSigners[p] = true
+	}
+	for _, p := range dealers {
+		r.Dealers = append(r.Dealers, p.Me.PartyID())
+		if !inSigners[p] {
+			participants = append(participants, p)
+		}
+	}
+
+	next := make(map[*Party]*ethwallet.Wallet)
+	for _, p := range signers {
+		others := []user.User{}
+		for _, o := range signers {
+			if o != p {
+				others = append(others, o.Me.User)
+			}
+		}
+		next[p] = ethwallet.NewEmptyWallet(h.Network, name, threshold, p.Me.User, others)
+	}
+
+	err := h.run(participants, func(p *Party, net network.Network) error {
+		return protocols.RunReshare(p.Me.PartyID(), r, p.Wallets[name], next[p], net)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, p := range participants {
+		delete(p.Wallets, name)
+	}
+	for p, w := range next {
+		if w.GetFormattedAddress() != address {
+			return fmt.Errorf("%s reshared to address %s, expected %s", p.Me.Nick, w.GetFormattedAddress(), address)
+		}
+		w.SetConn(h.Backend.Conn())
+		p.Wallets[name] = w
+	}
+
+	return nil
+}
+
+// Run the signing protocol for msghash between the signers, returning the eth signature (r | s | v).
+// Every signer must produce the same signature and it must recover to the wallet address.
+func (h *Harness) Sign(name string, msghash []byte, signers []*Party) ([]byte, error) {
+	users := []user.User{}
+	for _, p := range signers {
+		users = append(users, p.Me.User)
+	}
+
+	ethsigs := make(map[*Party][]byte)
+	var mutex sync.Mutex
+
+	err := h.run(signers, func(p *Party, net network.Network) error {
+		w := p.Wallets[name]
+		sig, err := protocols.RunSign(w, msghash, users, net)
+		if err != nil {
+			return err
+		}
+		ethsig, err := w.MpcSigToEthSig(msghash, sig)
+		if err != nil {
+			return err
+		}
+		mutex.Lock()
+		ethsigs[p] = ethsig
+		mutex.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ethsig := ethsigs[signers[0]]
+	for p, s := range ethsigs {
+		if !bytes.Equal(s, ethsig) {
+			return nil, fmt.Errorf("%s produced a different signature", p.Me.Nick)
+		}
+	}
+
+	w := signers[0].Wallets[name]
+	recovered, err := RecoverAddress(msghash, ethsig, w.Config.NetworkID)
+	if err != nil {
+		return nil, err
+	}
+	if recovered.String() != w.GetFormattedAddress() {
+		return nil, fmt.Errorf("signature recovers to %s, expected wallet address %s", recovered.String(), w.GetFormattedAddress())
+	}
+
+	return ethsig, nil
+}
+
+// Build a transaction from the wallet, sign it with the signers and broadcast it to the fake backend.
+// The backend only accepts the transaction if it is validly signed by the wallet address.
+func (h *Harness) SendTx(name string, to common.Address, value *big.Int, signers []*Party) (string, error) {
+	w := signers[0].Wallets[name]
+
+	tx, err := w.CreateNormalTransaction(&to, value, []byte{}, big.NewInt(0), 0)
+	if err != nil {
+		return "", err
+	}
+
+	ethsig, err := h.Sign(name, tx.ToSignHash(w.Config.NetworkID), signers)
+	if err != nil {
+		return "", err
+	}
+	tx.SetSignature(ethsig)
+
+	txid, err := w.PublishTx(tx.ToRawTx())
+	if err != nil {
+		return "", err
+	}
+
+	txs := h.Backend.Transactions()
+	from, err := h.Backend.Sender(txs[len(txs)-1])
+	if err != nil {
+		return "", err
+	}
+	if from.String() != w.GetFormattedAddress() {
+		return "", fmt.Errorf("transaction was sent from %s, expected wallet address %s", from.String(), w.GetFormattedAddress())
+	}
+
+	return txid, nil
+}
+
+// Recover the signing address from an eth signature (r | s | v) with an EIP-155 v value
+func RecoverAddress(msghash []byte, ethsig []byte, chainID byte) (common.Address, error) {
+	if len(ethsig) != 65 {
+		return common.Address{}, fmt.Errorf("invalid signature length %d", len(ethsig))
+	}
+	sig := append([]byte{}, ethsig...)
+	sig[64] -= chainID*2 + 35
+
+	pub, err := ethcrypto.SigToPub(msghash, sig)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return ethcrypto.PubkeyToAddress(*pub), nil
+}
+
+// Run fn concurrently for each party, each on a fresh network shared only by these parties
+func (h *Harness) run(parties []*Party, fn func(p *Party, net network.Network) error) error {
+	ids := party.IDSlice{}
+	for _, p := range parties {
+		ids = append(ids, p.Me.PartyID())
+	}
+	net := channet.NewNetwork(party.NewIDSlice(ids))
+
+	errs := make(chan error, len(parties))
+	for _, p := range parties {
+		go func(p *Party) {
+			if err := fn(p, net); err != nil {
+				errs <- fmt.Errorf("%s: %w", p.Me.Nick, err)
+				return
+			}
+			errs <- nil
+		}(p)
+	}
+
+	timeout := time.After(h.Timeout)
+	for range parties {
+		select {
+		case err := <-errs:
+			if err != nil {
+				return err
+			}
+		case <-timeout:
+			return fmt.Errorf("protocol did not complete within %s", h.Timeout)
+		}
+	}
+
+	return nil
+}
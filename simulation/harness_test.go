@@ -0,0 +1,96 @@
+package simulation
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/shykerbogdan/mpc-wallet/utils"
+)
+
+// Keygen is slow, so all steps run on the one wallet, in order
+func TestHarness(t *testing.T) {
+	h, err := NewHarness(3, "goerli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	const name = "test"
+	address, err := h.Keygen(name, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range h.Parties() {
+		if got := p.Wallets[name].GetFormattedAddress(); got != address {
+			t.Fatalf("%s has address %s, want %s", p.Me.Nick, got, address)
+		}
+	}
+	chainID := h.Parties()[0].Wallets[name].Config.NetworkID
+
+	// the signature of any quorum recovers to the wallet address
+	sign := func(t *testing.T, message string, quorum []*Party) {
+		msghash := utils.DigestAvaMsg(message)
+		ethsig, err := h.Sign(name, msghash, quorum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		recovered, err := RecoverAddress(msghash, ethsig, chainID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if recovered.String() != quorum[0].Wallets[name].GetFormattedAddress() {
+			t.Errorf("signature recovers to %s, want %s", recovered.String(), address)
+		}
+	}
+
+	t.Run("Sign", func(t *testing.T) {
+		sign(t, "first quorum", h.Quorum(2))
+		sign(t, "other quorum", h.Parties()[1:])
+	})
+
+	t.Run("SendTx", func(t *testing.T) {
+		h.Backend.Fund(common.HexToAddress(address), big.NewInt(1000000000000000000))
+		dest := common.HexToAddress("0x000000000000000000000000000000000000dEaD")
+		if _, err := h.SendTx(name, dest, big.NewInt(1000), h.Quorum(2)); err != nil {
+			t.Fatal(err)
+		}
+
+		txs := h.Backend.Transactions()
+		if len(txs) != 1 {
+			t.Fatalf("backend has %d transactions, want 1", len(txs))
+		}
+		from, err := h.Backend.Sender(txs[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if from.String() != address {
+			t.Errorf("transaction sent from %s, want %s", from.String(), address)
+		}
+		if h.Backend.Balance(dest).Cmp(big.NewInt(1000)) != 0 {
+			t.Errorf("destination has %s wei, want 1000", h.Backend.Balance(dest))
+		}
+	})
+
+	t.Run("Reshare", func(t *testing.T) {
+		old := h.Parties()
+		newcomer, err := h.AddParty()
+		if err != nil {
+			t.Fatal(err)
+		}
+		signers := []*Party{old[1], old[2], newcomer}
+		// the removed signer deals too, and loses the wallet
+		if err := h.Reshare(name, 1, old, signers); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := old[0].Wallets[name]; ok {
+			t.Error("removed signer still has the wallet")
+		}
+		for _, p := range signers {
+			if got := p.Wallets[name].GetFormattedAddress(); got != address {
+				t.Fatalf("%s has address %s after reshare, want %s", p.Me.Nick, got, address)
+			}
+		}
+		sign(t, "after reshare", []*Party{old[2], newcomer})
+	})
+}
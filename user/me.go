@@ -14,63 +14,51 @@ type Me struct {
 	IdentPrivKey libp2pcrypto.PrivKey
 }
 
+// Logs as the user, without the private key
+func (u Me) LogValue() interface{} {
+	return u.User
+}
+
 func (u Me) MarshalJSON() ([]byte, error) {
-	identpubkey, err := libp2pcrypto.MarshalPublicKey(u.IdentPubKey)
+	if u.IdentPubKey == nil {
+		return []byte{}, fmt.Errorf("error marshaling user IdentPubKey: no key")
+	}
+	userb, err := u.User.MarshalJSON()
 	if err != nil {
-		return []byte{}, fmt.Errorf("error marshaling user IdentPubKey %v", err)
+		return []byte{}, err
+	}
+	var objMap map[string]json.RawMessage
+	if err := json.Unmarshal(userb, &objMap); err != nil {
+		return []byte{}, err
 	}
 
 	identprivkey, err := libp2pcrypto.MarshalPrivateKey(u.IdentPrivKey)
 	if err != nil {
 		return []byte{}, fmt.Errorf("error marshaling user IdentPrivKey %v", err)
 	}
-	return json.Marshal(map[string]string{
-		"Nick": u.Nick,
-		"Address": u.Address,
-		"IdentPubKey": libp2pcrypto.ConfigEncodeKey(identpubkey),
-		"IdentPrivKey": libp2pcrypto.ConfigEncodeKey(identprivkey),
-	})	
-}
-
-// TODO Please let there be a nicer way to do this
-func (u *Me) UnmarshalJSON(b []byte) error {
-	var objMap map[string]*json.RawMessage
-	err := json.Unmarshal(b, &objMap)
+	objMap["IdentPrivKey"], err = json.Marshal(libp2pcrypto.ConfigEncodeKey(identprivkey))
 	if err != nil {
-		return err		
+		return []byte{}, err
 	}
+	return json.Marshal(objMap)
+}
 
-	var nick string
-	err = json.Unmarshal(*objMap["Nick"], &nick)
-	if err != nil {
-		return err		
+func (u *Me) UnmarshalJSON(b []byte) error {
+	if err := u.User.UnmarshalJSON(b); err != nil {
+		return err
 	}
-	u.Nick = nick
-
-	var address string
-	err = json.Unmarshal(*objMap["Address"], &address)
-	if err != nil {
-		return err		
+	if u.IdentPubKey == nil {
+		return fmt.Errorf("user has no IdentPubKey")
 	}
-	u.Address = address
 
-	var identpubkeyb64 string
-	err = json.Unmarshal(*objMap["IdentPubKey"], &identpubkeyb64)
-	if err != nil {
-		return err		
-	}
-	identpubkeybytes, err := libp2pcrypto.ConfigDecodeKey(identpubkeyb64)
-	if err != nil {
-		return err		
-	}
-	identpubkey, err := libp2pcrypto.UnmarshalPublicKey(identpubkeybytes)
+	var objMap map[string]*json.RawMessage
+	err := json.Unmarshal(b, &objMap)
 	if err != nil {
 		return err		
 	}
-	u.IdentPubKey = identpubkey
 
 	var identprivkeyb64 string
-	err = json.Unmarshal(*objMap["IdentPrivKey"], &identprivkeyb64)
+	err = unmarshalField(objMap, "IdentPrivKey", &identprivkeyb64)
 	if err != nil {
 		return err		
 	}
@@ -90,7 +78,7 @@ func (u *Me) UnmarshalJSON(b []byte) error {
 // Create a user which is ourselves
 func NewMe(nick string, address string) (Me, error) {
 	// If we are creating ourselves, we wont have a identpubkey yet, so create our libp2p identity which is a pub/priv key
-	privkey, pubkey, err := libp2pcrypto.GenerateKeyPairWithReader(libp2pcrypto.RSA, 2048, rand.Reader)
+	privkey, pubkey, err := libp2pcrypto.GenerateEd25519Key(rand.Reader)
 	if err != nil {
 		panic(err)
 	}
@@ -106,3 +94,29 @@ func NewMe(nick string, address string) (Me, error) {
 	return u, nil
 }
 
+// Replace the identity key with a new Ed25519 key. The party id is pinned, so the wallets of the previous
+// key keep working, and the previous key signs the new one so the other signers can accept it.
+func (u *Me) RotateIdentity() error {
+	privkey, pubkey, err := libp2pcrypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		return err
+	}
+	payload, err := rotationPayload(pubkey)
+	if err != nil {
+		return err
+	}
+	sig, err := u.IdentPrivKey.Sign(payload)
+	if err != nil {
+		return fmt.Errorf("error signing the new identity key with the previous one: %w", err)
+	}
+
+	u.Party = u.PartyID()
+	if u.PreviousIdentPubKey != nil {
+		u.EarlierRotations = append(append([]KeyRotation{}, u.EarlierRotations...), KeyRotation{IdentPubKey: u.PreviousIdentPubKey, Sig: u.RotationSig})
+	}
+	u.PreviousIdentPubKey = u.IdentPubKey
+	u.RotationSig = sig
+	u.IdentPubKey = pubkey
+	u.IdentPrivKey = privkey
+	return nil
+}
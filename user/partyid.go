@@ -0,0 +1,145 @@
+package user
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	libp2pcrypto "github.com/libp2p/go-libp2p-core/crypto"
+	crypto_pb "github.com/libp2p/go-libp2p-core/crypto/pb"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/taurusgroup/multi-party-sig/pkg/math/curve"
+	"github.com/taurusgroup/multi-party-sig/pkg/party"
+)
+
+// Length of a party id. The mpc protocols use party ids as 32 byte numbers, longer ids are inconsistent.
+const partyIDLength = 32
+
+// Domain separation of the hash party ids are derived with
+const partyIDDomain = "thresher party id v1:"
+
+var ErrPartyIDCollision = errors.New("party ids collide")
+
+// The MPC protocol lib requires each user to have a unique party id of at most 32 bytes. A party id pinned
+// in Party is used as is, otherwise it is derived from the identity key, see DerivePartyID. A pinned party id
+// is only kept when decoding a user if its identity key rotations prove it, see verifyPartyPin, so the users
+// received from others can not claim the party id of someone else.
+func (u User) PartyID() party.ID {
+	if u.Party != "" {
+		return u.Party
+	}
+	return DerivePartyID(u.IdentPubKey)
+}
+
+// Derive the party id of an identity key: the first 16 bytes of a hash of the key, hex encoded.
+// RSA identities from before Ed25519 keep the party id they had, the last 32 characters of the Pretty()
+// peer id, so the wallets created with them keep working.
+func DerivePartyID(pubkey libp2pcrypto.PubKey) party.ID {
+	if pubkey.Type() == crypto_pb.KeyType_RSA {
+		pid, err := peer.IDFromPublicKey(pubkey)
+		if err != nil {
+			panic(err)
+		}
+		return party.ID(pid.Pretty()[(len(pid.Pretty()) - partyIDLength):])
+	}
+
+	b, err := libp2pcrypto.MarshalPublicKey(pubkey)
+	if err != nil {
+		panic(err)
+	}
+	hash := sha256.Sum256(append([]byte(partyIDDomain), b...))
+	return party.ID(hex.EncodeToString(hash[:partyIDLength/2]))
+}
+
+// Check the party ids of users are usable together: distinct, also as the numbers the mpc protocols use
+// them as, and none of them zero
+func CheckPartyIDs(users []User) error {
+	group := curve.Secp256k1{}
+	seen := make(map[string]string)
+	for _, u := range users {
+		id := u.PartyID()
+		if id == "" || len(id) > partyIDLength {
+			return fmt.Errorf("party id %q of %s is not between 1 and %d bytes", id, u.Nick, partyIDLength)
+		}
+
+		scalar := id.Scalar(group)
+		if scalar.IsZero() {
+			return fmt.Errorf("party id of %s is zero as a number", u.Nick)
+		}
+		b, err := scalar.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		if other, ok := seen[string(b)]; ok {
+			return fmt.Errorf("%w: %s and %s both have party id %s", ErrPartyIDCollision, other, u.Nick, id)
+		}
+		seen[string(b)] = u.Nick
+	}
+	return nil
+}
+
+// What the previous identity key signs when it is replaced
+func rotationPayload(pubkey libp2pcrypto.PubKey) ([]byte, error) {
+	b, err := libp2pcrypto.MarshalPublicKey(pubkey)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte("thresher identity rotation:"), b...), nil
+}
+
+// Check PreviousIdentPubKey signed IdentPubKey
+func (u User) VerifyRotation() error {
+	if u.PreviousIdentPubKey == nil {
+		return errors.New("identity key has not been replaced")
+	}
+	payload, err := rotationPayload(u.IdentPubKey)
+	if err != nil {
+		return err
+	}
+	ok, err := u.PreviousIdentPubKey.Verify(payload, u.RotationSig)
+	if err != nil || !ok {
+		return errors.New("identity key is not signed by the previous identity key")
+	}
+	return nil
+}
+
+// Check the pinned party id is derived from the first identity key of u, which through the signed rotations
+// led to the current identity key
+func (u User) verifyPartyPin() error {
+	if err := u.VerifyRotation(); err != nil {
+		return err
+	}
+	key := u.PreviousIdentPubKey
+	for i := len(u.EarlierRotations) - 1; i >= 0; i-- {
+		r := u.EarlierRotations[i]
+		if r.IdentPubKey == nil {
+			return errors.New("identity key rotation has no key")
+		}
+		payload, err := rotationPayload(key)
+		if err != nil {
+			return err
+		}
+		ok, err := r.IdentPubKey.Verify(payload, r.Sig)
+		if err != nil || !ok {
+			return errors.New("identity key rotations are not signed one by the other")
+		}
+		key = r.IdentPubKey
+	}
+	if DerivePartyID(key) != u.Party {
+		return errors.New("pinned party id is not derived from the first identity key")
+	}
+	return nil
+}
+
+// Whether u is old with its identity key replaced: it has the nick and party id of old, and the previous
+// identity key of u, which is the key of old, signed the new one
+func (u User) Replaces(old User) bool {
+	return u.PreviousIdentPubKey != nil && old.IdentPubKey != nil &&
+		u.PreviousIdentPubKey.Equals(old.IdentPubKey) &&
+		!u.IdentPubKey.Equals(old.IdentPubKey) &&
+		u.Nick == old.Nick &&
+		u.PartyID() == old.PartyID() &&
+		u.VerifyRotation() == nil
+}
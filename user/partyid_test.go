@@ -0,0 +1,161 @@
+package user
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	libp2pcrypto "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/taurusgroup/multi-party-sig/pkg/party"
+)
+
+func TestDerivePartyID(t *testing.T) {
+	// RSA identities keep the party id of the wallets made with them
+	_, rsapub, err := libp2pcrypto.GenerateKeyPairWithReader(libp2pcrypto.RSA, 2048, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := peer.IDFromPublicKey(rsapub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := DerivePartyID(rsapub), party.ID(pid.Pretty()[len(pid.Pretty())-32:]); got != want {
+		t.Errorf("RSA party id %s, want %s", got, want)
+	}
+
+	me, err := NewMe("alice", "0x1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := me.PartyID()
+	if len(id) != partyIDLength {
+		t.Errorf("party id %s has length %d, want %d", id, len(id), partyIDLength)
+	}
+	if DerivePartyID(me.IdentPubKey) != id {
+		t.Error("party id derivation is not deterministic")
+	}
+}
+
+func TestCheckPartyIDs(t *testing.T) {
+	alice, _ := NewMe("alice", "0x1")
+	bob, _ := NewMe("bob", "0x2")
+	if err := CheckPartyIDs([]User{alice.User, bob.User}); err != nil {
+		t.Fatal(err)
+	}
+
+	bob.Party = alice.PartyID()
+	if err := CheckPartyIDs([]User{alice.User, bob.User}); !errors.Is(err, ErrPartyIDCollision) {
+		t.Errorf("same party ids: got %v, want ErrPartyIDCollision", err)
+	}
+
+	// Party ids are used as numbers, leading zero bytes do not tell them apart
+	bob.Party = party.ID("\x00" + string(alice.PartyID()[:partyIDLength-1]))
+	alice.Party = alice.PartyID()[:partyIDLength-1]
+	if err := CheckPartyIDs([]User{alice.User, bob.User}); !errors.Is(err, ErrPartyIDCollision) {
+		t.Errorf("numerically equal party ids: got %v, want ErrPartyIDCollision", err)
+	}
+
+	bob.Party = party.ID(make([]byte, partyIDLength+1))
+	if err := CheckPartyIDs([]User{bob.User}); err == nil {
+		t.Error("too long party id was accepted")
+	}
+}
+
+func TestRotateIdentity(t *testing.T) {
+	old, err := NewMe("alice", "0x1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	me := old
+	if err := me.RotateIdentity(); err != nil {
+		t.Fatal(err)
+	}
+	if me.PartyID() != old.PartyID() {
+		t.Errorf("party id changed from %s to %s", old.PartyID(), me.PartyID())
+	}
+	if me.IdentPubKey.Equals(old.IdentPubKey) {
+		t.Error("identity key was not replaced")
+	}
+
+	// The rotation travels with the advertised user
+	b, err := json.Marshal(me.User)
+	if err != nil {
+		t.Fatal(err)
+	}
+	advertised := User{}
+	if err := json.Unmarshal(b, &advertised); err != nil {
+		t.Fatal(err)
+	}
+	if !advertised.Replaces(old.User) {
+		t.Error("rotated identity does not replace the previous one")
+	}
+
+	other, _ := NewMe("alice", "0x1")
+	if advertised.Replaces(other.User) {
+		t.Error("rotated identity replaces an unrelated identity with the same nick")
+	}
+
+	forged := advertised
+	forged.RotationSig = append([]byte{}, advertised.RotationSig...)
+	forged.RotationSig[0] ^= 1
+	if forged.Replaces(old.User) {
+		t.Error("rotation with an invalid signature was accepted")
+	}
+}
+
+// Decode a user as received from another signer
+func roundTrip(t *testing.T, u User) User {
+	b, err := json.Marshal(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := User{}
+	if err := json.Unmarshal(b, &received); err != nil {
+		t.Fatal(err)
+	}
+	return received
+}
+
+func TestPinnedPartyID(t *testing.T) {
+	alice, _ := NewMe("alice", "0x1")
+	mallory, _ := NewMe("mallory", "0x2")
+
+	// a party id claimed without any rotation
+	forged := mallory.User
+	forged.Party = alice.PartyID()
+	if id := roundTrip(t, forged).PartyID(); id != mallory.PartyID() {
+		t.Errorf("forged party id %s was kept", id)
+	}
+
+	// a party id claimed with a valid rotation of mallory's own key
+	rotated := mallory
+	if err := rotated.RotateIdentity(); err != nil {
+		t.Fatal(err)
+	}
+	forged = rotated.User
+	forged.Party = alice.PartyID()
+	if id := roundTrip(t, forged).PartyID(); id == alice.PartyID() {
+		t.Error("party id not derived from the rotated key was kept")
+	}
+
+	// the party id of alice survives rotating twice
+	me := alice
+	for i := 0; i < 2; i++ {
+		if err := me.RotateIdentity(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	received := roundTrip(t, me.User)
+	if received.PartyID() != alice.PartyID() || len(received.EarlierRotations) != 1 {
+		t.Errorf("party id after two rotations is %s, want %s", received.PartyID(), alice.PartyID())
+	}
+
+	// a broken link in the chain
+	broken := me.User
+	broken.EarlierRotations = []KeyRotation{{IdentPubKey: mallory.IdentPubKey, Sig: me.EarlierRotations[0].Sig}}
+	if id := roundTrip(t, broken).PartyID(); id == alice.PartyID() {
+		t.Error("party id kept with a broken rotation chain")
+	}
+}
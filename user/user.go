@@ -15,30 +15,86 @@ type User struct {
 	Address string
 	// All users will have a pub key used for identification in libp2p
 	IdentPubKey libp2pcrypto.PubKey
+	// The party id of the user if it is not derived from IdentPubKey, which is the case after replacing the
+	// identity key so the wallets of the previous key keep working. See PartyID.
+	Party party.ID
+	// After replacing the identity key: the previous key and its signature of the new one, so others can
+	// tell the new key belongs to the same user
+	PreviousIdentPubKey libp2pcrypto.PubKey
+	RotationSig         []byte
+	// The keys replaced before the previous one, oldest first, each with its signature of the next key. The
+	// chain leads back to the key the pinned party id is derived from.
+	EarlierRotations []KeyRotation
+}
+
+// A replaced identity key and its signature of the key that replaced it
+type KeyRotation struct {
+	IdentPubKey libp2pcrypto.PubKey
+	Sig         []byte
+}
+
+type encodedRotation struct {
+	IdentPubKey string
+	Sig         []byte
 }
 
 func (u User) MarshalJSON() ([]byte, error) {
 	// We have to marshal an empty User at some points (TODO fix) so support it
-	var identpubkey_encoded string
-	if u.IdentPubKey != nil {
-		identpubkey, err := libp2pcrypto.MarshalPublicKey(u.IdentPubKey)
+	identpubkey_encoded, err := encodePubKey(u.IdentPubKey)
+	if err != nil {
+		return []byte{}, fmt.Errorf("error marshaling user IdentPubKey %v", err)
+	}
+	previous_encoded, err := encodePubKey(u.PreviousIdentPubKey)
+	if err != nil {
+		return []byte{}, fmt.Errorf("error marshaling user PreviousIdentPubKey %v", err)
+	}
+	earlier := []encodedRotation{}
+	for _, r := range u.EarlierRotations {
+		encoded, err := encodePubKey(r.IdentPubKey)
 		if err != nil {
-			return []byte{}, fmt.Errorf("error marshaling user IdentPubKey %v", err)
+			return []byte{}, fmt.Errorf("error marshaling user EarlierRotations %v", err)
 		}
-		identpubkey_encoded = libp2pcrypto.ConfigEncodeKey(identpubkey)
+		earlier = append(earlier, encodedRotation{IdentPubKey: encoded, Sig: r.Sig})
 	}
 
 	return json.Marshal(struct{
 		Nick string
 		Address string
 		IdentPubKey string
+		PartyID             string            `json:",omitempty"`
+		PreviousIdentPubKey string            `json:",omitempty"`
+		RotationSig         []byte            `json:",omitempty"`
+		EarlierRotations    []encodedRotation `json:",omitempty"`
 	}{
 		Nick: u.Nick,
 		Address: u.Address,
 		IdentPubKey: identpubkey_encoded,
+		PartyID:             string(u.Party),
+		PreviousIdentPubKey: previous_encoded,
+		RotationSig:         u.RotationSig,
+		EarlierRotations:    earlier,
 	})
 }
 
+func encodePubKey(pubkey libp2pcrypto.PubKey) (string, error) {
+	if pubkey == nil {
+		return "", nil
+	}
+	b, err := libp2pcrypto.MarshalPublicKey(pubkey)
+	if err != nil {
+		return "", err
+	}
+	return libp2pcrypto.ConfigEncodeKey(b), nil
+}
+
+func decodePubKey(encoded string) (libp2pcrypto.PubKey, error) {
+	b, err := libp2pcrypto.ConfigDecodeKey(encoded)
+	if err != nil {
+		return nil, err
+	}
+	return libp2pcrypto.UnmarshalPublicKey(b)
+}
+
 // TODO Please let there be a nicer way to do this
 func (u *User) UnmarshalJSON(b []byte) error {
 	var objMap map[string]*json.RawMessage
@@ -48,21 +104,21 @@ func (u *User) UnmarshalJSON(b []byte) error {
 	}
 
 	var nick string
-	err = json.Unmarshal(*objMap["Nick"], &nick)
+	err = unmarshalField(objMap, "Nick", &nick)
 	if err != nil {
 		return err		
 	}
 	u.Nick = nick
 
 	var address string
-	err = json.Unmarshal(*objMap["Address"], &address)
+	err = unmarshalField(objMap, "Address", &address)
 	if err != nil {
 		return err		
 	}
 	u.Address = address
 
 	var identpubkeyb64 string
-	err = json.Unmarshal(*objMap["IdentPubKey"], &identpubkeyb64)
+	err = unmarshalField(objMap, "IdentPubKey", &identpubkeyb64)
 	if err != nil {
 		return err		
 	}
@@ -78,9 +134,61 @@ func (u *User) UnmarshalJSON(b []byte) error {
 		u.IdentPubKey = identpubkey
 	}
 
+	// Only present after replacing the identity key
+	if _, ok := objMap["PartyID"]; ok {
+		var partyid string
+		if err := unmarshalField(objMap, "PartyID", &partyid); err != nil {
+			return err
+		}
+		u.Party = party.ID(partyid)
+	}
+	if _, ok := objMap["PreviousIdentPubKey"]; ok {
+		var previousb64 string
+		if err := unmarshalField(objMap, "PreviousIdentPubKey", &previousb64); err != nil {
+			return err
+		}
+		previous, err := decodePubKey(previousb64)
+		if err != nil {
+			return err
+		}
+		u.PreviousIdentPubKey = previous
+	}
+	if _, ok := objMap["RotationSig"]; ok {
+		if err := unmarshalField(objMap, "RotationSig", &u.RotationSig); err != nil {
+			return err
+		}
+	}
+	if _, ok := objMap["EarlierRotations"]; ok {
+		earlier := []encodedRotation{}
+		if err := unmarshalField(objMap, "EarlierRotations", &earlier); err != nil {
+			return err
+		}
+		for _, r := range earlier {
+			pubkey, err := decodePubKey(r.IdentPubKey)
+			if err != nil {
+				return err
+			}
+			u.EarlierRotations = append(u.EarlierRotations, KeyRotation{IdentPubKey: pubkey, Sig: r.Sig})
+		}
+	}
+
+	// Anyone can claim a party id, only keep the one the rotations of the identity key prove
+	if u.Party != "" && u.verifyPartyPin() != nil {
+		u.Party = ""
+	}
+
 	return nil
 }
 
+// Unmarshal a field of a user object, failing instead of panicking if a damaged or foreign file lacks it
+func unmarshalField(objMap map[string]*json.RawMessage, name string, v interface{}) error {
+	raw, ok := objMap[name]
+	if !ok || raw == nil {
+		return fmt.Errorf("user has no %s", name)
+	}
+	return json.Unmarshal(*raw, v)
+}
+
 func NewUser(nick string, address string, identpubkey libp2pcrypto.PubKey) (User, error) {
 	u := User{
 		Nick: nick,
@@ -98,17 +206,6 @@ func (u User) PeerID() peer.ID {
 	return pid
 }
 
-// The MSP protocol lib requires each user to have a unique Party ID represented as an exactly 32 byte string
-// Since each user has a pub key, which is used as a peer id in libp2p, lets use that.
-// Marshal the key to bytes, convert to Pretty() format, then take last 32 characters.
-func (u User) PartyID() party.ID {
-	pid, err := peer.IDFromPublicKey(u.IdentPubKey)
-	if err != nil {
-		panic(err)
-	}
-	return party.ID(pid.Pretty()[(len(pid.Pretty())-32):])
-}
-
 // TODO how to do this? verify that a particular nick verified somehow, use libp2p pub/priv key?
 func (u User) IsVerified() bool {
 	return true
@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Sealed data layout is magic | version | salt | nonce | AES-256-GCM ciphertext
+var sealMagic = []byte("THSEAL")
+
+const (
+	sealVersion  = 1
+	sealSaltSize = 16
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+)
+
+var ErrSealedDataInvalid = errors.New("sealed data is invalid or the passphrase is wrong")
+
+// SealWithPassphrase encrypts and authenticates data with a key derived from passphrase
+func SealWithPassphrase(data []byte, passphrase []byte) ([]byte, error) {
+	salt := make([]byte, sealSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+
+	aead, err := sealCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	header := append(append([]byte{}, sealMagic...), sealVersion)
+	out := append(append(header, salt...), nonce...)
+	return aead.Seal(out, nonce, data, header), nil
+}
+
+// OpenWithPassphrase reverses SealWithPassphrase
+func OpenWithPassphrase(sealed []byte, passphrase []byte) ([]byte, error) {
+	headerSize := len(sealMagic) + 1
+	if len(sealed) < headerSize+sealSaltSize || !bytes.Equal(sealed[:len(sealMagic)], sealMagic) {
+		return nil, ErrSealedDataInvalid
+	}
+	if sealed[len(sealMagic)] != sealVersion {
+		return nil, errors.New("sealed data has an unsupported version")
+	}
+	header := sealed[:headerSize]
+	salt := sealed[headerSize : headerSize+sealSaltSize]
+
+	aead, err := sealCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	rest := sealed[headerSize+sealSaltSize:]
+	if len(rest) < aead.NonceSize() {
+		return nil, ErrSealedDataInvalid
+	}
+
+	data, err := aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], header)
+	if err != nil {
+		return nil, ErrSealedDataInvalid
+	}
+	return data, nil
+}
+
+func sealCipher(passphrase []byte, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
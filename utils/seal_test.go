@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestSealWithPassphrase(t *testing.T) {
+	data := []byte("a key share")
+	sealed, err := SealWithPassphrase(data, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, data) {
+		t.Error("sealed data contains the plaintext")
+	}
+
+	opened, err := OpenWithPassphrase(sealed, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, data) {
+		t.Errorf("opened %q, want %q", opened, data)
+	}
+
+	if _, err := OpenWithPassphrase(sealed, []byte("wrong")); !errors.Is(err, ErrSealedDataInvalid) {
+		t.Errorf("opened with a wrong passphrase: %v", err)
+	}
+
+	// any changed byte, including the header, is caught
+	for _, i := range []int{0, len(sealMagic) + 1, len(sealed) - 1} {
+		changed := append([]byte{}, sealed...)
+		changed[i] ^= 1
+		if _, err := OpenWithPassphrase(changed, []byte("passphrase")); !errors.Is(err, ErrSealedDataInvalid) {
+			t.Errorf("opened with byte %d changed: %v", i, err)
+		}
+	}
+	if _, err := OpenWithPassphrase(sealed[:len(sealMagic)+1+sealSaltSize], []byte("passphrase")); !errors.Is(err, ErrSealedDataInvalid) {
+		t.Errorf("opened truncated data: %v", err)
+	}
+}
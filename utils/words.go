@@ -0,0 +1,38 @@
+package utils
+
+// One word for every byte value, for bytes people read out or type back in: the paper backups and the keygen
+// fingerprints. All words have at least four letters and a unique first four letters, so just those are enough.
+var Words = [256]string{
+	"acid", "acorn", "actor", "agent", "alarm", "alert", "alley", "angle",
+	"ankle", "april", "arena", "armor", "atlas", "attic", "award", "axis",
+	"badge", "bagel", "bamboo", "banjo", "barn", "batch", "beach", "bench",
+	"berry", "bird", "bison", "blanket", "bloom", "board", "bonus", "boot",
+	"bowl", "brain", "bridge", "broom", "buffalo", "bulb", "bunny", "cabin",
+	"cactus", "candle", "canoe", "carbon", "cargo", "castle", "cattle", "cedar",
+	"chalk", "cherry", "chimney", "circle", "clam", "cliff", "cloud", "coast",
+	"cobra", "comet", "copper", "cotton", "cousin", "crayon", "cricket", "crystal",
+	"cube", "cupboard", "cycle", "daisy", "delta", "denim", "diamond", "dinner",
+	"donkey", "dragon", "drama", "duck", "dune", "earth", "eclipse", "elder",
+	"elephant", "engine", "envelope", "equator", "fabric", "falcon", "fancy", "farm",
+	"fence", "ferry", "field", "finger", "fiscal", "flame", "flute", "fossil",
+	"fountain", "frog", "fruit", "galaxy", "garden", "garlic", "gecko", "giant",
+	"giraffe", "glacier", "globe", "glove", "gold", "gorilla", "grape", "guitar",
+	"habit", "harbor", "harvest", "hazel", "helmet", "hinge", "hockey", "honey",
+	"hotel", "hunter", "icon", "igloo", "infant", "inkwell", "island", "ivory",
+	"jaguar", "jelly", "jewel", "jockey", "journey", "juice", "jungle", "kernel",
+	"kettle", "kingdom", "kitten", "kiwi", "knife", "koala", "ladder", "lagoon",
+	"lantern", "laptop", "lawn", "lemon", "leopard", "lily", "lion", "lobster",
+	"locket", "magnet", "mango", "marble", "market", "meadow", "mercury", "mirror",
+	"moose", "mosaic", "muffin", "museum", "napkin", "navy", "nectar", "nephew",
+	"nest", "noodle", "north", "oasis", "ocean", "omelet", "onion", "opera",
+	"orchid", "otter", "oyster", "paddle", "panda", "parrot", "peach", "pebble",
+	"pelican", "pepper", "piano", "pillow", "pilot", "planet", "plum", "polar",
+	"potato", "pumpkin", "quail", "quartz", "rabbit", "radar", "raft", "rainbow",
+	"record", "reef", "rhino", "rifle", "river", "rocket", "rose", "rugby",
+	"saddle", "sandal", "satin", "scarf", "scout", "shadow", "sheep", "shell",
+	"silver", "siren", "sketch", "sleigh", "slipper", "socket", "soda", "sponge",
+	"squid", "statue", "sugar", "sunset", "swan", "table", "timber", "toast",
+	"tonic", "torch", "trumpet", "tulip", "turtle", "tuxedo", "umbrella", "uniform",
+	"urchin", "valley", "vapor", "venus", "violin", "volcano", "voyage", "wagon",
+	"walrus", "water", "wheat", "window", "wizard", "wolf", "yogurt", "zebra",
+}
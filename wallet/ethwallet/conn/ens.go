@@ -0,0 +1,64 @@
+package conn
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"golang.org/x/crypto/sha3"
+)
+
+// The ENS registry, at the same address on mainnet and the test networks
+var ENSRegistry = common.HexToAddress("0x00000000000C2E074eC69A0dFb2997BA6C7d2e1e")
+
+// Function selectors of resolver(bytes32) on the registry and addr(bytes32) on a resolver
+var (
+	SelectorResolver = []byte{0x01, 0x78, 0xb8, 0xbf}
+	SelectorAddr     = []byte{0x3b, 0x3b, 0x57, 0xde}
+)
+
+// The ENS namehash of a name, e.g. vitalik.eth. Names are only lowercased, not fully normalized.
+func NameHash(name string) common.Hash {
+	node := common.Hash{}
+	if name == "" {
+		return node
+	}
+	labels := strings.Split(strings.ToLower(name), ".")
+	for i := len(labels) - 1; i >= 0; i-- {
+		labelHash := keccak256([]byte(labels[i]))
+		node = common.BytesToHash(keccak256(node.Bytes(), labelHash))
+	}
+	return node
+}
+
+// Resolve an ENS name to the address it points to, the zero address if it has none. The name is looked up
+// with eth_call on the registry and its resolver through the JSON-RPC endpoint of the connection.
+func (c *EthConn) ResolveName(name string) (common.Address, error) {
+	node := NameHash(name)
+
+	result, err := c.CallContract(CallMsg{To: &ENSRegistry, Data: append(append([]byte{}, SelectorResolver...), node.Bytes()...)})
+	if err != nil {
+		return common.Address{}, fmt.Errorf("error looking up the resolver: %w", err)
+	}
+	resolver := common.BytesToAddress(result)
+	if len(result) < 32 || resolver == (common.Address{}) {
+		return common.Address{}, nil
+	}
+
+	result, err = c.CallContract(CallMsg{To: &resolver, Data: append(append([]byte{}, SelectorAddr...), node.Bytes()...)})
+	if err != nil {
+		return common.Address{}, fmt.Errorf("error looking up the address: %w", err)
+	}
+	if len(result) < 32 {
+		return common.Address{}, nil
+	}
+	return common.BytesToAddress(result[:32]), nil
+}
+
+func keccak256(data ...[]byte) []byte {
+	h := sha3.NewLegacyKeccak256()
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
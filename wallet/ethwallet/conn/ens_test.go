@@ -0,0 +1,91 @@
+package conn
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+func TestNameHash(t *testing.T) {
+	cases := map[string]string{
+		"":        "0x0000000000000000000000000000000000000000000000000000000000000000",
+		"eth":     "0x93cdeb708b7545dc668eb9280176169d1c33cfd8ed6f04690a0bcc88a93fc4ae",
+		"foo.eth": "0xde9b09fd7c5f901e23a3f19fecc54828e9c848539801e86591bd9801b019f84f",
+		"FOO.eth": "0xde9b09fd7c5f901e23a3f19fecc54828e9c848539801e86591bd9801b019f84f",
+	}
+	for name, want := range cases {
+		if got := NameHash(name).Hex(); got != want {
+			t.Errorf("namehash of %q is %s, want %s", name, got, want)
+		}
+	}
+}
+
+// A JSON-RPC endpoint answering eth_call for the ENS registry and one resolver, which knows the names in addrs
+func ensStub(t *testing.T, resolver common.Address, addrs map[string]common.Address) *EthConn {
+	word := func(a common.Address) string {
+		return hexutil.Encode(common.LeftPadBytes(a.Bytes(), 32))
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := struct {
+			Method string
+			Params []json.RawMessage
+		}{}
+		call := struct {
+			To   common.Address
+			Data hexutil.Bytes
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Method != "eth_call" || len(req.Params) == 0 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if err := json.Unmarshal(req.Params[0], &call); err != nil || len(call.Data) != 36 {
+			http.Error(w, "bad call", http.StatusBadRequest)
+			return
+		}
+
+		result := word(common.Address{})
+		for name, addr := range addrs {
+			if !bytes.Equal(call.Data[4:], NameHash(name).Bytes()) {
+				continue
+			}
+			switch {
+			case call.To == ENSRegistry && bytes.Equal(call.Data[:4], SelectorResolver):
+				result = word(resolver)
+			case call.To == resolver && bytes.Equal(call.Data[:4], SelectorAddr):
+				result = word(addr)
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "result": result})
+	}))
+	t.Cleanup(srv.Close)
+	return NewEthConn(srv.URL)
+}
+
+func TestResolveName(t *testing.T) {
+	resolver := common.HexToAddress("0x4976fb03C32e5B8cfe2b6cCB31c09Ba78EBaBa41")
+	vitalik := common.HexToAddress("0xd8dA6BF26964aF9D7eEd9e03E53415D37aA96045")
+	c := ensStub(t, resolver, map[string]common.Address{"vitalik.eth": vitalik})
+
+	addr, err := c.ResolveName("vitalik.eth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr != vitalik {
+		t.Errorf("resolved to %s, want %s", addr.Hex(), vitalik.Hex())
+	}
+
+	// a name without a resolver has no address
+	addr, err = c.ResolveName("nobody.eth")
+	if err != nil || addr != (common.Address{}) {
+		t.Errorf("unknown name resolved to %s: %v", addr.Hex(), err)
+	}
+
+	if _, err := NewEthConn("http://127.0.0.1:1").ResolveName("vitalik.eth"); err == nil {
+		t.Error("resolved without an endpoint")
+	}
+}
@@ -7,9 +7,11 @@ import (
 	"io/ioutil"
 	"math/big"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/shykerbogdan/mpc-wallet/metrics"
 	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet/types"
 	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet/utils"
 )
@@ -35,7 +37,17 @@ func NewEthConn(url string) *EthConn {
 	}
 }
 
-func (c *EthConn) get(route string, result interface{}) error {
+// The method of a route for the metrics, without its query
+func routeMethod(route string) string {
+	if i := strings.IndexByte(route, '?'); i >= 0 {
+		return route[:i]
+	}
+	return route
+}
+
+func (c *EthConn) get(route string, result interface{}) (err error) {
+	defer func(start time.Time) { metrics.ObserveRPC(routeMethod(route), start, err) }(time.Now())
+
 	req, err := http.NewRequest("GET", fmt.Sprintf("%s/%s", c.url, route), nil)
 	if err != nil {
 		return fmt.Errorf("consturct http request error: %v\n", err)
@@ -61,10 +73,12 @@ func (c *EthConn) get(route string, result interface{}) error {
 	return nil
 }
 
-func (c *EthConn) post(route string, result interface{}, msg interface{}) error {
+func (c *EthConn) post(route string, result interface{}, msg interface{}) (err error) {
+	defer func(start time.Time) { metrics.ObserveRPC(routeMethod(route), start, err) }(time.Now())
+
 	body, err := json.Marshal(msg)
 	if err != nil {
-		fmt.Errorf("json marshal error: %v", err)
+		return fmt.Errorf("json marshal error: %v", err)
 	}
 	req, err := http.NewRequest("POST", fmt.Sprintf("%s/%s", c.url, route), bytes.NewReader(body))
 	req.Header.Set("Content-Type", "application/json")
@@ -0,0 +1,198 @@
+package conn
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/shykerbogdan/mpc-wallet/metrics"
+)
+
+// A call to simulate with eth_call or eth_estimateGas, zero fields are left out
+type CallMsg struct {
+	From     common.Address
+	To       *common.Address
+	Gas      uint64
+	GasPrice *big.Int
+	Value    *big.Int
+	Data     []byte
+}
+
+func (m CallMsg) arg() map[string]interface{} {
+	arg := map[string]interface{}{}
+	if m.From != (common.Address{}) {
+		arg["from"] = m.From.Hex()
+	}
+	if m.To != nil {
+		arg["to"] = m.To.Hex()
+	}
+	if m.Gas != 0 {
+		arg["gas"] = hexutil.EncodeUint64(m.Gas)
+	}
+	if m.GasPrice != nil {
+		arg["gasPrice"] = hexutil.EncodeBig(m.GasPrice)
+	}
+	if m.Value != nil {
+		arg["value"] = hexutil.EncodeBig(m.Value)
+	}
+	if len(m.Data) > 0 {
+		arg["data"] = hexutil.Encode(m.Data)
+	}
+	return arg
+}
+
+// An error returned by the JSON-RPC endpoint, e.g. for a call that reverted
+type RPCError struct {
+	Method  string
+	Code    int
+	Message string
+	// The revert data of a failed call, if the node returned it
+	Data []byte
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("%s failed: %s", e.Method, e.Message)
+}
+
+// Whether executing the call failed, e.g. it reverted, ran out of gas or the sender lacks funds, rather than
+// the request itself
+func (e *RPCError) IsExecutionError() bool {
+	// 3 is a revert with data, -32000 the generic error geth returns for the others
+	return e.Code == 3 || e.Code == -32000 || e.Code == -32015
+}
+
+// Why the call failed, the decoded Error(string) or Panic(uint256) revert data if there is any
+func (e *RPCError) RevertReason() string {
+	if reason, err := abi.UnpackRevert(e.Data); err == nil {
+		return reason
+	}
+	if len(e.Data) == 36 && bytes.Equal(e.Data[:4], selectorPanic) {
+		return fmt.Sprintf("panic code 0x%x", new(big.Int).SetBytes(e.Data[4:]))
+	}
+	if len(e.Data) > 0 {
+		return fmt.Sprintf("%s, data %s", e.Message, hexutil.Encode(e.Data))
+	}
+	return e.Message
+}
+
+// Selector of Panic(uint256), the revert data of failed asserts, overflows and the like
+var selectorPanic = []byte{0x4e, 0x48, 0x7b, 0x71}
+
+// Run a call on the latest state without making a transaction, returning what it returned
+func (c *EthConn) CallContract(msg CallMsg) ([]byte, error) {
+	var result string
+	if err := c.rpc("eth_call", []interface{}{msg.arg(), "latest"}, &result); err != nil {
+		return nil, err
+	}
+	return hexutil.Decode(result)
+}
+
+// Estimate the gas a transaction needs on the latest state, which fails like CallContract if it reverts
+func (c *EthConn) EstimateGas(msg CallMsg) (uint64, error) {
+	var result string
+	if err := c.rpc("eth_estimateGas", []interface{}{msg.arg()}, &result); err != nil {
+		return 0, err
+	}
+	return hexutil.DecodeUint64(result)
+}
+
+// The base fees and priority fee percentiles of recent blocks, from eth_feeHistory
+type FeeHistory struct {
+	// The base fee of each block, and of the next block last
+	BaseFees []*big.Int
+	// For each block, the priority fee at each of the requested percentiles
+	Rewards [][]*big.Int
+}
+
+// The fee history of the latest blocks, with the priority fees paid at the percentiles (0 to 100) in each block
+func (c *EthConn) FeeHistory(blocks int, percentiles []float64) (*FeeHistory, error) {
+	var result struct {
+		BaseFeePerGas []*hexutil.Big   `json:"baseFeePerGas"`
+		Reward        [][]*hexutil.Big `json:"reward"`
+	}
+	if err := c.rpc("eth_feeHistory", []interface{}{hexutil.EncodeUint64(uint64(blocks)), "latest", percentiles}, &result); err != nil {
+		return nil, err
+	}
+	if len(result.BaseFeePerGas) == 0 {
+		return nil, errors.New("eth_feeHistory returned no base fees, the network may not support EIP-1559")
+	}
+
+	history := &FeeHistory{}
+	for _, fee := range result.BaseFeePerGas {
+		history.BaseFees = append(history.BaseFees, fee.ToInt())
+	}
+	for _, block := range result.Reward {
+		rewards := []*big.Int{}
+		for _, fee := range block {
+			rewards = append(rewards, fee.ToInt())
+		}
+		history.Rewards = append(history.Rewards, rewards)
+	}
+	return history, nil
+}
+
+type rpcRequest struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      int           `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type rpcResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int             `json:"code"`
+		Message string          `json:"message"`
+		Data    json.RawMessage `json:"data"`
+	} `json:"error"`
+}
+
+// Make a JSON-RPC call to the endpoint of the connection
+func (c *EthConn) rpc(method string, params []interface{}, result interface{}) (err error) {
+	defer func(start time.Time) { metrics.ObserveRPC(method, start, err) }(time.Now())
+
+	body, err := json.Marshal(rpcRequest{JSONRPC: "2.0", ID: 1, Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+	res, err := c.conn.Post(c.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("connected error: %v", err)
+	}
+	defer res.Body.Close()
+
+	resBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s failed with status %s: %s", method, res.Status, string(resBody))
+	}
+
+	var response rpcResponse
+	if err := json.Unmarshal(resBody, &response); err != nil {
+		return fmt.Errorf("json unmarshal resbody error: %v", err)
+	}
+	if response.Error != nil {
+		rpcErr := &RPCError{Method: method, Code: response.Error.Code, Message: response.Error.Message}
+		// Revert data is a hex string, some nodes leave it out or send other details
+		var data string
+		if json.Unmarshal(response.Error.Data, &data) == nil && strings.HasPrefix(data, "0x") {
+			rpcErr.Data, _ = hexutil.Decode(data)
+		}
+		return rpcErr
+	}
+	if len(response.Result) == 0 {
+		return errors.New(method + " returned no result")
+	}
+	return json.Unmarshal(response.Result, result)
+}
@@ -3,32 +3,43 @@ package ethwallet
 import (
 	stdecdsa "crypto/ecdsa"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
-	"math"
-	stdmath "math"
 	"math/big"
 	"sort"
 	"sync"
 	"time"
 
 	secp256k1 "github.com/decred/dcrd/dcrec/secp256k1/v3"
-	
-    secp256k1Eth "github.com/ethereum/go-ethereum/crypto/secp256k1"
+
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/shykerbogdan/mpc-wallet/constants"
+	"github.com/shykerbogdan/mpc-wallet/logging"
 	"github.com/shykerbogdan/mpc-wallet/user"
 
 	"github.com/fxamacker/cbor/v2"
 	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet/conn"
 	ethcrypto "github.com/shykerbogdan/mpc-wallet/wallet/ethwallet/crypto"
+	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet/fees"
+	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet/txpreview"
 	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet/types"
+	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet/units"
 	mpsecdsa "github.com/taurusgroup/multi-party-sig/pkg/ecdsa"
 	"github.com/taurusgroup/multi-party-sig/pkg/math/curve"
+	"github.com/taurusgroup/multi-party-sig/pkg/math/polynomial"
 	"github.com/taurusgroup/multi-party-sig/pkg/party"
 	mpsconfig "github.com/taurusgroup/multi-party-sig/protocols/cmp/config"
 )
 
+var logger = logging.New("wallet")
+
+var ErrUnconfirmed = errors.New("keygen of the wallet has not been confirmed by all signers")
+
+var (
+	secp256k1N     = secp256k1.S256().N
+	secp256k1HalfN = new(big.Int).Rsh(secp256k1N, 1)
+)
+
 type Asset struct {
 	AssetID      string
 	Name         string
@@ -37,15 +48,25 @@ type Asset struct {
 }
 
 type Wallet struct {
-	Name      string
+	Name string
+	// The project room the wallet belongs to, empty for the project of the config
+	Project   string `json:",omitempty"`
 	Threshold int
 	Me        user.User
 	Others    []user.User
-	KeyData   []byte
+	// Nicks of the other signers we prefer to sign with, most preferred first
+	PreferredSigners []string `json:",omitempty"`
+	KeyData          []byte
+	// Keygen completed but not all signers confirmed they got the same result. The share is kept, but can not
+	// sign until the confirmation has been run again.
+	Unconfirmed bool `json:",omitempty"`
 	// Public address computed from the MPC config and stored here so it shows up in the persisted JSON for reference
 	Address string
 	// Config params for a blockchain
 	Config constants.ChainConfig
+	// How to pick the fees of transactions, and the most a transaction may pay in fees in wei (no limit if nil)
+	Fees   fees.Strategy `json:",omitempty"`
+	MaxFee *big.Int      `json:",omitempty"`
 
 	CreatedAt time.Time
 
@@ -105,6 +126,14 @@ func (w *Wallet) UnmarshalJSON(data []byte) error {
 		return err
 	}
 
+	// Initialize exits on a share it can not decode, so report a damaged or foreign wallet as an error instead
+	if len(w.KeyData) == 0 {
+		return fmt.Errorf("wallet %s has no key share", w.Name)
+	}
+	if err := cbor.Unmarshal(w.KeyData, mpsconfig.EmptyConfig(curve.Secp256k1{})); err != nil {
+		return fmt.Errorf("key share of wallet %s can not be decoded: %w", w.Name, err)
+	}
+
 	w.Initialize(w.KeyData)
 	return nil
 }
@@ -118,6 +147,15 @@ func (w *Wallet) Initialize(keydata []byte) {
 
 	// Store in the struct so it gets serialized for easy reference
 	w.Address = w.GetFormattedAddress()
+
+	if w.conn == nil {
+		w.conn = conn.NewEthConn(w.Config.RPCHostURL)
+	}
+}
+
+// Use a specific blockchain backend connection, e.g. a local node or a simulated backend
+func (w *Wallet) SetConn(c *conn.EthConn) {
+	w.conn = c
 }
 
 func (w *Wallet) GetName() string     { return w.Name }
@@ -157,16 +195,87 @@ func (w *Wallet) AllPartyNicks() []string {
 	return list
 }
 
+// Sort users by the signer preference of the wallet, then by nick
+func (w *Wallet) SortByPreference(users []user.User) {
+	rank := make(map[string]int)
+	for i, nick := range w.PreferredSigners {
+		rank[nick] = i + 1
+	}
+	sort.SliceStable(users, func(i, j int) bool {
+		ri, rj := rank[users[i].Nick], rank[users[j].Nick]
+		if ri != rj {
+			// Unranked (0) go last
+			return rj == 0 || (ri != 0 && ri < rj)
+		}
+		return users[i].Nick < users[j].Nick
+	})
+}
+
 // Unmarshal the MPC config which contains the key data
 func (w *Wallet) GetUnwrappedKeyData() mpsconfig.Config {
 	c := mpsconfig.EmptyConfig(curve.Secp256k1{})
 	err := cbor.Unmarshal(w.KeyData, c)
 	if err != nil {
-		log.Fatalf("GetUnwrappedKeyData error %v", err)
+		logger.Fatal("Error unmarshaling the key data", "wallet", w.Name, "err", err)
 	} 
 	return *c
 }
 
+// Check that the key share is consistent with the wallet: it belongs to us, our secret shares match our
+// public shares, the public shares of all signers lie on the same polynomial and the public point they
+// define is the wallet address. Used to catch a corrupted or mismatched share, e.g. when restoring a backup.
+func (w *Wallet) VerifyKeyShare() error {
+	kd := mpsconfig.EmptyConfig(curve.Secp256k1{})
+	if err := cbor.Unmarshal(w.KeyData, kd); err != nil {
+		return fmt.Errorf("key share can not be decoded: %w", err)
+	}
+
+	if kd.ID != w.Me.PartyID() {
+		return fmt.Errorf("key share belongs to party %s, not %s", kd.ID, w.Me.PartyID())
+	}
+	if kd.Threshold != w.Threshold {
+		return fmt.Errorf("key share threshold %d does not match wallet threshold %d", kd.Threshold, w.Threshold)
+	}
+	if all := w.AllPartyIDs(); len(kd.Public) != len(all) || !kd.PartyIDs().Contains(all...) {
+		return errors.New("key share signers do not match the wallet signers")
+	}
+
+	public, ok := kd.Public[kd.ID]
+	if !ok {
+		return errors.New("key share has no public share for us")
+	}
+	if !kd.ECDSA.ActOnBase().Equal(public.ECDSA) {
+		return errors.New("secret share does not match our public share")
+	}
+	if !kd.ElGamal.ActOnBase().Equal(public.ElGamal) {
+		return errors.New("ElGamal secret does not match our public ElGamal key")
+	}
+	if kd.Paillier == nil || !kd.Paillier.PublicKey.Equal(public.Paillier) {
+		return errors.New("Paillier secret key does not match our public Paillier key")
+	}
+
+	// Any threshold+1 public shares must interpolate to the same public point. Checking each window
+	// of consecutive signers is enough, since neighbouring windows share all but one signer.
+	ids := kd.PartyIDs()
+	point := kd.PublicPoint()
+	for i := 0; i+kd.Threshold < len(ids); i++ {
+		window := ids[i : i+kd.Threshold+1]
+		sum := kd.Group.NewPoint()
+		for id, l := range polynomial.Lagrange(kd.Group, window) {
+			sum = sum.Add(l.Act(kd.Public[id].ECDSA))
+		}
+		if !sum.Equal(point) {
+			return errors.New("public shares of the signers are inconsistent")
+		}
+	}
+
+	if w.Address != "" && w.Address != w.GetFormattedAddress() {
+		return fmt.Errorf("key share is for address %s, not the wallet address %s", w.GetFormattedAddress(), w.Address)
+	}
+
+	return nil
+}
+
 // From the MPC key data, convert to an eth public key
 func (w *Wallet) PublicKeyEth() stdecdsa.PublicKey {
 	kd := w.GetUnwrappedKeyData()
@@ -216,16 +325,11 @@ func (ew *Wallet) FetchBalance() error {
 	defer ew.doneFetching()
 	defer ew.mutex.Unlock()	
 
-	commonAddress := ew.GetCommonAddress()
-	stringadd:= commonAddress.String()
-	fmt.Errorf("Common address was : %s", stringadd)
-
-	balance, err := ew.conn.GetBalance(commonAddress)
-	ew.balance = *balance
-
+	balance, err := ew.conn.GetBalance(ew.GetCommonAddress())
 	if err != nil {
 		return err
 	}
+	ew.balance = *balance
 	return nil
 }
 
@@ -233,17 +337,17 @@ func (w *Wallet) doneFetching() {
 	w.isFetching = false
 }
 
-// Balance returns the amount of the assets in this wallet
-func (w *Wallet) Balance() uint64 {
-	return w.balance.Uint64()
+// Balance returns the balance in wei as of the last FetchBalance
+func (w *Wallet) Balance() *big.Int {
+	return new(big.Int).Set(&w.balance)
 }
 
+// The balance in ether, exact
 func (w *Wallet) BalanceForDisplay(assetID string) string {
 	if w.IsFetching() {
 		return "<fetching balance>"
 	} else {
-		f := float64(w.Balance())
-		return fmt.Sprintf("%f", f)
+		return units.Format(w.Balance(), units.Ether)
 	}
 }
 
@@ -298,7 +402,70 @@ func (ew *Wallet) CreateNormalTransaction(to *common.Address, value *big.Int, da
 	return tx, nil
 }
 
+// Create a transaction with the current nonce and the gas price of the fee strategy, and simulate it. Its gas
+// limit is the estimate of the simulation with the headroom of the strategy, or a default if it reverts.
+func (ew *Wallet) CreateSimulatedTransaction(to *common.Address, value *big.Int, data []byte, strategy fees.Strategy) (*types.Transaction, *txpreview.Preview, error) {
+	gasPrice, err := ew.PickGasPrice(strategy)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce, err := ew.GetNonce(types.Latest)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting the nonce: %w", err)
+	}
+
+	tx := ew.NewTransaction(to, value, data, gasPrice, 0, nonce)
+	preview := ew.Simulate(tx)
+	switch {
+	case preview.GasEstimate != 0:
+		tx.GasLimit = strategy.GasLimit(preview.GasEstimate, len(data) > 0)
+	case len(data) == 0:
+		tx.GasLimit = txpreview.TransferGasLimit
+	default:
+		tx.GasLimit = txpreview.ContractCallGasLimit
+	}
+	return tx, preview, nil
+}
+
+// Refuse a wallet whose keygen has not been confirmed by all signers
+func (w *Wallet) CheckConfirmed() error {
+	if w.Unconfirmed {
+		return fmt.Errorf("%w: %s, run the confirmation again first", ErrUnconfirmed, w.Name)
+	}
+	return nil
+}
+
+// Check the fee a transaction may pay against the maximum fee of the wallet
+func (ew *Wallet) CheckMaxFee(tx *types.Transaction) error {
+	return fees.CheckMaxFee(tx.GasPrice, tx.GasLimit, ew.MaxFee)
+}
+
+// The gas price the strategy picks on the current state of the chain
+func (ew *Wallet) PickGasPrice(strategy fees.Strategy) (*big.Int, error) {
+	return strategy.PickGasPrice(ew.conn)
+}
+
+// Simulate a transaction of the wallet on the current state of the chain
+func (ew *Wallet) Simulate(tx *types.Transaction) *txpreview.Preview {
+	return txpreview.Simulate(ew.conn, tx)
+}
+
+// Build a transaction from known parameters, e.g. ones another signer proposed
+func (ew *Wallet) NewTransaction(to *common.Address, value *big.Int, data []byte, gasPrice *big.Int, gasLimit uint64, nonce uint64) *types.Transaction {
+	address := ew.GetCommonAddress()
+	return &types.Transaction{
+		From:     &address,
+		To:       to,
+		GasPrice: gasPrice,
+		GasLimit: gasLimit,
+		Nonce:    nonce,
+		Value:    value,
+		Data:     data,
+	}
+}
+
 // Convert the signature generated by the MPC protocol into an eth recoverable signature
+// Eth sig is r | s | v where v is used to encode the recovery ID (EIP-155)
 func (w *Wallet) MpcSigToEthSig(hashedmsg []byte, mpcsig *mpsecdsa.Signature) ([]byte, error) {
 	rb, err := mpcsig.R.XScalar().MarshalBinary()
 	if err != nil {
@@ -310,30 +477,30 @@ func (w *Wallet) MpcSigToEthSig(hashedmsg []byte, mpcsig *mpsecdsa.Signature) ([
 		return []byte{}, err
 	}
 
-	rs := append(rb, sb...)
-	v, err := secp256k1Eth.RecoverPubkey(hashedmsg, rs)
+	// Ethereum only accepts the lower of the two valid s values (EIP-2)
+	s := new(big.Int).SetBytes(sb)
+	if s.Cmp(secp256k1HalfN) > 0 {
+		s.Sub(secp256k1N, s)
+	}
 
-	 
-    // Convert V to an Ethereum-compatible format
-	if v[31] == 0 {
-        v[31] += w.Config.NetworkID * 2 + 35 
-    } else if v[31] == 1 {
-        v[31] += w.Config.NetworkID * 2 + 35
-    } else {
-        v[31] += w.Config.NetworkID *2 + 4
-    }
-    
-    // Concatenate R, S, and V into a single slice in Ethereum format
-    ethSig := append(rs, v[31])
+	rs := make([]byte, 64)
+	copy(rs[32-len(rb):32], rb)
+	s.FillBytes(rs[32:64])
 
-	return ethSig, nil
+	// The MPC lib does not hand us the recovery id, so find the one that recovers our public key
+	pub := w.PublicKeyEth()
+	for recid := byte(0); recid < 2; recid++ {
+		sig := append(append([]byte{}, rs...), recid)
+		recovered, err := ethcrypto.SigToPub(hashedmsg, sig)
+		if err != nil {
+			continue
+		}
+		if recovered.X.Cmp(pub.X) == 0 && recovered.Y.Cmp(pub.Y) == 0 {
+			return append(rs, recid+w.Config.NetworkID*2+35), nil
+		}
+	}
 
-	// // Eth sig is r | s | v  where v is used to encode the recovery ID
-	// var sigeth [65]byte
-	// copy(sigeth[0:32], rb[0:32])
-	// copy(sigeth[32:64], sb[0:32])
-	// copy(sigeth[64:], []byte{sigeth[64] + 35 + w.Config.NetworkID*2})
-	// return sigeth[:], nil
+	return []byte{}, errors.New("signature does not recover to the wallet public key")
 }
 
 func CheckValueEnough(value *big.Int, gasPrice *big.Int, gasLimit uint64, ether *big.Int) bool {
@@ -358,8 +525,8 @@ func (w *Wallet) GetAsset(assetID string) Asset {
 	return w.assets[assetID]
 }
 
-func (w *Wallet) FormatAmount(asset Asset, amt uint64) string {
-	return fmt.Sprintf("%.4f", float64(amt)/stdmath.Pow(10, float64(asset.Denomination)))
+func (w *Wallet) FormatAmount(asset Asset, amt *big.Int) string {
+	return units.Format(amt, int(asset.Denomination))
 }
 
 func (ew *Wallet) GetBalance() (*big.Int, error) {
@@ -370,6 +537,19 @@ func (ew *Wallet) GetBalance() (*big.Int, error) {
 	return balance, nil
 }
 
+// Check the blockchain backend of the wallet answers
+func (ew *Wallet) CheckBackend() error {
+	if ew.conn == nil {
+		return errors.New("no blockchain backend")
+	}
+	_, err := ew.conn.GetBlockNumber()
+	return err
+}
+
+func (ew *Wallet) ResolveName(name string) (common.Address, error) {
+	return ew.conn.ResolveName(name)
+}
+
 func (ew *Wallet) GetGasPrice() (*big.Int, error) {
 	gasPrice, err := ew.conn.GetGasPrice()
 	if err != nil {
@@ -405,10 +585,3 @@ func (ew *Wallet) ConstructEtherscanUrl(networkName, txid string) string {
 func (ew *Wallet) ToCommonAddress(address string) common.Address {
 	return common.HexToAddress(address)
 }
-
-func weiToEther(wei *big.Int) string {
-	fwei := new(big.Float)
-	fwei.SetString(wei.String())
-	ethValue := new(big.Float).Quo(fwei, big.NewFloat(math.Pow10(18)))
-	return ethValue.String()
-}
@@ -0,0 +1,206 @@
+// Package fees picks the gas price and gas limit of a transaction, following a fee strategy: a speed based
+// on the fees paid in recent blocks or a fixed gas price, optionally capped, and headroom for the gas limit.
+package fees
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet/conn"
+	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet/units"
+)
+
+// The speeds of the fee history strategies
+const (
+	Slow   = "slow"
+	Normal = "normal"
+	Fast   = "fast"
+)
+
+// Gas limit estimates are multiplied by this by default, as state changes between the simulation and the
+// transaction running can make it need more gas
+const DefaultGasLimitMultiplier = 1.2
+
+// The gas of a plain ether transfer, which never needs headroom
+const transferGas = 21000
+
+// Blocks of fee history the speeds are computed from
+const historyBlocks = 20
+
+type speed struct {
+	// Percentile of the priority fees paid in recent blocks
+	percentile float64
+	// Headroom on the base fee of the next block, which rises by at most 12.5% per block
+	baseFeeNum, baseFeeDen int64
+}
+
+var speeds = map[string]speed{
+	Slow:   {percentile: 10, baseFeeNum: 1, baseFeeDen: 1},
+	Normal: {percentile: 50, baseFeeNum: 9, baseFeeDen: 8},
+	Fast:   {percentile: 90, baseFeeNum: 81, baseFeeDen: 64},
+}
+
+var ErrFeeTooHigh = errors.New("transaction fee is above the maximum fee of the wallet")
+
+// How to pick the fees of a transaction. The zero value is the normal speed with the default gas limit
+// multiplier.
+type Strategy struct {
+	// slow, normal or fast, ignored if GasPrice is set
+	Speed string `json:",omitempty"`
+	// A fixed gas price in wei
+	GasPrice *big.Int `json:",omitempty"`
+	// The most to pay per gas in wei, higher gas prices are capped
+	MaxGasPrice *big.Int `json:",omitempty"`
+	// Multiplier of gas limit estimates, at least 1
+	GasLimitMultiplier float64 `json:",omitempty"`
+}
+
+// Parse a speed, or a fixed gas price like "30 gwei". Gas prices without a unit are in gwei.
+func Parse(s string) (Strategy, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return Strategy{}, nil
+	}
+	if _, ok := speeds[s]; ok {
+		return Strategy{Speed: s}, nil
+	}
+	price, err := units.Parse(s, units.Gwei)
+	if err != nil {
+		return Strategy{}, fmt.Errorf("invalid fee %q, expected slow, normal, fast or a gas price like 30gwei: %v", s, err)
+	}
+	return Strategy{GasPrice: price}, nil
+}
+
+// The strategy with the speed or fixed gas price parsed from s, keeping its cap and gas limit multiplier.
+// An empty s keeps the strategy as it is.
+func (s Strategy) Override(speedOrPrice string) (Strategy, error) {
+	if strings.TrimSpace(speedOrPrice) == "" {
+		return s, nil
+	}
+	parsed, err := Parse(speedOrPrice)
+	if err != nil {
+		return s, err
+	}
+	s.Speed, s.GasPrice = parsed.Speed, parsed.GasPrice
+	return s, nil
+}
+
+func (s Strategy) Validate() error {
+	if s.GasPrice == nil {
+		if _, ok := speeds[s.speed()]; !ok {
+			return fmt.Errorf("unknown fee speed %q, expected slow, normal or fast", s.Speed)
+		}
+	} else if s.GasPrice.Sign() <= 0 {
+		return errors.New("gas price must be positive")
+	}
+	if s.MaxGasPrice != nil && s.MaxGasPrice.Sign() <= 0 {
+		return errors.New("maximum gas price must be positive")
+	}
+	if s.GasLimitMultiplier != 0 && s.GasLimitMultiplier < 1 {
+		return fmt.Errorf("gas limit multiplier %v must be at least 1", s.GasLimitMultiplier)
+	}
+	return nil
+}
+
+func (s Strategy) speed() string {
+	if s.Speed == "" {
+		return Normal
+	}
+	return s.Speed
+}
+
+// e.g. "fast, at most 50 gwei" or "30 gwei"
+func (s Strategy) String() string {
+	desc := s.speed()
+	if s.GasPrice != nil {
+		desc = units.Format(s.GasPrice, units.Gwei) + " gwei"
+	}
+	if s.MaxGasPrice != nil {
+		desc += fmt.Sprintf(", at most %s gwei", units.Format(s.MaxGasPrice, units.Gwei))
+	}
+	if s.GasLimitMultiplier != 0 && s.GasLimitMultiplier != DefaultGasLimitMultiplier {
+		desc += fmt.Sprintf(", gas limit x%v", s.GasLimitMultiplier)
+	}
+	return desc
+}
+
+// The blockchain calls picking a gas price needs, implemented by conn.EthConn
+type Backend interface {
+	FeeHistory(blocks int, percentiles []float64) (*conn.FeeHistory, error)
+	GetGasPrice() (*big.Int, error)
+}
+
+// The gas price to pay according to the strategy. Networks without a fee history get the gas price the node
+// suggests.
+func (s Strategy) PickGasPrice(b Backend) (*big.Int, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+
+	price := s.GasPrice
+	if price == nil {
+		sp := speeds[s.speed()]
+		history, err := b.FeeHistory(historyBlocks, []float64{sp.percentile})
+		if err == nil {
+			price = sp.gasPrice(history)
+		} else if price, err = b.GetGasPrice(); err != nil {
+			return nil, fmt.Errorf("error getting the gas price: %w", err)
+		}
+	}
+
+	if s.MaxGasPrice != nil && price.Cmp(s.MaxGasPrice) > 0 {
+		price = s.MaxGasPrice
+	}
+	return new(big.Int).Set(price), nil
+}
+
+// The base fee of the next block with headroom, plus the median of the priority fees at the percentile
+func (sp speed) gasPrice(history *conn.FeeHistory) *big.Int {
+	price := new(big.Int).Set(history.BaseFees[len(history.BaseFees)-1])
+	price.Mul(price, big.NewInt(sp.baseFeeNum))
+	price.Div(price, big.NewInt(sp.baseFeeDen))
+
+	tips := []*big.Int{}
+	for _, rewards := range history.Rewards {
+		if len(rewards) > 0 {
+			tips = append(tips, rewards[0])
+		}
+	}
+	if len(tips) > 0 {
+		sort.Slice(tips, func(i, j int) bool { return tips[i].Cmp(tips[j]) < 0 })
+		price.Add(price, tips[len(tips)/2])
+	}
+	return price
+}
+
+// The gas limit for a gas estimate, with headroom unless it is a plain ether transfer
+func (s Strategy) GasLimit(estimate uint64, hasData bool) uint64 {
+	if estimate == transferGas && !hasData {
+		return estimate
+	}
+	multiplier := s.GasLimitMultiplier
+	if multiplier == 0 {
+		multiplier = DefaultGasLimitMultiplier
+	}
+	return uint64(math.Ceil(float64(estimate) * multiplier))
+}
+
+// The most a transaction with the gas price and limit can pay in fees
+func MaxFee(gasPrice *big.Int, gasLimit uint64) *big.Int {
+	return new(big.Int).Mul(gasPrice, new(big.Int).SetUint64(gasLimit))
+}
+
+// Check the fee of a transaction against the maximum fee of a wallet, nil for no maximum
+func CheckMaxFee(gasPrice *big.Int, gasLimit uint64, max *big.Int) error {
+	if max == nil {
+		return nil
+	}
+	if fee := MaxFee(gasPrice, gasLimit); fee.Cmp(max) > 0 {
+		return fmt.Errorf("%w: %s is more than %s", ErrFeeTooHigh, units.FormatEther(fee), units.FormatEther(max))
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package fees
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet/conn"
+)
+
+func gwei(n int64) *big.Int {
+	return new(big.Int).Mul(big.NewInt(n), big.NewInt(1000000000))
+}
+
+// A chain whose next block has a base fee of 64 gwei, where the priority fee at percentile p is p/10 gwei
+type stubBackend struct {
+	noHistory bool
+	gasPrice  *big.Int
+}
+
+func (b stubBackend) FeeHistory(blocks int, percentiles []float64) (*conn.FeeHistory, error) {
+	if b.noHistory {
+		return nil, errors.New("eth_feeHistory is not supported")
+	}
+	h := &conn.FeeHistory{}
+	for i := 0; i < blocks; i++ {
+		h.BaseFees = append(h.BaseFees, gwei(60))
+		h.Rewards = append(h.Rewards, []*big.Int{gwei(int64(percentiles[0]) / 10)})
+	}
+	h.BaseFees = append(h.BaseFees, gwei(64))
+	return h, nil
+}
+
+func (b stubBackend) GetGasPrice() (*big.Int, error) {
+	if b.gasPrice == nil {
+		return nil, errors.New("connection refused")
+	}
+	return b.gasPrice, nil
+}
+
+func TestValidate(t *testing.T) {
+	valid := []Strategy{
+		{},
+		{Speed: Fast},
+		{GasPrice: gwei(30), Speed: "ignored"},
+		{Speed: Slow, MaxGasPrice: gwei(50), GasLimitMultiplier: 1},
+	}
+	for _, s := range valid {
+		if err := s.Validate(); err != nil {
+			t.Errorf("%s: %v", s, err)
+		}
+	}
+
+	invalid := map[string]Strategy{
+		"unknown fee speed":               {Speed: "ludicrous"},
+		"gas price must be positive":      {GasPrice: new(big.Int)},
+		"maximum gas price must be":       {MaxGasPrice: big.NewInt(-1)},
+		"multiplier 0.5 must be at least": {GasLimitMultiplier: 0.5},
+	}
+	for want, s := range invalid {
+		if err := s.Validate(); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%+v: got %v, want %q", s, err, want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	for input, want := range map[string]string{"": "normal", " FAST ": "fast", "30": "30 gwei", "1.5gwei": "1.5 gwei"} {
+		s, err := Parse(input)
+		if err != nil {
+			t.Errorf("%q: %v", input, err)
+		} else if s.String() != want {
+			t.Errorf("%q parsed as %s, want %s", input, s, want)
+		}
+	}
+	if _, err := Parse("quick"); err == nil {
+		t.Error("parsed an unknown speed")
+	}
+
+	capped := Strategy{Speed: Slow, MaxGasPrice: gwei(50), GasLimitMultiplier: 1.5}
+	s, err := capped.Override("40 gwei")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.String() != "40 gwei, at most 50 gwei, gas limit x1.5" {
+		t.Errorf("override is %s", s)
+	}
+}
+
+func TestPickGasPrice(t *testing.T) {
+	cases := []struct {
+		strategy Strategy
+		backend  stubBackend
+		want     *big.Int
+	}{
+		// next base fee plus the priority fee, with headroom for base fee rises
+		{Strategy{Speed: Slow}, stubBackend{}, gwei(64 + 1)},
+		{Strategy{}, stubBackend{}, gwei(72 + 5)},
+		{Strategy{Speed: Fast}, stubBackend{}, gwei(81 + 9)},
+		{Strategy{Speed: Fast, MaxGasPrice: gwei(50)}, stubBackend{}, gwei(50)},
+		{Strategy{GasPrice: gwei(30)}, stubBackend{}, gwei(30)},
+		{Strategy{GasPrice: gwei(30), MaxGasPrice: gwei(20)}, stubBackend{}, gwei(20)},
+		// networks without a fee history
+		{Strategy{Speed: Fast}, stubBackend{noHistory: true, gasPrice: gwei(42)}, gwei(42)},
+	}
+	for _, c := range cases {
+		price, err := c.strategy.PickGasPrice(c.backend)
+		if err != nil {
+			t.Errorf("%s: %v", c.strategy, err)
+		} else if price.Cmp(c.want) != 0 {
+			t.Errorf("%s picked %s, want %s", c.strategy, price, c.want)
+		}
+	}
+
+	if _, err := (Strategy{}).PickGasPrice(stubBackend{noHistory: true}); err == nil {
+		t.Error("picked a gas price without a connection")
+	}
+	if _, err := (Strategy{Speed: "ludicrous"}).PickGasPrice(stubBackend{}); err == nil {
+		t.Error("picked a gas price with an invalid strategy")
+	}
+}
+
+func TestGasLimit(t *testing.T) {
+	cases := []struct {
+		strategy Strategy
+		estimate uint64
+		hasData  bool
+		want     uint64
+	}{
+		{Strategy{}, 21000, false, 21000},
+		{Strategy{}, 21000, true, 25200},
+		{Strategy{}, 100000, true, 120000},
+		{Strategy{GasLimitMultiplier: 1.5}, 100001, true, 150002},
+		{Strategy{GasLimitMultiplier: 1}, 100000, true, 100000},
+	}
+	for _, c := range cases {
+		if got := c.strategy.GasLimit(c.estimate, c.hasData); got != c.want {
+			t.Errorf("%s: gas limit of %d is %d, want %d", c.strategy, c.estimate, got, c.want)
+		}
+	}
+}
+
+func TestCheckMaxFee(t *testing.T) {
+	// 50 gwei * 21000 gas = 0.00105 ether
+	if fee := MaxFee(gwei(50), 21000); fee.Cmp(big.NewInt(1050000000000000)) != 0 {
+		t.Errorf("max fee %s", fee)
+	}
+	if err := CheckMaxFee(gwei(50), 21000, nil); err != nil {
+		t.Errorf("no maximum: %v", err)
+	}
+	if err := CheckMaxFee(gwei(50), 21000, big.NewInt(1050000000000000)); err != nil {
+		t.Errorf("fee at the maximum: %v", err)
+	}
+	err := CheckMaxFee(gwei(50), 21001, big.NewInt(1050000000000000))
+	if !errors.Is(err, ErrFeeTooHigh) {
+		t.Errorf("fee above the maximum: %v", err)
+	}
+}
@@ -0,0 +1,307 @@
+package txpreview
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// The functions of a well known contract interface
+type knownABI struct {
+	Contract string
+	ABI      abi.ABI
+}
+
+// Calldata is decoded against these interfaces, the first one with a matching selector wins. transferFrom
+// has the same selector for ERC-20 and ERC-721 tokens, so it has an interface of its own.
+var knownABIs = []knownABI{
+	mustParseABI("ERC-20",
+		"transfer(address to,uint256 amount)",
+		"approve(address spender,uint256 amount)",
+		"increaseAllowance(address spender,uint256 addedValue)",
+		"decreaseAllowance(address spender,uint256 subtractedValue)",
+	),
+	mustParseABI("ERC-20/ERC-721",
+		"transferFrom(address from,address to,uint256 amount)",
+	),
+	mustParseABI("ERC-721",
+		"safeTransferFrom(address from,address to,uint256 tokenId)",
+		"safeTransferFrom(address from,address to,uint256 tokenId,bytes data)",
+		"setApprovalForAll(address operator,bool approved)",
+	),
+	mustParseABI("WETH",
+		"deposit()",
+		"withdraw(uint256 amount)",
+	),
+	mustParseABI("Safe",
+		"execTransaction(address to,uint256 value,bytes data,uint8 operation,uint256 safeTxGas,uint256 baseGas,uint256 gasPrice,address gasToken,address refundReceiver,bytes signatures)",
+		"addOwnerWithThreshold(address owner,uint256 threshold)",
+		"removeOwner(address prevOwner,address owner,uint256 threshold)",
+		"swapOwner(address prevOwner,address oldOwner,address newOwner)",
+		"changeThreshold(uint256 threshold)",
+		"enableModule(address module)",
+		"disableModule(address prevModule,address module)",
+		"setGuard(address guard)",
+	),
+	mustParseABI("Uniswap V2 Router",
+		"swapExactETHForTokens(uint256 amountOutMin,address[] path,address to,uint256 deadline)",
+		"swapETHForExactTokens(uint256 amountOut,address[] path,address to,uint256 deadline)",
+		"swapExactTokensForETH(uint256 amountIn,uint256 amountOutMin,address[] path,address to,uint256 deadline)",
+		"swapTokensForExactETH(uint256 amountOut,uint256 amountInMax,address[] path,address to,uint256 deadline)",
+		"swapExactTokensForTokens(uint256 amountIn,uint256 amountOutMin,address[] path,address to,uint256 deadline)",
+		"swapTokensForExactTokens(uint256 amountOut,uint256 amountInMax,address[] path,address to,uint256 deadline)",
+	),
+	mustParseABI("Uniswap V3 Router",
+		"exactInputSingle((address tokenIn,address tokenOut,uint24 fee,address recipient,uint256 deadline,uint256 amountIn,uint256 amountOutMinimum,uint160 sqrtPriceLimitX96) params)",
+		"exactInput((bytes path,address recipient,uint256 deadline,uint256 amountIn,uint256 amountOutMinimum) params)",
+		"multicall(bytes[] data)",
+	),
+}
+
+// A decoded contract call
+type Call struct {
+	// The contract called and its interface, e.g. ERC-20
+	To       common.Address
+	Contract string
+	Method   string
+	Args     []Arg
+	// The call this one makes, e.g. the transaction a Safe executes
+	Inner *Call
+}
+
+// An argument of a decoded call
+type Arg struct {
+	Name  string
+	Type  string
+	Value interface{}
+}
+
+// Decode calldata to the contract at to. Returns nil without data, and an error if the function is not one
+// of the known interfaces.
+func Decode(to common.Address, data []byte) (*Call, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	if len(data) < 4 {
+		return nil, fmt.Errorf("calldata %s is too short for a function call", hexutil.Encode(data))
+	}
+
+	for _, known := range knownABIs {
+		method, err := known.ABI.MethodById(data[:4])
+		if err != nil {
+			continue
+		}
+		values, err := method.Inputs.Unpack(data[4:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid arguments of %s %s: %v", known.Contract, method.RawName, err)
+		}
+
+		c := &Call{To: to, Contract: known.Contract, Method: method.RawName}
+		for i, input := range method.Inputs {
+			c.Args = append(c.Args, Arg{Name: input.Name, Type: input.Type.String(), Value: values[i]})
+		}
+		if c.Contract == "Safe" && c.Method == "execTransaction" {
+			if inner, ok := c.Arg("data").([]byte); ok && len(inner) > 0 {
+				// The Safe transaction itself may be any call, only show it if we know it
+				c.Inner, _ = Decode(c.Address("to"), inner)
+			}
+		}
+		return c, nil
+	}
+	return nil, fmt.Errorf("unknown function selector %s", hexutil.Encode(data[:4]))
+}
+
+// The value of the argument with the name, nil if there is none
+func (c *Call) Arg(name string) interface{} {
+	for _, a := range c.Args {
+		if a.Name == name {
+			return a.Value
+		}
+	}
+	return nil
+}
+
+func (c *Call) Address(name string) common.Address {
+	addr, _ := c.Arg(name).(common.Address)
+	return addr
+}
+
+func (c *Call) Amount(name string) *big.Int {
+	amount, _ := c.Arg(name).(*big.Int)
+	return amount
+}
+
+// A field of a tuple argument, e.g. field(c.Arg("params"), "amountIn")
+func field(tuple interface{}, name string) interface{} {
+	v := reflect.ValueOf(tuple)
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	f := v.FieldByName(abi.ToCamelCase(name))
+	if !f.IsValid() {
+		return nil
+	}
+	return f.Interface()
+}
+
+// e.g. ERC-20 transfer(to: 0x..., amount: 1000)
+func (c *Call) String() string {
+	args := []string{}
+	for _, a := range c.Args {
+		args = append(args, fmt.Sprintf("%s: %s", a.Name, formatValue(reflect.ValueOf(a.Value))))
+	}
+	s := fmt.Sprintf("%s %s(%s)", c.Contract, c.Method, strings.Join(args, ", "))
+	if c.Inner != nil {
+		s += fmt.Sprintf(", executing %s on %s", c.Inner, c.Inner.To.Hex())
+	}
+	return s
+}
+
+// Longer byte arguments are cut short in a preview
+const maxShownBytes = 36
+
+func formatValue(v reflect.Value) string {
+	if !v.IsValid() {
+		return "<nil>"
+	}
+	switch x := v.Interface().(type) {
+	case common.Address:
+		return x.Hex()
+	case *big.Int:
+		return x.String()
+	case []byte:
+		if len(x) > maxShownBytes {
+			return fmt.Sprintf("%s... (%d bytes)", hexutil.Encode(x[:maxShownBytes]), len(x))
+		}
+		return hexutil.Encode(x)
+	}
+
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			// Fixed size bytes, e.g. bytes32
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			return formatValue(reflect.ValueOf(b))
+		}
+		items := []string{}
+		for i := 0; i < v.Len(); i++ {
+			items = append(items, formatValue(v.Index(i)))
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case reflect.Struct:
+		fields := []string{}
+		for i := 0; i < v.NumField(); i++ {
+			name := v.Type().Field(i).Name
+			fields = append(fields, fmt.Sprintf("%s: %s", strings.ToLower(name[:1])+name[1:], formatValue(v.Field(i))))
+		}
+		return "{" + strings.Join(fields, ", ") + "}"
+	default:
+		return fmt.Sprint(v.Interface())
+	}
+}
+
+// An argument in the JSON ABI format
+type abiArgument struct {
+	Name       string        `json:"name"`
+	Type       string        `json:"type"`
+	Components []abiArgument `json:"components,omitempty"`
+}
+
+type abiFunction struct {
+	Type            string        `json:"type"`
+	Name            string        `json:"name"`
+	Inputs          []abiArgument `json:"inputs"`
+	Outputs         []abiArgument `json:"outputs"`
+	StateMutability string        `json:"stateMutability"`
+}
+
+// Build an ABI from human readable function signatures like "transfer(address to,uint256 amount)", which
+// are a lot shorter than their JSON
+func mustParseABI(contract string, signatures ...string) knownABI {
+	functions := []abiFunction{}
+	for _, sig := range signatures {
+		open := strings.IndexByte(sig, '(')
+		if open < 0 || !strings.HasSuffix(sig, ")") {
+			panic("invalid function signature " + sig)
+		}
+		inputs, err := parseArguments(sig[open+1 : len(sig)-1])
+		if err != nil {
+			panic(fmt.Sprintf("invalid function signature %s: %v", sig, err))
+		}
+		functions = append(functions, abiFunction{
+			Type:            "function",
+			Name:            sig[:open],
+			Inputs:          inputs,
+			Outputs:         []abiArgument{},
+			StateMutability: "payable",
+		})
+	}
+
+	def, err := json.Marshal(functions)
+	if err != nil {
+		panic(err)
+	}
+	parsed, err := abi.JSON(strings.NewReader(string(def)))
+	if err != nil {
+		panic(fmt.Sprintf("invalid ABI of %s: %v", contract, err))
+	}
+	return knownABI{Contract: contract, ABI: parsed}
+}
+
+// Parse a comma separated list of "type name", where type may be a tuple like "(address a,uint256 b)[]"
+func parseArguments(list string) ([]abiArgument, error) {
+	args := []abiArgument{}
+	if strings.TrimSpace(list) == "" {
+		return args, nil
+	}
+
+	// Split at the commas outside of tuples
+	parts := []string{}
+	depth, start := 0, 0
+	for i, ch := range list {
+		switch ch {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, list[start:i])
+				start = i + 1
+			}
+		}
+	}
+	parts = append(parts, list[start:])
+
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		arg := abiArgument{}
+		if strings.HasPrefix(part, "(") {
+			end := strings.LastIndexByte(part, ')')
+			if end < 0 {
+				return nil, errors.New("unbalanced parentheses in " + part)
+			}
+			components, err := parseArguments(part[1:end])
+			if err != nil {
+				return nil, err
+			}
+			arg.Components = components
+			part = "tuple" + part[end+1:]
+		}
+		fields := strings.Fields(part)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("expected a type and name in %q", part)
+		}
+		arg.Type, arg.Name = fields[0], fields[1]
+		args = append(args, arg)
+	}
+	return args, nil
+}
@@ -0,0 +1,73 @@
+package txpreview
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+var (
+	usdc    = common.HexToAddress("0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48")
+	alice   = common.HexToAddress("0x1111111111111111111111111111111111111111")
+	bob     = common.HexToAddress("0x2222222222222222222222222222222222222222")
+	spender = common.HexToAddress("0x3333333333333333333333333333333333333333")
+)
+
+// Calldata of a call with a selector and static arguments, each padded to 32 bytes
+func calldata(selector string, args ...[]byte) []byte {
+	data := hexutil.MustDecode(selector)
+	for _, a := range args {
+		data = append(data, common.LeftPadBytes(a, 32)...)
+	}
+	return data
+}
+
+func TestDecodeERC20(t *testing.T) {
+	amount := big.NewInt(2500000)
+	cases := []struct {
+		data    []byte
+		method  string
+		account common.Address
+		arg     string
+		str     string
+	}{
+		{calldata("0xa9059cbb", bob.Bytes(), amount.Bytes()), "transfer", bob, "to",
+			"ERC-20 transfer(to: 0x2222222222222222222222222222222222222222, amount: 2500000)"},
+		{calldata("0x095ea7b3", spender.Bytes(), amount.Bytes()), "approve", spender, "spender",
+			"ERC-20 approve(spender: 0x3333333333333333333333333333333333333333, amount: 2500000)"},
+	}
+	for _, c := range cases {
+		call, err := Decode(usdc, c.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if call.To != usdc || call.Contract != "ERC-20" || call.Method != c.method {
+			t.Errorf("decoded %s %s on %s", call.Contract, call.Method, call.To.Hex())
+		}
+		if call.Address(c.arg) != c.account || call.Amount("amount").Cmp(amount) != 0 {
+			t.Errorf("%s of %s %s", c.method, call.Amount("amount"), call.Address(c.arg).Hex())
+		}
+		if call.String() != c.str {
+			t.Errorf("%s shown as %s", c.method, call)
+		}
+	}
+}
+
+func TestDecodeUnknown(t *testing.T) {
+	if call, err := Decode(usdc, nil); call != nil || err != nil {
+		t.Errorf("decoded no calldata as %v, %v", call, err)
+	}
+	if _, err := Decode(usdc, []byte{0xa9, 0x05}); err == nil || !strings.Contains(err.Error(), "too short") {
+		t.Errorf("short calldata: %v", err)
+	}
+	if _, err := Decode(usdc, calldata("0xdeadbeef", bob.Bytes())); err == nil || !strings.Contains(err.Error(), "unknown function selector 0xdeadbeef") {
+		t.Errorf("unknown selector: %v", err)
+	}
+	// a transfer missing its amount
+	if _, err := Decode(usdc, calldata("0xa9059cbb", bob.Bytes())); err == nil || !strings.Contains(err.Error(), "invalid arguments of ERC-20 transfer") {
+		t.Errorf("truncated transfer: %v", err)
+	}
+}
@@ -0,0 +1,408 @@
+// Package txpreview simulates a transaction on the current state of the chain before it is signed, and
+// describes what it does for the signers: the decoded call, the balances it changes and whether it reverts.
+package txpreview
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet/conn"
+	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet/types"
+	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet/units"
+)
+
+// Gas limits for transactions whose gas could not be estimated, e.g. because they revert
+const (
+	TransferGasLimit     = 21000
+	ContractCallGasLimit = 300000
+)
+
+// The blockchain calls a simulation needs, implemented by conn.EthConn
+type Backend interface {
+	GetBalance(addr common.Address) (*big.Int, error)
+	CallContract(msg conn.CallMsg) ([]byte, error)
+	EstimateGas(msg conn.CallMsg) (uint64, error)
+}
+
+// What a transaction does according to a simulation on the current state
+type Preview struct {
+	Tx *types.Transaction
+	// The decoded calldata, nil for a plain ether transfer or calldata of an unknown function
+	Call *Call
+	// Ether balance of the sender before the transaction
+	Balance     *big.Int
+	GasEstimate uint64
+	Deltas      []Delta
+	// The transaction fails on the current state, and why
+	Reverted     bool
+	RevertReason string
+	// The simulation could not run, e.g. the node is unreachable, so the transaction may or may not revert
+	Err      error
+	Warnings []string
+
+	tokens map[common.Address]token
+}
+
+// A balance change of an account, in wei for ether or the base unit of a token
+type Delta struct {
+	Account common.Address
+	// The token contract, nil for ether
+	Token  *common.Address
+	Amount *big.Int
+	// The ID of a non-fungible token, whose Amount is 1 or -1
+	TokenID *big.Int
+	// "at least" or "at most" if the amount depends on the state when the transaction runs, e.g. for swaps
+	Bound string
+}
+
+type token struct {
+	Symbol   string
+	Decimals int
+	// Whether decimals() answered, which ERC-721 tokens do not
+	Fungible bool
+}
+
+// Simulate a transaction with eth_estimateGas and eth_call. A transaction without a gas limit gets the
+// estimate, one with a gas limit is checked against it.
+func Simulate(b Backend, tx *types.Transaction) *Preview {
+	p := &Preview{Tx: tx, tokens: make(map[common.Address]token)}
+	value := tx.Value
+	if value == nil {
+		value = new(big.Int)
+	}
+
+	if tx.To != nil && len(tx.Data) > 0 {
+		call, err := Decode(*tx.To, tx.Data)
+		if err != nil {
+			p.Warnings = append(p.Warnings, fmt.Sprintf("the calldata can not be decoded, check it with the contract: %v", err))
+		}
+		p.Call = call
+	}
+	p.fetchTokens(b)
+	p.Deltas = p.deltas(*tx.From, value)
+
+	msg := conn.CallMsg{From: *tx.From, To: tx.To, Gas: tx.GasLimit, Value: value, Data: tx.Data}
+	gas, err := b.EstimateGas(msg)
+	if p.failed(err) {
+		return p
+	}
+	p.GasEstimate = gas
+	if tx.GasLimit != 0 && gas > tx.GasLimit {
+		p.Reverted = true
+		p.RevertReason = fmt.Sprintf("out of gas, it needs %d but the gas limit is %d", gas, tx.GasLimit)
+		return p
+	}
+
+	// With the gas price set, the node also checks the sender can pay for the gas
+	msg.Gas = p.GasLimit()
+	msg.GasPrice = tx.GasPrice
+	if _, err := b.CallContract(msg); p.failed(err) {
+		return p
+	}
+
+	balance, err := b.GetBalance(*tx.From)
+	if err != nil {
+		p.Warnings = append(p.Warnings, fmt.Sprintf("could not get the balance of %s: %v", tx.From.Hex(), err))
+		return p
+	}
+	p.Balance = balance
+	if needed := new(big.Int).Add(value, p.MaxFee()); balance.Cmp(needed) < 0 {
+		p.Reverted = true
+		p.RevertReason = fmt.Sprintf("insufficient funds, the balance is %s but it needs %s", units.FormatEther(balance), units.FormatEther(needed))
+	}
+	return p
+}
+
+// Record the error of a simulation call, a failed execution means the transaction reverts
+func (p *Preview) failed(err error) bool {
+	if err == nil {
+		return false
+	}
+	var rpcErr *conn.RPCError
+	if errors.As(err, &rpcErr) && rpcErr.IsExecutionError() {
+		p.Reverted = true
+		p.RevertReason = rpcErr.RevertReason()
+	} else {
+		p.Err = err
+	}
+	return true
+}
+
+// The gas limit of the transaction, or the estimate if it has none yet
+func (p *Preview) GasLimit() uint64 {
+	if p.Tx.GasLimit != 0 {
+		return p.Tx.GasLimit
+	}
+	return p.GasEstimate
+}
+
+// The most the transaction can cost in gas
+func (p *Preview) MaxFee() *big.Int {
+	if p.Tx.GasPrice == nil {
+		return new(big.Int)
+	}
+	return new(big.Int).Mul(p.Tx.GasPrice, new(big.Int).SetUint64(p.GasLimit()))
+}
+
+// The balance changes of the transaction, as far as the call tells. A simulation can not see what contracts
+// do internally, so swap outputs are only known as the minimum the call accepts.
+func (p *Preview) deltas(from common.Address, value *big.Int) []Delta {
+	to := common.Address{}
+	if p.Tx.To != nil {
+		to = *p.Tx.To
+	}
+	return p.callDeltas(from, to, value, p.Call)
+}
+
+func (p *Preview) callDeltas(from common.Address, to common.Address, value *big.Int, c *Call) []Delta {
+	deltas := []Delta{}
+	add := func(account common.Address, tok *common.Address, amount *big.Int, sign int, bound string) {
+		if amount == nil || amount.Sign() == 0 {
+			return
+		}
+		d := Delta{Account: account, Token: tok, Amount: new(big.Int).Set(amount), Bound: bound}
+		if sign < 0 {
+			d.Amount.Neg(d.Amount)
+		}
+		deltas = append(deltas, d)
+	}
+	addNFT := func(account common.Address, tok common.Address, id *big.Int, sign int) {
+		if id == nil {
+			return
+		}
+		deltas = append(deltas, Delta{Account: account, Token: &tok, Amount: big.NewInt(int64(sign)), TokenID: id})
+	}
+	eth := (*common.Address)(nil)
+	tok := &to
+
+	add(from, eth, value, -1, "")
+	add(to, eth, value, 1, "")
+	if c == nil {
+		return deltas
+	}
+
+	switch c.Method {
+	case "transfer":
+		add(from, tok, c.Amount("amount"), -1, "")
+		add(c.Address("to"), tok, c.Amount("amount"), 1, "")
+	case "transferFrom":
+		if p.tokens[to].Fungible {
+			add(c.Address("from"), tok, c.Amount("amount"), -1, "")
+			add(c.Address("to"), tok, c.Amount("amount"), 1, "")
+		} else {
+			addNFT(c.Address("from"), to, c.Amount("amount"), -1)
+			addNFT(c.Address("to"), to, c.Amount("amount"), 1)
+		}
+	case "safeTransferFrom":
+		addNFT(c.Address("from"), to, c.Amount("tokenId"), -1)
+		addNFT(c.Address("to"), to, c.Amount("tokenId"), 1)
+	case "deposit":
+		add(from, tok, value, 1, "")
+	case "withdraw":
+		add(from, tok, c.Amount("amount"), -1, "")
+		add(from, eth, c.Amount("amount"), 1, "")
+	case "execTransaction":
+		// The Safe makes the call, a delegatecall (operation 1) runs in the Safe itself
+		if op, _ := c.Arg("operation").(uint8); op == 0 {
+			deltas = append(deltas, p.callDeltas(to, c.Address("to"), c.Amount("value"), c.Inner)...)
+		}
+	case "swapExactETHForTokens":
+		add(c.Address("to"), last(c.Arg("path")), c.Amount("amountOutMin"), 1, "at least")
+	case "swapETHForExactTokens":
+		add(c.Address("to"), last(c.Arg("path")), c.Amount("amountOut"), 1, "")
+	case "swapExactTokensForETH":
+		add(from, first(c.Arg("path")), c.Amount("amountIn"), -1, "")
+		add(c.Address("to"), eth, c.Amount("amountOutMin"), 1, "at least")
+	case "swapTokensForExactETH":
+		add(from, first(c.Arg("path")), c.Amount("amountInMax"), -1, "at most")
+		add(c.Address("to"), eth, c.Amount("amountOut"), 1, "")
+	case "swapExactTokensForTokens":
+		add(from, first(c.Arg("path")), c.Amount("amountIn"), -1, "")
+		add(c.Address("to"), last(c.Arg("path")), c.Amount("amountOutMin"), 1, "at least")
+	case "swapTokensForExactTokens":
+		add(from, first(c.Arg("path")), c.Amount("amountInMax"), -1, "at most")
+		add(c.Address("to"), last(c.Arg("path")), c.Amount("amountOut"), 1, "")
+	case "exactInputSingle":
+		params := c.Arg("params")
+		tokenIn, _ := field(params, "tokenIn").(common.Address)
+		tokenOut, _ := field(params, "tokenOut").(common.Address)
+		recipient, _ := field(params, "recipient").(common.Address)
+		amountIn, _ := field(params, "amountIn").(*big.Int)
+		amountOut, _ := field(params, "amountOutMinimum").(*big.Int)
+		add(from, &tokenIn, amountIn, -1, "")
+		add(recipient, &tokenOut, amountOut, 1, "at least")
+	case "exactInput":
+		// The path is the input token, then fee (3 bytes) and token for each hop
+		params := c.Arg("params")
+		path, _ := field(params, "path").([]byte)
+		recipient, _ := field(params, "recipient").(common.Address)
+		amountIn, _ := field(params, "amountIn").(*big.Int)
+		amountOut, _ := field(params, "amountOutMinimum").(*big.Int)
+		if len(path) >= 2*common.AddressLength+3 {
+			tokenIn := common.BytesToAddress(path[:common.AddressLength])
+			tokenOut := common.BytesToAddress(path[len(path)-common.AddressLength:])
+			add(from, &tokenIn, amountIn, -1, "")
+			add(recipient, &tokenOut, amountOut, 1, "at least")
+		}
+	}
+	return deltas
+}
+
+func first(path interface{}) *common.Address {
+	addrs, _ := path.([]common.Address)
+	if len(addrs) == 0 {
+		return nil
+	}
+	return &addrs[0]
+}
+
+func last(path interface{}) *common.Address {
+	addrs, _ := path.([]common.Address)
+	if len(addrs) == 0 {
+		return nil
+	}
+	return &addrs[len(addrs)-1]
+}
+
+// Selectors of symbol() and decimals() of ERC-20 tokens
+var (
+	selectorSymbol   = []byte{0x95, 0xd8, 0x9b, 0x41}
+	selectorDecimals = []byte{0x31, 0x3c, 0xe5, 0x67}
+)
+
+// Look up the symbol and decimals of the tokens the call moves
+func (p *Preview) fetchTokens(b Backend) {
+	contracts := []common.Address{}
+	for c := p.Call; c != nil; c = c.Inner {
+		contracts = append(contracts, c.To)
+		for _, a := range c.Args {
+			switch v := a.Value.(type) {
+			case common.Address:
+				contracts = append(contracts, v)
+			case []common.Address:
+				contracts = append(contracts, v...)
+			}
+		}
+		for _, name := range []string{"tokenIn", "tokenOut"} {
+			if addr, ok := field(c.Arg("params"), name).(common.Address); ok {
+				contracts = append(contracts, addr)
+			}
+		}
+	}
+
+	for _, addr := range contracts {
+		if _, ok := p.tokens[addr]; ok {
+			continue
+		}
+		addr := addr
+		t := token{}
+		if result, err := b.CallContract(conn.CallMsg{To: &addr, Data: selectorDecimals}); err == nil && len(result) == 32 {
+			t.Decimals = int(new(big.Int).SetBytes(result).Int64())
+			t.Fungible = t.Decimals <= 77
+		}
+		if result, err := b.CallContract(conn.CallMsg{To: &addr, Data: selectorSymbol}); err == nil {
+			t.Symbol = decodeSymbol(result)
+		}
+		p.tokens[addr] = t
+	}
+}
+
+// Symbols are ABI encoded strings, or bytes32 for some older tokens
+func decodeSymbol(result []byte) string {
+	stringType, _ := abi.NewType("string", "", nil)
+	if values, err := (abi.Arguments{{Type: stringType}}).Unpack(result); err == nil {
+		return values[0].(string)
+	}
+	if len(result) == 32 {
+		return strings.TrimRight(string(result), "\x00")
+	}
+	return ""
+}
+
+// Describes accounts in a preview, e.g. by their address book label
+type Labeler func(addr common.Address) string
+
+func (p *Preview) String() string {
+	return p.Format(nil)
+}
+
+// Describe the transaction for a signer, one fact per line
+func (p *Preview) Format(label Labeler) string {
+	name := func(addr common.Address) string {
+		if label != nil {
+			if l := label(addr); l != "" {
+				return fmt.Sprintf("%s (%s)", l, addr.Hex())
+			}
+		}
+		return addr.Hex()
+	}
+
+	lines := []string{}
+	switch {
+	case p.Tx.To == nil:
+		lines = append(lines, "Deploy a contract")
+	case p.Call != nil:
+		lines = append(lines, fmt.Sprintf("Call %s: %s", name(*p.Tx.To), p.Call))
+	case len(p.Tx.Data) > 0:
+		lines = append(lines, fmt.Sprintf("Call an unknown function on %s with %d bytes of calldata", name(*p.Tx.To), len(p.Tx.Data)))
+	}
+	if p.GasLimit() != 0 && p.Tx.GasPrice != nil {
+		lines = append(lines, fmt.Sprintf("Gas limit %d at %s gwei, at most %s in fees", p.GasLimit(), units.Format(p.Tx.GasPrice, units.Gwei), units.FormatEther(p.MaxFee())))
+	}
+
+	if len(p.Deltas) > 0 {
+		lines = append(lines, "Balance changes:")
+		for _, d := range p.Deltas {
+			lines = append(lines, fmt.Sprintf("  %s: %s", name(d.Account), p.formatDelta(d)))
+		}
+	}
+	if p.Balance != nil {
+		lines = append(lines, fmt.Sprintf("Sender balance %s", units.FormatEther(p.Balance)))
+	}
+
+	for _, w := range p.Warnings {
+		lines = append(lines, "Warning: "+w)
+	}
+	switch {
+	case p.Reverted:
+		lines = append(lines, "Simulation: REVERTS, "+p.RevertReason)
+	case p.Err != nil:
+		lines = append(lines, fmt.Sprintf("Simulation: could not simulate the transaction: %v", p.Err))
+	default:
+		lines = append(lines, "Simulation: succeeds on the current state")
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (p *Preview) formatDelta(d Delta) string {
+	if d.Token == nil {
+		return signed(units.FormatEther(d.Amount), d.Bound)
+	}
+
+	t := p.tokens[*d.Token]
+	symbol := t.Symbol
+	if symbol == "" {
+		symbol = "token"
+	}
+	if d.TokenID != nil {
+		return signed(fmt.Sprintf("%s %s #%s (%s)", d.Amount, symbol, d.TokenID, d.Token.Hex()), d.Bound)
+	}
+	if !t.Fungible {
+		return signed(fmt.Sprintf("%s base units of %s", d.Amount, d.Token.Hex()), d.Bound)
+	}
+	return signed(fmt.Sprintf("%s %s (%s)", units.Format(d.Amount, t.Decimals), symbol, d.Token.Hex()), d.Bound)
+}
+
+func signed(amount string, bound string) string {
+	if !strings.HasPrefix(amount, "-") {
+		amount = "+" + amount
+	}
+	if bound != "" {
+		return bound + " " + amount
+	}
+	return amount
+}
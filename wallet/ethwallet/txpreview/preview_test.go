@@ -0,0 +1,131 @@
+package txpreview
+
+import (
+	"bytes"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet/conn"
+	"github.com/shykerbogdan/mpc-wallet/wallet/ethwallet/types"
+)
+
+// A chain with one token of 6 decimals, where calls either succeed or revert with a reason
+type stubBackend struct {
+	token   common.Address
+	symbol  string
+	balance *big.Int
+	revert  string
+}
+
+func encodeString(s string) []byte {
+	stringType, _ := abi.NewType("string", "", nil)
+	b, err := (abi.Arguments{{Type: stringType}}).Pack(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func (b *stubBackend) GetBalance(addr common.Address) (*big.Int, error) {
+	return b.balance, nil
+}
+
+func (b *stubBackend) CallContract(msg conn.CallMsg) ([]byte, error) {
+	switch {
+	case msg.To != nil && *msg.To == b.token && bytes.Equal(msg.Data, selectorDecimals):
+		return common.LeftPadBytes([]byte{6}, 32), nil
+	case msg.To != nil && *msg.To == b.token && bytes.Equal(msg.Data, selectorSymbol):
+		return encodeString(b.symbol), nil
+	case len(msg.Data) == 4:
+		// no other account is a token
+		return nil, &conn.RPCError{Method: "eth_call", Code: 3, Message: "execution reverted"}
+	}
+	return nil, b.err()
+}
+
+func (b *stubBackend) EstimateGas(msg conn.CallMsg) (uint64, error) {
+	return 50000, b.err()
+}
+
+func (b *stubBackend) err() error {
+	if b.revert == "" {
+		return nil
+	}
+	return &conn.RPCError{Method: "eth_estimateGas", Code: 3, Message: "execution reverted", Data: append([]byte{0x08, 0xc3, 0x79, 0xa0}, encodeString(b.revert)...)}
+}
+
+func newTx(to common.Address, data []byte) *types.Transaction {
+	return &types.Transaction{From: &alice, To: &to, Value: new(big.Int), Data: data, GasPrice: big.NewInt(20000000000)}
+}
+
+func TestPreviewTransfer(t *testing.T) {
+	b := &stubBackend{token: usdc, symbol: "USDC", balance: big.NewInt(1000000000000000000)}
+	p := Simulate(b, newTx(usdc, calldata("0xa9059cbb", bob.Bytes(), big.NewInt(2500000).Bytes())))
+	if p.Err != nil || p.Reverted || len(p.Warnings) != 0 {
+		t.Fatalf("simulation failed: %v %s %v", p.Err, p.RevertReason, p.Warnings)
+	}
+	if p.GasLimit() != 50000 || p.MaxFee().Cmp(big.NewInt(1000000000000000)) != 0 {
+		t.Errorf("gas limit %d and fee %s", p.GasLimit(), p.MaxFee())
+	}
+	if len(p.Deltas) != 2 || p.Deltas[0].Account != alice || p.Deltas[0].Amount.Int64() != -2500000 || p.Deltas[1].Account != bob {
+		t.Fatalf("deltas %+v", p.Deltas)
+	}
+
+	s := p.Format(func(addr common.Address) string {
+		if addr == bob {
+			return "bob"
+		}
+		return ""
+	})
+	for _, want := range []string{
+		"Call 0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48: ERC-20 transfer(to: 0x2222222222222222222222222222222222222222, amount: 2500000)",
+		"Gas limit 50000 at 20 gwei",
+		"0x1111111111111111111111111111111111111111: -2.5 USDC",
+		"bob (0x2222222222222222222222222222222222222222): +2.5 USDC",
+		"Simulation: succeeds on the current state",
+	} {
+		if !strings.Contains(s, want) {
+			t.Errorf("preview does not contain %q:\n%s", want, s)
+		}
+	}
+}
+
+func TestPreviewApprove(t *testing.T) {
+	b := &stubBackend{token: usdc, symbol: "USDC", balance: big.NewInt(1000000000000000000), revert: "approve from non-zero"}
+	p := Simulate(b, newTx(usdc, calldata("0x095ea7b3", spender.Bytes(), big.NewInt(1).Bytes())))
+	// an approval moves no tokens
+	if len(p.Deltas) != 0 {
+		t.Errorf("approval has deltas %+v", p.Deltas)
+	}
+	if !p.Reverted || p.RevertReason != "approve from non-zero" {
+		t.Errorf("reverted %v: %s", p.Reverted, p.RevertReason)
+	}
+	if s := p.String(); !strings.Contains(s, "ERC-20 approve(spender: ") || !strings.Contains(s, "Simulation: REVERTS, approve from non-zero") {
+		t.Errorf("preview:\n%s", s)
+	}
+}
+
+func TestPreviewUnknownCalldata(t *testing.T) {
+	contract := common.HexToAddress("0x4444444444444444444444444444444444444444")
+	b := &stubBackend{token: usdc, balance: big.NewInt(1000000000000000000)}
+	p := Simulate(b, newTx(contract, calldata("0xdeadbeef", bob.Bytes())))
+	if p.Call != nil {
+		t.Errorf("unknown calldata decoded as %s", p.Call)
+	}
+	if len(p.Warnings) != 1 || !strings.Contains(p.Warnings[0], "the calldata can not be decoded") {
+		t.Errorf("warnings %v", p.Warnings)
+	}
+	s := p.String()
+	for _, want := range []string{
+		"Call an unknown function on 0x4444444444444444444444444444444444444444 with 36 bytes of calldata",
+		"Warning: the calldata can not be decoded, check it with the contract: unknown function selector 0xdeadbeef",
+		"Simulation: succeeds on the current state",
+	} {
+		if !strings.Contains(s, want) {
+			t.Errorf("preview does not contain %q:\n%s", want, s)
+		}
+	}
+}
@@ -117,6 +117,13 @@ func (t *Transaction) ToSignHash(chainId byte) (res []byte) {
 	return
 }
 
+// Set the V, R and S values from an eth signature in r | s | v form
+func (t *Transaction) SetSignature(sig []byte) {
+	t.R = new(big.Int).SetBytes(sig[:32])
+	t.S = new(big.Int).SetBytes(sig[32:64])
+	t.V = new(big.Int).SetBytes(sig[64:])
+}
+
 func (t *Transaction) ToRLP() (res []byte) {
 	tx := t.ToByteArray()
 	res = rlp.EncodeList(tx)
@@ -0,0 +1,145 @@
+// Package units converts between decimal amounts as people write them, like "1.5 eth" or "20 gwei",
+// and exact integer amounts in the smallest unit (wei, or the base unit of a token).
+package units
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Decimals of the ether units, relative to wei
+const (
+	Wei   = 0
+	Gwei  = 9
+	Ether = 18
+)
+
+var unitDecimals = map[string]int{
+	"wei":      Wei,
+	"kwei":     3,
+	"babbage":  3,
+	"mwei":     6,
+	"lovelace": 6,
+	"gwei":     Gwei,
+	"shannon":  Gwei,
+	"szabo":    12,
+	"finney":   15,
+	"eth":      Ether,
+	"ether":    Ether,
+}
+
+// Parse an ether amount with an optional unit, e.g. "1.5 eth", "20gwei" or "1000 wei", into wei.
+// Amounts without a unit are in defaultDecimals, e.g. Ether.
+func Parse(s string, defaultDecimals int) (*big.Int, error) {
+	s = strings.TrimSpace(s)
+	number, unit := splitUnit(s)
+
+	decimals := defaultDecimals
+	if unit != "" {
+		d, ok := unitDecimals[unit]
+		if !ok {
+			return nil, fmt.Errorf("unknown unit %q in amount %q", unit, s)
+		}
+		decimals = d
+	}
+	return ParseDecimal(number, decimals)
+}
+
+// Parse an ether amount that has to have a unit, e.g. "1.5 eth" or "1000 wei", into wei. For where no unit
+// is the obvious default, as a bare number means wei to some and ether to others.
+func ParseWithUnit(s string) (*big.Int, error) {
+	s = strings.TrimSpace(s)
+	if _, unit := splitUnit(s); unit == "" {
+		return nil, fmt.Errorf("amount %q has no unit, e.g. 1.5eth, 20gwei or 1000wei", s)
+	}
+	return Parse(s, Wei)
+}
+
+// Split off the unit, the letters at the end
+func splitUnit(s string) (number string, unit string) {
+	i := len(s)
+	for i > 0 && isLetter(s[i-1]) {
+		i--
+	}
+	return strings.TrimSpace(s[:i]), strings.ToLower(s[i:])
+}
+
+// Parse a decimal number like "1.5" into an integer amount of its smallest unit, for a currency or token
+// with the given decimals. Fails if the number has more fractional digits than decimals, instead of rounding.
+func ParseDecimal(s string, decimals int) (*big.Int, error) {
+	if decimals < 0 {
+		return nil, fmt.Errorf("invalid decimals %d", decimals)
+	}
+	if s == "" {
+		return nil, errors.New("no amount given")
+	}
+
+	whole, frac := s, ""
+	if dot := strings.IndexByte(s, '.'); dot >= 0 {
+		whole, frac = s[:dot], s[dot+1:]
+	}
+	if whole == "" && frac == "" {
+		return nil, fmt.Errorf("invalid amount %q", s)
+	}
+	if !isDigits(whole) || !isDigits(frac) {
+		return nil, fmt.Errorf("invalid amount %q, use digits and an optional decimal point", s)
+	}
+
+	frac = strings.TrimRight(frac, "0")
+	if len(frac) > decimals {
+		return nil, fmt.Errorf("amount %q has more than %d decimals", s, decimals)
+	}
+
+	digits := whole + frac + strings.Repeat("0", decimals-len(frac))
+	amount, ok := new(big.Int).SetString(digits, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid amount %q", s)
+	}
+	return amount, nil
+}
+
+// Format an integer amount of the smallest unit as an exact decimal number, e.g. 1500000000000000000 with
+// 18 decimals is "1.5"
+func Format(amount *big.Int, decimals int) string {
+	if amount == nil {
+		amount = new(big.Int)
+	}
+	sign := ""
+	if amount.Sign() < 0 {
+		sign = "-"
+	}
+
+	digits := new(big.Int).Abs(amount).String()
+	if decimals <= 0 {
+		return sign + digits
+	}
+	if len(digits) <= decimals {
+		digits = strings.Repeat("0", decimals-len(digits)+1) + digits
+	}
+
+	whole, frac := digits[:len(digits)-decimals], strings.TrimRight(digits[len(digits)-decimals:], "0")
+	if frac == "" {
+		return sign + whole
+	}
+	return sign + whole + "." + frac
+}
+
+// Format wei as ether, e.g. "1.5 ETH"
+func FormatEther(wei *big.Int) string {
+	return Format(wei, Ether) + " ETH"
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
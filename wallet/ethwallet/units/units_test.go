@@ -0,0 +1,148 @@
+package units
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in       string
+		decimals int
+		want     string
+		wantErr  bool
+	}{
+		{in: "1", decimals: Ether, want: "1000000000000000000"},
+		{in: "0.5", decimals: Ether, want: "500000000000000000"},
+		{in: "1.5 eth", decimals: Wei, want: "1500000000000000000"},
+		{in: "1.5ETH", decimals: Wei, want: "1500000000000000000"},
+		{in: "  2 ether ", decimals: Wei, want: "2000000000000000000"},
+		{in: "20 gwei", decimals: Ether, want: "20000000000"},
+		{in: "1.000000001 gwei", decimals: Ether, want: "1000000001"},
+		{in: "1000 wei", decimals: Ether, want: "1000"},
+		{in: "1000", decimals: Wei, want: "1000"},
+		{in: ".5 eth", decimals: Wei, want: "500000000000000000"},
+		{in: "5. eth", decimals: Wei, want: "5000000000000000000"},
+		{in: "0", decimals: Ether, want: "0"},
+		{in: "0.000000000000000001 eth", decimals: Wei, want: "1"},
+		{in: "1.10000000000000000000 eth", decimals: Wei, want: "1100000000000000000"},
+		{in: "3 finney", decimals: Wei, want: "3000000000000000"},
+		{in: "123456789012345678901234567890 eth", decimals: Wei, want: "123456789012345678901234567890000000000000000000"},
+
+		{in: "", decimals: Ether, wantErr: true},
+		{in: "eth", decimals: Ether, wantErr: true},
+		{in: ".", decimals: Ether, wantErr: true},
+		{in: "-1 eth", decimals: Wei, wantErr: true},
+		{in: "1e18", decimals: Wei, wantErr: true},
+		{in: "1,000 wei", decimals: Wei, wantErr: true},
+		{in: "1.2.3 eth", decimals: Wei, wantErr: true},
+		{in: "0.0000000000000000001 eth", decimals: Wei, wantErr: true},
+		{in: "1.5 wei", decimals: Ether, wantErr: true},
+		{in: "1 btc", decimals: Ether, wantErr: true},
+		{in: "1 e th", decimals: Ether, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := Parse(tt.in, tt.decimals)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Parse(%q) = %s, want error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%q) error %v", tt.in, err)
+			continue
+		}
+		if got.String() != tt.want {
+			t.Errorf("Parse(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseWithUnit(t *testing.T) {
+	if got, err := ParseWithUnit(" 1000 wei "); err != nil || got.String() != "1000" {
+		t.Errorf("ParseWithUnit(1000 wei) = %s, %v", got, err)
+	}
+	if got, err := ParseWithUnit("1.5eth"); err != nil || got.String() != "1500000000000000000" {
+		t.Errorf("ParseWithUnit(1.5eth) = %s, %v", got, err)
+	}
+	for _, in := range []string{"1000", "1.5", "", "1 btc"} {
+		if got, err := ParseWithUnit(in); err == nil {
+			t.Errorf("ParseWithUnit(%q) = %s, want error", in, got)
+		}
+	}
+}
+
+func TestParseDecimalToken(t *testing.T) {
+	tests := []struct {
+		in       string
+		decimals int
+		want     string
+		wantErr  bool
+	}{
+		{in: "1.5", decimals: 6, want: "1500000"},
+		{in: "0.000001", decimals: 6, want: "1"},
+		{in: "0.0000001", decimals: 6, wantErr: true},
+		{in: "42", decimals: 0, want: "42"},
+		{in: "42.0", decimals: 0, want: "42"},
+		{in: "42.5", decimals: 0, wantErr: true},
+		{in: "1", decimals: -1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseDecimal(tt.in, tt.decimals)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseDecimal(%q, %d) = %s, want error", tt.in, tt.decimals, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseDecimal(%q, %d) error %v", tt.in, tt.decimals, err)
+			continue
+		}
+		if got.String() != tt.want {
+			t.Errorf("ParseDecimal(%q, %d) = %s, want %s", tt.in, tt.decimals, got, tt.want)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		in       string
+		decimals int
+		want     string
+	}{
+		{in: "0", decimals: Ether, want: "0"},
+		{in: "1", decimals: Ether, want: "0.000000000000000001"},
+		{in: "1500000000000000000", decimals: Ether, want: "1.5"},
+		{in: "1000000000000000000", decimals: Ether, want: "1"},
+		{in: "123456789012345678901234567890", decimals: Ether, want: "123456789012.34567890123456789"},
+		{in: "-2500000000000000000", decimals: Ether, want: "-2.5"},
+		{in: "20000000000", decimals: Gwei, want: "20"},
+		{in: "1500000", decimals: 6, want: "1.5"},
+		{in: "42", decimals: 0, want: "42"},
+	}
+
+	for _, tt := range tests {
+		amount, _ := new(big.Int).SetString(tt.in, 10)
+		if got := Format(amount, tt.decimals); got != tt.want {
+			t.Errorf("Format(%s, %d) = %s, want %s", tt.in, tt.decimals, got, tt.want)
+		}
+		// Formatting and parsing again gives the same amount
+		if amount.Sign() >= 0 {
+			back, err := ParseDecimal(Format(amount, tt.decimals), tt.decimals)
+			if err != nil || back.Cmp(amount) != 0 {
+				t.Errorf("ParseDecimal(Format(%s, %d)) = %v, %v", tt.in, tt.decimals, back, err)
+			}
+		}
+	}
+
+	if got := Format(nil, Ether); got != "0" {
+		t.Errorf("Format(nil) = %s, want 0", got)
+	}
+	if got := FormatEther(big.NewInt(1e17)); got != "0.1 ETH" {
+		t.Errorf("FormatEther(1e17) = %s, want 0.1 ETH", got)
+	}
+}